@@ -0,0 +1,379 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+)
+
+// Annotation is an annotation as stored in RuntimeVisibleAnnotations and the
+// related attributes. TypeIndex names the field descriptor of the annotation
+// interface, e.g. Ljava/lang/Deprecated;.
+type Annotation struct {
+    TypeIndex CpIndex
+    Elements []ElementValuePair
+}
+
+type ElementValuePair struct {
+    NameIndex CpIndex
+    Value ElementValue
+}
+
+// ElementValue is the value of an annotation element. Tag is one of the
+// descriptor characters BCDFIJSZ or s for constants, which are found at
+// ConstIndex; e for an enum constant named by TypeNameIndex and
+// ConstNameIndex; c for a class literal at ClassInfoIndex; @ for a nested
+// Annotation; and [ for an array of Values.
+type ElementValue struct {
+    Tag byte
+    ConstIndex CpIndex
+    TypeNameIndex CpIndex
+    ConstNameIndex CpIndex
+    ClassInfoIndex CpIndex
+    Annotation *Annotation
+    Values []ElementValue
+}
+
+func DecodeAnnotations(info []byte) (annotations []Annotation, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            annotations = nil
+            err = fmt.Errorf("Malformed annotations attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    return mustReadAnnotations(&r), nil
+}
+
+// DecodeParameterAnnotations reads the RuntimeVisibleParameterAnnotations
+// and RuntimeInvisibleParameterAnnotations attributes, returning the
+// annotations of each parameter.
+func DecodeParameterAnnotations(info []byte) (annotations [][]Annotation, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            annotations = nil
+            err = fmt.Errorf("Malformed parameter annotations attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var count uint8
+    mustRead(&r, &count)
+    annotations = make([][]Annotation, count)
+    for i := range annotations {
+        annotations[i] = mustReadAnnotations(&r)
+    }
+    return annotations, nil
+}
+
+// DecodeAnnotationDefault reads the default value of an annotation
+// interface element.
+func DecodeAnnotationDefault(info []byte) (value ElementValue, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("Malformed AnnotationDefault attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    return mustReadElementValue(&r), nil
+}
+
+func mustReadAnnotations(r *io.Reader) []Annotation {
+    var count uint16
+    mustRead(r, &count)
+    annotations := make([]Annotation, count)
+    for i := range annotations {
+        annotations[i] = mustReadAnnotation(r)
+    }
+    return annotations
+}
+
+func mustReadAnnotation(r *io.Reader) Annotation {
+    var a Annotation
+    mustRead(r, &a.TypeIndex)
+    var count uint16
+    mustRead(r, &count)
+    a.Elements = make([]ElementValuePair, count)
+    for i := range a.Elements {
+        mustRead(r, &a.Elements[i].NameIndex)
+        a.Elements[i].Value = mustReadElementValue(r)
+    }
+    return a
+}
+
+func mustReadElementValue(r *io.Reader) ElementValue {
+    var v ElementValue
+    mustRead(r, &v.Tag)
+    switch v.Tag {
+    case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z', 's':
+        mustRead(r, &v.ConstIndex)
+    case 'e':
+        mustRead(r, &v.TypeNameIndex)
+        mustRead(r, &v.ConstNameIndex)
+    case 'c':
+        mustRead(r, &v.ClassInfoIndex)
+    case '@':
+        a := mustReadAnnotation(r)
+        v.Annotation = &a
+    case '[':
+        var count uint16
+        mustRead(r, &count)
+        v.Values = make([]ElementValue, count)
+        for i := range v.Values {
+            v.Values[i] = mustReadElementValue(r)
+        }
+    default:
+        panic(fmt.Sprintf("unknown element value tag %q", v.Tag))
+    }
+    return v
+}
+
+// EncodeAnnotations fails on element values with an unknown tag or a
+// missing nested annotation.
+func EncodeAnnotations(annotations []Annotation) (info []byte, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            info = nil
+            err = fmt.Errorf("Unable to encode annotations: %v", r)
+        }
+    }()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWriteAnnotations(&w, annotations)
+    return buf.Bytes(), nil
+}
+
+func EncodeParameterAnnotations(annotations [][]Annotation) (info []byte, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            info = nil
+            err = fmt.Errorf("Unable to encode parameter annotations: %v", r)
+        }
+    }()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, uint8(len(annotations)))
+    for _, param := range annotations {
+        mustWriteAnnotations(&w, param)
+    }
+    return buf.Bytes(), nil
+}
+
+func EncodeAnnotationDefault(value ElementValue) (info []byte, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            info = nil
+            err = fmt.Errorf("Unable to encode AnnotationDefault: %v", r)
+        }
+    }()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWriteElementValue(&w, value)
+    return buf.Bytes(), nil
+}
+
+func mustWriteAnnotations(w *io.Writer, annotations []Annotation) {
+    mustWrite(w, uint16(len(annotations)))
+    for _, a := range annotations {
+        mustWriteAnnotation(w, a)
+    }
+}
+
+func mustWriteAnnotation(w *io.Writer, a Annotation) {
+    mustWrite(w, a.TypeIndex)
+    mustWrite(w, uint16(len(a.Elements)))
+    for _, e := range a.Elements {
+        mustWrite(w, e.NameIndex)
+        mustWriteElementValue(w, e.Value)
+    }
+}
+
+func mustWriteElementValue(w *io.Writer, v ElementValue) {
+    mustWrite(w, v.Tag)
+    switch v.Tag {
+    case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z', 's':
+        mustWrite(w, v.ConstIndex)
+    case 'e':
+        mustWrite(w, v.TypeNameIndex)
+        mustWrite(w, v.ConstNameIndex)
+    case 'c':
+        mustWrite(w, v.ClassInfoIndex)
+    case '@':
+        if v.Annotation == nil {
+            panic("element value @ has no annotation")
+        }
+        mustWriteAnnotation(w, *v.Annotation)
+    case '[':
+        mustWrite(w, uint16(len(v.Values)))
+        for _, value := range v.Values {
+            mustWriteElementValue(w, value)
+        }
+    default:
+        panic(fmt.Sprintf("unknown element value tag %q", v.Tag))
+    }
+}
+
+// Type returns the descriptor of the annotation interface.
+func (a Annotation) Type(cp *ConstantPool) string {
+    return cp.GetUtf8(a.TypeIndex)
+}
+
+// Element returns the value given to the element called name, which is
+// missing when the annotation leaves it at its default.
+func (a Annotation) Element(cp *ConstantPool, name string) (ElementValue, bool) {
+    for _, e := range a.Elements {
+        if cp.GetUtf8(e.NameIndex) == name {
+            return e.Value, true
+        }
+    }
+    return ElementValue{}, false
+}
+
+// Java renders the annotation as Java source, naming classes with name.
+// A lone value element is written in the single element form, @A(x).
+func (a Annotation) Java(cp *ConstantPool, name func(internal string) string) string {
+    text := "@" + annotationType(cp.GetUtf8(a.TypeIndex), name)
+    if len(a.Elements) == 0 {
+        return text
+    }
+    if len(a.Elements) == 1 && cp.GetUtf8(a.Elements[0].NameIndex) == "value" {
+        return text + "(" + a.Elements[0].Value.Java(cp, name) + ")"
+    }
+    var elements []string
+    for _, e := range a.Elements {
+        elements = append(elements, cp.GetUtf8(e.NameIndex) + " = " + e.Value.Java(cp, name))
+    }
+    return text + "(" + strings.Join(elements, ", ") + ")"
+}
+
+// Java renders the element value as a Java expression, naming classes with
+// name.
+func (v ElementValue) Java(cp *ConstantPool, name func(internal string) string) string {
+    switch v.Tag {
+    case 'e':
+        return annotationType(cp.GetUtf8(v.TypeNameIndex), name) + "." + cp.GetUtf8(v.ConstNameIndex)
+    case 'c':
+        return annotationType(cp.GetUtf8(v.ClassInfoIndex), name) + ".class"
+    case '@':
+        return v.Annotation.Java(cp, name)
+    case '[':
+        var values []string
+        for _, value := range v.Values {
+            values = append(values, value.Java(cp, name))
+        }
+        return "{" + strings.Join(values, ", ") + "}"
+    case 's':
+        return javaStringLiteral(cp.GetUtf8(v.ConstIndex))
+    }
+    return javaLiteral(cp, v.ConstIndex, string(v.Tag))
+}
+
+// annotationType renders the descriptors annotations refer to types by,
+// including the V of void.class.
+func annotationType(d string, name func(internal string) string) string {
+    t, err := descriptor.ParseReturn(d)
+    if err != nil {
+        return d
+    }
+    return t.JavaName(name)
+}
+
+// FindAnnotations decodes both the RuntimeVisibleAnnotations and the
+// RuntimeInvisibleAnnotations in attrs.
+func FindAnnotations(cp *ConstantPool, attrs []Attribute) ([]Annotation, error) {
+    var annotations []Annotation
+    for _, name := range []string{"RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations"} {
+        attr := FindAttribute(cp, attrs, name)
+        if attr == nil {
+            continue
+        }
+        found, err := DecodeAnnotations(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        annotations = append(annotations, found...)
+    }
+    return annotations, nil
+}
+
+// findAnnotation returns the annotation in attrs whose interface has the
+// descriptor, ignoring malformed attributes.
+func findAnnotation(cp *ConstantPool, attrs []Attribute, descriptor string) (Annotation, bool) {
+    annotations, _ := FindAnnotations(cp, attrs)
+    for _, a := range annotations {
+        if a.Type(cp) == descriptor {
+            return a, true
+        }
+    }
+    return Annotation{}, false
+}
+
+func (c *Class) Annotations() ([]Annotation, error) {
+    return FindAnnotations(c.ConstantPool, c.Attributes)
+}
+
+// HasAnnotation reports whether the class is annotated with the interface
+// of the descriptor, e.g. Ljava/lang/FunctionalInterface;.
+func (c *Class) HasAnnotation(descriptor string) bool {
+    _, ok := findAnnotation(c.ConstantPool, c.Attributes, descriptor)
+    return ok
+}
+
+func (f *Field) Annotations(cp *ConstantPool) ([]Annotation, error) {
+    return FindAnnotations(cp, f.Attributes)
+}
+
+func (f *Field) HasAnnotation(cp *ConstantPool, descriptor string) bool {
+    _, ok := findAnnotation(cp, f.Attributes, descriptor)
+    return ok
+}
+
+func (m *Method) Annotations(cp *ConstantPool) ([]Annotation, error) {
+    return FindAnnotations(cp, m.Attributes)
+}
+
+// HasAnnotation reports whether the method is annotated with the interface
+// of the descriptor, e.g. Lorg/junit/jupiter/api/Test;.
+func (m *Method) HasAnnotation(cp *ConstantPool, descriptor string) bool {
+    _, ok := findAnnotation(cp, m.Attributes, descriptor)
+    return ok
+}
+
+// ParameterAnnotations returns the visible and invisible annotations of
+// each parameter. The attributes may cover fewer parameters than the
+// descriptor declares.
+func (m *Method) ParameterAnnotations(cp *ConstantPool) ([][]Annotation, error) {
+    var params [][]Annotation
+    for _, name := range []string{"RuntimeVisibleParameterAnnotations", "RuntimeInvisibleParameterAnnotations"} {
+        attr := FindAttribute(cp, m.Attributes, name)
+        if attr == nil {
+            continue
+        }
+        found, err := DecodeParameterAnnotations(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        for i, annotations := range found {
+            if i == len(params) {
+                params = append(params, nil)
+            }
+            params[i] = append(params[i], annotations...)
+        }
+    }
+    return params, nil
+}
+
+// AnnotationDefault returns the default value of an annotation interface
+// element, or nil if it has none.
+func (m *Method) AnnotationDefault(cp *ConstantPool) (*ElementValue, error) {
+    attr := FindAttribute(cp, m.Attributes, "AnnotationDefault")
+    if attr == nil {
+        return nil, nil
+    }
+    value, err := DecodeAnnotationDefault(attr.Info)
+    if err != nil {
+        return nil, err
+    }
+    return &value, nil
+}
@@ -0,0 +1,418 @@
+package jcr
+
+import (
+    "encoding/binary"
+    "fmt"
+    "math"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// Label is a symbolic position in the code being assembled. Labels are
+// created with NewLabel and bound to the current position with Mark.
+type Label struct {
+    offset int
+    marked bool
+}
+
+type asmItem struct {
+    label *Label
+    code []byte
+
+    op Opcode
+    offset int
+    target *Label
+    wide bool
+
+    // switch instructions; for a tableswitch keys holds only the low value
+    dflt *Label
+    keys []int32
+    targets []*Label
+}
+
+type asmCatch struct {
+    start *Label
+    end *Label
+    handler *Label
+    catchType CpIndex
+}
+
+// Assembler emits instructions against symbolic labels and resolves them into
+// a Code attribute. Operand encodings are chosen automatically: short load and
+// store forms, ldc or ldc_w, wide locals, and goto_w (or an inverted condition
+// around a goto_w) for branches that don't fit in 16 bits.
+type Assembler struct {
+    ConstantPool *ConstantPool
+    MaxStack uint16
+    MaxLocals uint16
+    // Descriptor and Static describe the method being assembled. When
+    // Descriptor is set and MaxStack and MaxLocals are both left at zero,
+    // Assemble computes them from the code.
+    Descriptor string
+    Static bool
+
+    items []asmItem
+    catches []asmCatch
+    err error
+}
+
+func NewAssembler(cp *ConstantPool) *Assembler {
+    return &Assembler{ConstantPool: cp}
+}
+
+func (a *Assembler) NewLabel() *Label {
+    return &Label{}
+}
+
+func (a *Assembler) Mark(l *Label) {
+    if l.marked {
+        a.fail("Label marked more than once")
+        return
+    }
+    l.marked = true
+    a.items = append(a.items, asmItem{label: l})
+}
+
+// Op emits an instruction without operands.
+func (a *Assembler) Op(op Opcode) {
+    a.emit(byte(op))
+}
+
+// Var emits a load, store or ret against local variable index.
+func (a *Assembler) Var(op Opcode, index uint16) {
+    isLoad := op >= Iload && op <= Aload
+    isStore := op >= Istore && op <= Astore
+    if !isLoad && !isStore && op != Ret {
+        a.fail(fmt.Sprintf("%s is not a local variable instruction", op))
+        return
+    }
+    if index <= 3 && isLoad {
+        a.emit(byte(Iload0) + byte(op - Iload) * 4 + byte(index))
+    } else if index <= 3 && isStore {
+        a.emit(byte(Istore0) + byte(op - Istore) * 4 + byte(index))
+    } else if index <= 0xff {
+        a.emit(byte(op), byte(index))
+    } else {
+        a.emit(byte(Wide), byte(op), byte(index >> 8), byte(index))
+    }
+}
+
+func (a *Assembler) Iinc(index uint16, delta int16) {
+    if index <= 0xff && delta >= -128 && delta <= 127 {
+        a.emit(byte(Iinc), byte(index), byte(delta))
+    } else {
+        a.emit(byte(Wide), byte(Iinc), byte(index >> 8), byte(index), byte(delta >> 8), byte(delta))
+    }
+}
+
+// Push emits the shortest instruction that pushes the int constant v.
+func (a *Assembler) Push(v int32) {
+    switch {
+    case v >= -1 && v <= 5:
+        a.emit(byte(Iconst0) + byte(v))
+    case v >= -128 && v <= 127:
+        a.emit(byte(Bipush), byte(v))
+    case v >= -32768 && v <= 32767:
+        a.emit(byte(Sipush), byte(v >> 8), byte(v))
+    default:
+        a.Ldc(a.ConstantPool.AddInteger(v))
+    }
+}
+
+// Ldc loads the constant at index, choosing ldc, ldc_w or ldc2_w.
+func (a *Assembler) Ldc(index CpIndex) {
+    if int(index) < 1 || int(index) >= int(a.ConstantPool.Count()) || *a.ConstantPool.Get(index) == nil {
+        a.fail(fmt.Sprintf("Invalid constant pool index %s", index))
+        return
+    }
+    switch (*a.ConstantPool.Get(index)).Type() {
+    case TLong, TDouble:
+        a.emit(byte(Ldc2W), byte(index >> 8), byte(index))
+    default:
+        if index <= 0xff {
+            a.emit(byte(Ldc), byte(index))
+        } else {
+            a.emit(byte(LdcW), byte(index >> 8), byte(index))
+        }
+    }
+}
+
+// Ref emits an instruction whose only operand is a constant pool index, such
+// as getfield, invokevirtual, new or checkcast.
+func (a *Assembler) Ref(op Opcode, index CpIndex) {
+    a.emit(byte(op), byte(index >> 8), byte(index))
+}
+
+func (a *Assembler) InvokeInterface(index CpIndex, count byte) {
+    a.emit(byte(Invokeinterface), byte(index >> 8), byte(index), count, 0)
+}
+
+func (a *Assembler) InvokeDynamic(index CpIndex) {
+    a.emit(byte(Invokedynamic), byte(index >> 8), byte(index), 0, 0)
+}
+
+func (a *Assembler) Newarray(atype byte) {
+    a.emit(byte(Newarray), atype)
+}
+
+func (a *Assembler) Multianewarray(index CpIndex, dimensions byte) {
+    a.emit(byte(Multianewarray), byte(index >> 8), byte(index), dimensions)
+}
+
+// Branch emits a jump to target. Branches are widened during Assemble when
+// the target is out of range of a 16 bit offset; goto_w and jsr_w stay wide
+// regardless.
+func (a *Assembler) Branch(op Opcode, target *Label) {
+    if !isBranch(op) {
+        a.fail(fmt.Sprintf("%s is not a branch instruction", op))
+        return
+    }
+    if target == nil {
+        a.fail(fmt.Sprintf("%s target is nil", op))
+        return
+    }
+    wide := false
+    if op == Gotow {
+        op, wide = Goto, true
+    } else if op == Jsrw {
+        op, wide = Jsr, true
+    }
+    a.items = append(a.items, asmItem{op: op, target: target, wide: wide})
+}
+
+func (a *Assembler) TableSwitch(low int32, dflt *Label, targets ...*Label) {
+    if dflt == nil || len(targets) == 0 {
+        a.fail("Tableswitch needs a default and at least one target")
+        return
+    }
+    if int64(low) + int64(len(targets)) - 1 > math.MaxInt32 {
+        a.fail("Tableswitch keys overflow int")
+        return
+    }
+    for _, target := range targets {
+        if target == nil {
+            a.fail("Tableswitch target is nil")
+            return
+        }
+    }
+    a.items = append(a.items, asmItem{op: Tableswitch, dflt: dflt, keys: []int32{low}, targets: targets})
+}
+
+func (a *Assembler) LookupSwitch(dflt *Label, keys []int32, targets []*Label) {
+    if dflt == nil {
+        a.fail("Lookupswitch needs a default")
+        return
+    }
+    if len(keys) != len(targets) {
+        a.fail("Lookupswitch needs one target per key")
+        return
+    }
+    for _, target := range targets {
+        if target == nil {
+            a.fail("Lookupswitch target is nil")
+            return
+        }
+    }
+    for i := 1; i < len(keys); i++ {
+        if keys[i - 1] >= keys[i] {
+            a.fail("Lookupswitch keys must be sorted in increasing order")
+            return
+        }
+    }
+    a.items = append(a.items, asmItem{op: Lookupswitch, dflt: dflt, keys: keys, targets: targets})
+}
+
+// Catch adds an exception table entry covering [start, end). A catchType of
+// 0 catches everything.
+func (a *Assembler) Catch(start *Label, end *Label, handler *Label, catchType CpIndex) {
+    a.catches = append(a.catches, asmCatch{start, end, handler, catchType})
+}
+
+// Assemble resolves labels and returns the finished Code.
+func (a *Assembler) Assemble() (*Code, error) {
+    if a.err != nil {
+        return nil, a.err
+    }
+    for i := range a.items {
+        item := &a.items[i]
+        for _, l := range item.labels() {
+            if !l.marked {
+                return nil, fmt.Errorf("Branch target of %s is never marked", item.op)
+            }
+        }
+    }
+
+    length := a.layout()
+    for a.widen() {
+        length = a.layout()
+    }
+    if length > 0xffff {
+        return nil, fmt.Errorf("Code length %d exceeds 65535 bytes", length)
+    }
+
+    code := make([]byte, 0, length)
+    for i := range a.items {
+        code = a.items[i].encode(code)
+    }
+
+    handlers := make([]ExceptionHandler, len(a.catches))
+    for i, c := range a.catches {
+        if !c.start.marked || !c.end.marked || !c.handler.marked {
+            return nil, fmt.Errorf("Exception handler %d uses a label that is never marked", i)
+        }
+        if c.start.offset >= c.end.offset {
+            return nil, fmt.Errorf("Exception handler %d covers an empty range", i)
+        }
+        handlers[i] = ExceptionHandler{
+            StartPc: uint16(c.start.offset),
+            EndPc: uint16(c.end.offset),
+            HandlerPc: uint16(c.handler.offset),
+            CatchType: c.catchType,
+        }
+    }
+
+    c := &Code{
+        MaxStack: a.MaxStack,
+        MaxLocals: a.MaxLocals,
+        ByteCode: code,
+        ExceptionHandlers: handlers,
+    }
+    if a.Descriptor != "" && a.MaxStack == 0 && a.MaxLocals == 0 {
+        if err := c.ComputeMaxs(a.ConstantPool, a.Descriptor, a.Static); err != nil {
+            return nil, err
+        }
+    }
+    return c, nil
+}
+
+func (a *Assembler) emit(code ...byte) {
+    a.items = append(a.items, asmItem{code: code})
+}
+
+func (a *Assembler) fail(msg string) {
+    if a.err == nil {
+        a.err = fmt.Errorf("%s", msg)
+    }
+}
+
+// layout assigns offsets to every item and label and returns the code length.
+func (a *Assembler) layout() int {
+    offset := 0
+    for i := range a.items {
+        item := &a.items[i]
+        if item.label != nil {
+            item.label.offset = offset
+            continue
+        }
+        item.offset = offset
+        offset += item.size()
+    }
+    return offset
+}
+
+// widen marks short branches whose target is out of range as wide, reporting
+// whether anything changed. Widening only ever grows the code so repeating
+// layout and widen terminates.
+func (a *Assembler) widen() bool {
+    changed := false
+    for i := range a.items {
+        item := &a.items[i]
+        if item.target == nil || item.wide {
+            continue
+        }
+        delta := item.target.offset - item.offset
+        if delta < -32768 || delta > 32767 {
+            item.wide = true
+            changed = true
+        }
+    }
+    return changed
+}
+
+func (item *asmItem) labels() []*Label {
+    if item.target != nil {
+        return []*Label{item.target}
+    }
+    if item.dflt != nil {
+        return append([]*Label{item.dflt}, item.targets...)
+    }
+    return nil
+}
+
+func (item *asmItem) size() int {
+    switch {
+    case item.label != nil:
+        return 0
+    case item.code != nil:
+        return len(item.code)
+    case item.op == Tableswitch:
+        return 1 + switchPadding(item.offset) + 12 + 4 * len(item.targets)
+    case item.op == Lookupswitch:
+        return 1 + switchPadding(item.offset) + 8 + 8 * len(item.targets)
+    case !item.wide:
+        return 3
+    case item.op == Goto || item.op == Jsr:
+        return 5
+    default:
+        // inverted condition jumping over a goto_w
+        return 8
+    }
+}
+
+func (item *asmItem) encode(code []byte) []byte {
+    switch {
+    case item.label != nil:
+        return code
+    case item.code != nil:
+        return append(code, item.code...)
+    case item.op == Tableswitch || item.op == Lookupswitch:
+        code = append(code, byte(item.op))
+        for i := 0; i < switchPadding(item.offset); i++ {
+            code = append(code, 0)
+        }
+        code = binary.BigEndian.AppendUint32(code, uint32(item.dflt.offset - item.offset))
+        if item.op == Tableswitch {
+            low := item.keys[0]
+            code = binary.BigEndian.AppendUint32(code, uint32(low))
+            code = binary.BigEndian.AppendUint32(code, uint32(low + int32(len(item.targets)) - 1))
+            for _, t := range item.targets {
+                code = binary.BigEndian.AppendUint32(code, uint32(t.offset - item.offset))
+            }
+        } else {
+            code = binary.BigEndian.AppendUint32(code, uint32(len(item.targets)))
+            for i, t := range item.targets {
+                code = binary.BigEndian.AppendUint32(code, uint32(item.keys[i]))
+                code = binary.BigEndian.AppendUint32(code, uint32(t.offset - item.offset))
+            }
+        }
+        return code
+    case !item.wide:
+        code = append(code, byte(item.op))
+        return binary.BigEndian.AppendUint16(code, uint16(item.target.offset - item.offset))
+    case item.op == Goto || item.op == Jsr:
+        op := Gotow
+        if item.op == Jsr {
+            op = Jsrw
+        }
+        code = append(code, byte(op))
+        return binary.BigEndian.AppendUint32(code, uint32(item.target.offset - item.offset))
+    default:
+        code = append(code, byte(invertBranch(item.op)), 0, 8, byte(Gotow))
+        return binary.BigEndian.AppendUint32(code, uint32(item.target.offset - (item.offset + 3)))
+    }
+}
+
+func switchPadding(offset int) int {
+    return (4 - (offset + 1) % 4) % 4
+}
+
+func isBranch(op Opcode) bool {
+    return (op >= Ifeq && op <= Jsr) || op == Ifnull || op == Ifnonnull || op == Gotow || op == Jsrw
+}
+
+func invertBranch(op Opcode) Opcode {
+    if op >= Ifeq && op <= Ifacmpne {
+        return Ifeq + ((op - Ifeq) ^ 1)
+    }
+    // ifnull and ifnonnull
+    return op ^ 1
+}
@@ -0,0 +1,274 @@
+package jcr
+
+import (
+    "bytes"
+    "math"
+    "strings"
+    "testing"
+    . "github.com/jasonhightower/bytecode"
+)
+
+func TestAssemble(t *testing.T) {
+    nops := func(a *Assembler, n int) {
+        for i := 0; i < n; i++ {
+            a.Op(Nop)
+        }
+    }
+    tests := []struct {
+        name string
+        build func(a *Assembler)
+        // want is the whole code, or only its start when length is set
+        want []byte
+        length int
+        // ops are opcodes expected at further offsets
+        ops map[int]Opcode
+        err string
+    }{
+        {
+            name: "short locals",
+            build: func(a *Assembler) {
+                a.Var(Iload, 0)
+                a.Var(Astore, 3)
+                a.Var(Dload, 2)
+            },
+            want: []byte{byte(Iload0), byte(Astore3), byte(Dload2)},
+        },
+        {
+            name: "byte and wide locals",
+            build: func(a *Assembler) {
+                a.Var(Iload, 4)
+                a.Var(Lstore, 300)
+                a.Iinc(1, 200)
+            },
+            want: []byte{byte(Iload), 4, byte(Wide), byte(Lstore), 1, 44, byte(Wide), byte(Iinc), 0, 1, 0, 200},
+        },
+        {
+            name: "int constants",
+            build: func(a *Assembler) {
+                a.Push(-1)
+                a.Push(100)
+                a.Push(1000)
+            },
+            want: []byte{byte(IconstM1), byte(Bipush), 100, byte(Sipush), 0x03, 0xe8},
+        },
+        {
+            name: "backward branch",
+            build: func(a *Assembler) {
+                top := a.NewLabel()
+                a.Mark(top)
+                a.Op(Nop)
+                a.Branch(Goto, top)
+            },
+            want: []byte{byte(Nop), byte(Goto), 0xff, 0xff},
+        },
+        {
+            name: "tableswitch padding",
+            build: func(a *Assembler) {
+                dflt, one := a.NewLabel(), a.NewLabel()
+                a.Op(Nop)
+                a.TableSwitch(1, dflt, one)
+                a.Mark(dflt)
+                a.Mark(one)
+                a.Op(Return)
+            },
+            want: []byte{byte(Nop), byte(Tableswitch), 0, 0, 0, 0, 0, 19, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 19, byte(Return)},
+        },
+        {
+            name: "lookupswitch padding",
+            build: func(a *Assembler) {
+                dflt, one := a.NewLabel(), a.NewLabel()
+                a.LookupSwitch(dflt, []int32{7}, []*Label{one})
+                a.Mark(one)
+                a.Op(Nop)
+                a.Mark(dflt)
+                a.Op(Return)
+            },
+            want: []byte{byte(Lookupswitch), 0, 0, 0, 0, 0, 0, 21, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 20, byte(Nop), byte(Return)},
+        },
+        {
+            name: "far goto widened",
+            build: func(a *Assembler) {
+                end := a.NewLabel()
+                a.Branch(Goto, end)
+                nops(a, 40000)
+                a.Mark(end)
+                a.Op(Return)
+            },
+            want: []byte{byte(Gotow), 0, 0, 0x9c, 0x45},
+            length: 5 + 40000 + 1,
+        },
+        {
+            name: "far condition inverted around goto_w",
+            build: func(a *Assembler) {
+                end := a.NewLabel()
+                a.Branch(Ifeq, end)
+                nops(a, 40000)
+                a.Mark(end)
+                a.Op(Return)
+            },
+            want: []byte{byte(Ifne), 0, 8, byte(Gotow), 0, 0, 0x9c, 0x45},
+            length: 8 + 40000 + 1,
+        },
+        {
+            name: "widening pushes a backward branch out of range",
+            build: func(a *Assembler) {
+                top, end := a.NewLabel(), a.NewLabel()
+                a.Mark(top)
+                a.Branch(Ifeq, end)
+                nops(a, 32762)
+                a.Branch(Goto, top)
+                a.Mark(end)
+                a.Op(Return)
+            },
+            want: []byte{byte(Ifne), 0, 8, byte(Gotow)},
+            length: 8 + 32762 + 5 + 1,
+            ops: map[int]Opcode{8 + 32762: Gotow, 8 + 32762 + 5: Return},
+        },
+        {
+            name: "wide goto kept wide",
+            build: func(a *Assembler) {
+                end := a.NewLabel()
+                a.Branch(Gotow, end)
+                a.Mark(end)
+                a.Op(Return)
+            },
+            want: []byte{byte(Gotow), 0, 0, 0, 5, byte(Return)},
+        },
+        {
+            name: "unmarked label",
+            build: func(a *Assembler) {
+                a.Branch(Goto, a.NewLabel())
+            },
+            err: "never marked",
+        },
+        {
+            name: "nil branch target",
+            build: func(a *Assembler) {
+                a.Branch(Ifnull, nil)
+            },
+            err: "target is nil",
+        },
+        {
+            name: "tableswitch keys overflow",
+            build: func(a *Assembler) {
+                l := a.NewLabel()
+                a.TableSwitch(math.MaxInt32, l, l, l)
+            },
+            err: "overflow",
+        },
+        {
+            name: "unsorted lookupswitch keys",
+            build: func(a *Assembler) {
+                l := a.NewLabel()
+                a.LookupSwitch(l, []int32{2, 1}, []*Label{l, l})
+            },
+            err: "sorted",
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            a := NewAssembler(&ConstantPool{})
+            test.build(a)
+            code, err := a.Assemble()
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            got := code.ByteCode
+            if test.length == 0 {
+                if !bytes.Equal(got, test.want) {
+                    t.Fatalf("got % x, want % x", got, test.want)
+                }
+                return
+            }
+            if len(got) != test.length {
+                t.Fatalf("got %d bytes, want %d", len(got), test.length)
+            }
+            if !bytes.HasPrefix(got, test.want) {
+                t.Fatalf("got % x..., want % x...", got[:len(test.want)], test.want)
+            }
+            for offset, op := range test.ops {
+                if Opcode(got[offset]) != op {
+                    t.Errorf("got %s at offset %d, want %s", Opcode(got[offset]), offset, op)
+                }
+            }
+        })
+    }
+}
+
+func TestAssembleMaxs(t *testing.T) {
+    cp := &ConstantPool{}
+    parse := cp.AddMethod("java/lang/Long", "parseLong", "(Ljava/lang/String;)J")
+    tests := []struct {
+        name string
+        descriptor string
+        static bool
+        maxStack uint16
+        build func(a *Assembler)
+        wantStack uint16
+        wantLocals uint16
+    }{
+        {
+            name: "arguments only",
+            descriptor: "(IJ)V",
+            build: func(a *Assembler) {
+                a.Op(Return)
+            },
+            wantStack: 0,
+            wantLocals: 4,
+        },
+        {
+            name: "static with wide values",
+            descriptor: "(Ljava/lang/String;)J",
+            static: true,
+            build: func(a *Assembler) {
+                a.Var(Aload, 0)
+                a.Ref(Invokestatic, parse)
+                a.Op(Dup2)
+                a.Var(Lstore, 1)
+                a.Op(Lreturn)
+            },
+            wantStack: 4,
+            wantLocals: 3,
+        },
+        {
+            name: "limits given",
+            descriptor: "()V",
+            maxStack: 7,
+            build: func(a *Assembler) {
+                a.Op(Return)
+            },
+            wantStack: 7,
+            wantLocals: 0,
+        },
+        {
+            name: "no descriptor",
+            build: func(a *Assembler) {
+                a.Push(1)
+                a.Op(Pop)
+                a.Op(Return)
+            },
+            wantStack: 0,
+            wantLocals: 0,
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            a := NewAssembler(cp)
+            a.Descriptor, a.Static, a.MaxStack = test.descriptor, test.static, test.maxStack
+            test.build(a)
+            code, err := a.Assemble()
+            if err != nil {
+                t.Fatal(err)
+            }
+            if code.MaxStack != test.wantStack || code.MaxLocals != test.wantLocals {
+                t.Fatalf("got stack %d and locals %d, want %d and %d", code.MaxStack, code.MaxLocals, test.wantStack, test.wantLocals)
+            }
+        })
+    }
+}
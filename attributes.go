@@ -0,0 +1,126 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+)
+
+type LineNumber struct {
+    StartPc uint16
+    Line uint16
+}
+
+// LocalVariable is an entry of LocalVariableTable. In LocalVariableTypeTable
+// the descriptor holds a generic signature instead.
+type LocalVariable struct {
+    StartPc uint16
+    Length uint16
+    NameIndex CpIndex
+    DescriptorIndex CpIndex
+    Index uint16
+}
+
+type InnerClass struct {
+    InnerClassIndex CpIndex
+    OuterClassIndex CpIndex
+    InnerNameIndex CpIndex
+    Flags AccessFlag
+}
+
+type EnclosingMethod struct {
+    ClassIndex CpIndex
+    MethodIndex CpIndex
+}
+
+func DecodeLineNumberTable(info []byte) ([]LineNumber, error) {
+    return decodeTable[LineNumber](info)
+}
+
+func DecodeLocalVariableTable(info []byte) ([]LocalVariable, error) {
+    return decodeTable[LocalVariable](info)
+}
+
+func DecodeInnerClasses(info []byte) ([]InnerClass, error) {
+    return decodeTable[InnerClass](info)
+}
+
+// DecodeExceptions returns the class indexes listed in an Exceptions
+// attribute.
+func DecodeExceptions(info []byte) ([]CpIndex, error) {
+    return decodeTable[CpIndex](info)
+}
+
+func DecodeEnclosingMethod(info []byte) (EnclosingMethod, error) {
+    var m EnclosingMethod
+    if len(info) != 4 {
+        return m, fmt.Errorf("Malformed EnclosingMethod attribute")
+    }
+    m.ClassIndex = CpIndex(info[0]) << 8 | CpIndex(info[1])
+    m.MethodIndex = CpIndex(info[2]) << 8 | CpIndex(info[3])
+    return m, nil
+}
+
+func EncodeEnclosingMethod(m EnclosingMethod) []byte {
+    return append(EncodeIndex(m.ClassIndex), EncodeIndex(m.MethodIndex)...)
+}
+
+// DecodeIndex reads attributes such as SourceFile, Signature and
+// ConstantValue whose only content is a constant pool index.
+func DecodeIndex(info []byte) (CpIndex, error) {
+    if len(info) != 2 {
+        return 0, fmt.Errorf("Malformed attribute: expected 2 bytes but found %d", len(info))
+    }
+    return CpIndex(info[0]) << 8 | CpIndex(info[1]), nil
+}
+
+func EncodeIndex(index CpIndex) []byte {
+    return []byte{byte(index >> 8), byte(index)}
+}
+
+// decodeTable reads the common layout of a u2 count followed by fixed size
+// entries.
+func decodeTable[T any](info []byte) (table []T, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            table = nil
+            err = fmt.Errorf("Malformed attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var count uint16
+    mustRead(&r, &count)
+    table = make([]T, count)
+    mustRead(&r, &table)
+    return table, nil
+}
+
+func encodeTable[T any](table []T) []byte {
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, uint16(len(table)))
+    mustWrite(&w, table)
+    return buf.Bytes()
+}
+
+// MethodParameter is an entry of the MethodParameters attribute. NameIndex
+// is 0 for a parameter without a name.
+type MethodParameter struct {
+    NameIndex CpIndex
+    Flags AccessFlag
+}
+
+func DecodeMethodParameters(info []byte) (params []MethodParameter, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            params = nil
+            err = fmt.Errorf("Malformed MethodParameters attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var count uint8
+    mustRead(&r, &count)
+    params = make([]MethodParameter, count)
+    mustRead(&r, &params)
+    return params, nil
+}
@@ -0,0 +1,57 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+)
+
+// BootstrapMethod is an entry of the BootstrapMethods attribute: a method
+// handle and the static arguments passed to it.
+type BootstrapMethod struct {
+    MethodRef CpIndex
+    Arguments []CpIndex
+}
+
+func EncodeBootstrapMethods(methods []BootstrapMethod) []byte {
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, uint16(len(methods)))
+    for _, m := range methods {
+        mustWrite(&w, m.MethodRef)
+        mustWrite(&w, uint16(len(m.Arguments)))
+        mustWrite(&w, m.Arguments)
+    }
+    return buf.Bytes()
+}
+
+func DecodeBootstrapMethods(info []byte) (methods []BootstrapMethod, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            methods = nil
+            err = fmt.Errorf("Malformed BootstrapMethods attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var count uint16
+    mustRead(&r, &count)
+    methods = make([]BootstrapMethod, count)
+    for i := range methods {
+        mustRead(&r, &methods[i].MethodRef)
+        var n uint16
+        mustRead(&r, &n)
+        methods[i].Arguments = make([]CpIndex, n)
+        mustRead(&r, &methods[i].Arguments)
+    }
+    return methods, nil
+}
+
+// BootstrapMethods decodes the class's BootstrapMethods attribute, returning
+// nil if it has none.
+func (c *Class) BootstrapMethods() ([]BootstrapMethod, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "BootstrapMethods")
+    if attr == nil {
+        return nil, nil
+    }
+    return DecodeBootstrapMethods(attr.Info)
+}
@@ -0,0 +1,359 @@
+package jcr
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+)
+
+// CallSite is an invokedynamic call site or a dynamic constant resolved to
+// the bootstrap method that links it and the static arguments passed to it.
+type CallSite struct {
+    // Index is the InvokeDynamic or Dynamic constant
+    Index CpIndex
+    Dynamic bool
+    Name string
+    Descriptor string
+    BootstrapIndex uint16
+    Handle ConstMethodHandle
+    // Owner and Method name the bootstrap method, such as
+    // java/lang/invoke/LambdaMetafactory and metafactory
+    Owner string
+    Method string
+    Arguments []CpIndex
+}
+
+// ResolveCallSite resolves the InvokeDynamic or Dynamic constant at index
+// against the class's bootstrap methods.
+func ResolveCallSite(cp *ConstantPool, bootstraps []BootstrapMethod, index CpIndex) (*CallSite, error) {
+    site := &CallSite{Index: index}
+    switch c := (*cp.Get(index)).(type) {
+    case ConstInvokeDynamic:
+        site.BootstrapIndex = c.BootstrapMethodAttrIndex
+    case ConstDynamic:
+        site.BootstrapIndex, site.Dynamic = c.BootstrapMethodAttrIndex, true
+    default:
+        return nil, fmt.Errorf("Constant at index %d is not a dynamic call site", index)
+    }
+    _, site.Name, site.Descriptor = cp.GetMemberRef(index)
+    if int(site.BootstrapIndex) >= len(bootstraps) {
+        return nil, fmt.Errorf("missing bootstrap method %d", site.BootstrapIndex)
+    }
+    bootstrap := bootstraps[site.BootstrapIndex]
+    handle, ok := (*cp.Get(bootstrap.MethodRef)).(ConstMethodHandle)
+    if !ok {
+        return nil, fmt.Errorf("bootstrap method %d is not a method handle", site.BootstrapIndex)
+    }
+    site.Handle, site.Arguments = handle, bootstrap.Arguments
+    site.Owner, site.Method, _ = cp.GetMemberRef(handle.ReferenceIndex)
+    return site, nil
+}
+
+func (s *CallSite) is(owner string, methods ...string) bool {
+    if s.Owner != owner {
+        return false
+    }
+    for _, method := range methods {
+        if s.Method == method {
+            return true
+        }
+    }
+    return false
+}
+
+// LambdaCallSite is a lambda or method reference linked by
+// LambdaMetafactory. Erased is the descriptor of the functional interface
+// method and Instantiated the one it is specialised to. The values the
+// lambda captures are the arguments of the call site.
+type LambdaCallSite struct {
+    Interface string
+    Method string
+    Erased string
+    Instantiated string
+    Implementation ConstMethodHandle
+    ImplOwner string
+    ImplName string
+    ImplDescriptor string
+    Captured []descriptor.Type
+}
+
+// Lambda recognises a LambdaMetafactory call site, returning nil for other
+// call sites.
+func (s *CallSite) Lambda(cp *ConstantPool) *LambdaCallSite {
+    if s.Dynamic || !s.is("java/lang/invoke/LambdaMetafactory", "metafactory", "altMetafactory") || len(s.Arguments) < 3 {
+        return nil
+    }
+    erased, ok1 := (*cp.Get(s.Arguments[0])).(ConstMethodType)
+    impl, ok2 := (*cp.Get(s.Arguments[1])).(ConstMethodHandle)
+    instantiated, ok3 := (*cp.Get(s.Arguments[2])).(ConstMethodType)
+    m, err := descriptor.ParseMethod(s.Descriptor)
+    if !ok1 || !ok2 || !ok3 || err != nil || !m.Return.IsObject() {
+        return nil
+    }
+    lambda := &LambdaCallSite{
+        Interface: m.Return.Class,
+        Method: s.Name,
+        Erased: cp.GetUtf8(erased.DescriptorIndex),
+        Instantiated: cp.GetUtf8(instantiated.DescriptorIndex),
+        Implementation: impl,
+        Captured: m.Args,
+    }
+    lambda.ImplOwner, lambda.ImplName, lambda.ImplDescriptor = cp.GetMemberRef(impl.ReferenceIndex)
+    return lambda
+}
+
+// ConcatCallSite is a string concatenation linked by StringConcatFactory.
+// In the recipe \1 stands for the next argument of the call site and \2 for
+// the next of Constants.
+type ConcatCallSite struct {
+    Recipe string
+    Constants []CpIndex
+    Args []descriptor.Type
+}
+
+// Concat recognises a StringConcatFactory call site, returning nil for other
+// call sites. Call sites using makeConcat, which has no recipe, are given
+// one that concatenates their arguments.
+func (s *CallSite) Concat(cp *ConstantPool) *ConcatCallSite {
+    if s.Dynamic || !s.is("java/lang/invoke/StringConcatFactory", "makeConcat", "makeConcatWithConstants") {
+        return nil
+    }
+    m, err := descriptor.ParseMethod(s.Descriptor)
+    if err != nil {
+        return nil
+    }
+    concat := &ConcatCallSite{Recipe: strings.Repeat("\x01", len(m.Args)), Args: m.Args}
+    if s.Method == "makeConcatWithConstants" {
+        if len(s.Arguments) == 0 {
+            return nil
+        }
+        recipe, ok := (*cp.Get(s.Arguments[0])).(ConstString)
+        if !ok {
+            return nil
+        }
+        concat.Recipe, concat.Constants = cp.GetUtf8(recipe.StringIndex), s.Arguments[1:]
+    }
+    return concat
+}
+
+// ConcatPart is a piece of a concatenation: literal Text, the argument of
+// the call site at Arg, or the static Constant.
+type ConcatPart struct {
+    Text string
+    Arg int
+    Constant CpIndex
+}
+
+// Parts splits the recipe into the literal text, arguments and constants it
+// concatenates, in order. Arg is -1 for parts that are not arguments.
+func (c *ConcatCallSite) Parts() []ConcatPart {
+    var parts []ConcatPart
+    text := ""
+    flush := func() {
+        if text != "" {
+            parts = append(parts, ConcatPart{Text: text, Arg: -1})
+            text = ""
+        }
+    }
+    arg, constant := 0, 0
+    for _, r := range c.Recipe {
+        switch {
+        case r == 1 && arg < len(c.Args):
+            flush()
+            parts = append(parts, ConcatPart{Arg: arg})
+            arg++
+        case r == 2 && constant < len(c.Constants):
+            flush()
+            parts = append(parts, ConcatPart{Arg: -1, Constant: c.Constants[constant]})
+            constant++
+        default:
+            text += string(r)
+        }
+    }
+    flush()
+    return parts
+}
+
+// Java renders the concatenation as a Java expression, with args as the
+// expressions of the arguments.
+func (c *ConcatCallSite) Java(cp *ConstantPool, args []string) string {
+    var texts []string
+    for _, part := range c.Parts() {
+        switch {
+        case part.Arg >= 0:
+            texts = append(texts, args[part.Arg])
+        case part.Constant != 0:
+            texts = append(texts, callSiteConstant(cp, part.Constant))
+        default:
+            texts = append(texts, javaStringLiteral(part.Text))
+        }
+    }
+    if len(texts) == 0 {
+        return "\"\""
+    }
+    return strings.Join(texts, " + ")
+}
+
+// ObjectMethodsCallSite is the toString, hashCode or equals of a record,
+// linked by ObjectMethods over the record's components.
+type ObjectMethodsCallSite struct {
+    Method string
+    Record string
+    Components []string
+    Getters []ConstMethodHandle
+}
+
+// ObjectMethods recognises an ObjectMethods call site, returning nil for
+// other call sites.
+func (s *CallSite) ObjectMethods(cp *ConstantPool) *ObjectMethodsCallSite {
+    if s.Dynamic || !s.is("java/lang/runtime/ObjectMethods", "bootstrap") || len(s.Arguments) < 2 {
+        return nil
+    }
+    record, ok1 := (*cp.Get(s.Arguments[0])).(ConstClass)
+    names, ok2 := (*cp.Get(s.Arguments[1])).(ConstString)
+    if !ok1 || !ok2 {
+        return nil
+    }
+    site := &ObjectMethodsCallSite{Method: s.Name, Record: cp.GetUtf8(record.NameIndex)}
+    if text := cp.GetUtf8(names.StringIndex); text != "" {
+        site.Components = strings.Split(text, ";")
+    }
+    for _, arg := range s.Arguments[2:] {
+        getter, ok := (*cp.Get(arg)).(ConstMethodHandle)
+        if !ok {
+            return nil
+        }
+        site.Getters = append(site.Getters, getter)
+    }
+    return site
+}
+
+// SwitchCallSite is a pattern or enum switch linked by SwitchBootstraps.
+// Kind is typeSwitch or enumSwitch and Labels holds the case labels:
+// classes, strings, integers and, for enum constants, dynamic constants or,
+// in an enumSwitch, the names of the constants.
+type SwitchCallSite struct {
+    Kind string
+    Labels []CpIndex
+}
+
+// Switch recognises a SwitchBootstraps call site, returning nil for other
+// call sites.
+func (s *CallSite) Switch(cp *ConstantPool) *SwitchCallSite {
+    if s.Dynamic || !s.is("java/lang/runtime/SwitchBootstraps", "typeSwitch", "enumSwitch") {
+        return nil
+    }
+    return &SwitchCallSite{Kind: s.Method, Labels: s.Arguments}
+}
+
+// Explain describes what a call site of a recognised kind does, such as
+// the concatenation "x = " + arg0 or the constant java.lang.Integer.MAX_VALUE,
+// returning "" for other call sites.
+func (s *CallSite) Explain(cp *ConstantPool) string {
+    if constant := s.constant(cp); constant != "" {
+        return "constant " + constant
+    }
+    if lambda := s.Lambda(cp); lambda != nil {
+        kind := strconv.Itoa(int(lambda.Implementation.ReferenceKind))
+        if int(lambda.Implementation.ReferenceKind) < len(handleKindNames) {
+            kind = handleKindNames[lambda.Implementation.ReferenceKind]
+        }
+        return fmt.Sprintf("lambda %s.%s%s implemented by %s %s.%s%s", descriptor.QualifiedName(lambda.Interface),
+            lambda.Method, lambda.Instantiated, kind, descriptor.QualifiedName(lambda.ImplOwner), lambda.ImplName, lambda.ImplDescriptor)
+    }
+    if concat := s.Concat(cp); concat != nil {
+        var args []string
+        for i := range concat.Args {
+            args = append(args, "arg" + strconv.Itoa(i))
+        }
+        return "concat " + concat.Java(cp, args)
+    }
+    if methods := s.ObjectMethods(cp); methods != nil {
+        return fmt.Sprintf("record %s of %s over [%s]", methods.Method, descriptor.QualifiedName(methods.Record),
+            strings.Join(methods.Components, ", "))
+    }
+    if sw := s.Switch(cp); sw != nil {
+        var labels []string
+        for _, label := range sw.Labels {
+            if name, ok := (*cp.Get(label)).(ConstString); ok && sw.Kind == "enumSwitch" {
+                labels = append(labels, cp.GetUtf8(name.StringIndex))
+                continue
+            }
+            labels = append(labels, callSiteConstant(cp, label))
+        }
+        return fmt.Sprintf("%s cases [%s]", sw.Kind, strings.Join(labels, ", "))
+    }
+    return ""
+}
+
+// explainCallSite explains the call site or dynamic constant at index, as
+// the comment of the instruction using it, returning "" if it doesn't
+// resolve or is of no recognised kind.
+func explainCallSite(cp *ConstantPool, bootstraps []BootstrapMethod, index CpIndex) string {
+    site, err := ResolveCallSite(cp, bootstraps, index)
+    if err != nil {
+        return ""
+    }
+    return site.Explain(cp)
+}
+
+// constant renders the value of a dynamic constant linked by
+// ConstantBootstraps in Java syntax, returning "" for other call sites.
+func (s *CallSite) constant(cp *ConstantPool) string {
+    if !s.Dynamic || s.Owner != "java/lang/invoke/ConstantBootstraps" {
+        return ""
+    }
+    t, err := descriptor.ParseField(s.Descriptor)
+    if err != nil {
+        return ""
+    }
+    switch {
+    case s.Method == "nullConstant":
+        return "null"
+    case s.Method == "primitiveClass":
+        if primitive, err := descriptor.ParseField(s.Name); err == nil && primitive.IsPrimitive() {
+            return primitive.Java() + ".class"
+        }
+    case s.Method == "enumConstant" && t.IsObject():
+        return descriptor.QualifiedName(t.Class) + "." + s.Name
+    case s.Method == "getStaticFinal":
+        owner := t.Class
+        if len(s.Arguments) > 0 {
+            class, ok := (*cp.Get(s.Arguments[0])).(ConstClass)
+            if !ok {
+                return ""
+            }
+            owner = cp.GetUtf8(class.NameIndex)
+        }
+        if owner != "" {
+            return descriptor.QualifiedName(owner) + "." + s.Name
+        }
+    case s.Method == "invoke" && len(s.Arguments) > 0:
+        var args []string
+        for _, arg := range s.Arguments[1:] {
+            args = append(args, callSiteConstant(cp, arg))
+        }
+        return callSiteConstant(cp, s.Arguments[0]) + "(" + strings.Join(args, ", ") + ")"
+    }
+    return ""
+}
+
+// callSiteConstant renders a static argument in Java syntax where it has
+// one.
+func callSiteConstant(cp *ConstantPool, index CpIndex) string {
+    switch c := (*cp.Get(index)).(type) {
+    case ConstClass:
+        return descriptor.Object(cp.GetUtf8(c.NameIndex)).Java() + ".class"
+    case ConstMethodType:
+        return cp.GetUtf8(c.DescriptorIndex)
+    case ConstMethodHandle:
+        owner, name, _ := cp.GetMemberRef(c.ReferenceIndex)
+        return descriptor.QualifiedName(owner) + "::" + name
+    case ConstDynamic:
+        // its value is only known once its own bootstrap method runs
+        _, name, desc := cp.GetMemberRef(index)
+        return "<dynamic " + name + ":" + desc + ">"
+    }
+    return javaLiteral(cp, index, "")
+}
@@ -0,0 +1,247 @@
+package jcr
+
+import (
+    "fmt"
+    "io"
+    "strings"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// CFG is the control flow graph of a method, with its basic blocks in code
+// order.
+type CFG struct {
+    Blocks []*BasicBlock
+}
+
+// BasicBlock is a run of instructions that is only entered at its first
+// instruction and only left after its last one.
+type BasicBlock struct {
+    Start int
+    End int
+    Instructions []Instruction
+    Edges []Edge
+}
+
+type EdgeKind int
+
+const (
+    EdgeFall EdgeKind = iota
+    EdgeGoto
+    EdgeTrue
+    EdgeFalse
+    EdgeCase
+    EdgeDefault
+    EdgeException
+    EdgeJsr
+    EdgeRet
+)
+
+// Edge leads to the block at index Target. Case edges carry the switch keys
+// taking them and exception edges the caught class, "" catching anything.
+type Edge struct {
+    Kind EdgeKind
+    Target int
+    Keys []int32
+    CatchType string
+}
+
+// BuildCFG splits a method's code into basic blocks and connects them.
+// Exception edges leave every block inside a protected range.
+func BuildCFG(cp *ConstantPool, code *Code) (*CFG, error) {
+    instrs, err := DecodeInstructions(code.ByteCode)
+    if err != nil {
+        return nil, err
+    }
+    leaders := map[int]bool{0: true}
+    var returns []int
+    for _, instr := range instrs {
+        for _, target := range instr.Targets() {
+            leaders[target] = true
+        }
+        if len(instr.Targets()) > 0 || instr.IsTerminal() {
+            leaders[instr.Offset + instr.Length()] = true
+        }
+        if op := instr.Opcode; op == Jsr || op == Jsrw {
+            returns = append(returns, instr.Offset + instr.Length())
+        }
+    }
+    for _, handler := range code.ExceptionHandlers {
+        leaders[int(handler.StartPc)] = true
+        leaders[int(handler.EndPc)] = true
+        leaders[int(handler.HandlerPc)] = true
+    }
+
+    g := &CFG{}
+    index := make(map[int]int)
+    for _, instr := range instrs {
+        if leaders[instr.Offset] {
+            index[instr.Offset] = len(g.Blocks)
+            g.Blocks = append(g.Blocks, &BasicBlock{Start: instr.Offset})
+        }
+        b := g.Blocks[len(g.Blocks) - 1]
+        b.Instructions = append(b.Instructions, instr)
+        b.End = instr.Offset + instr.Length()
+    }
+    blockAt := func(pc int) (int, error) {
+        i, ok := index[pc]
+        if !ok {
+            return 0, fmt.Errorf("offset %d is not the start of an instruction", pc)
+        }
+        return i, nil
+    }
+
+    for i, b := range g.Blocks {
+        instr := b.Instructions[len(b.Instructions) - 1]
+        op := instr.Opcode
+        if op == Wide {
+            op = Opcode(instr.Operands[0])
+        }
+        var targets []int
+        var edges []Edge
+        switch {
+        case op == Tableswitch || op == Lookupswitch:
+            dflt, keys, cases := instr.Switch()
+            byTarget := make(map[int]int)
+            for k, target := range cases {
+                if at, ok := byTarget[target]; ok {
+                    edges[at].Keys = append(edges[at].Keys, keys[k])
+                    continue
+                }
+                byTarget[target] = len(edges)
+                targets = append(targets, target)
+                edges = append(edges, Edge{Kind: EdgeCase, Keys: []int32{keys[k]}})
+            }
+            targets = append(targets, dflt)
+            edges = append(edges, Edge{Kind: EdgeDefault})
+        case op == Goto || op == Gotow:
+            targets = instr.Targets()
+            edges = []Edge{{Kind: EdgeGoto}}
+        case op == Jsr || op == Jsrw:
+            targets = instr.Targets()
+            edges = []Edge{{Kind: EdgeJsr}}
+        case op == Ret:
+            targets = returns
+            for range returns {
+                edges = append(edges, Edge{Kind: EdgeRet})
+            }
+        case len(instr.Targets()) > 0:
+            targets = []int{instr.Targets()[0], b.End}
+            edges = []Edge{{Kind: EdgeTrue}, {Kind: EdgeFalse}}
+        case !instr.IsTerminal() && i + 1 < len(g.Blocks):
+            targets = []int{b.End}
+            edges = []Edge{{Kind: EdgeFall}}
+        }
+        for k, target := range targets {
+            if edges[k].Target, err = blockAt(target); err != nil {
+                return nil, err
+            }
+        }
+        b.Edges = edges
+    }
+
+    for _, handler := range code.ExceptionHandlers {
+        target, err := blockAt(int(handler.HandlerPc))
+        if err != nil {
+            return nil, err
+        }
+        catchType := ""
+        if !handler.IsFinally() {
+            catchType = cp.GetClassName(handler.CatchType)
+        }
+        for _, b := range g.Blocks {
+            if b.Start >= int(handler.StartPc) && b.End <= int(handler.EndPc) {
+                b.Edges = append(b.Edges, Edge{Kind: EdgeException, Target: target, CatchType: catchType})
+            }
+        }
+    }
+    return g, nil
+}
+
+// WriteDOT writes the control flow graph of a method as a Graphviz digraph,
+// with the instructions of each block in its node.
+func WriteDOT(w *io.Writer, c *Class, method *Method) error {
+    cp := c.ConstantPool
+    code := method.Code(cp)
+    if code == nil {
+        return fmt.Errorf("%s has no code", cp.GetUtf8(method.NameIndex))
+    }
+    g, err := BuildCFG(cp, code)
+    if err != nil {
+        return err
+    }
+    bootstraps, err := c.BootstrapMethods()
+    if err != nil {
+        return err
+    }
+
+    name := c.Name() + "." + cp.GetUtf8(method.NameIndex) + cp.GetUtf8(method.DescriptorIndex)
+    io.WriteString(*w, fmt.Sprintf("digraph %s {\n", dotString(name)))
+    io.WriteString(*w, "    node [shape=box, fontname=\"monospace\"];\n")
+    for _, b := range g.Blocks {
+        var label strings.Builder
+        for _, instr := range b.Instructions {
+            text := krakatauInstruction(cp, instr, bootstraps, false)
+            // switch targets are drawn as edges
+            text, _, _ = strings.Cut(text, "\n")
+            label.WriteString(fmt.Sprintf("%d: %s\n", instr.Offset, text))
+        }
+        io.WriteString(*w, fmt.Sprintf("    %s [label=%s];\n", dotNode(b), dotLabel(label.String())))
+    }
+    for _, b := range g.Blocks {
+        for _, edge := range b.Edges {
+            var attrs []string
+            switch edge.Kind {
+            case EdgeTrue:
+                attrs = append(attrs, "label=\"true\"")
+            case EdgeFalse:
+                attrs = append(attrs, "label=\"false\"")
+            case EdgeCase:
+                var keys []string
+                for _, key := range edge.Keys {
+                    keys = append(keys, fmt.Sprint(key))
+                }
+                attrs = append(attrs, "label=" + dotString("case " + strings.Join(keys, ", ")))
+            case EdgeDefault:
+                attrs = append(attrs, "label=\"default\"")
+            case EdgeException:
+                catchType := edge.CatchType
+                if catchType == "" {
+                    catchType = "any"
+                }
+                attrs = append(attrs, "style=dashed", "label=" + dotString(catchType))
+            case EdgeJsr:
+                attrs = append(attrs, "label=\"jsr\"")
+            case EdgeRet:
+                attrs = append(attrs, "style=dotted", "label=\"ret\"")
+            }
+            text := fmt.Sprintf("    %s -> %s", dotNode(b), dotNode(g.Blocks[edge.Target]))
+            if len(attrs) > 0 {
+                text += " [" + strings.Join(attrs, ", ") + "]"
+            }
+            io.WriteString(*w, text + ";\n")
+        }
+    }
+    io.WriteString(*w, "}\n")
+    return nil
+}
+
+func dotNode(b *BasicBlock) string {
+    return krakatauLabel(b.Start)
+}
+
+// dotString quotes s as a DOT string.
+func dotString(s string) string {
+    s = strings.ReplaceAll(s, "\\", "\\\\")
+    s = strings.ReplaceAll(s, "\"", "\\\"")
+    return "\"" + s + "\""
+}
+
+// dotLabel quotes a multi-line label, left aligning every line.
+func dotLabel(s string) string {
+    lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+    for i, line := range lines {
+        quoted := dotString(line)
+        lines[i] = quoted[1:len(quoted) - 1]
+    }
+    return "\"" + strings.Join(lines, "\\l") + "\\l\""
+}
@@ -0,0 +1,170 @@
+package jcr
+
+import (
+    "archive/zip"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// TypeHierarchy answers the questions about classes that frame computation
+// needs to merge reference types.
+type TypeHierarchy interface {
+    // SuperClass returns the internal name of the super class of name, or ""
+    // for java/lang/Object.
+    SuperClass(name string) (string, error)
+    IsInterface(name string) (bool, error)
+}
+
+// HierarchyFunc adapts a callback returning the super class and interface
+// flag of a class to a TypeHierarchy.
+type HierarchyFunc func(name string) (string, bool, error)
+
+func (f HierarchyFunc) SuperClass(name string) (string, error) {
+    super, _, err := f(name)
+    return super, err
+}
+
+func (f HierarchyFunc) IsInterface(name string) (bool, error) {
+    _, isInterface, err := f(name)
+    return isInterface, err
+}
+
+// ClassPath finds classes by internal name in a list of directories and jar
+// files. Classes are read at most once.
+type ClassPath struct {
+    entries []string
+    classes map[string]*Class
+    jars map[string]*zip.ReadCloser
+}
+
+func NewClassPath(entries ...string) *ClassPath {
+    return &ClassPath{
+        entries: entries,
+        classes: make(map[string]*Class),
+        jars: make(map[string]*zip.ReadCloser),
+    }
+}
+
+// Add makes a class that isn't on disk, such as one being generated, visible
+// through the class path.
+func (p *ClassPath) Add(c *Class) {
+    p.classes[c.Name()] = c
+}
+
+func (p *ClassPath) Find(name string) (*Class, error) {
+    if c, ok := p.classes[name]; ok {
+        return c, nil
+    }
+    file := name + ".class"
+    for _, entry := range p.entries {
+        var c *Class
+        var err error
+        if strings.HasSuffix(entry, ".jar") || strings.HasSuffix(entry, ".zip") {
+            c, err = p.findInJar(entry, file)
+        } else {
+            c, err = findInDir(entry, file)
+        }
+        if err != nil {
+            return nil, err
+        }
+        if c != nil {
+            p.classes[name] = c
+            return c, nil
+        }
+    }
+    return nil, fmt.Errorf("Class %s not found on the class path", name)
+}
+
+func (p *ClassPath) SuperClass(name string) (string, error) {
+    c, err := p.Find(name)
+    if err != nil {
+        return "", err
+    }
+    return c.SuperName(), nil
+}
+
+func (p *ClassPath) IsInterface(name string) (bool, error) {
+    c, err := p.Find(name)
+    if err != nil {
+        return false, err
+    }
+    return c.Flags.IsInterface(), nil
+}
+
+func (p *ClassPath) Close() error {
+    for path, jar := range p.jars {
+        jar.Close()
+        delete(p.jars, path)
+    }
+    return nil
+}
+
+func (p *ClassPath) findInJar(path string, file string) (*Class, error) {
+    jar, ok := p.jars[path]
+    if !ok {
+        var err error
+        jar, err = zip.OpenReader(path)
+        if err != nil {
+            return nil, err
+        }
+        p.jars[path] = jar
+    }
+    f, err := jar.Open(file)
+    if err != nil {
+        return nil, nil
+    }
+    defer f.Close()
+    return readClassFile(f)
+}
+
+func findInDir(dir string, file string) (*Class, error) {
+    f, err := os.Open(filepath.Join(dir, filepath.FromSlash(file)))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return readClassFile(f)
+}
+
+// readClassFile reads a class, turning the panics of a malformed file into
+// an error.
+func readClassFile(f io.Reader) (c *Class, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("Malformed class file: %v", r)
+        }
+    }()
+    return ReadClass(&f)
+}
+
+// ReadJar reads every class in a jar file, in the order they are stored.
+func ReadJar(path string) ([]*Class, error) {
+    jar, err := zip.OpenReader(path)
+    if err != nil {
+        return nil, err
+    }
+    defer jar.Close()
+
+    var classes []*Class
+    for _, file := range jar.File {
+        if !strings.HasSuffix(file.Name, ".class") {
+            continue
+        }
+        f, err := file.Open()
+        if err != nil {
+            return nil, err
+        }
+        c, err := readClassFile(f)
+        f.Close()
+        if err != nil {
+            return nil, fmt.Errorf("%s: %s", file.Name, err)
+        }
+        classes = append(classes, c)
+    }
+    return classes, nil
+}
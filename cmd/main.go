@@ -1,30 +1,97 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
     . "github.com/jasonhightower/jcr"
 )
 
 const (
     OutputKrakatau string = "krakatau"
     OutputJavap string = "javap"
+    OutputClass string = "class"
+    OutputJasmin string = "jasmin"
+    OutputJSON string = "json"
+    OutputStub string = "stub"
+    OutputJava string = "java"
+    OutputHex string = "hex"
+
+    InputClass string = "class"
+    InputKrakatau string = "krakatau"
+    InputJSON string = "json"
 )
 
-func chooseWriter(output *string) ClassWriter {
+func chooseWriter(output *string, lossless *bool) ClassWriter {
     if *output == OutputKrakatau {
-        return KrakatauWriter{}
+        return KrakatauWriter{Lossless: *lossless}
+    }
+    if *output == OutputClass {
+        return ClassFileWriter{}
+    }
+    if *output == OutputJasmin {
+        return JasminWriter{}
+    }
+    if *output == OutputJSON {
+        return JSONWriter{}
+    }
+    if *output == OutputStub {
+        return StubWriter{}
+    }
+    if *output == OutputJava {
+        return DecompileWriter{}
     }
     return JavapWriter{}
 }
 
+func chooseReader(input *string) func(r *io.Reader) (*Class, error) {
+    if *input == InputKrakatau {
+        return ReadKrakatau
+    }
+    if *input == InputJSON {
+        return ReadJSON
+    }
+    return ReadClass
+}
+
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == "cfg" {
+        cfgCommand(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "html" {
+        htmlCommand(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "uml" {
+        umlCommand(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "nest" {
+        nestCommand(os.Args[2:])
+        return
+    }
+    if len(os.Args) > 1 && os.Args[1] == "sealed" {
+        sealedCommand(os.Args[2:])
+        return
+    }
+
     classFile := flag.String("f", "", "Class file to read")
     printUsage := flag.Bool("h", false, "Help")
-    output := flag.String("o", OutputKrakatau, fmt.Sprintf("Output format (%s | %s)", OutputKrakatau, OutputJavap))
+    output := flag.String("o", OutputKrakatau, fmt.Sprintf("Output format (%s | %s | %s | %s | %s | %s | %s | %s)", OutputKrakatau, OutputJavap, OutputJasmin, OutputJSON, OutputStub, OutputJava, OutputClass, OutputHex))
+    input := flag.String("i", InputClass, fmt.Sprintf("Input format (%s | %s | %s)", InputClass, InputKrakatau, InputJSON))
+    check := flag.Bool("check", false, "Verify max_stack and max_locals of every method")
+    lossless := flag.Bool("lossless", false, "Keep the constant pool and raw attributes so krakatau output reassembles to identical bytes")
+    outDir := flag.String("out", "", "Directory to write the stubs or decompiled sources of a jar (-f app.jar -o stub|java) into")
 
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: jcr [flags]\n       jcr cfg -f Foo.class -m 'run(I)V'\n       jcr html app.jar -out site/\n       jcr uml -f app.jar [-format plantuml|mermaid]\n       jcr nest -f app.jar\n       jcr sealed -f app.jar [-cp lib.jar:classes/]\n")
+        flag.PrintDefaults()
+    }
     flag.Parse()
 
     if *printUsage {
@@ -32,28 +99,60 @@ func main() {
         return
     }
 
-    var r io.Reader
-    if *classFile != "" {
-        f, err := os.Open(*classFile)
-        checkErr(err)
-        defer f.Close()
-
-        r = f
-    } else {
-        r = os.Stdin
+    if strings.HasSuffix(*classFile, ".jar") && (*output == OutputStub || *output == OutputJava) {
+        classes, err := ReadJar(*classFile)
+        if err == nil && *output == OutputStub {
+            err = WriteStubs(classes, *outDir)
+        } else if err == nil {
+            err = WriteDecompiled(classes, *outDir)
+        }
+        if err != nil {
+            fmt.Printf("%s\n", err)
+            os.Exit(1)
+        }
+        return
     }
-    
-    class, err := ReadClass(&r)
-    if err != nil {
-        fmt.Printf("%s\n", err)
-        os.Exit(1)
+
+    if *output == OutputHex {
+        // the bytes are dumped as they are, so that broken files can be read
+        var data []byte
+        var err error
+        if *input == InputClass && *classFile != "" {
+            data, err = os.ReadFile(*classFile)
+        } else if *input == InputClass {
+            data, err = io.ReadAll(os.Stdin)
+        } else {
+            var buf bytes.Buffer
+            var w io.Writer = &buf
+            err = WriteClass(&w, readClass(*classFile, input))
+            data = buf.Bytes()
+        }
+        checkErr(err)
+        var out io.Writer = os.Stdout
+        if err := DumpClass(&out, data); err != nil {
+            // the dump already ends with the error
+            os.Exit(1)
+        }
+        return
     }
 
+    class := readClass(*classFile, input)
+
+    if *check {
+        if err := class.CheckMaxs(); err != nil {
+            fmt.Printf("%s\n", err)
+            os.Exit(1)
+        }
+        return
+    }
 
     var out io.Writer = os.Stdout
 
-    writer := chooseWriter(output)
-    writer.Write(&out, class)
+    writer := chooseWriter(output, lossless)
+    if err := writer.Write(&out, class); err != nil {
+        fmt.Fprintf(os.Stderr, "%s\n", err)
+        os.Exit(1)
+    }
     
     /*
     if *output == OutputJavap {
@@ -107,8 +206,194 @@ func main() {
     */
 }
 
+// readClass reads the class in file, or standard input when file is empty.
+func readClass(file string, input *string) *Class {
+    var r io.Reader
+    if file != "" {
+        f, err := os.Open(file)
+        checkErr(err)
+        defer f.Close()
+
+        r = f
+    } else {
+        r = os.Stdin
+    }
+    
+    class, err := chooseReader(input)(&r)
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
+    return class
+}
+
+// cfgCommand implements "jcr cfg", writing the control flow graph of one
+// method in Graphviz DOT format.
+func cfgCommand(args []string) {
+    flags := flag.NewFlagSet("cfg", flag.ExitOnError)
+    classFile := flags.String("f", "", "Class file to read")
+    input := flags.String("i", InputClass, fmt.Sprintf("Input format (%s | %s | %s)", InputClass, InputKrakatau, InputJSON))
+    selector := flags.String("m", "", "Method to graph, as name or name plus descriptor, e.g. 'run(I)V'")
+    flags.Parse(args)
+
+    class := readClass(*classFile, input)
+    method, err := class.FindMethod(*selector)
+    if err == nil {
+        var out io.Writer = os.Stdout
+        err = WriteDOT(&out, class, method)
+    }
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
+}
+
+// htmlCommand implements "jcr html", writing a static site for browsing the
+// classes of a jar.
+func htmlCommand(args []string) {
+    flags := flag.NewFlagSet("html", flag.ExitOnError)
+    outDir := flags.String("out", "site", "Directory to write the site into")
+    jar := ""
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        jar, args = args[0], args[1:]
+    }
+    flags.Parse(args)
+    if jar == "" {
+        jar = flags.Arg(0)
+    }
+    if jar == "" {
+        fmt.Printf("usage: jcr html app.jar -out site/\n")
+        os.Exit(2)
+    }
+
+    classes, err := ReadJar(jar)
+    if err == nil {
+        err = WriteHTML(classes, *outDir)
+    }
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
+}
+
+// umlCommand implements "jcr uml", writing a class diagram of a jar or a
+// single class.
+func umlCommand(args []string) {
+    flags := flag.NewFlagSet("uml", flag.ExitOnError)
+    classFile := flags.String("f", "", "Jar or class file to read")
+    input := flags.String("i", InputClass, fmt.Sprintf("Input format of a single class (%s | %s | %s)", InputClass, InputKrakatau, InputJSON))
+    format := flags.String("format", UMLPlantUML, fmt.Sprintf("Diagram format (%s | %s)", UMLPlantUML, UMLMermaid))
+    packages := flags.String("package", "", "Comma separated package prefixes to include, e.g. com.example.model")
+    visibility := flags.String("visibility", "private", "Least visible classes and members shown (public | protected | package | private)")
+    flags.Parse(args)
+
+    var classes []*Class
+    if strings.HasSuffix(*classFile, ".jar") {
+        var err error
+        if classes, err = ReadJar(*classFile); err != nil {
+            fmt.Printf("%s\n", err)
+            os.Exit(1)
+        }
+    } else {
+        classes = []*Class{readClass(*classFile, input)}
+    }
+    opts := UMLOptions{Format: *format, Visibility: *visibility}
+    if *packages != "" {
+        opts.Packages = strings.Split(*packages, ",")
+    }
+    var out io.Writer = os.Stdout
+    if err := WriteUML(&out, classes, opts); err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
+}
+
+// nestCommand implements "jcr nest", printing how the classes of a jar nest
+// in each other and any disagreement between nest hosts and members.
+func nestCommand(args []string) {
+    flags := flag.NewFlagSet("nest", flag.ExitOnError)
+    classFile := flags.String("f", "", "Jar to read")
+    flags.Parse(args)
+
+    classes, err := ReadJar(*classFile)
+    var top []*NestedClass
+    if err == nil {
+        top, err = ResolveNesting(classes)
+    }
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
+    var print func(n *NestedClass, indent string)
+    print = func(n *NestedClass, indent string) {
+        line := indent + n.Name
+        if n.Kind != NestingTopLevel {
+            line += " (" + n.Kind.String()
+            if n.SimpleName != "" {
+                line += " " + n.SimpleName
+            }
+            if n.Method != "" {
+                line += " in " + n.Method
+            }
+            line += ")"
+        }
+        if n.Class == nil {
+            line += " [not in jar]"
+        }
+        fmt.Println(line)
+        for _, nested := range n.Nested {
+            print(nested, indent + "    ")
+        }
+    }
+    for _, n := range top {
+        print(n, "")
+    }
+    problems := CheckNests(classes)
+    for _, problem := range problems {
+        fmt.Printf("error: %s\n", problem)
+    }
+    if len(problems) > 0 {
+        os.Exit(1)
+    }
+}
+
 func checkErr(err error) {
     if err != nil {
         panic(err)
     }
 }
+
+// sealedCommand implements "jcr sealed", checking the sealed classes of a jar
+// against their permitted subclasses, which may live in the jars on -cp.
+func sealedCommand(args []string) {
+    flags := flag.NewFlagSet("sealed", flag.ExitOnError)
+    classFile := flags.String("f", "", "Jar to read")
+    classPath := flags.String("cp", "", "Jars and directories holding the classes outside the jar")
+    flags.Parse(args)
+
+    classes, err := ReadJar(*classFile)
+    if err != nil {
+        fmt.Printf("%s\n", err)
+        os.Exit(1)
+    }
+    var path *ClassPath
+    if *classPath != "" {
+        path = NewClassPath(filepath.SplitList(*classPath)...)
+    }
+    for _, c := range classes {
+        permitted, err := c.PermittedSubclasses()
+        if err == nil && permitted != nil {
+            fmt.Printf("%s permits %s\n", c.Name(), strings.Join(permitted, ", "))
+        }
+    }
+    problems := CheckSealed(classes, path)
+    if path != nil {
+        path.Close()
+    }
+    for _, problem := range problems {
+        fmt.Printf("error: %s\n", problem)
+    }
+    if len(problems) > 0 {
+        os.Exit(1)
+    }
+}
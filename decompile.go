@@ -0,0 +1,2888 @@
+package jcr
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "strings"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// DecompileWriter prints a class as Java source. Method bodies are rebuilt
+// from their code: the stack is replayed into expressions, short circuit
+// conditions and conditional values are folded back together and the
+// control flow graph is structured into if, loop, switch and try statements.
+// A method that can't be structured keeps a compilable body that throws
+// UnsupportedOperationException, with its bytecode in a comment.
+type DecompileWriter struct {}
+
+func (d DecompileWriter) Write(w *io.Writer, c *Class) error {
+    return writeSource(w, c, map[string]*Class{c.Name(): c}, true)
+}
+
+// WriteDecompiled decompiles every top level class into dir, in a directory
+// tree that mirrors the packages.
+func WriteDecompiled(classes []*Class, dir string) error {
+    return writeSources(classes, dir, true)
+}
+
+// decompileError aborts the decompilation of a method. It is raised with
+// panic and turned into the fallback body.
+type decompileError struct {
+    message string
+}
+
+func (d *decompiler) fail(format string, args ...any) {
+    panic(decompileError{fmt.Sprintf(format, args...)})
+}
+
+// splitDescriptor splits a method descriptor, failing on a malformed one.
+func (d *decompiler) splitDescriptor(s string) ([]string, string) {
+    args, ret, err := splitMethodDescriptor(s)
+    if err != nil {
+        d.fail("%s", err)
+    }
+    return args, ret
+}
+
+// jexpr is a Java expression. Types are field descriptors, with "null" for
+// the null literal; op selects how the other fields are used.
+type jexpr struct {
+    op string
+    text string
+    typ string
+    args []*jexpr
+    value int64
+    local *jlocal
+}
+
+type jlocal struct {
+    name string
+    typ string
+    types []string
+    slot int
+    param bool
+    catchVar bool
+}
+
+type jstmt struct {
+    kind string
+    expr *jexpr
+    target *jexpr
+    local *jlocal
+    body []*jstmt
+    other []*jstmt
+    cases []jcase
+    catches []jcatch
+    loop *jstmt
+    label string
+    labeled bool
+    finally bool
+}
+
+type jcase struct {
+    labels []string
+    body []*jstmt
+}
+
+type jcatch struct {
+    types []string
+    local *jlocal
+    body []*jstmt
+}
+
+const (
+    termFall = iota
+    termGoto
+    termCond
+    termSwitch
+    termExit
+)
+
+type dblock struct {
+    start int
+    end int
+    instrs []Instruction
+    entry []*jexpr
+    stmts []*jstmt
+    stack []*jexpr
+    term int
+    cond *jexpr
+    target int
+    keys []int32
+    targets []int
+    dflt int
+    handler bool
+    boundary bool
+    translated bool
+}
+
+type decompiler struct {
+    s *stubber
+    class *Class
+    cp *ConstantPool
+    method *Method
+    code *Code
+    bootstraps []BootstrapMethod
+    static bool
+    instrs []Instruction
+    blocks []*dblock
+    locals map[string]*jlocal
+    allLocals []*jlocal
+    defs map[int]int
+    uses map[int]int
+    entry map[int]int
+    parent []int
+    vars map[int]*jlocal
+    read map[int]bool
+    lvt []LocalVariable
+    names map[string]bool
+    ranges map[int][]pcRange
+    catchAll map[int]bool
+    handlers []int
+    openHandlers map[int]bool
+    opened map[int]bool
+    labels int
+    // outer is the decompiler of the method a lambda body is inlined into
+    outer *decompiler
+    // inlined are the synthetic methods of lambdas written in the body
+    inlined []*Method
+}
+
+type dctx struct {
+    loop bool
+    header int
+    exit int
+    stmt *jstmt
+    parent *dctx
+}
+
+// methodBody decompiles a method into the lines of its body, falling back
+// to a commented bytecode listing.
+func (s *stubber) methodBody(c *Class, method *Method, names []string, skip int) (lines []string) {
+    if method.Code(c.ConstantPool) == nil {
+        return []string{"throw new UnsupportedOperationException();"}
+    }
+    d := s.newDecompiler(c, method)
+    imports := s.imports.save()
+    defer func() {
+        if r := recover(); r != nil {
+            s.imports.restore(imports)
+            failure, ok := r.(decompileError)
+            if !ok {
+                failure = decompileError{fmt.Sprint(r)}
+            }
+            lines = d.fallback(failure.message)
+        }
+    }()
+    lines = d.decompile(names, skip)
+    for _, lambda := range d.inlined {
+        s.inlined[lambda] = true
+    }
+    return lines
+}
+
+// newDecompiler returns a decompiler for a method of c that has code.
+func (s *stubber) newDecompiler(c *Class, method *Method) *decompiler {
+    bootstraps, err := c.BootstrapMethods()
+    if err != nil {
+        bootstraps = nil
+    }
+    return &decompiler{
+        s: s,
+        class: c,
+        cp: c.ConstantPool,
+        method: method,
+        code: method.Code(c.ConstantPool),
+        bootstraps: bootstraps,
+        static: method.Flags.IsStatic(),
+        locals: make(map[string]*jlocal),
+        defs: make(map[int]int),
+        uses: make(map[int]int),
+        entry: make(map[int]int),
+        vars: make(map[int]*jlocal),
+        read: make(map[int]bool),
+        names: make(map[string]bool),
+        openHandlers: make(map[int]bool),
+        opened: make(map[int]bool),
+    }
+}
+
+type savedImports struct {
+    simple map[string]string
+    used map[string]bool
+}
+
+// save copies the imports so the names claimed by a method that can't be
+// decompiled can be dropped again.
+func (s *stubImports) save() savedImports {
+    saved := savedImports{make(map[string]string), make(map[string]bool)}
+    for k, v := range s.simple {
+        saved.simple[k] = v
+    }
+    for k, v := range s.used {
+        saved.used[k] = v
+    }
+    return saved
+}
+
+func (s *stubImports) restore(saved savedImports) {
+    s.simple, s.used = saved.simple, saved.used
+}
+
+func (d *decompiler) fallback(reason string) []string {
+    lines := []string{"/* jcr: could not decompile: " + strings.ReplaceAll(reason, "*/", "*\\/")}
+    if instructions, err := DecodeInstructions(d.code.ByteCode); err == nil {
+        for _, instr := range instructions {
+            text := krakatauInstruction(d.cp, instr, d.bootstraps, false)
+            for _, line := range strings.Split(text, "\n") {
+                lines = append(lines, fmt.Sprintf(" * %4d: %s", instr.Offset, strings.ReplaceAll(line, "*/", "*\\/")))
+            }
+        }
+    }
+    lines = append(lines, " */")
+    if d.cp.GetUtf8(d.method.NameIndex) == "<clinit>" {
+        // a static initializer must be able to complete normally
+        return append(lines, "if (true) throw new UnsupportedOperationException();")
+    }
+    if d.cp.GetUtf8(d.method.NameIndex) == "<init>" {
+        // a super class constructor that can't be read is left out, as
+        // the fallback has no way to report it
+        if call, err := d.s.superCall(d.class); err == nil && call != "" {
+            lines = append(lines, call)
+        }
+    }
+    return append(lines, "throw new UnsupportedOperationException();")
+}
+
+func (d *decompiler) decompile(names []string, skip int) []string {
+    var lines []string
+    d.statements(&lines, d.body(names, skip), "")
+    return lines
+}
+
+// body decompiles the method into statements.
+func (d *decompiler) body(names []string, skip int) []*jstmt {
+    instructions, err := DecodeInstructions(d.code.ByteCode)
+    if err != nil {
+        d.fail("%s", err)
+    }
+    d.instrs = instructions
+    if attr := FindAttribute(d.cp, d.code.Attributes, "LocalVariableTable"); attr != nil {
+        if d.lvt, err = DecodeLocalVariableTable(attr.Info); err != nil {
+            d.fail("%s", err)
+        }
+    }
+    d.tryReturns()
+    d.handlerRanges()
+    d.buildBlocks()
+    d.variables()
+    d.parameters(names)
+    // conditional values are folded before the block using them is
+    // translated, so that it sees the whole expression
+    for {
+        d.reduce()
+        next := -1
+        for i, b := range d.blocks {
+            if !b.translated {
+                next = i
+                break
+            }
+        }
+        if next < 0 {
+            break
+        }
+        d.translate(next)
+    }
+    for _, b := range d.blocks {
+        if len(b.stack) > 0 || len(b.entry) > 0 && !b.handler {
+            d.fail("value left on the stack at offset %d", b.start)
+        }
+    }
+
+    body := d.region(0, len(d.blocks), -1, nil)
+    if n := len(body); n > 0 && body[n - 1].kind == "return" && body[n - 1].expr == nil {
+        body = body[:n - 1]
+    }
+    if d.cp.GetUtf8(d.method.NameIndex) == "<init>" {
+        body = d.constructor(body, skip)
+    }
+    body = d.stringSwitches(body)
+    return d.declare(body, true)
+}
+
+// constructor checks that the body starts with the call to another
+// constructor and drops the implicit super(). Inner classes store their
+// outer instance before that call, from a parameter the source doesn't have.
+func (d *decompiler) constructor(body []*jstmt, skip int) []*jstmt {
+    if skip > 0 {
+        outer := d.vars[d.find(d.entry[1])]
+        for len(body) > 0 && body[0].kind == "assign" && body[0].expr.op == "local" && body[0].expr.local == outer {
+            body = body[1:]
+        }
+        if stmtsMention(body, outer) {
+            d.fail("the outer instance is used in the constructor")
+        }
+    }
+    if len(body) == 0 || body[0].kind != "expr" || body[0].expr.op != "call" || body[0].expr.args[0] != nil {
+        d.fail("the constructor doesn't start with a constructor call")
+    }
+    for _, st := range body[1:] {
+        if stmtCallsConstructor(st) {
+            d.fail("constructor call after the first statement")
+        }
+    }
+    if body[0].expr.text == "super" && len(body[0].expr.args) == 1 {
+        body = body[1:]
+    }
+    return body
+}
+
+func stmtCallsConstructor(st *jstmt) bool {
+    if st.kind == "expr" && st.expr.op == "call" && st.expr.args[0] == nil {
+        return true
+    }
+    for _, list := range [][]*jstmt{st.body, st.other} {
+        for _, inner := range list {
+            if stmtCallsConstructor(inner) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// parameters creates the locals holding this and the arguments.
+func (d *decompiler) parameters(names []string) {
+    args, _ := d.splitDescriptor(d.cp.GetUtf8(d.method.DescriptorIndex))
+    slot := 0
+    if !d.static {
+        d.vars[d.find(d.entry[0])] = nil
+        slot = 1
+    }
+    for i, arg := range args {
+        local := &jlocal{name: names[i], typ: arg, slot: slot, param: true}
+        d.names[local.name] = true
+        d.vars[d.find(d.entry[slot])] = local
+        for j, entry := range d.lvt {
+            if entry.StartPc == 0 && int(entry.Index) == slot {
+                d.locals[fmt.Sprintf("lvt%d", j)] = local
+            }
+        }
+        slot += fieldSlots(arg)
+    }
+    d.names["this"] = true
+}
+
+// localUse tells whether an instruction reads and writes a local.
+func localUse(instr Instruction) (load bool, store bool) {
+    op := instr.Opcode
+    if op == Wide {
+        op = Opcode(instr.Operands[0])
+    }
+    switch {
+    case op >= Iload && op <= Aload || op >= Iload0 && op <= Aload3:
+        return true, false
+    case op >= Istore && op <= Astore || op >= Istore0 && op <= Astore3:
+        return false, true
+    case op == Iinc:
+        return true, true
+    }
+    return false, false
+}
+
+// variables splits the local slots into variables: a store belongs to the
+// same variable as every other store reaching one of its loads. This keeps
+// apart the values javac puts in a slot it reuses.
+func (d *decompiler) variables() {
+    var slots []int
+    define := func(slot int) int {
+        slots = append(slots, slot)
+        d.parent = append(d.parent, len(d.parent))
+        return len(slots) - 1
+    }
+    args, _ := d.splitDescriptor(d.cp.GetUtf8(d.method.DescriptorIndex))
+    slot := 0
+    if !d.static {
+        d.entry[0] = define(0)
+        slot = 1
+    }
+    for _, arg := range args {
+        d.entry[slot] = define(slot)
+        slot += fieldSlots(arg)
+    }
+    for _, instr := range d.instrs {
+        if _, store := localUse(instr); store {
+            d.defs[instr.Offset] = define(instr.Local())
+        }
+    }
+
+    caught := make(map[int][]int)
+    for _, handler := range d.code.ExceptionHandlers {
+        for i, b := range d.blocks {
+            if b.start >= int(handler.StartPc) && b.end <= int(handler.EndPc) {
+                caught[i] = append(caught[i], d.blockAt(int(handler.HandlerPc)))
+            }
+        }
+    }
+    // flow runs a block from the definitions reaching its start, returning
+    // those reaching its end and those live anywhere in it
+    flow := func(b *dblock, in []bool, load func(Instruction, []bool)) ([]bool, []bool) {
+        set := append([]bool(nil), in...)
+        all := append([]bool(nil), in...)
+        for _, instr := range b.instrs {
+            isLoad, isStore := localUse(instr)
+            if isLoad && load != nil {
+                load(instr, set)
+            }
+            if isStore {
+                for j, slot := range slots {
+                    if slot == instr.Local() {
+                        set[j] = false
+                    }
+                }
+                set[d.defs[instr.Offset]] = true
+                all[d.defs[instr.Offset]] = true
+            }
+        }
+        return set, all
+    }
+
+    in := make([][]bool, len(d.blocks))
+    for i := range in {
+        in[i] = make([]bool, len(slots))
+    }
+    for _, def := range d.entry {
+        in[0][def] = true
+    }
+    visited := make([]bool, len(d.blocks))
+    work := []int{0}
+    for len(work) > 0 {
+        i := work[len(work) - 1]
+        work = work[:len(work) - 1]
+        visited[i] = true
+        out, all := flow(d.blocks[i], in[i], nil)
+        merge := func(target int, set []bool) {
+            changed := !visited[target]
+            for j, reaches := range set {
+                if reaches && !in[target][j] {
+                    in[target][j] = true
+                    changed = true
+                }
+            }
+            if changed {
+                work = append(work, target)
+            }
+        }
+        for _, succ := range d.successors(i) {
+            if succ < len(d.blocks) {
+                merge(succ, out)
+            }
+        }
+        for _, handler := range caught[i] {
+            merge(handler, all)
+        }
+    }
+
+    for i, b := range d.blocks {
+        if !visited[i] {
+            continue
+        }
+        flow(b, in[i], func(instr Instruction, set []bool) {
+            first := -1
+            for j, reaches := range set {
+                if reaches && slots[j] == instr.Local() {
+                    if first < 0 {
+                        first = j
+                    } else {
+                        d.parent[d.find(j)] = d.find(first)
+                    }
+                }
+            }
+            if first < 0 {
+                d.fail("local %d is read before it is written at offset %d", instr.Local(), instr.Offset)
+            }
+            d.uses[instr.Offset] = first
+            if def, ok := d.defs[instr.Offset]; ok {
+                d.parent[d.find(def)] = d.find(first)
+            }
+        })
+    }
+    for _, def := range d.uses {
+        d.read[d.find(def)] = true
+    }
+}
+
+func (d *decompiler) find(def int) int {
+    for d.parent[def] != def {
+        d.parent[def] = d.parent[d.parent[def]]
+        def = d.parent[def]
+    }
+    return def
+}
+
+// stores counts the instructions writing a variable.
+func (d *decompiler) stores(local *jlocal) int {
+    n := 0
+    for _, def := range d.defs {
+        if d.vars[d.find(def)] == local {
+            n++
+        }
+    }
+    return n
+}
+
+// local returns the variable a load, store or iinc accesses, or nil for
+// this. Variables are named from the LocalVariableTable when it has them.
+func (d *decompiler) local(instr Instruction) *jlocal {
+    load, _ := localUse(instr)
+    def, ok := d.defs[instr.Offset]
+    if load {
+        def, ok = d.uses[instr.Offset]
+    }
+    if !ok {
+        d.fail("unreachable code at offset %d", instr.Offset)
+    }
+    root := d.find(def)
+    if local, ok := d.vars[root]; ok {
+        return local
+    }
+    slot, kind, pc := instr.Local(), byte('I'), instr.Offset
+    if op := instr.Opcode; op != Iinc && !(op == Wide && Opcode(instr.Operands[0]) == Iinc) {
+        kind = localAccessKind(instr)
+    }
+    if !load {
+        pc += instr.Length()
+    }
+    key, lvtName, lvtType := "", "", ""
+    for j, entry := range d.lvt {
+        if int(entry.Index) == slot && pc >= int(entry.StartPc) && pc < int(entry.StartPc) + int(entry.Length) &&
+            localKind(d.cp.GetUtf8(entry.DescriptorIndex)) == kind {
+            key = fmt.Sprintf("lvt%d", j)
+            lvtName, lvtType = d.cp.GetUtf8(entry.NameIndex), d.cp.GetUtf8(entry.DescriptorIndex)
+        }
+    }
+    if local, ok := d.locals[key]; ok && key != "" {
+        d.vars[root] = local
+        return local
+    }
+    local := &jlocal{slot: slot, typ: lvtType}
+    name := lvtName
+    if !javaIdentifier(name) || d.names[name] {
+        name = fmt.Sprintf("var%d", slot)
+    }
+    for base, n := name, 2; d.names[name]; n++ {
+        name = fmt.Sprintf("%s_%d", base, n)
+    }
+    local.name = name
+    d.names[name] = true
+    if key != "" {
+        d.locals[key] = local
+    }
+    d.vars[root] = local
+    d.allLocals = append(d.allLocals, local)
+    return local
+}
+
+func localKind(descriptor string) byte {
+    switch descriptor[0] {
+    case 'Z', 'B', 'C', 'S', 'I':
+        return 'I'
+    case 'J', 'F', 'D':
+        return descriptor[0]
+    }
+    return 'A'
+}
+
+// local finds the variable that a load or store of slot at pc uses, creating
+// it on first use. Variables are told apart by the local variable table when
+// there is one and by the slot and kind otherwise.
+
+// freshName returns a name that no local uses.
+func (d *decompiler) freshName(base string) string {
+    name := base
+    for n := 2; d.names[name]; n++ {
+        name = fmt.Sprintf("%s%d", base, n)
+    }
+    d.names[name] = true
+    return name
+}
+
+func (d *decompiler) buildBlocks() {
+    leaders := map[int]bool{0: true}
+    boundaries := make(map[int]bool)
+    for _, instr := range d.instrs {
+        op := instr.Opcode
+        for _, target := range instr.Targets() {
+            leaders[target] = true
+        }
+        if len(instr.Targets()) > 0 || instr.IsTerminal() {
+            leaders[instr.Offset + instr.Length()] = true
+        }
+        if op == Jsr || op == Jsrw || op == Ret || op == Monitorenter || op == Monitorexit {
+            d.fail("%s is not supported", Mnemonic(op))
+        }
+        if op == Wide && Opcode(instr.Operands[0]) == Ret {
+            d.fail("ret is not supported")
+        }
+    }
+    handlers := make(map[int]bool)
+    for _, handler := range d.code.ExceptionHandlers {
+        for _, pc := range []int{int(handler.StartPc), int(handler.EndPc), int(handler.HandlerPc)} {
+            leaders[pc] = true
+            boundaries[pc] = true
+        }
+        handlers[int(handler.HandlerPc)] = true
+    }
+
+    var b *dblock
+    for _, instr := range d.instrs {
+        if leaders[instr.Offset] {
+            b = &dblock{start: instr.Offset, handler: handlers[instr.Offset], boundary: boundaries[instr.Offset]}
+            d.blocks = append(d.blocks, b)
+        }
+        b.instrs = append(b.instrs, instr)
+        b.end = instr.Offset + instr.Length()
+    }
+
+    for _, b := range d.blocks {
+        instr := b.instrs[len(b.instrs) - 1]
+        op := instr.Opcode
+        switch {
+        case op == Goto || op == Gotow:
+            b.term = termGoto
+            b.target = d.blockAt(instr.Targets()[0])
+        case op == Tableswitch || op == Lookupswitch:
+            dflt, keys, targets := instr.Switch()
+            b.term = termSwitch
+            b.keys = keys
+            b.dflt = d.blockAt(dflt)
+            for _, target := range targets {
+                b.targets = append(b.targets, d.blockAt(target))
+            }
+        case len(instr.Targets()) > 0:
+            b.term = termCond
+            b.target = d.blockAt(instr.Targets()[0])
+        case instr.IsTerminal():
+            b.term = termExit
+        }
+    }
+}
+
+// blockAt returns the index of the block starting at pc, or the number of
+// blocks for the end of the code.
+func (d *decompiler) blockAt(pc int) int {
+    for i, b := range d.blocks {
+        if b.start == pc {
+            return i
+        }
+    }
+    if pc == len(d.code.ByteCode) {
+        return len(d.blocks)
+    }
+    d.fail("no block at offset %d", pc)
+    return 0
+}
+
+// translate replays the instructions of a block. A block with a single
+// predecessor starts with the values that one left on the stack; other
+// values reaching a block are placeholders.
+func (d *decompiler) translate(index int) {
+    b := d.blocks[index]
+    var from []int
+    for i := range d.blocks {
+        for _, succ := range d.successors(i) {
+            if succ == index {
+                from = append(from, i)
+            }
+        }
+    }
+    switch {
+    case b.handler:
+        b.entry = []*jexpr{{op: "in", typ: d.caughtType(b.start)}}
+    case len(from) == 1 && d.blocks[from[0]].translated && !b.boundary:
+        b.entry = append([]*jexpr{}, d.blocks[from[0]].stack...)
+    default:
+        for _, i := range from {
+            if pred := d.blocks[i]; pred.translated && len(pred.stack) > 0 {
+                d.fail("values on the stack merge at offset %d", b.start)
+            }
+        }
+    }
+    b.stack = append([]*jexpr{}, b.entry...)
+    for _, instr := range b.instrs {
+        d.execute(b, instr)
+    }
+    b.translated = true
+}
+
+// caughtType returns the type of the exception a handler receives.
+func (d *decompiler) caughtType(pc int) string {
+    typ := ""
+    for _, handler := range d.code.ExceptionHandlers {
+        if int(handler.HandlerPc) != pc {
+            continue
+        }
+        name := "java/lang/Throwable"
+        if !handler.IsFinally() {
+            name = d.cp.GetClassName(handler.CatchType)
+        }
+        if typ != "" && typ != name {
+            return "Ljava/lang/Throwable;"
+        }
+        typ = name
+    }
+    return classNameDescriptor(typ)
+}
+
+func (d *decompiler) pop(b *dblock) *jexpr {
+    if len(b.stack) == 0 {
+        d.fail("stack underflow in block at offset %d", b.start)
+    }
+    e := b.stack[len(b.stack) - 1]
+    b.stack = b.stack[:len(b.stack) - 1]
+    return e
+}
+
+func (d *decompiler) popN(b *dblock, n int) []*jexpr {
+    values := make([]*jexpr, n)
+    for i := n - 1; i >= 0; i-- {
+        values[i] = d.pop(b)
+    }
+    return values
+}
+
+func (d *decompiler) push(b *dblock, e *jexpr) {
+    b.stack = append(b.stack, e)
+}
+
+// emit appends a statement to the block. Values still on the stack would be
+// evaluated after the statement in the source, so they may not depend on
+// anything it changes.
+func (d *decompiler) emit(b *dblock, st *jstmt, writes *jlocal, heap bool) {
+    for _, e := range b.stack {
+        if !exprPure(e) || writes != nil && exprMentions(e, writes) || heap && exprReadsHeap(e) {
+            d.fail("assignment inside an expression at offset %d", b.start)
+        }
+    }
+    b.stmts = append(b.stmts, st)
+}
+
+// typeName writes a type, failing for anonymous and local classes, which
+// can't be named in the source.
+func (d *decompiler) typeName(descriptor string) string {
+    text := d.s.descriptorType(descriptor)
+    base := strings.TrimRight(text, "[]")
+    if len(descriptor) > 0 && strings.TrimLeft(descriptor, "[")[0] != 'L' {
+        return text
+    }
+    for _, part := range strings.Split(base, ".") {
+        if !javaIdentifier(part) {
+            d.fail("%s can't be named", text)
+        }
+    }
+    return text
+}
+
+// classType names a class operand, which is an array descriptor for array
+// classes.
+func (d *decompiler) classType(name string) string {
+    if strings.HasPrefix(name, "[") {
+        return d.typeName(name)
+    }
+    return d.typeName(classNameDescriptor(name))
+}
+
+func (d *decompiler) classDescriptor(name string) string {
+    if strings.HasPrefix(name, "[") {
+        return name
+    }
+    return classNameDescriptor(name)
+}
+
+func intExpr(v int64) *jexpr {
+    return &jexpr{op: "int", typ: "I", value: v}
+}
+
+func litExpr(text string, typ string) *jexpr {
+    return &jexpr{op: "lit", text: text, typ: typ}
+}
+
+func binExpr(op string, typ string, a *jexpr, b *jexpr) *jexpr {
+    return &jexpr{op: "bin", text: op, typ: typ, args: []*jexpr{a, b}}
+}
+
+func (d *decompiler) localExpr(local *jlocal) *jexpr {
+    if local == nil {
+        return &jexpr{op: "this", typ: classNameDescriptor(d.class.Name())}
+    }
+    typ := local.typ
+    if typ == "" {
+        typ = local.inferredType()
+    }
+    return &jexpr{op: "local", local: local, typ: typ}
+}
+
+func (l *jlocal) inferredType() string {
+    typ := ""
+    for _, t := range l.types {
+        if t == "null" {
+            continue
+        }
+        if typ != "" && typ != t {
+            return "Ljava/lang/Object;"
+        }
+        typ = t
+    }
+    if typ == "" {
+        return "Ljava/lang/Object;"
+    }
+    return typ
+}
+
+var kindTypes = []string{"I", "J", "F", "D", "Ljava/lang/Object;"}
+var arrayTypes8 = []string{"I", "J", "F", "D", "Ljava/lang/Object;", "B", "C", "S"}
+var compareOps = []string{"==", "!=", "<", ">=", ">", "<="}
+var conversions = []struct{ typ string; name string }{
+    {"J", "long"}, {"F", "float"}, {"D", "double"}, {"I", "int"}, {"F", "float"}, {"D", "double"},
+    {"I", "int"}, {"J", "long"}, {"D", "double"}, {"I", "int"}, {"J", "long"}, {"F", "float"},
+    {"B", "byte"}, {"C", "char"}, {"S", "short"},
+}
+
+func (d *decompiler) execute(b *dblock, instr Instruction) {
+    op := instr.Opcode
+    switch {
+    case op == Nop:
+    case op == AconstNull:
+        d.push(b, litExpr("null", "null"))
+    case op >= IconstM1 && op <= Iconst5:
+        d.push(b, intExpr(int64(op) - int64(Iconst0)))
+    case op == Lconst0 || op == Lconst1:
+        d.push(b, litExpr(fmt.Sprintf("%dL", op - Lconst0), "J"))
+    case op >= Fconst0 && op <= Fconst2:
+        d.push(b, litExpr(fmt.Sprintf("%d.0f", op - Fconst0), "F"))
+    case op == Dconst0 || op == Dconst1:
+        d.push(b, litExpr(fmt.Sprintf("%d.0", op - Dconst0), "D"))
+    case op == Bipush:
+        d.push(b, intExpr(int64(int8(instr.Operands[0]))))
+    case op == Sipush:
+        d.push(b, intExpr(int64(int16(uint16(instr.Operands[0]) << 8 | uint16(instr.Operands[1])))))
+    case op == Ldc || op == LdcW || op == Ldc2W:
+        d.push(b, d.constant(instr.Index()))
+    case op >= Iload && op <= Aload || op >= Iload0 && op <= Aload3 || op == Wide && Opcode(instr.Operands[0]) <= Aload:
+        d.push(b, d.localExpr(d.local(instr)))
+    case op >= Istore && op <= Astore || op >= Istore0 && op <= Astore3 || op == Wide && Opcode(instr.Operands[0]) >= Istore && Opcode(instr.Operands[0]) <= Astore:
+        kind := localAccessKind(instr)
+        value := d.pop(b)
+        if !d.read[d.find(d.defs[instr.Offset])] && exprPure(value) {
+            // a value nothing reads, such as an unused exception
+            break
+        }
+        local := d.local(instr)
+        if local == nil {
+            d.fail("this is overwritten at offset %d", instr.Offset)
+        }
+        if local.typ == "" {
+            if kind == 'I' && strings.Contains("ZBCS", value.typ) && value.typ != "" {
+                local.typ = value.typ
+            } else if kind != 'A' {
+                local.typ = kindTypes[strings.IndexByte("IJFDA", kind)]
+            }
+        }
+        if kind == 'A' && local.typ == "" {
+            local.types = append(local.types, value.typ)
+        } else {
+            value = coerce(value, local.typ)
+        }
+        d.emit(b, &jstmt{kind: "assign", target: d.localExpr(local), expr: value, local: local}, local, false)
+    case op == Iinc || op == Wide && Opcode(instr.Operands[0]) == Iinc:
+        local := d.local(instr)
+        amount := int64(int8(instr.Operands[1]))
+        if op == Wide {
+            amount = int64(int16(uint16(instr.Operands[3]) << 8 | uint16(instr.Operands[4])))
+        }
+        if n := len(b.stack); n > 0 && b.stack[n - 1].op == "local" && b.stack[n - 1].local == local && (amount == 1 || amount == -1) {
+            text := "++"
+            if amount < 0 {
+                text = "--"
+            }
+            b.stack[n - 1] = &jexpr{op: "postinc", text: text, typ: local.typ, local: local}
+            return
+        }
+        d.emit(b, &jstmt{kind: "inc", target: d.localExpr(local), expr: intExpr(amount)}, local, false)
+    case op >= Iaload && op <= Saload:
+        index := d.pop(b)
+        array := d.pop(b)
+        typ := arrayTypes8[op - Iaload]
+        if strings.HasPrefix(array.typ, "[") {
+            typ = array.typ[1:]
+        }
+        d.push(b, &jexpr{op: "index", typ: typ, args: []*jexpr{array, index}})
+    case op >= Iastore && op <= Sastore:
+        values := d.popN(b, 3)
+        typ := arrayTypes8[op - Iastore]
+        if strings.HasPrefix(values[0].typ, "[") {
+            typ = values[0].typ[1:]
+        }
+        if n := len(b.stack); values[0].op == "arrayinit" && n > 0 && b.stack[n - 1] == values[0] &&
+            values[1].op == "int" && values[1].value == int64(len(values[0].args)) {
+            values[0].args = append(values[0].args, coerce(values[2], typ))
+            break
+        }
+        target := &jexpr{op: "index", typ: typ, args: values[:2]}
+        d.emit(b, &jstmt{kind: "assign", target: target, expr: coerce(values[2], typ)}, nil, true)
+    case op == Pop || op == Pop2:
+        value := d.pop(b)
+        if op == Pop2 && !isWideType(value.typ) {
+            d.pop(b)
+        }
+        if d.nullCheck(value) {
+            // javac checks the receiver of a method reference this way
+            break
+        }
+        if !exprPure(value) {
+            if value.op != "call" && value.op != "new" && value.op != "postinc" {
+                d.fail("discarded expression at offset %d", instr.Offset)
+            }
+            d.emit(b, &jstmt{kind: "expr", expr: value}, nil, true)
+        }
+    case op == Dup:
+        value := d.pop(b)
+        if value.op == "newarray" && len(value.args) == 1 && value.args[0].op == "int" && value.value == 0 {
+            // the stores that follow fill in an array initializer
+            value = &jexpr{op: "arrayinit", text: value.text, typ: value.typ, value: value.args[0].value}
+        }
+        if !exprPure(value) && value.op != "arrayinit" {
+            d.fail("dup of an expression with side effects at offset %d", instr.Offset)
+        }
+        d.push(b, value)
+        d.push(b, value)
+    case op == Dup2:
+        value := d.pop(b)
+        if isWideType(value.typ) {
+            d.push(b, value)
+            d.push(b, value)
+            break
+        }
+        under := d.pop(b)
+        if !exprPure(value) || !exprPure(under) {
+            d.fail("dup2 of an expression with side effects at offset %d", instr.Offset)
+        }
+        b.stack = append(b.stack, under, value, under, value)
+    case op == Swap:
+        values := d.popN(b, 2)
+        if !exprPure(values[0]) || !exprPure(values[1]) {
+            d.fail("swap of expressions with side effects at offset %d", instr.Offset)
+        }
+        b.stack = append(b.stack, values[1], values[0])
+    case op >= DupX1 && op <= Dup2X2:
+        d.fail("%s is not supported", Mnemonic(op))
+    case op >= Iadd && op <= Drem:
+        values := d.popN(b, 2)
+        operator := []string{"+", "-", "*", "/", "%"}[(op - Iadd) / 4]
+        d.push(b, binExpr(operator, kindTypes[(op - Iadd) % 4], values[0], values[1]))
+    case op >= Ineg && op <= Dneg:
+        d.push(b, &jexpr{op: "neg", typ: kindTypes[op - Ineg], args: []*jexpr{d.pop(b)}})
+    case op >= Ishl && op <= Lushr:
+        values := d.popN(b, 2)
+        operator := []string{"<<", ">>", ">>>"}[(op - Ishl) / 2]
+        d.push(b, binExpr(operator, kindTypes[(op - Ishl) % 2], values[0], values[1]))
+    case op >= Iand && op <= Lxor:
+        values := d.popN(b, 2)
+        operator := []string{"&", "|", "^"}[(op - Iand) / 2]
+        typ := kindTypes[(op - Iand) % 2]
+        if values[0].typ == "Z" || values[1].typ == "Z" {
+            typ = "Z"
+            values[0], values[1] = coerce(values[0], "Z"), coerce(values[1], "Z")
+        }
+        d.push(b, binExpr(operator, typ, values[0], values[1]))
+    case op >= I2l && op <= 0x93:
+        conversion := conversions[op - I2l]
+        d.push(b, &jexpr{op: "cast", text: conversion.name, typ: conversion.typ, args: []*jexpr{d.pop(b)}})
+    case op >= 0x94 && op <= 0x98:
+        values := d.popN(b, 2)
+        kind := []string{"lcmp", "fcmpl", "fcmpg", "dcmpl", "dcmpg"}[op - 0x94]
+        d.push(b, &jexpr{op: "cmp", text: kind, typ: "I", args: values})
+    case op >= Ifeq && op <= Ifle:
+        value := d.pop(b)
+        b.cond = d.compareZero(compareOps[op - Ifeq], value)
+    case op >= IfIcmpeq && op <= Ifacmpne:
+        values := d.popN(b, 2)
+        operator := compareOps[op - IfIcmpeq]
+        if values[0].typ == "Z" || values[1].typ == "Z" {
+            values[0], values[1] = coerce(values[0], "Z"), coerce(values[1], "Z")
+        }
+        if values[0].typ == "C" && values[1].op == "int" {
+            values[1] = coerce(values[1], "C")
+        }
+        b.cond = binExpr(operator, "Z", values[0], values[1])
+    case op == Ifnull || op == Ifnonnull:
+        operator := "=="
+        if op == Ifnonnull {
+            operator = "!="
+        }
+        b.cond = binExpr(operator, "Z", d.pop(b), litExpr("null", "null"))
+    case op == Goto || op == Gotow:
+    case op == Tableswitch || op == Lookupswitch:
+        b.cond = d.pop(b)
+    case op >= Ireturn && op <= Areturn:
+        _, ret := d.splitDescriptor(d.cp.GetUtf8(d.method.DescriptorIndex))
+        d.emit(b, &jstmt{kind: "return", expr: coerce(d.pop(b), ret)}, nil, false)
+    case op == Return:
+        d.emit(b, &jstmt{kind: "return"}, nil, false)
+    case op == Athrow:
+        d.emit(b, &jstmt{kind: "throw", expr: d.pop(b)}, nil, false)
+    case op >= Getstatic && op <= Putfield:
+        owner, name, descriptor := d.cp.GetMemberRef(instr.Index())
+        var receiver *jexpr
+        var value *jexpr
+        if op == Putstatic || op == Putfield {
+            value = coerce(d.pop(b), descriptor)
+        }
+        if op == Getfield || op == Putfield {
+            receiver = d.pop(b)
+        } else {
+            receiver = &jexpr{op: "class", text: owner}
+        }
+        field := &jexpr{op: "field", text: name, typ: descriptor, args: []*jexpr{receiver}}
+        if value == nil {
+            d.push(b, field)
+        } else {
+            d.emit(b, &jstmt{kind: "assign", target: field, expr: value}, nil, true)
+        }
+    case op >= Invokevirtual && op <= Invokeinterface:
+        d.invoke(b, instr)
+    case op == Invokedynamic:
+        d.push(b, d.invokeDynamic(b, instr))
+    case op == New:
+        name := d.cp.GetClassName(instr.Index())
+        d.push(b, &jexpr{op: "uninit", text: d.classType(name), typ: classNameDescriptor(name)})
+    case op == Newarray:
+        elem := string("ZCFDBSIJ"[instr.Operands[0] - 4])
+        d.push(b, &jexpr{op: "newarray", text: javaType(elem), typ: "[" + elem, args: []*jexpr{d.pop(b)}})
+    case op == Anewarray:
+        elem := d.classDescriptor(d.cp.GetClassName(instr.Index()))
+        d.push(b, &jexpr{op: "newarray", text: d.typeName(elem), typ: "[" + elem, args: []*jexpr{d.pop(b)}})
+    case op == Multianewarray:
+        descriptor := d.cp.GetClassName(instr.Index())
+        dims := d.popN(b, int(instr.Operands[2]))
+        elem := strings.TrimLeft(descriptor, "[")
+        extra := strings.Count(descriptor, "[") - len(dims)
+        d.push(b, &jexpr{op: "newarray", text: d.typeName(elem), typ: descriptor, args: dims, value: int64(extra)})
+    case op == Arraylength:
+        d.push(b, &jexpr{op: "length", typ: "I", args: []*jexpr{d.pop(b)}})
+    case op == Checkcast:
+        name := d.cp.GetClassName(instr.Index())
+        d.push(b, &jexpr{op: "cast", text: d.classType(name), typ: d.classDescriptor(name), args: []*jexpr{d.pop(b)}})
+    case op == Instanceof:
+        name := d.cp.GetClassName(instr.Index())
+        d.push(b, &jexpr{op: "instanceof", text: d.classType(name), typ: "Z", args: []*jexpr{d.pop(b)}})
+    default:
+        d.fail("%s is not supported", Mnemonic(op))
+    }
+}
+
+func localAccessKind(instr Instruction) byte {
+    op := instr.Opcode
+    if op == Wide {
+        op = Opcode(instr.Operands[0])
+    }
+    switch {
+    case op >= Iload && op <= Aload:
+        return "IJFDA"[op - Iload]
+    case op >= Istore && op <= Astore:
+        return "IJFDA"[op - Istore]
+    case op >= Iload0 && op <= Aload3:
+        return "IJFDA"[(op - Iload0) / 4]
+    }
+    return "IJFDA"[(op - Istore0) / 4]
+}
+
+func (d *decompiler) nullCheck(e *jexpr) bool {
+    if e.op != "call" || e.args[0] == nil || !exprPure(e.args[len(e.args) - 1]) {
+        return false
+    }
+    return e.text == "requireNonNull" && len(e.args) == 2 && e.args[0].op == "class" && e.args[0].text == "java/util/Objects" ||
+        e.text == "getClass" && len(e.args) == 1
+}
+
+func isWideType(typ string) bool {
+    return typ == "J" || typ == "D"
+}
+
+// compareZero builds the condition of ifeq and friends, which compare an int
+// with zero or finish the comparison started by lcmp, fcmpl and so on.
+func (d *decompiler) compareZero(operator string, value *jexpr) *jexpr {
+    if value.op == "cmp" {
+        a, b := value.args[0], value.args[1]
+        if value.text == "lcmp" || operator == "==" || operator == "!=" {
+            return binExpr(operator, "Z", a, b)
+        }
+        // a NaN operand makes fcmpg and dcmpg push 1 and the others -1
+        nanIsGreater := value.text == "fcmpg" || value.text == "dcmpg"
+        negated := map[string]string{"<": ">=", ">=": "<", ">": "<=", "<=": ">"}
+        if nanIsGreater == (operator == ">" || operator == ">=") {
+            return &jexpr{op: "not", typ: "Z", args: []*jexpr{binExpr(negated[operator], "Z", a, b)}}
+        }
+        return binExpr(operator, "Z", a, b)
+    }
+    if value.typ == "Z" {
+        if operator == "==" {
+            return negate(value)
+        }
+        if operator == "!=" {
+            return value
+        }
+    }
+    return binExpr(operator, "Z", value, intExpr(0))
+}
+
+func (d *decompiler) constant(index CpIndex) *jexpr {
+    switch c := (*d.cp.Get(index)).(type) {
+    case ConstInteger:
+        return intExpr(int64(c.Value))
+    case ConstFloat:
+        return litExpr(javaFloatLiteral(float64(c.Value), 32), "F")
+    case ConstLong:
+        return litExpr(strconv.FormatInt(c.Value, 10) + "L", "J")
+    case ConstDouble:
+        return litExpr(javaFloatLiteral(c.Value, 64), "D")
+    case ConstString:
+        return litExpr(javaStringLiteral(d.cp.GetUtf8(c.StringIndex)), "Ljava/lang/String;")
+    case ConstClass:
+        name := d.cp.GetUtf8(c.NameIndex)
+        return &jexpr{op: "classlit", text: d.classType(name), typ: "Ljava/lang/Class;"}
+    }
+    d.fail("ldc of %s is not supported", javapConstant(d.cp, index))
+    return nil
+}
+
+func (d *decompiler) arguments(b *dblock, descriptor string) ([]*jexpr, string) {
+    args, ret := d.splitDescriptor(descriptor)
+    values := d.popN(b, len(args))
+    for i, arg := range args {
+        values[i] = coerce(values[i], arg)
+        if values[i].op == "int" && (arg == "B" || arg == "S") {
+            // constants are not narrowed in method invocation contexts
+            values[i] = &jexpr{op: "cast", text: javaType(arg), typ: arg, args: []*jexpr{values[i]}}
+        }
+    }
+    return values, ret
+}
+
+func (d *decompiler) invoke(b *dblock, instr Instruction) {
+    op := instr.Opcode
+    owner, name, descriptor := d.cp.GetMemberRef(instr.Index())
+    args, ret := d.arguments(b, descriptor)
+    var call *jexpr
+    switch {
+    case op == Invokestatic:
+        call = &jexpr{op: "call", text: name, typ: ret, args: append([]*jexpr{{op: "class", text: owner}}, args...)}
+    case op == Invokespecial && name == "<init>":
+        receiver := d.pop(b)
+        switch receiver.op {
+        case "uninit":
+            receiver.op = "new"
+            receiver.args = args
+            for _, e := range b.stack {
+                if e == receiver {
+                    return
+                }
+            }
+            d.emit(b, &jstmt{kind: "expr", expr: receiver}, nil, true)
+        case "this":
+            text := "super"
+            if owner == d.class.Name() {
+                text = "this"
+            }
+            call = &jexpr{op: "call", text: text, typ: "V", args: append([]*jexpr{nil}, args...)}
+            d.emit(b, &jstmt{kind: "expr", expr: call}, nil, true)
+        default:
+            d.fail("constructor called on an unexpected value at offset %d", instr.Offset)
+        }
+        return
+    default:
+        receiver := d.pop(b)
+        if op == Invokespecial && receiver.op == "this" && owner != d.class.Name() {
+            receiver = &jexpr{op: "super"}
+        }
+        call = &jexpr{op: "call", text: name, typ: ret, args: append([]*jexpr{receiver}, args...)}
+    }
+    if ret == "V" {
+        d.emit(b, &jstmt{kind: "expr", expr: call}, nil, true)
+    } else {
+        d.push(b, call)
+    }
+}
+
+// invokeDynamic rebuilds the string concatenations and lambdas that javac
+// compiles to invokedynamic.
+func (d *decompiler) invokeDynamic(b *dblock, instr Instruction) *jexpr {
+    site, err := ResolveCallSite(d.cp, d.bootstraps, instr.Index())
+    if err != nil {
+        d.fail("%s", err)
+    }
+    args, ret := d.arguments(b, site.Descriptor)
+
+    if concat := site.Concat(d.cp); concat != nil {
+        var parts []*jexpr
+        for _, part := range concat.Parts() {
+            switch {
+            case part.Arg >= 0:
+                parts = append(parts, args[part.Arg])
+            case part.Constant != 0:
+                parts = append(parts, d.constant(part.Constant))
+            default:
+                parts = append(parts, litExpr(javaStringLiteral(part.Text), "Ljava/lang/String;"))
+            }
+        }
+        isString := func(e *jexpr) bool { return e.typ == "Ljava/lang/String;" }
+        if len(parts) < 2 || !isString(parts[0]) && !isString(parts[1]) {
+            parts = append([]*jexpr{litExpr("\"\"", "Ljava/lang/String;")}, parts...)
+        }
+        return &jexpr{op: "concat", typ: "Ljava/lang/String;", args: parts}
+    }
+    if lambda := site.Lambda(d.cp); lambda != nil {
+        return d.lambda(instr, args, ret, lambda.Erased, lambda.Implementation)
+    }
+    d.fail("invokedynamic %s with bootstrap %s.%s is not supported", site.Name, site.Owner, site.Method)
+    return nil
+}
+
+// lambda rebuilds a lambda or method reference from the arguments of
+// LambdaMetafactory: the erased signature of the functional interface method
+// and the method that implements it, with the captured values first.
+func (d *decompiler) lambda(instr Instruction, captured []*jexpr, typ string, sam string, impl ConstMethodHandle) *jexpr {
+    samArgs, _ := d.splitDescriptor(sam)
+    owner, name, descriptor := d.cp.GetMemberRef(impl.ReferenceIndex)
+    implArgs, implRet := d.splitDescriptor(descriptor)
+    receiverArgs := implArgs
+    switch impl.ReferenceKind {
+    case REF_invokeStatic, REF_newInvokeSpecial:
+    case REF_invokeVirtual, REF_invokeInterface, REF_invokeSpecial:
+        receiverArgs = append([]string{classNameDescriptor(owner)}, implArgs...)
+    default:
+        d.fail("lambda implemented by a field handle at offset %d", instr.Offset)
+    }
+    if len(captured) + len(samArgs) != len(receiverArgs) {
+        d.fail("lambda arguments don't match %s at offset %d", descriptor, instr.Offset)
+    }
+
+    if inline := d.inlineLambda(captured, typ, samArgs, impl); inline != nil {
+        return inline
+    }
+
+    // a method reference when the parameters need no casts
+    exact := impl.ReferenceKind != REF_invokeSpecial && len(captured) <= 1 &&
+        (len(captured) == 0 || impl.ReferenceKind == REF_invokeVirtual || impl.ReferenceKind == REF_invokeInterface)
+    for i, arg := range samArgs {
+        if arg != receiverArgs[len(captured) + i] {
+            exact = false
+        }
+    }
+    if exact && !strings.HasPrefix(owner, "[") {
+        if impl.ReferenceKind == REF_newInvokeSpecial {
+            return &jexpr{op: "ref", text: d.classType(owner) + "::new", typ: typ}
+        }
+        if len(captured) == 1 {
+            return &jexpr{op: "ref", text: name, typ: typ, args: captured}
+        }
+        return &jexpr{op: "ref", text: d.classType(owner) + "::" + name, typ: typ}
+    }
+
+    var params []string
+    values := append([]*jexpr{}, captured...)
+    for i, arg := range samArgs {
+        param := d.freshName("arg")
+        params = append(params, param)
+        value := &jexpr{op: "lit", text: param, typ: arg}
+        if want := receiverArgs[len(captured) + i]; want != arg {
+            value = &jexpr{op: "cast", text: d.typeName(want), typ: want, args: []*jexpr{value}}
+        }
+        values = append(values, value)
+    }
+    var body *jexpr
+    switch impl.ReferenceKind {
+    case REF_newInvokeSpecial:
+        body = &jexpr{op: "new", text: d.classType(owner), typ: classNameDescriptor(owner), args: values}
+    case REF_invokeStatic:
+        body = &jexpr{op: "call", text: name, typ: implRet, args: append([]*jexpr{{op: "class", text: owner}}, values...)}
+    default:
+        body = &jexpr{op: "call", text: name, typ: implRet, args: values}
+    }
+    return &jexpr{op: "lambda", text: "(" + strings.Join(params, ", ") + ")", typ: typ, args: []*jexpr{body}}
+}
+
+// lambdaMethod reports whether a method holds the body of a lambda, which
+// javac compiles to a synthetic method named lambda$ and the name of the
+// method creating it.
+func lambdaMethod(cp *ConstantPool, method *Method) bool {
+    return method.Flags & FLAG_SYNTHETIC != 0 && strings.HasPrefix(cp.GetUtf8(method.NameIndex), "lambda$")
+}
+
+// inlineLambda writes the body of a lambda in place of the call to its
+// synthetic method. Its captured parameters take the names of the locals
+// passed for them, and this stays this. It returns nil to leave the call
+// when the body can't be decompiled or its parameters would need casts.
+func (d *decompiler) inlineLambda(captured []*jexpr, typ string, samArgs []string, impl ConstMethodHandle) (inline *jexpr) {
+    owner, name, descriptor := d.cp.GetMemberRef(impl.ReferenceIndex)
+    if owner != d.class.Name() {
+        return nil
+    }
+    var method *Method
+    for i := range d.class.Methods {
+        m := &d.class.Methods[i]
+        if d.cp.GetUtf8(m.NameIndex) == name && d.cp.GetUtf8(m.DescriptorIndex) == descriptor {
+            method = m
+        }
+    }
+    if method == nil || !lambdaMethod(d.cp, method) || method.Code(d.cp) == nil {
+        return nil
+    }
+    for outer := d; outer != nil; outer = outer.outer {
+        if outer.method == method {
+            return nil
+        }
+    }
+    implArgs, _ := d.splitDescriptor(descriptor)
+    locals := captured
+    if !method.Flags.IsStatic() {
+        if len(captured) == 0 || captured[0].op != "this" {
+            return nil
+        }
+        locals = captured[1:]
+    }
+    if len(locals) + len(samArgs) != len(implArgs) {
+        return nil
+    }
+    for i, arg := range samArgs {
+        if implArgs[len(locals) + i] != arg {
+            return nil
+        }
+    }
+    defaults, err := stubParameterNames(d.class, method, len(implArgs))
+    if err != nil {
+        return nil
+    }
+    var names, params []string
+    for _, e := range locals {
+        if e.op != "local" {
+            return nil
+        }
+        names = append(names, e.local.name)
+    }
+    for i := range samArgs {
+        param := defaults[len(locals) + i]
+        if param == fmt.Sprintf("arg%d", len(locals) + i) {
+            param = "arg"
+        }
+        param = d.freshName(param)
+        names = append(names, param)
+        params = append(params, param)
+    }
+
+    inner := d.s.newDecompiler(d.class, method)
+    inner.outer = d
+    for name := range d.names {
+        inner.names[name] = true
+    }
+    imports := d.s.imports.save()
+    defer func() {
+        if r := recover(); r != nil {
+            if _, ok := r.(decompileError); !ok {
+                panic(r)
+            }
+            d.s.imports.restore(imports)
+            for _, param := range params {
+                delete(d.names, param)
+            }
+            inline = nil
+        }
+    }()
+    body := inner.body(names, 0)
+    for name := range inner.names {
+        d.names[name] = true
+    }
+    d.inlined = append(append(d.inlined, inner.inlined...), method)
+
+    // the captured values stay arguments so that their locals count as used
+    text := "(" + strings.Join(params, ", ") + ")"
+    if len(params) == 1 {
+        text = params[0]
+    }
+    if len(body) == 1 && (body[0].kind == "return" && body[0].expr != nil || body[0].kind == "expr") {
+        return &jexpr{op: "lambda", text: text, typ: typ, args: append([]*jexpr{body[0].expr}, captured...)}
+    }
+    block := &jexpr{op: "block", text: "{}"}
+    if len(body) > 0 {
+        lines := []string{"{"}
+        inner.statements(&lines, body, "    ")
+        block.text = strings.Join(append(lines, "}"), "\n")
+    }
+    return &jexpr{op: "lambda", text: text, typ: typ, args: append([]*jexpr{block}, captured...)}
+}
+
+// coerce adjusts a value to the type it is used as, since the JVM has no
+// boolean or char values and the constants 0 and 1 stand for false and true.
+func coerce(e *jexpr, typ string) *jexpr {
+    switch typ {
+    case "Z":
+        switch {
+        case e.op == "int":
+            return litExpr(strconv.FormatBool(e.value != 0), "Z")
+        case e.op == "ternary":
+            return simplifyTernary(e.args[0], coerce(e.args[1], "Z"), coerce(e.args[2], "Z"))
+        case e.typ == "I" || e.typ == "B" || e.typ == "S" || e.typ == "C":
+            return binExpr("!=", "Z", e, intExpr(0))
+        }
+    case "C":
+        switch {
+        case e.op == "int":
+            return litExpr(javaCharLiteral(rune(uint16(e.value))), "C")
+        case e.op == "ternary":
+            return &jexpr{op: "ternary", typ: "C", args: []*jexpr{e.args[0], coerce(e.args[1], "C"), coerce(e.args[2], "C")}}
+        }
+    case "I", "B", "S", "J", "F", "D":
+        if e.typ == "Z" {
+            return &jexpr{op: "ternary", typ: "I", args: []*jexpr{e, intExpr(1), intExpr(0)}}
+        }
+    }
+    return e
+}
+
+func simplifyTernary(cond *jexpr, a *jexpr, b *jexpr) *jexpr {
+    if a.op == "lit" && b.op == "lit" {
+        if a.text == "true" && b.text == "false" {
+            return cond
+        }
+        if a.text == "false" && b.text == "true" {
+            return negate(cond)
+        }
+    }
+    typ := a.typ
+    if typ == "null" || a.op == "int" && b.typ != "null" {
+        typ = b.typ
+    }
+    if typ == "Z" {
+        a, b = coerce(a, "Z"), coerce(b, "Z")
+    }
+    return &jexpr{op: "ternary", typ: typ, args: []*jexpr{cond, a, b}}
+}
+
+// negate returns the opposite condition. Comparisons of floating point
+// values are only flipped for == and != since the others differ for NaN.
+func negate(e *jexpr) *jexpr {
+    switch e.op {
+    case "not":
+        return e.args[0]
+    case "lit":
+        if e.text == "true" || e.text == "false" {
+            return litExpr(strconv.FormatBool(e.text == "false"), "Z")
+        }
+    case "bin":
+        a := e.args[0]
+        floating := a.typ == "F" || a.typ == "D" || e.args[1].typ == "F" || e.args[1].typ == "D"
+        switch e.text {
+        case "==", "!=":
+            return binExpr(map[string]string{"==": "!=", "!=": "=="}[e.text], "Z", a, e.args[1])
+        case "<", ">=", ">", "<=":
+            if !floating {
+                return binExpr(map[string]string{"<": ">=", ">=": "<", ">": "<=", "<=": ">"}[e.text], "Z", a, e.args[1])
+            }
+        case "&&":
+            return binExpr("||", "Z", negate(a), negate(e.args[1]))
+        case "||":
+            return binExpr("&&", "Z", negate(a), negate(e.args[1]))
+        }
+    }
+    return &jexpr{op: "not", typ: "Z", args: []*jexpr{e}}
+}
+
+func exprPure(e *jexpr) bool {
+    if e == nil {
+        return true
+    }
+    switch e.op {
+    case "call", "new", "postinc", "newarray", "arrayinit", "lambda", "concat":
+        return false
+    }
+    for _, arg := range e.args {
+        if !exprPure(arg) {
+            return false
+        }
+    }
+    return true
+}
+
+func exprMentions(e *jexpr, local *jlocal) bool {
+    if e == nil {
+        return false
+    }
+    if e.local == local {
+        return true
+    }
+    for _, arg := range e.args {
+        if exprMentions(arg, local) {
+            return true
+        }
+    }
+    return false
+}
+
+func exprReadsHeap(e *jexpr) bool {
+    if e == nil {
+        return false
+    }
+    if e.op == "field" || e.op == "index" || e.op == "length" {
+        return true
+    }
+    for _, arg := range e.args {
+        if exprReadsHeap(arg) {
+            return true
+        }
+    }
+    return false
+}
+
+// reduce folds the blocks that javac creates for && and || and for
+// conditional values back into single blocks until nothing changes. Only
+// translated blocks take part.
+func (d *decompiler) reduce() {
+    for changed := true; changed; {
+        changed = false
+        preds := d.predecessors()
+        for i := range d.blocks {
+            if d.mergeFall(i, preds) || d.mergeConditions(i, preds) || d.mergeTernary(i, preds) {
+                changed = true
+                break
+            }
+        }
+    }
+}
+
+// predecessors counts the edges into each block, with an extra one for
+// blocks that start a handler or try range so they are never merged away.
+func (d *decompiler) predecessors() []int {
+    preds := make([]int, len(d.blocks) + 1)
+    for i, b := range d.blocks {
+        if b.handler || b.boundary {
+            preds[i]++
+        }
+        for _, succ := range d.successors(i) {
+            preds[succ]++
+        }
+    }
+    preds[0]++
+    return preds
+}
+
+func (d *decompiler) successors(i int) []int {
+    b := d.blocks[i]
+    switch b.term {
+    case termFall:
+        return []int{i + 1}
+    case termGoto:
+        return []int{b.target}
+    case termCond:
+        return []int{b.target, i + 1}
+    case termSwitch:
+        return append([]int{b.dflt}, b.targets...)
+    }
+    return nil
+}
+
+// remove deletes blocks, renumbering the targets of the others.
+func (d *decompiler) remove(indexes ...int) {
+    removed := make(map[int]bool)
+    for _, i := range indexes {
+        removed[i] = true
+    }
+    renumber := make([]int, len(d.blocks) + 1)
+    var blocks []*dblock
+    for i, b := range d.blocks {
+        renumber[i] = len(blocks)
+        if !removed[i] {
+            blocks = append(blocks, b)
+        }
+    }
+    renumber[len(d.blocks)] = len(blocks)
+    for _, b := range blocks {
+        b.target = renumber[b.target]
+        b.dflt = renumber[b.dflt]
+        for j := range b.targets {
+            b.targets[j] = renumber[b.targets[j]]
+        }
+    }
+    d.blocks = blocks
+}
+
+// mergeFall joins a block to the next one when nothing else leads there.
+func (d *decompiler) mergeFall(i int, preds []int) bool {
+    if i + 1 >= len(d.blocks) {
+        return false
+    }
+    x, y := d.blocks[i], d.blocks[i + 1]
+    if !x.translated || !y.translated || preds[i + 1] != 1 ||
+        x.term != termFall && (x.term != termGoto || x.target != i + 1) {
+        return false
+    }
+    x.stmts = append(x.stmts, y.stmts...)
+    x.stack, x.term, x.cond, x.target = y.stack, y.term, y.cond, y.target
+    x.keys, x.targets, x.dflt = y.keys, y.targets, y.dflt
+    x.end = y.end
+    d.remove(i + 1)
+    return true
+}
+
+// mergeConditions folds two conditional jumps into one when the second only
+// tests another part of the same condition.
+func (d *decompiler) mergeConditions(i int, preds []int) bool {
+    if i + 1 >= len(d.blocks) {
+        return false
+    }
+    b1, b2 := d.blocks[i], d.blocks[i + 1]
+    if !b1.translated || !b2.translated || b1.term != termCond || b2.term != termCond || preds[i + 1] != 1 ||
+        len(b2.stmts) > 0 || !sameStack(b2.entry, b1.stack) || !sameStack(b2.stack, b2.entry) {
+        return false
+    }
+    switch {
+    case b2.target == b1.target:
+        b1.cond = binExpr("||", "Z", b1.cond, b2.cond)
+    case b1.target == i + 2:
+        b1.cond = binExpr("&&", "Z", negate(b1.cond), b2.cond)
+        b1.target = b2.target
+    default:
+        return false
+    }
+    d.remove(i + 1)
+    return true
+}
+
+func sameStack(a []*jexpr, b []*jexpr) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for k := range a {
+        if a[k] != b[k] {
+            return false
+        }
+    }
+    return true
+}
+
+// mergeTernary folds cond ? a : b, which javac compiles to a conditional
+// jump over two blocks that each push one value and meet in a third block.
+// The condition block is left pushing the value.
+func (d *decompiler) mergeTernary(i int, preds []int) bool {
+    if i + 3 > len(d.blocks) {
+        return false
+    }
+    c, a, b := d.blocks[i], d.blocks[i + 1], d.blocks[i + 2]
+    join := i + 3
+    if c.term != termCond || c.target != i + 2 || a.term != termGoto || a.target != join || b.term != termFall ||
+        !c.translated || !a.translated || !b.translated || preds[i + 1] != 1 || preds[i + 2] != 1 {
+        return false
+    }
+    height := len(c.stack)
+    pushesOne := func(x *dblock) bool {
+        return len(x.stmts) == 0 && len(x.stack) == height + 1 && sameStack(x.entry, c.stack) &&
+            sameStack(x.stack[:height], c.stack)
+    }
+    if !pushesOne(a) || !pushesOne(b) {
+        return false
+    }
+    c.stack = append(append([]*jexpr{}, c.stack...), simplifyTernary(c.cond, b.stack[height], a.stack[height]))
+    c.term, c.cond = termFall, nil
+    c.end = b.end
+    d.remove(i + 1, i + 2)
+    return true
+}
+
+// resolve follows blocks that hold nothing but a goto.
+func (d *decompiler) resolve(target int) int {
+    for seen := 0; target < len(d.blocks) && seen < len(d.blocks); seen++ {
+        b := d.blocks[target]
+        if b.term != termGoto || len(b.stmts) > 0 || b.handler || b.boundary {
+            break
+        }
+        target = b.target
+    }
+    return target
+}
+
+// jump returns the statement that moves control to target, or nil if it is
+// where control goes next anyway.
+func (d *decompiler) jump(target int, next int, ctx *dctx) *jstmt {
+    target = d.resolve(target)
+    if next >= 0 && target == d.resolve(next) {
+        return nil
+    }
+    innermostLoop, innermost := true, true
+    for c := ctx; c != nil; c = c.parent {
+        if c.loop && target == c.header {
+            st := &jstmt{kind: "continue", loop: c.stmt}
+            if !innermostLoop {
+                d.label(st)
+            }
+            return st
+        }
+        if target == d.resolve(c.exit) {
+            st := &jstmt{kind: "break", loop: c.stmt}
+            if !innermost {
+                d.label(st)
+            }
+            return st
+        }
+        innermost = false
+        if c.loop {
+            innermostLoop = false
+        }
+    }
+    if target == len(d.blocks) {
+        // the code can't fall off its end, so this is never reached
+        return nil
+    }
+    d.fail("unstructured jump to offset %d", d.blocks[target].start)
+    return nil
+}
+
+func (d *decompiler) label(st *jstmt) {
+    if st.loop.label == "" {
+        d.labels++
+        st.loop.label = fmt.Sprintf("label%d", d.labels)
+    }
+    st.loop.labeled = true
+    st.labeled = true
+}
+
+// latch returns the last block that jumps back to header, or -1 if header
+// does not start a loop.
+func (d *decompiler) latch(header int) int {
+    latch := -1
+    for i := header; i < len(d.blocks); i++ {
+        for _, succ := range d.successors(i) {
+            if succ == header {
+                latch = i
+            }
+        }
+    }
+    return latch
+}
+
+// region structures the blocks from up to stop. Control falling off the end
+// goes to block follow.
+func (d *decompiler) region(from int, stop int, follow int, ctx *dctx) []*jstmt {
+    var stmts []*jstmt
+    preds := d.predecessors()
+    next := func(i int) int {
+        if i < stop {
+            return i
+        }
+        return follow
+    }
+    for i := from; i < stop; {
+        b := d.blocks[i]
+        if preds[i] == 0 {
+            i++
+            continue
+        }
+        if end, ok := d.tryAt(i, stop, follow, ctx, &stmts); ok {
+            i = end
+            continue
+        }
+        if latch := d.latch(i); latch >= 0 && !d.opened[i] {
+            if latch >= stop {
+                d.fail("loop at offset %d leaves its region", b.start)
+            }
+            d.opened[i] = true
+            loop := &jstmt{kind: "while", expr: litExpr("true", "Z")}
+            inner := &dctx{loop: true, header: i, exit: latch + 1, stmt: loop, parent: ctx}
+            loop.body = d.region(i, latch + 1, i, inner)
+            d.opened[i] = false
+            stmts = append(stmts, simplifyLoop(loop))
+            if latch + 1 == stop {
+                if st := d.jump(stop, follow, ctx); st != nil {
+                    stmts = append(stmts, st)
+                }
+            }
+            i = latch + 1
+            continue
+        }
+
+        stmts = append(stmts, b.stmts...)
+        switch b.term {
+        case termExit:
+            i++
+        case termFall:
+            if st := d.jump(i + 1, next(i + 1), ctx); st != nil {
+                stmts = append(stmts, st)
+            }
+            i++
+        case termGoto:
+            if st := d.jump(b.target, next(i + 1), ctx); st != nil {
+                stmts = append(stmts, st)
+            }
+            i++
+        case termCond:
+            i = d.conditional(i, stop, follow, ctx, &stmts)
+        case termSwitch:
+            i = d.switchAt(i, stop, follow, ctx, &stmts)
+        }
+    }
+    return stmts
+}
+
+func (d *decompiler) subFollow(end int, stop int, follow int) int {
+    if end < stop {
+        return end
+    }
+    return follow
+}
+
+func (d *decompiler) conditional(i int, stop int, follow int, ctx *dctx, stmts *[]*jstmt) int {
+    b := d.blocks[i]
+    target := b.target
+    if target > i + 1 && (target < stop || target == stop && d.resolve(stop) == d.resolve(follow)) {
+        join := target
+        var elseStmts []*jstmt
+        if last := d.blocks[target - 1]; target - 1 > i && target < stop && last.term == termGoto &&
+            last.target > target && (last.target < stop || last.target == stop && d.resolve(stop) == d.resolve(follow)) {
+            join = last.target
+            elseStmts = d.region(target, join, d.subFollow(join, stop, follow), ctx)
+        }
+        thenStmts := d.region(i + 1, target, d.subFollow(join, stop, follow), ctx)
+        st := &jstmt{kind: "if", expr: negate(b.cond), body: thenStmts, other: elseStmts}
+        if len(thenStmts) == 0 && len(elseStmts) > 0 {
+            st = &jstmt{kind: "if", expr: b.cond, body: elseStmts}
+        }
+        if len(st.body) > 0 || len(st.other) > 0 {
+            *stmts = append(*stmts, st)
+        }
+        return join
+    }
+    if jump := d.jump(target, i + 1, ctx); jump != nil {
+        *stmts = append(*stmts, &jstmt{kind: "if", expr: b.cond, body: []*jstmt{jump}})
+    }
+    if i + 1 == stop {
+        if st := d.jump(stop, follow, ctx); st != nil {
+            *stmts = append(*stmts, st)
+        }
+    }
+    return i + 1
+}
+
+func (d *decompiler) switchAt(i int, stop int, follow int, ctx *dctx, stmts *[]*jstmt) int {
+    b := d.blocks[i]
+    starts := map[int]bool{b.dflt: true}
+    last := i
+    for _, target := range b.targets {
+        starts[target] = true
+        if target > last {
+            last = target
+        }
+    }
+    // the breaks of the cases before the last one jump to the exit, which
+    // is where the default target goes if there is no default case
+    if b.dflt > last {
+        last = b.dflt
+    }
+    exit := -1
+    for j := i + 1; j < last; j++ {
+        x := d.blocks[j]
+        if x.term == termGoto || x.term == termCond {
+            if t := x.target; t >= last && (exit < 0 || t < exit) {
+                exit = t
+            }
+        }
+    }
+    switch {
+    case exit < 0 && b.dflt == last:
+        exit = b.dflt
+    case exit < 0:
+        exit = stop
+    }
+    if exit > stop {
+        d.fail("switch at offset %d leaves its region", b.start)
+    }
+    var order []int
+    for start := range starts {
+        if start <= i || start > exit {
+            d.fail("unstructured switch at offset %d", b.start)
+        }
+        if start < exit {
+            order = append(order, start)
+        }
+    }
+    sort.Ints(order)
+
+    st := &jstmt{kind: "switch", expr: b.cond}
+    constants := d.enumSwitch(st)
+    inner := &dctx{exit: exit, stmt: st, parent: ctx}
+    for k, start := range order {
+        end := exit
+        if k + 1 < len(order) {
+            end = order[k + 1]
+        }
+        var labels []string
+        for j, target := range b.targets {
+            if target != start {
+                continue
+            }
+            if constants == nil {
+                labels = append(labels, d.expr(coerce(intExpr(int64(b.keys[j])), b.cond.typ), 0))
+            } else if name, ok := constants[b.keys[j]]; ok {
+                labels = append(labels, name)
+            } else {
+                d.fail("unknown enum switch key %d at offset %d", b.keys[j], b.start)
+            }
+        }
+        if b.dflt == start {
+            labels = append(labels, "default")
+        }
+        st.cases = append(st.cases, jcase{labels: labels, body: d.region(start, end, d.subFollow(end, stop, follow), inner)})
+    }
+    *stmts = append(*stmts, st)
+    if exit == stop {
+        if jump := d.jump(stop, follow, ctx); jump != nil {
+            *stmts = append(*stmts, jump)
+        }
+    }
+    return exit
+}
+
+type pcRange struct {
+    start int
+    end int
+}
+
+// handlerRanges collects the code each handler protects. javac leaves gaps
+// in the ranges for the copies of a finally block.
+// tryReturns takes the return after a protected range into the range when
+// it returns the value computed inside, as javac compiles
+// try { return f(); } with the return left out. The return can't throw
+// anything a handler catches, and the value no longer crosses the end of
+// the try.
+func (d *decompiler) tryReturns() {
+    targets := make(map[int]bool)
+    for _, instr := range d.instrs {
+        for _, target := range instr.Targets() {
+            targets[target] = true
+        }
+    }
+    handlers := append([]ExceptionHandler{}, d.code.ExceptionHandlers...)
+    for _, instr := range d.instrs {
+        pc := instr.Offset
+        if instr.Opcode < Ireturn || instr.Opcode > Areturn || targets[pc] {
+            continue
+        }
+        ends, inside := false, false
+        for _, handler := range handlers {
+            ends = ends || int(handler.EndPc) == pc
+            inside = inside || int(handler.StartPc) <= pc && pc < int(handler.EndPc) || int(handler.HandlerPc) == pc
+        }
+        if !ends || inside {
+            continue
+        }
+        for i := range handlers {
+            if int(handlers[i].EndPc) == pc {
+                handlers[i].EndPc = uint16(pc + instr.Length())
+            }
+        }
+    }
+    code := *d.code
+    code.ExceptionHandlers = handlers
+    d.code = &code
+}
+
+func (d *decompiler) handlerRanges() {
+    d.ranges = make(map[int][]pcRange)
+    d.catchAll = make(map[int]bool)
+    seen := make(map[int]bool)
+    for _, handler := range d.code.ExceptionHandlers {
+        pc := int(handler.HandlerPc)
+        if !seen[pc] {
+            seen[pc] = true
+            d.handlers = append(d.handlers, pc)
+            d.catchAll[pc] = handler.IsFinally()
+        } else if d.catchAll[pc] != handler.IsFinally() {
+            d.fail("handler at offset %d mixes catch and finally", pc)
+        }
+        if handler.IsFinally() {
+            if int(handler.StartPc) >= pc {
+                // javac used to protect the start of the handler itself
+                continue
+            }
+        }
+        d.ranges[pc] = append(d.ranges[pc], pcRange{int(handler.StartPc), int(handler.EndPc)})
+    }
+    sort.Ints(d.handlers)
+    for _, pc := range d.handlers {
+        sort.Slice(d.ranges[pc], func(a, b int) bool { return d.ranges[pc][a].start < d.ranges[pc][b].start })
+    }
+}
+
+func sameRanges(a []pcRange, b []pcRange) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func lastEnd(ranges []pcRange) int {
+    if len(ranges) == 0 {
+        return -1
+    }
+    return ranges[len(ranges) - 1].end
+}
+
+// enumSwitch recognizes the switch javac compiles a switch on an enum to,
+// which looks up the ordinal in an array that a synthetic class fills in
+// from the constant names at run time. It switches the statement to the enum
+// value and returns the constant of each key.
+func (d *decompiler) enumSwitch(st *jstmt) map[int32]string {
+    e := st.expr
+    if e.op != "index" || e.args[0].op != "field" || e.args[0].args[0].op != "class" ||
+        !strings.HasPrefix(e.args[0].text, "$SwitchMap$") {
+        return nil
+    }
+    ordinal := e.args[1]
+    if ordinal.op != "call" || ordinal.text != "ordinal" || len(ordinal.args) != 1 {
+        return nil
+    }
+    owner := d.s.classes[e.args[0].args[0].text]
+    if owner == nil {
+        return nil
+    }
+    var clinit *Method
+    for k := range owner.Methods {
+        if owner.ConstantPool.GetUtf8(owner.Methods[k].NameIndex) == "<clinit>" {
+            clinit = &owner.Methods[k]
+        }
+    }
+    if clinit == nil {
+        return nil
+    }
+    instructions, err := DecodeInstructions(clinit.Code(owner.ConstantPool).ByteCode)
+    if err != nil {
+        return nil
+    }
+    // getstatic $SwitchMap$..., getstatic E.NAME, invokevirtual ordinal,
+    // a constant, iastore
+    constants := make(map[int32]string)
+    field, name := "", ""
+    var key int32
+    for _, instr := range instructions {
+        switch op := instr.Opcode; {
+        case op == Getstatic:
+            _, member, descriptor := owner.ConstantPool.GetMemberRef(instr.Index())
+            if descriptor == "[I" {
+                field, name = member, ""
+            } else {
+                name = member
+            }
+        case op == Invokevirtual || op == Dup || op == Nop:
+        case op >= Iconst0 && op <= Iconst5:
+            key = int32(op - Iconst0)
+        case op == Bipush:
+            key = int32(int8(instr.Operands[0]))
+        case op == Sipush:
+            key = int32(int16(uint16(instr.Operands[0]) << 8 | uint16(instr.Operands[1])))
+        case op == Iastore:
+            if field == e.args[0].text && name != "" {
+                constants[key] = name
+            }
+            name = ""
+        }
+    }
+    if len(constants) == 0 {
+        return nil
+    }
+    st.expr = ordinal.args[0]
+    return constants
+}
+
+// tryAt structures the outermost try statement starting at block i that
+// hasn't been opened yet. The catch clauses of a statement protect the same
+// ranges; a catch all handler that also covers the catch clauses is its
+// finally block.
+func (d *decompiler) tryAt(i int, stop int, follow int, ctx *dctx, stmts *[]*jstmt) (int, bool) {
+    start := d.blocks[i].start
+    var groups [][]int
+    finallyPc := -1
+    for _, pc := range d.handlers {
+        ranges := d.ranges[pc]
+        if d.openHandlers[pc] || len(ranges) == 0 || ranges[0].start != start {
+            continue
+        }
+        if d.catchAll[pc] {
+            if finallyPc >= 0 {
+                d.fail("two finally handlers at offset %d", start)
+            }
+            finallyPc = pc
+            continue
+        }
+        placed := false
+        for k, group := range groups {
+            if sameRanges(d.ranges[group[0]], ranges) {
+                groups[k] = append(group, pc)
+                placed = true
+                break
+            }
+        }
+        if !placed {
+            groups = append(groups, []int{pc})
+        }
+    }
+    if len(groups) == 0 && finallyPc < 0 {
+        return 0, false
+    }
+    var catches []int
+    for _, group := range groups {
+        if catches == nil || lastEnd(d.ranges[group[0]]) > lastEnd(d.ranges[catches[0]]) {
+            catches = group
+        }
+    }
+    if finallyPc >= 0 && catches != nil {
+        var covered []pcRange
+        for _, r := range d.ranges[finallyPc] {
+            if r.start < catches[0] {
+                covered = append(covered, r)
+            }
+        }
+        if !sameRanges(covered, d.ranges[catches[0]]) {
+            // separate statements, one nested in the other
+            if lastEnd(d.ranges[catches[0]]) >= lastEnd(covered) {
+                finallyPc = -1
+            } else {
+                catches = nil
+            }
+        }
+    }
+    if finallyPc < 0 && len(d.ranges[catches[0]]) > 1 {
+        d.fail("try at offset %d has gaps", start)
+    }
+    for _, pc := range append([]int{finallyPc}, catches...) {
+        d.openHandlers[pc] = true
+        defer delete(d.openHandlers, pc)
+    }
+
+    first := finallyPc
+    if len(catches) > 0 {
+        first = catches[0]
+    }
+    firstBlock := d.blockAt(first)
+    finallyBlock, finallyEnd := -1, -1
+    var thrown *jlocal
+    if finallyPc >= 0 {
+        finallyBlock = d.blockAt(finallyPc)
+        hb := d.blocks[finallyBlock]
+        if len(hb.stmts) == 0 || hb.stmts[0].kind != "assign" || hb.stmts[0].expr != hb.entry[0] || hb.stmts[0].local == nil {
+            d.fail("unexpected finally handler at offset %d", finallyPc)
+        }
+        thrown = hb.stmts[0].local
+        for j := finallyBlock; j < len(d.blocks) && finallyEnd < 0; j++ {
+            b := d.blocks[j]
+            if n := len(b.stmts); n > 0 && b.term == termExit && b.stmts[n - 1].kind == "throw" &&
+                b.stmts[n - 1].expr.op == "local" && b.stmts[n - 1].expr.local == thrown {
+                finallyEnd = j + 1
+            }
+        }
+        if finallyEnd < 0 {
+            d.fail("finally handler at offset %d doesn't rethrow", finallyPc)
+        }
+    } else {
+        // the protected code must run up to the catch clauses, but for the
+        // jump past them
+        for j := d.blockAt(lastEnd(d.ranges[catches[0]])); j < firstBlock; j++ {
+            if b := d.blocks[j]; b.term != termGoto || len(b.stmts) > 0 {
+                d.fail("unprotected code at the end of the try at offset %d", start)
+            }
+        }
+    }
+
+    join := finallyEnd
+    if join < 0 {
+        if b := d.blocks[firstBlock - 1]; firstBlock - 1 >= i && b.term == termGoto && b.target > firstBlock {
+            join = b.target
+        }
+        for k := range catches {
+            limit := stop
+            if k + 1 < len(catches) {
+                limit = d.blockAt(catches[k + 1])
+            }
+            for j := d.blockAt(catches[k]); j < limit && join < 0; j++ {
+                if b := d.blocks[j]; b.term == termGoto && b.target >= limit {
+                    join = b.target
+                }
+            }
+        }
+    }
+    if join < 0 {
+        join = stop
+    }
+    if join > stop || firstBlock > stop {
+        d.fail("try at offset %d leaves its region", start)
+    }
+    after := d.subFollow(join, stop, follow)
+
+    st := &jstmt{kind: "try", finally: finallyPc >= 0}
+    st.body = d.region(i, firstBlock, after, ctx)
+    for k, pc := range catches {
+        h := d.blockAt(pc)
+        limit := join
+        if k + 1 < len(catches) {
+            limit = d.blockAt(catches[k + 1])
+        } else if finallyBlock >= 0 {
+            limit = finallyBlock
+        }
+        var types []string
+        for _, handler := range d.code.ExceptionHandlers {
+            if int(handler.HandlerPc) == pc && int(handler.StartPc) == start {
+                types = append(types, d.classType(d.cp.GetClassName(handler.CatchType)))
+            }
+        }
+        catch := jcatch{types: types}
+        hb := d.blocks[h]
+        exception := hb.entry[0]
+        if len(hb.stmts) > 0 && hb.stmts[0].kind == "assign" && hb.stmts[0].expr == exception &&
+            hb.stmts[0].local != nil && d.stores(hb.stmts[0].local) == 1 {
+            catch.local = hb.stmts[0].local
+            catch.local.catchVar = true
+            hb.stmts = hb.stmts[1:]
+        } else {
+            catch.local = &jlocal{name: d.freshName("ex"), catchVar: true}
+            *exception = jexpr{op: "local", local: catch.local, typ: exception.typ}
+        }
+        catch.body = d.region(h, limit, after, ctx)
+        st.catches = append(st.catches, catch)
+    }
+    if finallyBlock >= 0 {
+        handler := d.region(finallyBlock, finallyEnd, -1, ctx)
+        if len(handler) < 2 || handler[0].local != thrown || handler[len(handler) - 1].kind != "throw" {
+            d.fail("unexpected finally handler at offset %d", finallyPc)
+        }
+        st.other = handler[1:len(handler) - 1]
+        var lines []string
+        d.statements(&lines, st.other, "")
+        body, ok := d.stripFinally(st.body, lines, make(map[*jstmt]bool), true)
+        if !ok {
+            d.fail("the finally block at offset %d isn't copied to every exit", finallyPc)
+        }
+        st.body = body
+        for k := range st.catches {
+            if st.catches[k].body, ok = d.stripFinally(st.catches[k].body, lines, make(map[*jstmt]bool), true); !ok {
+                d.fail("the finally block at offset %d isn't copied to every exit", finallyPc)
+            }
+        }
+    }
+    *stmts = append(*stmts, st)
+    if join == stop {
+        if jump := d.jump(stop, follow, ctx); jump != nil {
+            *stmts = append(*stmts, jump)
+        }
+    }
+    return join, true
+}
+
+// stripFinally removes the copy of the finally block that javac places
+// before each return and each jump out of the try statement, and at the end
+// of the list when tail is set and control can reach it. It reports false if
+// one of them is missing.
+func (d *decompiler) stripFinally(stmts []*jstmt, finally []string, inner map[*jstmt]bool, tail bool) ([]*jstmt, bool) {
+    var result []*jstmt
+    ok := true
+    strip := func() {
+        n := len(result)
+        for k := n; k >= 0 && ok; k-- {
+            var lines []string
+            d.statements(&lines, result[k:], "")
+            if strings.Join(lines, "\n") == strings.Join(finally, "\n") {
+                result = result[:k]
+                return
+            }
+            if len(lines) > len(finally) {
+                break
+            }
+        }
+        ok = false
+    }
+    for _, st := range stmts {
+        switch st.kind {
+        case "return":
+            strip()
+        case "break", "continue":
+            if !inner[st.loop] {
+                strip()
+            }
+        case "while", "do", "switch":
+            inner[st] = true
+        }
+        var nested bool
+        if st.body, nested = d.stripFinally(st.body, finally, inner, false); !nested {
+            ok = false
+        }
+        if st.kind != "try" || !st.finally {
+            if st.other, nested = d.stripFinally(st.other, finally, inner, false); !nested {
+                ok = false
+            }
+        }
+        for k := range st.cases {
+            if st.cases[k].body, nested = d.stripFinally(st.cases[k].body, finally, inner, false); !nested {
+                ok = false
+            }
+        }
+        for k := range st.catches {
+            if st.catches[k].body, nested = d.stripFinally(st.catches[k].body, finally, inner, false); !nested {
+                ok = false
+            }
+        }
+        result = append(result, st)
+    }
+    if tail && completes(result) {
+        strip()
+    }
+    return result, ok
+}
+
+// completes reports whether control can fall off the end of the list, as
+// far as its last statement tells.
+func completes(stmts []*jstmt) bool {
+    if len(stmts) == 0 {
+        return true
+    }
+    last := stmts[len(stmts) - 1]
+    switch last.kind {
+    case "return", "throw", "break", "continue":
+        return false
+    case "if":
+        return len(last.other) == 0 || completes(last.body) || completes(last.other)
+    }
+    return true
+}
+
+// stringSwitches turns the two switches javac compiles a switch on a string
+// to back into one. The first switches on the hash code and sets a local to
+// the position of the case that equals the string, which the second then
+// switches on.
+func (d *decompiler) stringSwitches(stmts []*jstmt) []*jstmt {
+    for _, st := range stmts {
+        st.body = d.stringSwitches(st.body)
+        st.other = d.stringSwitches(st.other)
+        for k := range st.cases {
+            st.cases[k].body = d.stringSwitches(st.cases[k].body)
+        }
+        for k := range st.catches {
+            st.catches[k].body = d.stringSwitches(st.catches[k].body)
+        }
+    }
+    for k := 0; k + 3 < len(stmts); k++ {
+        value, reset, hash, cases := stmts[k], stmts[k + 1], stmts[k + 2], stmts[k + 3]
+        if value.kind != "assign" || value.local == nil || reset.kind != "assign" || reset.local == nil ||
+            reset.expr.op != "int" || reset.expr.value != -1 || hash.kind != "switch" || cases.kind != "switch" {
+            continue
+        }
+        if call := hash.expr; call.op != "call" || call.text != "hashCode" || len(call.args) != 1 ||
+            call.args[0].op != "local" || call.args[0].local != value.local {
+            continue
+        }
+        if cases.expr.op != "local" || cases.expr.local != reset.local {
+            continue
+        }
+        labels, ok := stringCases(hash, value.local, reset.local)
+        if !ok || stmtsMention(stmts[k + 4:], value.local) || stmtsMention(stmts[k + 4:], reset.local) {
+            continue
+        }
+        rewritten := &jstmt{kind: "switch", expr: value.expr, label: cases.label, labeled: cases.labeled}
+        for _, c := range cases.cases {
+            var names []string
+            for _, label := range c.labels {
+                if label == "default" {
+                    names = append(names, label)
+                } else if text, found := labels[label]; found {
+                    names = append(names, text)
+                }
+            }
+            if len(names) == 0 || stmtsMention(c.body, value.local) || stmtsMention(c.body, reset.local) {
+                ok = false
+            }
+            rewritten.cases = append(rewritten.cases, jcase{labels: names, body: c.body})
+        }
+        if !ok {
+            continue
+        }
+        // breaks of the old switch now belong to the new one
+        *cases = *rewritten
+        stmts = append(stmts[:k], stmts[k + 3:]...)
+    }
+    return stmts
+}
+
+// stringCases reads the string of each position from the cases of the hash
+// code switch, which test s.equals("a") and then set the position.
+func stringCases(hash *jstmt, value *jlocal, index *jlocal) (map[string]string, bool) {
+    labels := make(map[string]string)
+    test := func(e *jexpr) (string, bool) {
+        if e.op != "call" || e.text != "equals" || len(e.args) != 2 || e.args[0].op != "local" ||
+            e.args[0].local != value || e.args[1].op != "lit" || e.args[1].typ != "Ljava/lang/String;" {
+            return "", false
+        }
+        return e.args[1].text, true
+    }
+    set := func(st *jstmt, text string) bool {
+        if st.kind != "assign" || st.local != index || st.expr.op != "int" {
+            return false
+        }
+        labels[strconv.FormatInt(st.expr.value, 10)] = text
+        return true
+    }
+    isBreak := func(st *jstmt) bool {
+        return st.kind == "break" && st.loop == hash
+    }
+    var read func(stmts []*jstmt) bool
+    read = func(stmts []*jstmt) bool {
+        for n := 0; n < len(stmts); n++ {
+            st := stmts[n]
+            switch {
+            case isBreak(st) && n == len(stmts) - 1:
+            case st.kind != "if":
+                return false
+            case len(st.body) == 1 && isBreak(st.body[0]) && len(st.other) == 0 && st.expr.op == "not":
+                // if (!s.equals("a")) break; i = 0;
+                text, ok := test(st.expr.args[0])
+                if !ok || n + 1 >= len(stmts) || !set(stmts[n + 1], text) {
+                    return false
+                }
+                n++
+            default:
+                text, ok := test(st.expr)
+                if !ok || len(st.body) == 0 || !set(st.body[0], text) || len(st.body) > 2 ||
+                    len(st.body) == 2 && !isBreak(st.body[1]) || !read(st.other) {
+                    return false
+                }
+            }
+        }
+        return true
+    }
+    for _, c := range hash.cases {
+        if !read(c.body) {
+            return nil, false
+        }
+    }
+    return labels, true
+}
+
+// simplifyLoop turns while (true) loops that start by testing for the exit,
+// or that end by testing for another iteration, into while and do loops.
+func simplifyLoop(loop *jstmt) *jstmt {
+    body := loop.body
+    if len(body) > 0 {
+        first := body[0]
+        if first.kind == "if" && len(first.other) == 0 && len(first.body) == 1 && first.body[0].kind == "break" &&
+            first.body[0].loop == loop && !first.body[0].labeled {
+            loop.expr = negate(first.expr)
+            loop.body = body[1:]
+            return loop
+        }
+    }
+    if n := len(body); n >= 2 {
+        test, exit := body[n - 2], body[n - 1]
+        if test.kind == "if" && len(test.other) == 0 && len(test.body) == 1 && test.body[0].kind == "continue" &&
+            test.body[0].loop == loop && exit.kind == "break" && exit.loop == loop && !exit.labeled &&
+            !continues(body[:n - 2], loop) {
+            loop.kind = "do"
+            loop.expr = test.expr
+            loop.body = body[:n - 2]
+        }
+    }
+    return loop
+}
+
+func continues(stmts []*jstmt, loop *jstmt) bool {
+    for _, st := range stmts {
+        if st.kind == "continue" && st.loop == loop {
+            return true
+        }
+        if continues(st.body, loop) || continues(st.other, loop) {
+            return true
+        }
+        for _, c := range st.cases {
+            if continues(c.body, loop) {
+                return true
+            }
+        }
+        for _, c := range st.catches {
+            if continues(c.body, loop) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// declare places the declaration of each local in the innermost statement
+// list that contains all of its uses, turning its first assignment into the
+// declaration when that assignment comes first.
+func (d *decompiler) declare(stmts []*jstmt, top bool) []*jstmt {
+    candidates := d.allLocals
+    if !top {
+        return stmts
+    }
+    return d.declareIn(stmts, candidates)
+}
+
+func (d *decompiler) declareIn(stmts []*jstmt, locals []*jlocal) []*jstmt {
+    var hoisted []*jstmt
+    pushed := make(map[int][]*jlocal)
+    for _, local := range locals {
+        if local.catchVar {
+            continue
+        }
+        var users []int
+        for k, st := range stmts {
+            if stmtMentions(st, local) {
+                users = append(users, k)
+            }
+        }
+        if len(users) == 0 {
+            continue
+        }
+        first := stmts[users[0]]
+        if len(users) == 1 && first.kind != "assign" && first.kind != "inc" {
+            pushed[users[0]] = append(pushed[users[0]], local)
+            continue
+        }
+        if first.kind == "assign" && first.local == local && !exprMentions(first.expr, local) {
+            first.kind = "decl"
+            continue
+        }
+        hoisted = append(hoisted, &jstmt{kind: "decl", local: local, target: d.localExpr(local),
+            expr: litExpr(javaDefaultValue(d.localExpr(local).typ), "")})
+    }
+    for k, locals := range pushed {
+        st := stmts[k]
+        lists := [][]*jstmt{st.body, st.other}
+        var owner []*jlocal
+        for _, local := range locals {
+            holders := 0
+            for _, list := range lists {
+                if stmtsMention(list, local) {
+                    holders++
+                }
+            }
+            for _, c := range st.cases {
+                if stmtsMention(c.body, local) {
+                    holders++
+                }
+            }
+            for _, c := range st.catches {
+                if stmtsMention(c.body, local) {
+                    holders++
+                }
+            }
+            if holders != 1 || exprMentions(st.expr, local) {
+                owner = append(owner, local)
+            }
+        }
+        for _, local := range owner {
+            hoisted = append(hoisted, &jstmt{kind: "decl", local: local, target: d.localExpr(local),
+                expr: litExpr(javaDefaultValue(d.localExpr(local).typ), "")})
+        }
+        inner := without(locals, owner)
+        st.body = d.declareIn(st.body, inner)
+        st.other = d.declareIn(st.other, inner)
+        for j := range st.cases {
+            st.cases[j].body = d.declareIn(st.cases[j].body, inner)
+        }
+        for j := range st.catches {
+            st.catches[j].body = d.declareIn(st.catches[j].body, inner)
+        }
+    }
+    return append(hoisted, stmts...)
+}
+
+func without(locals []*jlocal, remove []*jlocal) []*jlocal {
+    var result []*jlocal
+    for _, local := range locals {
+        keep := true
+        for _, r := range remove {
+            if r == local {
+                keep = false
+            }
+        }
+        if keep {
+            result = append(result, local)
+        }
+    }
+    return result
+}
+
+func stmtsMention(stmts []*jstmt, local *jlocal) bool {
+    for _, st := range stmts {
+        if stmtMentions(st, local) {
+            return true
+        }
+    }
+    return false
+}
+
+func stmtMentions(st *jstmt, local *jlocal) bool {
+    if st.local == local || exprMentions(st.expr, local) || exprMentions(st.target, local) ||
+        stmtsMention(st.body, local) || stmtsMention(st.other, local) {
+        return true
+    }
+    for _, c := range st.cases {
+        if stmtsMention(c.body, local) {
+            return true
+        }
+    }
+    for _, c := range st.catches {
+        if c.local == local || stmtsMention(c.body, local) {
+            return true
+        }
+    }
+    return false
+}
+
+func (d *decompiler) statements(lines *[]string, stmts []*jstmt, indent string) {
+    add := func(text string) {
+        // lambda bodies span several lines
+        for _, line := range strings.Split(text, "\n") {
+            *lines = append(*lines, indent + line)
+        }
+    }
+    for _, st := range stmts {
+        switch st.kind {
+        case "expr":
+            add(d.expr(st.expr, 0) + ";")
+        case "assign":
+            add(d.expr(st.target, 0) + " = " + d.expr(st.expr, 1) + ";")
+        case "decl":
+            typ := st.local.typ
+            if typ == "" {
+                typ = st.local.inferredType()
+            }
+            add(d.typeName(typ) + " " + st.local.name + " = " + d.expr(coerce(st.expr, typ), 1) + ";")
+        case "inc":
+            name := d.expr(st.target, 0)
+            switch st.expr.value {
+            case 1:
+                add(name + "++;")
+            case -1:
+                add(name + "--;")
+            default:
+                if st.expr.value < 0 {
+                    add(fmt.Sprintf("%s -= %d;", name, -st.expr.value))
+                } else {
+                    add(fmt.Sprintf("%s += %d;", name, st.expr.value))
+                }
+            }
+        case "return":
+            if st.expr == nil {
+                add("return;")
+            } else {
+                add("return " + d.expr(st.expr, 0) + ";")
+            }
+        case "throw":
+            add("throw " + d.expr(st.expr, 0) + ";")
+        case "break", "continue":
+            if st.labeled {
+                add(st.kind + " " + st.loop.label + ";")
+            } else {
+                add(st.kind + ";")
+            }
+        case "if":
+            add("if (" + d.expr(st.expr, 0) + ") {")
+            d.statements(lines, st.body, indent + "    ")
+            for len(st.other) == 1 && st.other[0].kind == "if" {
+                st = st.other[0]
+                add("} else if (" + d.expr(st.expr, 0) + ") {")
+                d.statements(lines, st.body, indent + "    ")
+            }
+            if len(st.other) > 0 {
+                add("} else {")
+                d.statements(lines, st.other, indent + "    ")
+            }
+            add("}")
+        case "while", "do":
+            prefix := ""
+            if st.labeled {
+                prefix = st.label + ": "
+            }
+            if st.kind == "do" {
+                add(prefix + "do {")
+                d.statements(lines, st.body, indent + "    ")
+                add("} while (" + d.expr(st.expr, 0) + ");")
+                break
+            }
+            add(prefix + "while (" + d.expr(st.expr, 0) + ") {")
+            d.statements(lines, st.body, indent + "    ")
+            add("}")
+        case "switch":
+            prefix := ""
+            if st.labeled {
+                prefix = st.label + ": "
+            }
+            add(prefix + "switch (" + d.expr(st.expr, 0) + ") {")
+            for _, c := range st.cases {
+                for _, label := range c.labels {
+                    if label == "default" {
+                        add("default:")
+                    } else {
+                        add("case " + label + ":")
+                    }
+                }
+                d.statements(lines, c.body, indent + "    ")
+            }
+            add("}")
+        case "try":
+            add("try {")
+            d.statements(lines, st.body, indent + "    ")
+            for _, c := range st.catches {
+                add("} catch (" + strings.Join(c.types, " | ") + " " + c.local.name + ") {")
+                d.statements(lines, c.body, indent + "    ")
+            }
+            if st.finally {
+                add("} finally {")
+                d.statements(lines, st.other, indent + "    ")
+            }
+            add("}")
+        }
+    }
+}
+
+var binaryPrecedence = map[string]int{
+    "*": 12, "/": 12, "%": 12, "+": 11, "-": 11, "<<": 10, ">>": 10, ">>>": 10,
+    "<": 9, ">": 9, "<=": 9, ">=": 9, "==": 8, "!=": 8, "&": 7, "^": 6, "|": 5, "&&": 4, "||": 3,
+}
+
+func precedence(e *jexpr) int {
+    switch e.op {
+    case "bin":
+        return binaryPrecedence[e.text]
+    case "concat":
+        return 11
+    case "not", "neg", "cast":
+        return 13
+    case "instanceof":
+        return 9
+    case "ternary":
+        return 2
+    case "lambda":
+        return 1
+    case "int":
+        if e.value < 0 {
+            return 13
+        }
+    case "lit":
+        if strings.HasPrefix(e.text, "-") {
+            return 13
+        }
+    }
+    return 15
+}
+
+func (d *decompiler) wrap(e *jexpr, min int) string {
+    if precedence(e) < min {
+        return "(" + d.expr(e, 0) + ")"
+    }
+    return d.expr(e, 0)
+}
+
+func (d *decompiler) expr(e *jexpr, min int) string {
+    if precedence(e) < min {
+        return "(" + d.expr(e, 0) + ")"
+    }
+    switch e.op {
+    case "int":
+        return strconv.FormatInt(e.value, 10)
+    case "lit":
+        return e.text
+    case "class":
+        return d.classType(e.text)
+    case "local", "postinc":
+        return e.local.name + e.text
+    case "this", "super":
+        return e.op
+    case "bin":
+        p := binaryPrecedence[e.text]
+        return d.wrap(e.args[0], p) + " " + e.text + " " + d.wrap(e.args[1], p + 1)
+    case "not":
+        return "!" + d.wrap(e.args[0], 13)
+    case "neg":
+        operand := d.wrap(e.args[0], 13)
+        if strings.HasPrefix(operand, "-") {
+            return "-(" + operand + ")"
+        }
+        return "-" + operand
+    case "cast":
+        return "(" + e.text + ") " + d.wrap(e.args[0], 13)
+    case "instanceof":
+        return d.wrap(e.args[0], 9) + " instanceof " + e.text
+    case "field":
+        return d.wrap(e.args[0], 15) + "." + e.text
+    case "index":
+        return d.wrap(e.args[0], 15) + "[" + d.expr(e.args[1], 0) + "]"
+    case "call":
+        var args []string
+        for _, arg := range e.args[1:] {
+            args = append(args, d.expr(arg, 0))
+        }
+        text := e.text + "(" + strings.Join(args, ", ") + ")"
+        if e.args[0] == nil {
+            return text
+        }
+        return d.wrap(e.args[0], 15) + "." + text
+    case "new":
+        var args []string
+        for _, arg := range e.args {
+            args = append(args, d.expr(arg, 0))
+        }
+        return "new " + e.text + "(" + strings.Join(args, ", ") + ")"
+    case "newarray":
+        text := "new " + strings.TrimSuffix(e.text, strings.Repeat("[]", strings.Count(e.text, "[]")))
+        for _, dim := range e.args {
+            text += "[" + d.expr(dim, 0) + "]"
+        }
+        return text + strings.Repeat("[]", int(e.value) + strings.Count(e.text, "[]"))
+    case "arrayinit":
+        if len(e.args) == 0 {
+            return fmt.Sprintf("new %s[%d]", e.text, e.value)
+        }
+        var elems []string
+        for _, elem := range e.args {
+            elems = append(elems, d.expr(elem, 2))
+        }
+        for n := len(e.args); n < int(e.value); n++ {
+            elems = append(elems, javaDefaultValue(e.typ[1:]))
+        }
+        return "new " + e.text + "[]{" + strings.Join(elems, ", ") + "}"
+    case "length":
+        return d.wrap(e.args[0], 15) + ".length"
+    case "ternary":
+        return d.wrap(e.args[0], 3) + " ? " + d.wrap(e.args[1], 3) + " : " + d.wrap(e.args[2], 2)
+    case "lambda":
+        return e.text + " -> " + d.expr(e.args[0], 2)
+    case "block":
+        return e.text
+    case "concat":
+        var parts []string
+        for _, part := range e.args {
+            parts = append(parts, d.wrap(part, 12))
+        }
+        return strings.Join(parts, " + ")
+    case "classlit":
+        return e.text + ".class"
+    case "ref":
+        if len(e.args) > 0 {
+            return d.wrap(e.args[0], 15) + "::" + e.text
+        }
+        return e.text
+    }
+    panic(decompileError{fmt.Sprintf("%s expression left over", e.op)})
+}
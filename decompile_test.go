@@ -0,0 +1,205 @@
+package jcr
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+)
+
+func TestDecompile(t *testing.T) {
+    tests := []struct {
+        name string
+        // super holds the methods of a p/Base for D to extend, if set
+        super string
+        code string
+        // want are lines the source must contain, in order
+        want []string
+        // missing are texts the source must not contain
+        missing []string
+    }{
+        {
+            name: "arithmetic",
+            code: `.method public static add : (II)I
+    iload_0
+    iload_1
+    iadd
+    ireturn
+.end method`,
+            want: []string{
+                "public static int add(int arg0, int arg1) {",
+                "return arg0 + arg1;",
+            },
+        },
+        {
+            name: "if",
+            code: `.method public static max : (II)I
+    iload_0
+    iload_1
+    if_icmple L9
+    iload_0
+    ireturn
+L9:
+    iload_1
+    ireturn
+.end method`,
+            want: []string{
+                "public static int max(int arg0, int arg1) {",
+                "if (arg0 > arg1) {",
+                "return arg0;",
+                "}",
+                "return arg1;",
+            },
+        },
+        {
+            name: "string concatenation",
+            code: `.method public static greet : (Ljava/lang/String;)Ljava/lang/String;
+    aload_0
+    invokedynamic InvokeDynamic invokeStatic Method java/lang/invoke/StringConcatFactory makeConcatWithConstants (Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite; "hi \u0001!" : makeConcatWithConstants (Ljava/lang/String;)Ljava/lang/String;
+    areturn
+.end method`,
+            want: []string{
+                "public static String greet(String arg0) {",
+                `return "hi " + arg0 + "!";`,
+            },
+        },
+        {
+            name: "try returning a value",
+            code: `.method public static parse : (Ljava/lang/String;)I
+L0:
+    aload_0
+    invokestatic Method java/lang/Integer parseInt (Ljava/lang/String;)I
+L4:
+    ireturn
+L5:
+    astore_1
+    iconst_m1
+    ireturn
+    .catch java/lang/NumberFormatException from L0 to L4 using L5
+.end method`,
+            want: []string{
+                "try {",
+                "return Integer.parseInt(arg0);",
+                "} catch (NumberFormatException ex) {",
+                "return -1;",
+            },
+        },
+        {
+            name: "lambdas",
+            code: `.field private n I
+
+.method public run : (Ljava/lang/String;I)Ljava/util/function/Supplier;
+    aload_1
+    iload_2
+    invokedynamic InvokeDynamic invokeStatic Method java/lang/invoke/LambdaMetafactory metafactory (Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite; MethodType ()Ljava/lang/Object; MethodHandle invokeStatic Method p/D lambda$run$0 (Ljava/lang/String;I)Ljava/lang/Object; MethodType ()Ljava/lang/Object; : get (Ljava/lang/String;I)Ljava/util/function/Supplier;
+    astore_3
+    aload_0
+    invokedynamic InvokeDynamic invokeStatic Method java/lang/invoke/LambdaMetafactory metafactory (Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite; MethodType (I)V MethodHandle invokeSpecial Method p/D lambda$run$1 (I)V MethodType (I)V : accept (Lp/D;)Ljava/util/function/IntConsumer;
+    astore 4
+    aload 4
+    iconst_1
+    invokeinterface InterfaceMethod java/util/function/IntConsumer accept (I)V 2
+    aload_3
+    areturn
+.end method
+
+.method private static synthetic lambda$run$0 : (Ljava/lang/String;I)Ljava/lang/Object;
+    aload_0
+    iload_1
+    invokevirtual Method java/lang/String substring (I)Ljava/lang/String;
+    areturn
+.end method
+
+.method private synthetic lambda$run$1 : (I)V
+    iload_1
+    ifle L12
+    aload_0
+    iload_1
+    putfield Field p/D n I
+L12:
+    return
+.end method`,
+            want: []string{
+                "public Supplier run(String arg0, int arg1) {",
+                "Supplier var3 = () -> arg0.substring(arg1);",
+                "IntConsumer var4 = arg -> {",
+                "    if (arg > 0) {",
+                "        this.n = arg;",
+                "    }",
+                "};",
+                "var4.accept(1);",
+                "return var3;",
+            },
+            missing: []string{"lambda$"},
+        },
+        {
+            name: "fallback",
+            code: `.method public static sub : ()V
+    jsr L4
+    return
+L4:
+    astore_0
+    ret 0
+.end method`,
+            want: []string{
+                "public static void sub() {",
+                "/* jcr: could not decompile: jsr is not supported",
+                "*    0: jsr L4",
+                "*    5: ret 0",
+                "*/",
+                "throw new UnsupportedOperationException();",
+            },
+        },
+        {
+            name: "fallback in a constructor",
+            super: `.method public <init> : (I)V
+    aload_0
+    invokespecial Method java/lang/Object <init> ()V
+    return
+.end method`,
+            code: `.method public <init> : ()V
+    aconst_null
+    pop
+    return
+.end method`,
+            want: []string{
+                "public D() {",
+                "/* jcr: could not decompile: the constructor doesn't start with a constructor call",
+                "super((int) 0);",
+                "throw new UnsupportedOperationException();",
+            },
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            super := "java/lang/Object"
+            classes := make(map[string]*Class)
+            if test.super != "" {
+                super = "p/Base"
+                base := assemble(t, ".version 52 0\n.class public super p/Base\n.super java/lang/Object\n\n" + test.super + "\n.end class\n")
+                classes[base.Name()] = base
+            }
+            class := assemble(t, ".version 52 0\n.class public super p/D\n.super " + super + "\n\n" + test.code + "\n.end class\n")
+            classes[class.Name()] = class
+            var buf bytes.Buffer
+            var w io.Writer = &buf
+            if err := writeSource(&w, class, classes, true); err != nil {
+                t.Fatal(err)
+            }
+            source := buf.String()
+            rest := source
+            for _, line := range test.want {
+                i := strings.Index(rest, line)
+                if i < 0 {
+                    t.Fatalf("missing %q after the lines before it in\n%s", line, source)
+                }
+                rest = rest[i + len(line):]
+            }
+            for _, text := range test.missing {
+                if strings.Contains(source, text) {
+                    t.Fatalf("unexpected %q in\n%s", text, source)
+                }
+            }
+        })
+    }
+}
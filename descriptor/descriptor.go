@@ -0,0 +1,273 @@
+// Package descriptor parses and builds the field and method descriptors of
+// the class file format, such as I, [Ljava/lang/String; and (IJ)V, and renders
+// them in Java syntax.
+package descriptor
+
+import (
+    "fmt"
+    "strings"
+)
+
+// Type is a field type, or void as the return type of a method. Base is the
+// descriptor character of the element type: one of B C D F I J S Z, V for
+// void, or L for a class, whose internal name is in Class. Arrays have Dims
+// greater than zero.
+type Type struct {
+    Base byte
+    Class string
+    Dims int
+}
+
+var (
+    Void = Type{Base: 'V'}
+    Boolean = Type{Base: 'Z'}
+    Byte = Type{Base: 'B'}
+    Char = Type{Base: 'C'}
+    Short = Type{Base: 'S'}
+    Int = Type{Base: 'I'}
+    Long = Type{Base: 'J'}
+    Float = Type{Base: 'F'}
+    Double = Type{Base: 'D'}
+)
+
+var primitiveNames = map[byte]string{
+    'B': "byte", 'C': "char", 'D': "double", 'F': "float", 'I': "int",
+    'J': "long", 'S': "short", 'Z': "boolean", 'V': "void",
+}
+
+// Object returns the type of a class given its internal name, such as
+// java/lang/String. Names of array classes, which class constants hold as
+// descriptors, give the array type.
+func Object(name string) Type {
+    if strings.HasPrefix(name, "[") {
+        if t, err := ParseField(name); err == nil {
+            return t
+        }
+    }
+    return Type{Base: 'L', Class: name}
+}
+
+// ArrayOf returns an array of dims dimensions of t, which may be an array
+// itself.
+func ArrayOf(t Type, dims int) Type {
+    t.Dims += dims
+    return t
+}
+
+func (t Type) IsPrimitive() bool {
+    return t.Dims == 0 && t.Base != 'L' && t.Base != 'V'
+}
+
+func (t Type) IsObject() bool {
+    return t.Dims == 0 && t.Base == 'L'
+}
+
+func (t Type) IsArray() bool {
+    return t.Dims > 0
+}
+
+// IsReference reports whether values of the type are references, that is
+// objects or arrays.
+func (t Type) IsReference() bool {
+    return t.Dims > 0 || t.Base == 'L'
+}
+
+// Elem returns the type of the elements of an array.
+func (t Type) Elem() Type {
+    if t.Dims == 0 {
+        panic(fmt.Sprintf("descriptor: %s is not an array", t))
+    }
+    t.Dims--
+    return t
+}
+
+// Slots returns the number of local variable or operand stack slots a value
+// of the type takes: two for long and double, none for void.
+func (t Type) Slots() int {
+    switch {
+    case t.Dims > 0:
+        return 1
+    case t.Base == 'J' || t.Base == 'D':
+        return 2
+    case t.Base == 'V':
+        return 0
+    }
+    return 1
+}
+
+// String returns the descriptor of the type.
+func (t Type) String() string {
+    base := string(t.Base)
+    if t.Base == 'L' {
+        base = "L" + t.Class + ";"
+    }
+    return strings.Repeat("[", t.Dims) + base
+}
+
+// Java renders the type in Java syntax with qualified class names, such as
+// java.lang.String[].
+func (t Type) Java() string {
+    return t.JavaName(QualifiedName)
+}
+
+// JavaName renders the type in Java syntax, naming classes with name.
+func (t Type) JavaName(name func(internal string) string) string {
+    base := primitiveNames[t.Base]
+    if t.Base == 'L' {
+        base = name(t.Class)
+    }
+    return base + strings.Repeat("[]", t.Dims)
+}
+
+// QualifiedName turns an internal name into a binary name, e.g.
+// java/util/Map$Entry into java.util.Map$Entry.
+func QualifiedName(internal string) string {
+    return strings.ReplaceAll(internal, "/", ".")
+}
+
+// Method is the type of a method: its argument types and return type.
+type Method struct {
+    Args []Type
+    Return Type
+}
+
+// MethodOf returns the method type taking args and returning ret.
+func MethodOf(ret Type, args ...Type) Method {
+    return Method{Args: args, Return: ret}
+}
+
+// ArgSlots returns the number of local variable slots the arguments take,
+// not counting this.
+func (m Method) ArgSlots() int {
+    slots := 0
+    for _, arg := range m.Args {
+        slots += arg.Slots()
+    }
+    return slots
+}
+
+// String returns the descriptor of the method.
+func (m Method) String() string {
+    var b strings.Builder
+    b.WriteByte('(')
+    for _, arg := range m.Args {
+        b.WriteString(arg.String())
+    }
+    b.WriteByte(')')
+    b.WriteString(m.Return.String())
+    return b.String()
+}
+
+// Java renders the method type in Java syntax with qualified class names,
+// such as void (java.lang.String[], int, long).
+func (m Method) Java() string {
+    return m.JavaName(QualifiedName)
+}
+
+// JavaName renders the method type in Java syntax, naming classes with name.
+func (m Method) JavaName(name func(internal string) string) string {
+    var args []string
+    for _, arg := range m.Args {
+        args = append(args, arg.JavaName(name))
+    }
+    return m.Return.JavaName(name) + " (" + strings.Join(args, ", ") + ")"
+}
+
+// ParseField parses a field descriptor.
+func ParseField(s string) (Type, error) {
+    t, n, err := parseType(s, 0)
+    if err == nil && t.Base == 'V' {
+        err = fmt.Errorf("descriptor: void field type in %q", s)
+    }
+    if err == nil && n != len(s) {
+        err = fmt.Errorf("descriptor: trailing characters in %q", s)
+    }
+    return t, err
+}
+
+// ParseMethod parses a method descriptor.
+func ParseMethod(s string) (Method, error) {
+    var m Method
+    if !strings.HasPrefix(s, "(") {
+        return m, fmt.Errorf("descriptor: %q is not a method descriptor", s)
+    }
+    i := 1
+    for i < len(s) && s[i] != ')' {
+        arg, n, err := parseType(s, i)
+        if err != nil {
+            return m, err
+        }
+        if arg.Base == 'V' {
+            return m, fmt.Errorf("descriptor: void argument in %q", s)
+        }
+        m.Args = append(m.Args, arg)
+        i = n
+    }
+    if i >= len(s) {
+        return m, fmt.Errorf("descriptor: missing ) in %q", s)
+    }
+    ret, n, err := parseType(s, i + 1)
+    if err != nil {
+        return m, err
+    }
+    if n != len(s) {
+        return m, fmt.Errorf("descriptor: trailing characters in %q", s)
+    }
+    m.Return = ret
+    return m, nil
+}
+
+// ParseReturn parses the return type of a method descriptor, a field
+// descriptor or V.
+func ParseReturn(s string) (Type, error) {
+    t, n, err := parseType(s, 0)
+    if err == nil && n != len(s) {
+        err = fmt.Errorf("descriptor: trailing characters in %q", s)
+    }
+    return t, err
+}
+
+// MustParseField is like ParseField but panics when s is malformed.
+func MustParseField(s string) Type {
+    t, err := ParseField(s)
+    if err != nil {
+        panic(err)
+    }
+    return t
+}
+
+// MustParseMethod is like ParseMethod but panics when s is malformed.
+func MustParseMethod(s string) Method {
+    m, err := ParseMethod(s)
+    if err != nil {
+        panic(err)
+    }
+    return m
+}
+
+// parseType parses the type starting at s[i], returning the index after it.
+func parseType(s string, i int) (Type, int, error) {
+    var t Type
+    for i < len(s) && s[i] == '[' {
+        t.Dims++
+        i++
+    }
+    if i >= len(s) {
+        return t, i, fmt.Errorf("descriptor: missing type in %q", s)
+    }
+    t.Base = s[i]
+    switch {
+    case t.Base == 'L':
+        end := strings.IndexByte(s[i:], ';')
+        if end <= 1 {
+            return t, i, fmt.Errorf("descriptor: unterminated class name in %q", s)
+        }
+        t.Class = s[i + 1:i + end]
+        return t, i + end + 1, nil
+    case t.Base == 'V' && t.Dims > 0:
+        return t, i, fmt.Errorf("descriptor: array of void in %q", s)
+    case primitiveNames[t.Base] == "":
+        return t, i, fmt.Errorf("descriptor: unknown type %q in %q", t.Base, s)
+    }
+    return t, i + 1, nil
+}
@@ -0,0 +1,118 @@
+package descriptor
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestParseField(t *testing.T) {
+    tests := []struct {
+        descriptor string
+        want Type
+        java string
+        slots int
+        err string
+    }{
+        {"I", Int, "int", 1, ""},
+        {"J", Long, "long", 2, ""},
+        {"Ljava/lang/String;", Object("java/lang/String"), "java.lang.String", 1, ""},
+        {"[[D", ArrayOf(Double, 2), "double[][]", 1, ""},
+        {"[Ljava/util/Map$Entry;", ArrayOf(Object("java/util/Map$Entry"), 1), "java.util.Map$Entry[]", 1, ""},
+        {"", Type{}, "", 0, "missing type"},
+        {"[", Type{}, "", 0, "missing type"},
+        {"L", Type{}, "", 0, "unterminated"},
+        {"L;", Type{}, "", 0, "unterminated"},
+        {"Ljava/lang/String", Type{}, "", 0, "unterminated"},
+        {"Q", Type{}, "", 0, "unknown type"},
+        {"V", Type{}, "", 0, "void field"},
+        {"[V", Type{}, "", 0, "array of void"},
+        {"II", Type{}, "", 0, "trailing"},
+    }
+    for _, test := range tests {
+        t.Run(test.descriptor, func(t *testing.T) {
+            got, err := ParseField(test.descriptor)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got != test.want {
+                t.Fatalf("got %#v, want %#v", got, test.want)
+            }
+            if got.String() != test.descriptor {
+                t.Errorf("got descriptor %s, want %s", got, test.descriptor)
+            }
+            if got.Java() != test.java {
+                t.Errorf("got %s in Java, want %s", got.Java(), test.java)
+            }
+            if got.Slots() != test.slots {
+                t.Errorf("got %d slots, want %d", got.Slots(), test.slots)
+            }
+        })
+    }
+}
+
+func TestParseMethod(t *testing.T) {
+    tests := []struct {
+        descriptor string
+        java string
+        argSlots int
+        err string
+    }{
+        {"()V", "void ()", 0, ""},
+        {"(IJ)V", "void (int, long)", 3, ""},
+        {"([Ljava/lang/String;)Ljava/lang/Object;", "java.lang.Object (java.lang.String[])", 1, ""},
+        {"(DLjava/util/List;[[Z)[I", "int[] (double, java.util.List, boolean[][])", 4, ""},
+        {"", "", 0, "not a method descriptor"},
+        {"V", "", 0, "not a method descriptor"},
+        {"(I", "", 0, "missing )"},
+        {"(Q)V", "", 0, "unknown type"},
+        {"(V)V", "", 0, "void argument"},
+        {"(Ljava/lang/String)V", "", 0, "unterminated"},
+        {"()", "", 0, "missing type"},
+        {"()VI", "", 0, "trailing"},
+    }
+    for _, test := range tests {
+        t.Run(test.descriptor, func(t *testing.T) {
+            got, err := ParseMethod(test.descriptor)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.String() != test.descriptor {
+                t.Errorf("got descriptor %s, want %s", got, test.descriptor)
+            }
+            if got.Java() != test.java {
+                t.Errorf("got %s in Java, want %s", got.Java(), test.java)
+            }
+            if got.ArgSlots() != test.argSlots {
+                t.Errorf("got %d argument slots, want %d", got.ArgSlots(), test.argSlots)
+            }
+        })
+    }
+}
+
+func TestObject(t *testing.T) {
+    tests := []struct {
+        name string
+        want Type
+    }{
+        {"java/lang/String", Type{Base: 'L', Class: "java/lang/String"}},
+        {"[I", ArrayOf(Int, 1)},
+        {"[[Ljava/lang/String;", ArrayOf(Object("java/lang/String"), 2)},
+    }
+    for _, test := range tests {
+        if got := Object(test.name); got != test.want {
+            t.Errorf("Object(%q) = %#v, want %#v", test.name, got, test.want)
+        }
+    }
+}
@@ -0,0 +1,118 @@
+package jcr
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "io"
+)
+
+func WriteClass(w *io.Writer, c *Class) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("%v", r)
+        }
+    }()
+    mustWrite(w, uint32(0xCAFEBABE))
+    mustWrite(w, c.Minor)
+    mustWrite(w, c.Major)
+
+    mustWriteConstantPool(w, c.ConstantPool)
+
+    mustWrite(w, c.Flags)
+    mustWrite(w, c.ThisIndex)
+    mustWrite(w, c.SuperIndex)
+
+    mustWrite(w, uint16(len(c.Interfaces)))
+    mustWrite(w, c.Interfaces)
+
+    mustWrite(w, uint16(len(c.Fields)))
+    for i := 0; i < len(c.Fields); i++ {
+        mustWriteMember(w, c.Fields[i].Flags, c.Fields[i].NameIndex, c.Fields[i].DescriptorIndex, c.Fields[i].Attributes)
+    }
+
+    mustWrite(w, uint16(len(c.Methods)))
+    for i := 0; i < len(c.Methods); i++ {
+        mustWriteMember(w, c.Methods[i].Flags, c.Methods[i].NameIndex, c.Methods[i].DescriptorIndex, c.Methods[i].Attributes)
+    }
+
+    mustWrite(w, uint16(len(c.Attributes)))
+    for i := 0; i < len(c.Attributes); i++ {
+        mustWriteAttribute(w, &c.Attributes[i])
+    }
+    return nil
+}
+
+// ClassFileWriter writes the class back out in the binary class file format.
+type ClassFileWriter struct {}
+
+func (c ClassFileWriter) Write(w *io.Writer, class *Class) error {
+    return WriteClass(w, class)
+}
+
+func WriteCode(w *io.Writer, c *Code) {
+    mustWrite(w, c.MaxStack)
+    mustWrite(w, c.MaxLocals)
+
+    mustWrite(w, uint32(len(c.ByteCode)))
+    mustWrite(w, c.ByteCode)
+
+    mustWrite(w, uint16(len(c.ExceptionHandlers)))
+    for i := 0; i < len(c.ExceptionHandlers); i++ {
+        mustWrite(w, c.ExceptionHandlers[i])
+    }
+
+    mustWrite(w, uint16(len(c.Attributes)))
+    for i := 0; i < len(c.Attributes); i++ {
+        mustWriteAttribute(w, &c.Attributes[i])
+    }
+}
+
+// CodeAttribute encodes c as a "Code" attribute, adding the attribute name to
+// the constant pool if necessary.
+func CodeAttribute(cp *ConstantPool, c *Code) Attribute {
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    WriteCode(&w, c)
+    return Attribute{NameIndex: cp.AddUtf8("Code"), Info: buf.Bytes()}
+}
+
+func mustWriteConstantPool(w *io.Writer, cp *ConstantPool) {
+    mustWrite(w, cp.Count())
+    for _, constant := range cp.Constants {
+        if constant == nil {
+            // second entry of a long or double
+            continue
+        }
+        mustWrite(w, constant.Type())
+        if utf8, ok := constant.(ConstUtf8); ok {
+            mustWrite(w, uint16(len(utf8.Data)))
+            mustWrite(w, utf8.Data)
+        } else {
+            mustWrite(w, constant)
+        }
+    }
+}
+
+func mustWriteMember(w *io.Writer, flags AccessFlag, name CpIndex, descriptor CpIndex, attributes []Attribute) {
+    mustWrite(w, flags)
+    mustWrite(w, name)
+    mustWrite(w, descriptor)
+    mustWrite(w, uint16(len(attributes)))
+    for i := 0; i < len(attributes); i++ {
+        mustWriteAttribute(w, &attributes[i])
+    }
+}
+
+func mustWriteAttribute(w *io.Writer, a *Attribute) {
+    mustWrite(w, a.NameIndex)
+    mustWrite(w, uint32(len(a.Info)))
+    mustWrite(w, a.Info)
+}
+
+func mustWrite(w *io.Writer, data any) {
+    err := binary.Write(*w, binary.BigEndian, data)
+    if err != nil {
+        panic(err)
+    }
+}
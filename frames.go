@@ -0,0 +1,614 @@
+package jcr
+
+import (
+    "fmt"
+    "github.com/jasonhightower/jcr/descriptor"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// frameState holds one verification type per local variable slot and per
+// stack slot; the second slot of a long or double is Top.
+type frameState struct {
+    locals []VerificationType
+    stack []VerificationType
+}
+
+func (s *frameState) copy() *frameState {
+    return &frameState{
+        locals: append([]VerificationType(nil), s.locals...),
+        stack: append([]VerificationType(nil), s.stack...),
+    }
+}
+
+func (s *frameState) push(v VerificationType) {
+    s.stack = append(s.stack, v)
+    if v.IsWide() {
+        s.stack = append(s.stack, VerificationType{Tag: VTop})
+    }
+}
+
+func (s *frameState) setLocal(index int, v VerificationType) error {
+    size := 1
+    if v.IsWide() {
+        size = 2
+    }
+    if index + size > len(s.locals) {
+        return fmt.Errorf("Local variable %d is out of range", index)
+    }
+    if index > 0 && s.locals[index - 1].IsWide() {
+        s.locals[index - 1] = VerificationType{Tag: VTop}
+    }
+    s.locals[index] = v
+    if size == 2 {
+        s.locals[index + 1] = VerificationType{Tag: VTop}
+    }
+    return nil
+}
+
+// entries converts slots to frame entries, where a long or double is a
+// single entry.
+func entries(slots []VerificationType) []VerificationType {
+    var result []VerificationType
+    for i := 0; i < len(slots); i++ {
+        result = append(result, slots[i])
+        if slots[i].IsWide() {
+            i++
+        }
+    }
+    return result
+}
+
+func localEntries(slots []VerificationType) []VerificationType {
+    result := entries(slots)
+    for len(result) > 0 && result[len(result) - 1].Tag == VTop {
+        result = result[:len(result) - 1]
+    }
+    return result
+}
+
+type frameAnalyzer struct {
+    class *Class
+    cp *ConstantPool
+    hierarchy TypeHierarchy
+    code *Code
+    instrs []Instruction
+    index map[int]int
+    states []*frameState
+    work []int
+}
+
+// ComputeFrames runs a data-flow analysis over the code of method and returns
+// the compressed StackMapTable frames it needs. h is consulted for the
+// common super class when two different reference types meet.
+func ComputeFrames(class *Class, method *Method, code *Code, h TypeHierarchy) ([]StackMapFrame, error) {
+    instrs, err := DecodeInstructions(code.ByteCode)
+    if err != nil {
+        return nil, err
+    }
+    a := &frameAnalyzer{
+        class: class,
+        cp: class.ConstantPool,
+        hierarchy: h,
+        code: code,
+        instrs: instrs,
+        index: make(map[int]int, len(instrs)),
+        states: make([]*frameState, len(instrs)),
+    }
+    for i, instr := range instrs {
+        a.index[instr.Offset] = i
+    }
+
+    initial, err := a.initialState(method)
+    if err != nil {
+        return nil, err
+    }
+    if len(instrs) == 0 {
+        return nil, nil
+    }
+    if err := a.analyze(initial); err != nil {
+        return nil, err
+    }
+
+    targets := make(map[int]bool)
+    for _, instr := range instrs {
+        for _, target := range instr.Targets() {
+            targets[target] = true
+        }
+    }
+    for _, handler := range code.ExceptionHandlers {
+        targets[int(handler.HandlerPc)] = true
+    }
+
+    var frames []StackMapFrame
+    for i, instr := range instrs {
+        if a.states[i] == nil {
+            return nil, fmt.Errorf("Unreachable code at offset %d", instr.Offset)
+        }
+        if targets[instr.Offset] {
+            frames = append(frames, StackMapFrame{
+                Offset: instr.Offset,
+                Locals: localEntries(a.states[i].locals),
+                Stack: entries(a.states[i].stack),
+            })
+        }
+    }
+    compressFrames(localEntries(initial.locals), frames)
+    return frames, nil
+}
+
+// UpdateFrames regenerates the StackMapTable of every method with code. Class
+// files older than version 50 don't use stack maps and are left untouched.
+func (class *Class) UpdateFrames(h TypeHierarchy) error {
+    if class.Major < 50 {
+        return nil
+    }
+    cp := class.ConstantPool
+    for i := range class.Methods {
+        method := &class.Methods[i]
+        code := method.Code(cp)
+        if code == nil {
+            continue
+        }
+        frames, err := ComputeFrames(class, method, code, h)
+        if err != nil {
+            return fmt.Errorf("%s%s: %s", cp.GetUtf8(method.NameIndex), cp.GetUtf8(method.DescriptorIndex), err)
+        }
+        code.SetStackMapTable(cp, frames)
+        method.SetCode(cp, code)
+    }
+    return nil
+}
+
+// InitialLocals returns the implicit frame a method starts with: the
+// receiver, if any, followed by the arguments.
+func InitialLocals(class *Class, method *Method) ([]VerificationType, error) {
+    cp := class.ConstantPool
+    var locals []VerificationType
+    if !method.Flags.IsStatic() {
+        this := VerificationType{Tag: VObject, Class: class.Name()}
+        if cp.GetUtf8(method.NameIndex) == "<init>" && class.Name() != "java/lang/Object" {
+            this = VerificationType{Tag: VUninitializedThis}
+        }
+        locals = append(locals, this)
+    }
+    args, _, err := splitMethodDescriptor(cp.GetUtf8(method.DescriptorIndex))
+    if err != nil {
+        return nil, err
+    }
+    for _, arg := range args {
+        locals = append(locals, descriptorType(arg))
+    }
+    return locals, nil
+}
+
+func (a *frameAnalyzer) initialState(method *Method) (*frameState, error) {
+    s := &frameState{locals: make([]VerificationType, a.code.MaxLocals)}
+    initial, err := InitialLocals(a.class, method)
+    if err != nil {
+        return nil, err
+    }
+    local := 0
+    for _, v := range initial {
+        if err := s.setLocal(local, v); err != nil {
+            return nil, err
+        }
+        local++
+        if v.IsWide() {
+            local++
+        }
+    }
+    return s, nil
+}
+
+func (a *frameAnalyzer) analyze(initial *frameState) error {
+    a.states[0] = initial.copy()
+    a.work = []int{0}
+    for len(a.work) > 0 {
+        i := a.work[len(a.work) - 1]
+        a.work = a.work[:len(a.work) - 1]
+        instr := a.instrs[i]
+        in := a.states[i]
+
+        out, err := a.execute(instr, in)
+        if err != nil {
+            return err
+        }
+
+        for _, handler := range a.code.ExceptionHandlers {
+            if instr.Offset < int(handler.StartPc) || instr.Offset >= int(handler.EndPc) {
+                continue
+            }
+            catchType := "java/lang/Throwable"
+            if !handler.IsFinally() {
+                catchType = a.cp.GetClassName(handler.CatchType)
+            }
+            stack := []VerificationType{{Tag: VObject, Class: catchType}}
+            for _, locals := range [][]VerificationType{in.locals, out.locals} {
+                s := &frameState{locals: locals, stack: stack}
+                if err := a.mergeInto(int(handler.HandlerPc), s); err != nil {
+                    return err
+                }
+            }
+        }
+        for _, target := range instr.Targets() {
+            if err := a.mergeInto(target, out); err != nil {
+                return err
+            }
+        }
+        if !instr.IsTerminal() {
+            if i + 1 >= len(a.instrs) {
+                return fmt.Errorf("Execution falls off the end of the code at offset %d", instr.Offset)
+            }
+            if err := a.mergeInto(a.instrs[i + 1].Offset, out); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+func (a *frameAnalyzer) mergeInto(offset int, s *frameState) error {
+    i, ok := a.index[offset]
+    if !ok {
+        return fmt.Errorf("Jump to offset %d which is not an instruction", offset)
+    }
+    existing := a.states[i]
+    if existing == nil {
+        a.states[i] = s.copy()
+        a.work = append(a.work, i)
+        return nil
+    }
+    if len(existing.stack) != len(s.stack) {
+        return fmt.Errorf("Inconsistent stack height at offset %d: %d != %d", offset, len(existing.stack), len(s.stack))
+    }
+    changed := false
+    for _, pair := range [][2][]VerificationType{{existing.locals, s.locals}, {existing.stack, s.stack}} {
+        for j := range pair[0] {
+            merged, err := a.mergeType(pair[0][j], pair[1][j])
+            if err != nil {
+                return err
+            }
+            if merged != pair[0][j] {
+                pair[0][j] = merged
+                changed = true
+            }
+        }
+    }
+    if changed {
+        a.work = append(a.work, i)
+    }
+    return nil
+}
+
+func (a *frameAnalyzer) mergeType(x VerificationType, y VerificationType) (VerificationType, error) {
+    switch {
+    case x == y:
+        return x, nil
+    case x.Tag == VNull && y.Tag == VObject:
+        return y, nil
+    case x.Tag == VObject && y.Tag == VNull:
+        return x, nil
+    case x.Tag == VObject && y.Tag == VObject:
+        common, err := a.commonSuperClass(x.Class, y.Class)
+        if err != nil {
+            return x, err
+        }
+        return VerificationType{Tag: VObject, Class: common}, nil
+    }
+    return VerificationType{Tag: VTop}, nil
+}
+
+func (a *frameAnalyzer) superClass(name string) (string, error) {
+    // Object is the root of every hierarchy, so it need not be on the class
+    // path
+    if name == "java/lang/Object" {
+        return "", nil
+    }
+    if name == a.class.Name() {
+        return a.class.SuperName(), nil
+    }
+    return a.hierarchy.SuperClass(name)
+}
+
+func (a *frameAnalyzer) isInterface(name string) (bool, error) {
+    if name == "java/lang/Object" {
+        return false, nil
+    }
+    if name == a.class.Name() {
+        return a.class.Flags.IsInterface(), nil
+    }
+    return a.hierarchy.IsInterface(name)
+}
+
+func (a *frameAnalyzer) commonSuperClass(x string, y string) (string, error) {
+    if x == y {
+        return x, nil
+    }
+    if x[0] == '[' || y[0] == '[' {
+        if x[0] == '[' && y[0] == '[' && isReferenceDescriptor(x[1:]) && isReferenceDescriptor(y[1:]) {
+            common, err := a.commonSuperClass(descriptorClassName(x[1:]), descriptorClassName(y[1:]))
+            if err != nil {
+                return "", err
+            }
+            return "[" + classNameDescriptor(common), nil
+        }
+        return "java/lang/Object", nil
+    }
+    for _, name := range []string{x, y} {
+        isInterface, err := a.isInterface(name)
+        if err != nil {
+            return "", err
+        }
+        if isInterface {
+            return "java/lang/Object", nil
+        }
+    }
+    supers := make(map[string]bool)
+    for name := x; name != ""; {
+        supers[name] = true
+        var err error
+        if name, err = a.superClass(name); err != nil {
+            return "", err
+        }
+    }
+    for name := y; name != ""; {
+        if supers[name] {
+            return name, nil
+        }
+        var err error
+        if name, err = a.superClass(name); err != nil {
+            return "", err
+        }
+    }
+    return "java/lang/Object", nil
+}
+
+func (a *frameAnalyzer) execute(instr Instruction, in *frameState) (*frameState, error) {
+    s := in.copy()
+    op := instr.Opcode
+    if op == Wide {
+        op = Opcode(instr.Operands[0])
+    }
+    if op == Jsr || op == Jsrw || op == Ret {
+        return nil, fmt.Errorf("Subroutines are not supported (%s at offset %d)", op, instr.Offset)
+    }
+
+    pop, push, err := stackEffect(a.cp, instr)
+    if err != nil {
+        return nil, err
+    }
+    if pop > len(s.stack) {
+        return nil, fmt.Errorf("Stack underflow at offset %d (%s)", instr.Offset, instr.Opcode)
+    }
+    popped := append([]VerificationType(nil), s.stack[len(s.stack) - pop:]...)
+    s.stack = s.stack[:len(s.stack) - pop]
+
+    switch {
+    case op >= Iload && op <= Aload || op >= Iload0 && op <= Aload3:
+        local := instr.Local()
+        if local >= len(s.locals) {
+            return nil, fmt.Errorf("Local variable %d is out of range at offset %d", local, instr.Offset)
+        }
+        v := s.locals[local]
+        kind := int(op - Iload)
+        if op >= Iload0 {
+            kind = int(op - Iload0) / 4
+        }
+        if kind != 4 {
+            v = VerificationType{Tag: []VerificationTag{VInteger, VLong, VFloat, VDouble}[kind]}
+        }
+        s.push(v)
+    case op >= Istore && op <= Astore || op >= Istore0 && op <= Astore3:
+        if err := s.setLocal(instr.Local(), popped[0]); err != nil {
+            return nil, err
+        }
+    case op >= Dup && op <= Swap:
+        // the stack slots after the operation, as indexes into popped
+        layouts := [][]int{
+            {0, 0}, {1, 0, 1}, {2, 0, 1, 2}, {0, 1, 0, 1},
+            {1, 2, 0, 1, 2}, {2, 3, 0, 1, 2, 3}, {1, 0},
+        }
+        for _, j := range layouts[op - Dup] {
+            s.stack = append(s.stack, popped[j])
+        }
+    case op == Aaload:
+        array := popped[0]
+        if array.Tag == VNull {
+            s.push(array)
+        } else if array.Tag == VObject && array.Class[0] == '[' {
+            s.push(descriptorType(array.Class[1:]))
+        } else {
+            return nil, fmt.Errorf("aaload on a non-array at offset %d", instr.Offset)
+        }
+    case op == Invokespecial:
+        _, name, descriptor := a.cp.GetMemberRef(instr.Index())
+        if name == "<init>" {
+            a.initialize(s, popped[0])
+        }
+        _, ret, err := splitMethodDescriptor(descriptor)
+        if err != nil {
+            return nil, err
+        }
+        if ret != "V" {
+            s.push(descriptorType(ret))
+        }
+    case push > 0:
+        v, err := a.result(op, instr)
+        if err != nil {
+            return nil, err
+        }
+        s.push(v)
+    }
+    return s, nil
+}
+
+// initialize replaces every occurrence of an uninitialized type with the
+// initialized class once its constructor has been called.
+func (a *frameAnalyzer) initialize(s *frameState, uninit VerificationType) {
+    var v VerificationType
+    switch uninit.Tag {
+    case VUninitializedThis:
+        v = VerificationType{Tag: VObject, Class: a.class.Name()}
+    case VUninitialized:
+        newInstr := a.instrs[a.index[int(uninit.Offset)]]
+        v = VerificationType{Tag: VObject, Class: a.cp.GetClassName(newInstr.Index())}
+    default:
+        return
+    }
+    for _, slots := range [][]VerificationType{s.locals, s.stack} {
+        for i := range slots {
+            if slots[i] == uninit {
+                slots[i] = v
+            }
+        }
+    }
+}
+
+// result returns the type pushed by instructions that produce a value
+// independent of their inputs.
+func (a *frameAnalyzer) result(op Opcode, instr Instruction) (VerificationType, error) {
+    integer := VerificationType{Tag: VInteger}
+    kinds := []VerificationTag{VInteger, VLong, VFloat, VDouble}
+    switch {
+    case op == AconstNull:
+        return VerificationType{Tag: VNull}, nil
+    case op >= IconstM1 && op <= Iconst5 || op == Bipush || op == Sipush:
+        return integer, nil
+    case op == Lconst0 || op == Lconst1:
+        return VerificationType{Tag: VLong}, nil
+    case op >= Fconst0 && op <= Fconst2:
+        return VerificationType{Tag: VFloat}, nil
+    case op == Dconst0 || op == Dconst1:
+        return VerificationType{Tag: VDouble}, nil
+    case op == Ldc || op == LdcW || op == Ldc2W:
+        return a.constantType(instr.Index())
+    case op >= Iaload && op <= Saload:
+        if op == Laload || op == Faload || op == Daload {
+            return VerificationType{Tag: kinds[op - Iaload]}, nil
+        }
+        return integer, nil
+    case op >= Iadd && op <= Drem:
+        return VerificationType{Tag: kinds[(op - Iadd) % 4]}, nil
+    case op >= Ineg && op <= Dneg:
+        return VerificationType{Tag: kinds[op - Ineg]}, nil
+    case op >= Ishl && op <= Lxor:
+        if (op - Ishl) % 2 == 1 {
+            return VerificationType{Tag: VLong}, nil
+        }
+        return integer, nil
+    case op >= I2l && op <= 0x93:
+        // i2l through i2s in opcode order
+        results := []VerificationTag{VLong, VFloat, VDouble, VInteger, VFloat, VDouble,
+            VInteger, VLong, VDouble, VInteger, VLong, VFloat, VInteger, VInteger, VInteger}
+        return VerificationType{Tag: results[op - I2l]}, nil
+    case op >= 0x94 && op <= 0x98:
+        // lcmp, fcmpl, fcmpg, dcmpl, dcmpg
+        return integer, nil
+    case op == Getstatic || op == Getfield:
+        _, _, descriptor := a.cp.GetMemberRef(instr.Index())
+        return descriptorType(descriptor), nil
+    case op >= Invokevirtual && op <= Invokedynamic:
+        _, _, descriptor := a.cp.GetMemberRef(instr.Index())
+        _, ret, err := splitMethodDescriptor(descriptor)
+        if err != nil {
+            return VerificationType{}, err
+        }
+        return descriptorType(ret), nil
+    case op == New:
+        return VerificationType{Tag: VUninitialized, Offset: uint16(instr.Offset)}, nil
+    case op == Newarray:
+        types := map[byte]string{4: "[Z", 5: "[C", 6: "[F", 7: "[D", 8: "[B", 9: "[S", 10: "[I", 11: "[J"}
+        name, ok := types[instr.Operands[0]]
+        if !ok {
+            return integer, fmt.Errorf("Invalid newarray type %d at offset %d", instr.Operands[0], instr.Offset)
+        }
+        return VerificationType{Tag: VObject, Class: name}, nil
+    case op == Anewarray:
+        return VerificationType{Tag: VObject, Class: "[" + classNameDescriptor(a.cp.GetClassName(instr.Index()))}, nil
+    case op == Checkcast || op == Multianewarray:
+        return VerificationType{Tag: VObject, Class: a.cp.GetClassName(instr.Index())}, nil
+    case op == Arraylength || op == Instanceof:
+        return integer, nil
+    }
+    return integer, fmt.Errorf("Unsupported opcode %s at offset %d", op, instr.Offset)
+}
+
+func (a *frameAnalyzer) constantType(index CpIndex) (VerificationType, error) {
+    object := func(name string) VerificationType {
+        return VerificationType{Tag: VObject, Class: name}
+    }
+    switch constant := (*a.cp.Get(index)).(type) {
+    case ConstInteger:
+        return VerificationType{Tag: VInteger}, nil
+    case ConstFloat:
+        return VerificationType{Tag: VFloat}, nil
+    case ConstLong:
+        return VerificationType{Tag: VLong}, nil
+    case ConstDouble:
+        return VerificationType{Tag: VDouble}, nil
+    case ConstString:
+        return object("java/lang/String"), nil
+    case ConstClass:
+        return object("java/lang/Class"), nil
+    case ConstMethodType:
+        return object("java/lang/invoke/MethodType"), nil
+    case ConstMethodHandle:
+        return object("java/lang/invoke/MethodHandle"), nil
+    case ConstDynamic:
+        _, _, descriptor := a.cp.GetMemberRef(index)
+        return descriptorType(descriptor), nil
+    default:
+        return VerificationType{}, fmt.Errorf("Constant %s is not loadable", constant)
+    }
+}
+
+// descriptorType returns the verification type of a value of the given field
+// descriptor.
+func descriptorType(descriptor string) VerificationType {
+    switch descriptor[0] {
+    case 'Z', 'B', 'C', 'S', 'I':
+        return VerificationType{Tag: VInteger}
+    case 'F':
+        return VerificationType{Tag: VFloat}
+    case 'J':
+        return VerificationType{Tag: VLong}
+    case 'D':
+        return VerificationType{Tag: VDouble}
+    }
+    return VerificationType{Tag: VObject, Class: descriptorClassName(descriptor)}
+}
+
+func isReferenceDescriptor(descriptor string) bool {
+    return descriptor[0] == 'L' || descriptor[0] == '['
+}
+
+// descriptorClassName converts a reference descriptor to the form used in
+// class constants: Ljava/lang/String; becomes java/lang/String while array
+// descriptors are kept as they are.
+func descriptorClassName(descriptor string) string {
+    if descriptor[0] == 'L' {
+        return descriptor[1:len(descriptor) - 1]
+    }
+    return descriptor
+}
+
+func classNameDescriptor(name string) string {
+    if name[0] == '[' {
+        return name
+    }
+    return "L" + name + ";"
+}
+
+// splitMethodDescriptor returns the argument descriptors and the return
+// descriptor of a method descriptor.
+func splitMethodDescriptor(s string) ([]string, string, error) {
+    m, err := descriptor.ParseMethod(s)
+    if err != nil {
+        return nil, "", err
+    }
+    var args []string
+    for _, arg := range m.Args {
+        args = append(args, arg.String())
+    }
+    return args, m.Return.String(), nil
+}
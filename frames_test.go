@@ -0,0 +1,80 @@
+package jcr
+
+import (
+    "strings"
+    "testing"
+)
+
+// frameHierarchy is p/A with the subclasses p/B and p/C, and the interface
+// p/I.
+func frameHierarchy(t *testing.T) *ClassPath {
+    t.Helper()
+    p := NewClassPath()
+    for _, text := range []string{
+        ".class public super p/A\n.super java/lang/Object\n.end class\n",
+        ".class public super p/B\n.super p/A\n.end class\n",
+        ".class public super p/C\n.super p/A\n.end class\n",
+        ".class public interface abstract p/I\n.super java/lang/Object\n.end class\n",
+    } {
+        p.Add(assemble(t, ".version 52 0\n" + text))
+    }
+    return p
+}
+
+func TestComputeFramesMerge(t *testing.T) {
+    tests := []struct {
+        name string
+        // the types of the values meeting at the join
+        left string
+        right string
+        want VerificationType
+        err string
+    }{
+        {"siblings", "Lp/B;", "Lp/C;", VerificationType{Tag: VObject, Class: "p/A"}, ""},
+        {"subclass", "Lp/B;", "Lp/A;", VerificationType{Tag: VObject, Class: "p/A"}, ""},
+        {"same class", "Lp/B;", "Lp/B;", VerificationType{Tag: VObject, Class: "p/B"}, ""},
+        {"interface", "Lp/B;", "Lp/I;", VerificationType{Tag: VObject, Class: "java/lang/Object"}, ""},
+        {"object", "Lp/C;", "Ljava/lang/Object;", VerificationType{Tag: VObject, Class: "java/lang/Object"}, ""},
+        {"array", "[Lp/B;", "Lp/C;", VerificationType{Tag: VObject, Class: "java/lang/Object"}, ""},
+        {"missing class", "Lp/B;", "Lp/Missing;", VerificationType{}, "p/Missing"},
+    }
+    hierarchy := frameHierarchy(t)
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            class := assemble(t, `.version 52 0
+.class public super p/T
+.super java/lang/Object
+
+.method public static m : (Z` + test.left + test.right + `)Ljava/lang/Object;
+    iload_0
+    ifeq LELSE
+    aload_1
+    goto LJOIN
+LELSE:
+    aload_2
+LJOIN:
+    areturn
+.end method
+.end class
+`)
+            method := &class.Methods[0]
+            frames, err := ComputeFrames(class, method, method.Code(class.ConstantPool), hierarchy)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(frames) != 2 {
+                t.Fatalf("got %d frames, want 2", len(frames))
+            }
+            join := frames[1]
+            if len(join.Stack) != 1 || join.Stack[0] != test.want {
+                t.Fatalf("got stack %v at the join, want [%v]", join.Stack, test.want)
+            }
+        })
+    }
+}
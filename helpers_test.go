@@ -0,0 +1,34 @@
+package jcr
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+)
+
+// assemble reads Krakatau assembly into a class, going through its class
+// file so that the class is what ReadClass would give.
+func assemble(t *testing.T, text string) *Class {
+    t.Helper()
+    var r io.Reader = strings.NewReader(text)
+    class, err := ReadKrakatau(&r)
+    if err != nil {
+        t.Fatalf("assembling: %s", err)
+    }
+    r = bytes.NewReader(classBytes(t, class))
+    if class, err = ReadClass(&r); err != nil {
+        t.Fatalf("reading: %s", err)
+    }
+    return class
+}
+
+func classBytes(t *testing.T, class *Class) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    if err := WriteClass(&w, class); err != nil {
+        t.Fatalf("writing: %s", err)
+    }
+    return buf.Bytes()
+}
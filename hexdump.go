@@ -0,0 +1,221 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// DumpClass writes a class file as a hex dump, each run of bytes next to the
+// part of the class it encodes. The annotations come from ReadClass itself
+// reading through a hexTracer, so the offsets are the ones it used. A file
+// that fails to parse is dumped up to the failure, followed by the rest of
+// its bytes and the error, which is also returned.
+func DumpClass(w *io.Writer, data []byte) error {
+    t := &hexTracer{r: bytes.NewReader(data)}
+    var r io.Reader = t
+    failure := func() (failure any) {
+        defer func() {
+            failure = recover()
+        }()
+        if _, err := ReadClass(&r); err != nil {
+            return err
+        }
+        return nil
+    }()
+    for _, span := range t.spans {
+        writeHexSpan(w, data, span)
+    }
+    if failure == nil {
+        if t.offset < len(data) {
+            writeHexSpan(w, data, hexSpan{t.offset, len(data), "trailing bytes"})
+        }
+        return nil
+    }
+    err, ok := failure.(error)
+    if !ok {
+        err = fmt.Errorf("%v", failure)
+    }
+    // the file may end exactly where the failed read started, leaving no
+    // bytes to label
+    if t.start < len(data) {
+        writeHexSpan(w, data, hexSpan{t.start, len(data), "not parsed"})
+    }
+    io.WriteString(*w, fmt.Sprintf("error: %s\n", err))
+    return err
+}
+
+type hexSpan struct {
+    start int
+    end int
+    label string
+}
+
+// hexTracer counts the bytes read through it. Each trace call labels the
+// bytes read since the previous one, prefixed with the structures entered.
+type hexTracer struct {
+    r io.Reader
+    base int
+    offset int
+    start int
+    context []string
+    cp *ConstantPool
+    spans []hexSpan
+}
+
+func (t *hexTracer) Read(p []byte) (int, error) {
+    n, err := t.r.Read(p)
+    t.offset += n
+    return n, err
+}
+
+func (t *hexTracer) add(start int, end int, label string) {
+    if len(t.context) > 0 {
+        label = strings.Join(t.context, ", ") + ": " + label
+    }
+    t.spans = append(t.spans, hexSpan{t.base + start, t.base + end, label})
+}
+
+func tracer(r *io.Reader) *hexTracer {
+    t, _ := (*r).(*hexTracer)
+    return t
+}
+
+// trace labels the bytes read since the last call when r is traced. Callers
+// whose arguments take work to build check tracer(r) first.
+func trace(r *io.Reader, format string, args ...any) {
+    if t := tracer(r); t != nil {
+        t.add(t.start, t.offset, fmt.Sprintf(format, args...))
+        t.start = t.offset
+    }
+}
+
+func traceEnter(r *io.Reader, format string, args ...any) {
+    if t := tracer(r); t != nil {
+        t.context = append(t.context, fmt.Sprintf(format, args...))
+    }
+}
+
+func traceLeave(r *io.Reader) {
+    if t := tracer(r); t != nil {
+        t.context = t.context[:len(t.context) - 1]
+    }
+}
+
+// constant returns a pool entry once the pool has been read, or nil.
+func (t *hexTracer) constant(index CpIndex) Constant {
+    if t == nil || t.cp == nil || int(index) < 1 || int(index) > len(t.cp.Constants) {
+        return nil
+    }
+    return *t.cp.Get(index)
+}
+
+// traceUtf8 renders a constant pool index for a label, with the string it
+// names when that is known.
+func traceUtf8(r *io.Reader, index CpIndex) string {
+    if utf8, ok := tracer(r).constant(index).(ConstUtf8); ok {
+        return fmt.Sprintf("#%d (%s)", index, utf8.String())
+    }
+    return fmt.Sprintf("#%d", index)
+}
+
+func traceClass(r *io.Reader, index CpIndex) string {
+    if class, ok := tracer(r).constant(index).(ConstClass); ok {
+        if utf8, ok := tracer(r).constant(class.NameIndex).(ConstUtf8); ok {
+            return fmt.Sprintf("#%d (%s)", index, utf8.String())
+        }
+    }
+    return fmt.Sprintf("#%d", index)
+}
+
+func traceConstant(r *io.Reader, index int, constant Constant) {
+    if tracer(r) == nil {
+        return
+    }
+    if utf8, ok := constant.(ConstUtf8); ok {
+        trace(r, "#%d tag %d, length %d: %s", index, constant.Type(), utf8.Length, krakatauPoolEntry(constant))
+        return
+    }
+    trace(r, "#%d tag %d: %s", index, constant.Type(), krakatauPoolEntry(constant))
+}
+
+// traceCode labels the instructions of the byte code just read.
+func traceCode(r *io.Reader, code []byte) {
+    t := tracer(r)
+    if t == nil {
+        return
+    }
+    start := t.offset - len(code)
+    if instrs, err := DecodeInstructions(code); err == nil {
+        for _, instr := range instrs {
+            text := func() (text string) {
+                // operands pointing at broken pool entries leave just the mnemonic
+                defer func() {
+                    if recover() != nil {
+                        text = Mnemonic(instr.Opcode)
+                    }
+                }()
+                text, _, _ = strings.Cut(krakatauInstruction(t.cp, instr, nil, false), "\n")
+                return text
+            }()
+            t.add(start + instr.Offset, start + instr.Offset + instr.Length(), fmt.Sprintf("code %d: %s", instr.Offset, text))
+        }
+        t.start = t.offset
+        return
+    }
+    trace(r, "byte code")
+}
+
+// traceAttribute labels the data of an attribute just read, breaking a Code
+// attribute down by reading it again with ReadCode.
+func traceAttribute(r *io.Reader, a *Attribute) {
+    t := tracer(r)
+    if t == nil {
+        return
+    }
+    if name, ok := t.constant(a.NameIndex).(ConstUtf8); !ok || name.String() != "Code" {
+        trace(r, "attribute data")
+        return
+    }
+    inner := &hexTracer{r: bytes.NewReader(a.Info), base: t.base + t.start, cp: t.cp}
+    inner.context = append(append(inner.context, t.context...), "Code")
+    var code Code
+    var ir io.Reader = inner
+    failed := func() (failed bool) {
+        defer func() {
+            failed = recover() != nil
+        }()
+        ReadCode(&ir, &code)
+        return false
+    }()
+    t.spans = append(t.spans, inner.spans...)
+    t.start += inner.start
+    if failed || inner.start < len(a.Info) {
+        trace(r, "malformed Code attribute data")
+    }
+    t.start = t.offset
+}
+
+// writeHexSpan writes the bytes of a span sixteen to a line, labelling the
+// first.
+func writeHexSpan(w *io.Writer, data []byte, span hexSpan) {
+    label := span.label
+    for start := span.start; start < span.end; start += 16 {
+        end := start + 16
+        if end > span.end {
+            end = span.end
+        }
+        var hex, text strings.Builder
+        for i := start; i < end; i++ {
+            hex.WriteString(fmt.Sprintf("%02x ", data[i]))
+            if data[i] >= 0x20 && data[i] < 0x7f {
+                text.WriteByte(data[i])
+            } else {
+                text.WriteByte('.')
+            }
+        }
+        io.WriteString(*w, strings.TrimRight(fmt.Sprintf("%08x  %-48s |%-16s|  %s", start, hex.String(), text.String(), label), " ") + "\n")
+        label = ""
+    }
+}
@@ -0,0 +1,350 @@
+package jcr
+
+import (
+    "encoding/json"
+    "fmt"
+    "html"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// WriteHTML writes a static site for browsing classes into dir: index.html
+// listing the packages, a page per class with its members, disassembly and
+// constant pool, and search.json indexing every class, field and method.
+// References to classes and members defined among classes are links. The
+// index is also written to search.js, which the search box on the index
+// loads with a script tag so that it works on pages opened from disk, where
+// browsers block fetching search.json.
+func WriteHTML(classes []*Class, dir string) error {
+    s := &htmlSite{classes: make(map[string]*Class)}
+    for _, c := range classes {
+        s.classes[c.Name()] = c
+    }
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return err
+    }
+    var search []htmlSearchEntry
+    for _, c := range classes {
+        page, entries, err := s.classPage(c)
+        if err != nil {
+            return fmt.Errorf("%s: %s", c.Name(), err)
+        }
+        if err := os.WriteFile(filepath.Join(dir, htmlPage(c.Name())), []byte(page), 0644); err != nil {
+            return err
+        }
+        search = append(search, entries...)
+    }
+    if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte(s.indexPage()), 0644); err != nil {
+        return err
+    }
+    index, err := json.MarshalIndent(search, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.WriteFile(filepath.Join(dir, "search.json"), index, 0644); err != nil {
+        return err
+    }
+    script := "var searchIndex = " + string(index) + ";\n"
+    return os.WriteFile(filepath.Join(dir, "search.js"), []byte(script), 0644)
+}
+
+type htmlSite struct {
+    classes map[string]*Class
+}
+
+type htmlSearchEntry struct {
+    Name string `json:"name"`
+    Kind string `json:"kind"`
+    Class string `json:"class,omitempty"`
+    Href string `json:"href"`
+}
+
+// htmlPage is the file name of a class page; all pages sit in one directory
+// so links between them need no relative paths.
+func htmlPage(name string) string {
+    return strings.ReplaceAll(name, "/", ".") + ".html"
+}
+
+const htmlStyle = `body { font-family: sans-serif; margin: 2em; }
+pre, code, td { font-family: monospace; }
+pre { background: #f6f6f6; padding: 0.5em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.1em 0.5em; text-align: left; vertical-align: top; }
+a { text-decoration: none; }
+a:hover { text-decoration: underline; }
+:target { background: #ffc; }`
+
+func htmlHeader(title string) string {
+    return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + html.EscapeString(title) +
+        "</title>\n<style>\n" + htmlStyle + "\n</style>\n</head>\n<body>\n"
+}
+
+func (s *htmlSite) indexPage() string {
+    packages := make(map[string][]string)
+    for name := range s.classes {
+        pkg := ""
+        if slash := strings.LastIndex(name, "/"); slash >= 0 {
+            pkg = name[:slash]
+        }
+        packages[pkg] = append(packages[pkg], name)
+    }
+    var names []string
+    for pkg := range packages {
+        names = append(names, pkg)
+    }
+    sort.Strings(names)
+
+    var b strings.Builder
+    b.WriteString(htmlHeader("Packages"))
+    b.WriteString("<h1>Packages</h1>\n")
+    b.WriteString("<p><input id=\"search\" placeholder=\"Search\" size=\"40\"></p>\n<ul id=\"results\"></ul>\n<ul>\n")
+    for _, pkg := range names {
+        b.WriteString(fmt.Sprintf("<li><a href=\"#%s\">%s</a></li>\n", htmlPackageID(pkg), html.EscapeString(htmlPackageName(pkg))))
+    }
+    b.WriteString("</ul>\n")
+    for _, pkg := range names {
+        b.WriteString(fmt.Sprintf("<h2 id=\"%s\">%s</h2>\n<ul>\n", htmlPackageID(pkg), html.EscapeString(htmlPackageName(pkg))))
+        sort.Strings(packages[pkg])
+        for _, name := range packages[pkg] {
+            simple := name
+            if pkg != "" {
+                simple = name[len(pkg) + 1:]
+            }
+            b.WriteString(fmt.Sprintf("<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(htmlPage(name)),
+                html.EscapeString(simple)))
+        }
+        b.WriteString("</ul>\n")
+    }
+    b.WriteString(htmlSearchScript)
+    b.WriteString("</body>\n</html>\n")
+    return b.String()
+}
+
+const htmlSearchScript = `<script src="search.js"></script>
+<script>
+(window.searchIndex ? Promise.resolve(window.searchIndex) : fetch("search.json").then(r => r.json())).then(index => {
+  const input = document.getElementById("search");
+  const results = document.getElementById("results");
+  input.addEventListener("input", () => {
+    const term = input.value.toLowerCase();
+    results.replaceChildren();
+    if (!term) {
+      return;
+    }
+    for (const entry of index.filter(e => e.name.toLowerCase().includes(term)).slice(0, 100)) {
+      const link = document.createElement("a");
+      link.href = entry.href;
+      link.textContent = entry.kind + " " + entry.name + (entry.class ? " in " + entry.class : "");
+      const item = document.createElement("li");
+      item.appendChild(link);
+      results.appendChild(item);
+    }
+  });
+});
+</script>
+`
+
+func htmlPackageName(pkg string) string {
+    if pkg == "" {
+        return "(default package)"
+    }
+    return strings.ReplaceAll(pkg, "/", ".")
+}
+
+func htmlPackageID(pkg string) string {
+    return "package-" + strings.ReplaceAll(pkg, "/", ".")
+}
+
+// classHref links to the page of a class, or of the element class of an
+// array, when it is part of the site.
+func (s *htmlSite) classHref(name string) string {
+    name = strings.TrimLeft(name, "[")
+    if strings.HasPrefix(name, "L") && strings.HasSuffix(name, ";") {
+        name = name[1:len(name) - 1]
+    }
+    if _, ok := s.classes[name]; !ok {
+        return ""
+    }
+    return htmlPage(name)
+}
+
+// memberHref links to the definition of a field or method, looking through
+// the superclasses and interfaces of owner as the JVM resolves references.
+func (s *htmlSite) memberHref(owner string, name string, descriptor string, field bool) string {
+    seen := make(map[string]bool)
+    var find func(owner string) string
+    find = func(owner string) string {
+        c, ok := s.classes[owner]
+        if !ok || seen[owner] {
+            return ""
+        }
+        seen[owner] = true
+        cp := c.ConstantPool
+        if field {
+            for i, f := range c.Fields {
+                if cp.GetUtf8(f.NameIndex) == name && cp.GetUtf8(f.DescriptorIndex) == descriptor {
+                    return fmt.Sprintf("%s#field-%d", htmlPage(owner), i)
+                }
+            }
+        } else {
+            for i, m := range c.Methods {
+                if cp.GetUtf8(m.NameIndex) == name && cp.GetUtf8(m.DescriptorIndex) == descriptor {
+                    return fmt.Sprintf("%s#method-%d", htmlPage(owner), i)
+                }
+            }
+        }
+        if super := c.SuperName(); super != "" {
+            if href := find(super); href != "" {
+                return href
+            }
+        }
+        for _, iface := range c.Interfaces {
+            if href := find(cp.GetClassName(iface)); href != "" {
+                return href
+            }
+        }
+        return ""
+    }
+    return find(owner)
+}
+
+// refHref links a constant pool entry referring to a class or member.
+func (s *htmlSite) refHref(cp *ConstantPool, index CpIndex) string {
+    switch c := (*cp.Get(index)).(type) {
+    case ConstClass:
+        return s.classHref(cp.GetUtf8(c.NameIndex))
+    case ConstField:
+        owner, name, descriptor := cp.GetMemberRef(index)
+        return s.memberHref(owner, name, descriptor, true)
+    case ConstMethod, ConstInterfaceMethod:
+        owner, name, descriptor := cp.GetMemberRef(index)
+        return s.memberHref(owner, name, descriptor, false)
+    case ConstMethodHandle:
+        return s.refHref(cp, c.ReferenceIndex)
+    }
+    return ""
+}
+
+func htmlLink(href string, text string) string {
+    if href == "" {
+        return html.EscapeString(text)
+    }
+    return fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(href), html.EscapeString(text))
+}
+
+func (s *htmlSite) classPage(c *Class) (string, []htmlSearchEntry, error) {
+    cp := c.ConstantPool
+    page := htmlPage(c.Name())
+    title := strings.ReplaceAll(c.Name(), "/", ".")
+    entries := []htmlSearchEntry{{Name: title, Kind: "class", Href: page}}
+    bootstraps, err := c.BootstrapMethods()
+    if err != nil {
+        return "", nil, err
+    }
+
+    var b strings.Builder
+    b.WriteString(htmlHeader(title))
+    b.WriteString("<p><a href=\"index.html\">Packages</a></p>\n")
+    b.WriteString("<h1><code>" + html.EscapeString(javapClassHeader(c)) + "</code></h1>\n<ul>\n")
+    if super := c.SuperName(); super != "" {
+        b.WriteString("<li>Superclass: <code>" + htmlLink(s.classHref(super), super) + "</code></li>\n")
+    }
+    for _, iface := range c.Interfaces {
+        name := cp.GetClassName(iface)
+        b.WriteString("<li>Interface: <code>" + htmlLink(s.classHref(name), name) + "</code></li>\n")
+    }
+    b.WriteString(fmt.Sprintf("<li>Version: %d.%d</li>\n</ul>\n", c.Major, c.Minor))
+
+    if len(c.Fields) > 0 {
+        b.WriteString("<h2>Fields</h2>\n<ul>\n")
+    }
+    for i := range c.Fields {
+        field := &c.Fields[i]
+        id := fmt.Sprintf("field-%d", i)
+        b.WriteString(fmt.Sprintf("<li id=\"%s\"><code>%s</code></li>\n", id, html.EscapeString(javapFieldHeader(cp, field))))
+        entries = append(entries, htmlSearchEntry{Name: cp.GetUtf8(field.NameIndex), Kind: "field", Class: title, Href: page + "#" + id})
+    }
+    if len(c.Fields) > 0 {
+        b.WriteString("</ul>\n")
+    }
+
+    if len(c.Methods) > 0 {
+        b.WriteString("<h2>Methods</h2>\n")
+    }
+    for i := range c.Methods {
+        method := &c.Methods[i]
+        id := fmt.Sprintf("method-%d", i)
+        header, err := javapMethodHeader(c, method)
+        if err != nil {
+            return "", nil, err
+        }
+        b.WriteString(fmt.Sprintf("<h3 id=\"%s\"><code>%s</code></h3>\n", id, html.EscapeString(header)))
+        entries = append(entries, htmlSearchEntry{Name: cp.GetUtf8(method.NameIndex) + cp.GetUtf8(method.DescriptorIndex),
+            Kind: "method", Class: title, Href: page + "#" + id})
+        if code := method.Code(cp); code != nil {
+            if err := s.code(&b, c, id, code, bootstraps); err != nil {
+                return "", nil, err
+            }
+        }
+    }
+
+    b.WriteString("<h2>Constant pool</h2>\n<table>\n<tr><th>#</th><th>Tag</th><th>Value</th><th>Resolves to</th></tr>\n")
+    for i := 1; i < int(cp.Count()); i++ {
+        constant := *cp.Get(CpIndex(i))
+        if constant == nil {
+            continue
+        }
+        args, comment := javapPoolEntry(cp, CpIndex(i))
+        b.WriteString(fmt.Sprintf("<tr id=\"cp-%d\"><td>%d</td><td>%s</td><td>%s</td><td>%s</td></tr>\n", i, i,
+            javapTags[constant.Type()], html.EscapeString(args), htmlLink(s.refHref(cp, CpIndex(i)), comment)))
+    }
+    b.WriteString("</table>\n</body>\n</html>\n")
+    return b.String(), entries, nil
+}
+
+var htmlLabel = regexp.MustCompile(`\bL\d+\b`)
+
+// code writes the disassembly of a method. Every instruction is anchored by
+// its label so that branches link to their targets.
+func (s *htmlSite) code(b *strings.Builder, c *Class, id string, code *Code, bootstraps []BootstrapMethod) error {
+    cp := c.ConstantPool
+    instrs, err := DecodeInstructions(code.ByteCode)
+    if err != nil {
+        return err
+    }
+    b.WriteString(fmt.Sprintf("<pre>.limit stack %d\n.limit locals %d\n", code.MaxStack, code.MaxLocals))
+    for _, instr := range instrs {
+        op := instr.Opcode
+        text := html.EscapeString(krakatauInstruction(cp, instr, bootstraps, false))
+        switch {
+        case len(instr.Targets()) > 0:
+            text = htmlLabel.ReplaceAllStringFunc(text, func(label string) string {
+                return fmt.Sprintf("<a href=\"#%s-%s\">%s</a>", id, label, label)
+            })
+        case op == Ldc || op == LdcW || op >= Getstatic && op <= Invokeinterface ||
+            op == New || op == Anewarray || op == Checkcast || op == Instanceof || op == Multianewarray:
+            if href := s.refHref(cp, instr.Index()); href != "" {
+                mnemonic, operands, _ := strings.Cut(text, " ")
+                text = fmt.Sprintf("%s <a href=\"%s\">%s</a>", mnemonic, html.EscapeString(href), operands)
+            }
+        }
+        label := krakatauLabel(instr.Offset)
+        b.WriteString(fmt.Sprintf("<span id=\"%s-%s\">%6s:</span> %s\n", id, label, label, text))
+    }
+    for _, handler := range code.ExceptionHandlers {
+        catchType := "any"
+        if !handler.IsFinally() {
+            name := cp.GetClassName(handler.CatchType)
+            catchType = htmlLink(s.classHref(name), name)
+        }
+        b.WriteString(fmt.Sprintf(".catch %s from %s to %s using <a href=\"#%s-%s\">%s</a>\n", catchType,
+            krakatauLabel(int(handler.StartPc)), krakatauLabel(int(handler.EndPc)),
+            id, krakatauLabel(int(handler.HandlerPc)), krakatauLabel(int(handler.HandlerPc))))
+    }
+    b.WriteString("</pre>\n")
+    return nil
+}
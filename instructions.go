@@ -0,0 +1,172 @@
+package jcr
+
+import (
+    "encoding/binary"
+    "fmt"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// Instruction is a decoded instruction along with its offset in the code.
+// Unlike ReadByteCode, DecodeInstructions understands tableswitch,
+// lookupswitch and wide. For wide the Operands start with the modified
+// opcode; for the switches they include the alignment padding.
+type Instruction struct {
+    Instr
+    Offset int
+}
+
+func (i Instruction) Length() int {
+    return 1 + len(i.Operands)
+}
+
+// Index returns the constant pool index operand of ldc, field, method, type
+// and invoke instructions.
+func (i Instruction) Index() CpIndex {
+    if i.Opcode == Ldc {
+        return CpIndex(i.Operands[0])
+    }
+    return CpIndex(binary.BigEndian.Uint16(i.Operands))
+}
+
+// Local returns the local variable index used by a load, store, iinc or ret,
+// including the implicit index of the short forms such as iload_2.
+func (i Instruction) Local() int {
+    op := i.Opcode
+    switch {
+    case op == Wide:
+        return int(binary.BigEndian.Uint16(i.Operands[1:]))
+    case op >= Iload0 && op <= Aload3:
+        return int(op - Iload0) % 4
+    case op >= Istore0 && op <= Astore3:
+        return int(op - Istore0) % 4
+    }
+    return int(i.Operands[0])
+}
+
+// Targets returns the absolute offsets that a branch or switch may jump to.
+// For switches the default target comes first.
+func (i Instruction) Targets() []int {
+    switch i.Opcode {
+    case Goto, Jsr, Ifnull, Ifnonnull:
+        return []int{i.Offset + int(int16(binary.BigEndian.Uint16(i.Operands)))}
+    case Gotow, Jsrw:
+        return []int{i.Offset + int(int32(binary.BigEndian.Uint32(i.Operands)))}
+    case Tableswitch, Lookupswitch:
+        dflt, _, targets := i.Switch()
+        return append([]int{dflt}, targets...)
+    }
+    if i.Opcode >= Ifeq && i.Opcode <= Ifacmpne {
+        return []int{i.Offset + int(int16(binary.BigEndian.Uint16(i.Operands)))}
+    }
+    return nil
+}
+
+// Switch decodes a tableswitch or lookupswitch into its absolute default
+// target and its case keys and targets.
+func (i Instruction) Switch() (int, []int32, []int) {
+    table := i.Operands[switchPadding(i.Offset):]
+    dflt := i.Offset + int(int32(binary.BigEndian.Uint32(table)))
+    var keys []int32
+    var targets []int
+    if i.Opcode == Tableswitch {
+        low := int32(binary.BigEndian.Uint32(table[4:]))
+        high := int32(binary.BigEndian.Uint32(table[8:]))
+        for k := 0; k <= int(high) - int(low); k++ {
+            keys = append(keys, low + int32(k))
+            targets = append(targets, i.Offset + int(int32(binary.BigEndian.Uint32(table[12 + 4 * k:]))))
+        }
+    } else {
+        pairs := int(binary.BigEndian.Uint32(table[4:]))
+        for k := 0; k < pairs; k++ {
+            keys = append(keys, int32(binary.BigEndian.Uint32(table[8 + 8 * k:])))
+            targets = append(targets, i.Offset + int(int32(binary.BigEndian.Uint32(table[12 + 8 * k:]))))
+        }
+    }
+    return dflt, keys, targets
+}
+
+// IsTerminal reports whether execution never falls through to the next
+// instruction.
+func (i Instruction) IsTerminal() bool {
+    switch i.Opcode {
+    case Goto, Gotow, Ret, Tableswitch, Lookupswitch, Athrow,
+        Ireturn, Lreturn, Freturn, Deturn, Areturn, Return:
+        return true
+    case Wide:
+        return Opcode(i.Operands[0]) == Ret
+    }
+    return false
+}
+
+func DecodeInstructions(code []byte) ([]Instruction, error) {
+    var instrs []Instruction
+    offset := 0
+    for offset < len(code) {
+        length, err := operandLength(code, offset)
+        if err != nil {
+            return nil, err
+        }
+        end := offset + 1 + length
+        if end > len(code) {
+            return nil, fmt.Errorf("Truncated %s instruction at offset %d", Opcode(code[offset]), offset)
+        }
+        instrs = append(instrs, Instruction{
+            Instr: Instr{Opcode: Opcode(code[offset]), Operands: code[offset + 1:end]},
+            Offset: offset,
+        })
+        offset = end
+    }
+    return instrs, nil
+}
+
+func operandLength(code []byte, offset int) (int, error) {
+    op := Opcode(code[offset])
+    switch op {
+    case Bipush, Ldc, Iload, Lload, Fload, Dload, Aload,
+        Istore, Lstore, Fstore, Dstore, Astore, Ret, Newarray:
+        return 1, nil
+    case Sipush, LdcW, Ldc2W, Iinc, Goto, Jsr, Ifnull, Ifnonnull,
+        Getstatic, Putstatic, Getfield, Putfield,
+        Invokevirtual, Invokespecial, Invokestatic,
+        New, Anewarray, Checkcast, Instanceof:
+        return 2, nil
+    case Multianewarray:
+        return 3, nil
+    case Invokeinterface, Invokedynamic, Gotow, Jsrw:
+        return 4, nil
+    case Wide:
+        if offset + 1 >= len(code) {
+            return 0, fmt.Errorf("Truncated wide instruction at offset %d", offset)
+        }
+        if Opcode(code[offset + 1]) == Iinc {
+            return 5, nil
+        }
+        return 3, nil
+    case Tableswitch, Lookupswitch:
+        pad := switchPadding(offset)
+        header := offset + 1 + pad
+        if header + 12 > len(code) {
+            return 0, fmt.Errorf("Truncated %s at offset %d", op, offset)
+        }
+        if op == Tableswitch {
+            low := int32(binary.BigEndian.Uint32(code[header + 4:]))
+            high := int32(binary.BigEndian.Uint32(code[header + 8:]))
+            if high < low {
+                return 0, fmt.Errorf("Tableswitch at offset %d has high < low", offset)
+            }
+            return pad + 12 + 4 * (int(high) - int(low) + 1), nil
+        }
+        pairs := int(int32(binary.BigEndian.Uint32(code[header + 4:])))
+        if pairs < 0 {
+            return 0, fmt.Errorf("Lookupswitch at offset %d has a negative pair count", offset)
+        }
+        return pad + 8 + 8 * pairs, nil
+    }
+    if op >= Ifeq && op <= Ifacmpne {
+        return 2, nil
+    }
+    if op > Jsrw && op != Breakpoint && op != Impdep1 && op != Impdep2 {
+        return 0, fmt.Errorf("Unknown opcode 0x%x at offset %d", byte(op), offset)
+    }
+    return 0, nil
+}
@@ -0,0 +1,253 @@
+package jcr
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+    "strings"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// JasminWriter disassembles a class into Jasmin assembly. Jasmin has no
+// syntax for stack map frames, bootstrap methods or most attributes, so those
+// are left out; constants it cannot express are written as comments.
+type JasminWriter struct {}
+
+func (j JasminWriter) Write(w *io.Writer, class *Class) error {
+    cp := class.ConstantPool
+
+    io.WriteString(*w, fmt.Sprintf(".bytecode %d.%d\n", class.Major, class.Minor))
+    if attr := FindAttribute(cp, class.Attributes, "SourceFile"); attr != nil {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return err
+        }
+        io.WriteString(*w, ".source " + cp.GetUtf8(index) + "\n")
+    }
+    directive := ".class"
+    if class.Flags.IsInterface() {
+        directive = ".interface"
+    }
+    io.WriteString(*w, directive + jasminFlags(class.Flags &^ (FLAG_SUPER | FLAG_INTERFACE), classFlagNames))
+    io.WriteString(*w, " " + class.Name() + "\n")
+    super := class.SuperName()
+    if super == "" {
+        super = "java/lang/Object"
+    }
+    io.WriteString(*w, ".super " + super + "\n")
+    for _, iface := range class.Interfaces {
+        io.WriteString(*w, ".implements " + cp.GetClassName(iface) + "\n")
+    }
+
+    if len(class.Fields) > 0 {
+        io.WriteString(*w, "\n")
+    }
+    for _, field := range class.Fields {
+        io.WriteString(*w, ".field" + jasminFlags(field.Flags, fieldFlagNames))
+        io.WriteString(*w, " " + jasminName(cp.GetUtf8(field.NameIndex)) + " " + cp.GetUtf8(field.DescriptorIndex))
+        if attr := FindAttribute(cp, field.Attributes, "ConstantValue"); attr != nil {
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, " = " + jasminConstant(cp, index))
+        }
+        io.WriteString(*w, "\n")
+    }
+
+    bootstraps, err := class.BootstrapMethods()
+    if err != nil {
+        return err
+    }
+    for i := range class.Methods {
+        method := &class.Methods[i]
+        io.WriteString(*w, "\n.method" + jasminFlags(method.Flags, methodFlagNames))
+        io.WriteString(*w, " " + jasminName(cp.GetUtf8(method.NameIndex)) + cp.GetUtf8(method.DescriptorIndex) + "\n")
+        if attr := FindAttribute(cp, method.Attributes, "Exceptions"); attr != nil {
+            exceptions, err := DecodeExceptions(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, exception := range exceptions {
+                io.WriteString(*w, "    .throws " + cp.GetClassName(exception) + "\n")
+            }
+        }
+        if code := method.Code(cp); code != nil {
+            if err := writeJasminCode(w, cp, code, bootstraps); err != nil {
+                return err
+            }
+        }
+        io.WriteString(*w, ".end method\n")
+    }
+    return nil
+}
+
+func writeJasminCode(w *io.Writer, cp *ConstantPool, code *Code, bootstraps []BootstrapMethod) error {
+    io.WriteString(*w, fmt.Sprintf("    .limit stack %d\n", code.MaxStack))
+    io.WriteString(*w, fmt.Sprintf("    .limit locals %d\n", code.MaxLocals))
+
+    instructions, err := DecodeInstructions(code.ByteCode)
+    if err != nil {
+        return err
+    }
+
+    labels := make(map[int]bool)
+    for _, instr := range instructions {
+        for _, target := range instr.Targets() {
+            labels[target] = true
+        }
+    }
+    for _, handler := range code.ExceptionHandlers {
+        labels[int(handler.StartPc)] = true
+        labels[int(handler.EndPc)] = true
+        labels[int(handler.HandlerPc)] = true
+    }
+    lines := make(map[int][]uint16)
+    var locals []LocalVariable
+    for _, attr := range code.Attributes {
+        switch cp.GetUtf8(attr.NameIndex) {
+        case "LineNumberTable":
+            table, err := DecodeLineNumberTable(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, entry := range table {
+                lines[int(entry.StartPc)] = append(lines[int(entry.StartPc)], entry.Line)
+            }
+        case "LocalVariableTable":
+            table, err := DecodeLocalVariableTable(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, entry := range table {
+                labels[int(entry.StartPc)] = true
+                labels[int(entry.StartPc) + int(entry.Length)] = true
+            }
+            locals = append(locals, table...)
+        }
+    }
+
+    for _, local := range locals {
+        io.WriteString(*w, fmt.Sprintf("    .var %d is %s %s from %s to %s\n", local.Index,
+            jasminName(cp.GetUtf8(local.NameIndex)), cp.GetUtf8(local.DescriptorIndex),
+            krakatauLabel(int(local.StartPc)), krakatauLabel(int(local.StartPc) + int(local.Length))))
+    }
+    for _, handler := range code.ExceptionHandlers {
+        catchType := "all"
+        if !handler.IsFinally() {
+            catchType = cp.GetClassName(handler.CatchType)
+        }
+        io.WriteString(*w, fmt.Sprintf("    .catch %s from %s to %s using %s\n", catchType,
+            krakatauLabel(int(handler.StartPc)), krakatauLabel(int(handler.EndPc)), krakatauLabel(int(handler.HandlerPc))))
+    }
+
+    for _, instr := range instructions {
+        if labels[instr.Offset] {
+            io.WriteString(*w, krakatauLabel(instr.Offset) + ":\n")
+        }
+        for _, line := range lines[instr.Offset] {
+            io.WriteString(*w, fmt.Sprintf("    .line %d\n", line))
+        }
+        io.WriteString(*w, "    " + jasminInstruction(cp, instr, bootstraps) + "\n")
+    }
+    if labels[len(code.ByteCode)] {
+        io.WriteString(*w, krakatauLabel(len(code.ByteCode)) + ":\n")
+    }
+    return nil
+}
+
+func jasminInstruction(cp *ConstantPool, instr Instruction, bootstraps []BootstrapMethod) string {
+    op := instr.Opcode
+    text := Mnemonic(op)
+    switch {
+    case op == Ldc || op == LdcW || op == Ldc2W:
+        return text + " " + jasminConstant(cp, instr.Index())
+    case op == Wide:
+        // Jasmin picks the wide encoding itself when an operand needs it
+        inner := Opcode(instr.Operands[0])
+        if inner == Iinc {
+            return fmt.Sprintf("%s %d %d", Mnemonic(inner), instr.Local(), int16(uint16(instr.Operands[3]) << 8 | uint16(instr.Operands[4])))
+        }
+        return fmt.Sprintf("%s %d", Mnemonic(inner), instr.Local())
+    case op == Tableswitch:
+        dflt, keys, targets := instr.Switch()
+        text = fmt.Sprintf("%s %d %d", text, keys[0], keys[0] + int32(len(targets)) - 1)
+        for _, target := range targets {
+            text += "\n        " + krakatauLabel(target)
+        }
+        return text + "\n        default : " + krakatauLabel(dflt)
+    case op >= Getstatic && op <= Putfield:
+        class, name, descriptor := cp.GetMemberRef(instr.Index())
+        return fmt.Sprintf("%s %s/%s %s", text, class, name, descriptor)
+    case op >= Invokevirtual && op <= Invokestatic:
+        class, name, descriptor := cp.GetMemberRef(instr.Index())
+        return fmt.Sprintf("%s %s/%s%s", text, class, name, descriptor)
+    case op == Invokeinterface:
+        class, name, descriptor := cp.GetMemberRef(instr.Index())
+        return fmt.Sprintf("%s %s/%s%s %d", text, class, name, descriptor, instr.Operands[2])
+    case op == Invokedynamic:
+        indy := (*cp.Get(instr.Index())).(ConstInvokeDynamic)
+        _, name, descriptor := cp.GetMemberRef(instr.Index())
+        return fmt.Sprintf("%s %s%s ; bootstrap %s", text, name, descriptor,
+            krakatauCallSite(cp, indy.BootstrapMethodAttrIndex, indy.NameAndTypeIndex, bootstraps))
+    }
+    // the remaining operand forms are written the same way as in Krakatau
+    return krakatauInstruction(cp, instr, bootstraps, false)
+}
+
+// jasminConstant writes a loadable constant. Numbers carry no suffix since
+// Jasmin takes the type from the instruction or field descriptor.
+func jasminConstant(cp *ConstantPool, index CpIndex) string {
+    switch constant := (*cp.Get(index)).(type) {
+    case ConstInteger:
+        return strconv.FormatInt(int64(constant.Value), 10)
+    case ConstFloat:
+        return jasminFloat(float64(constant.Value), "Float")
+    case ConstLong:
+        return strconv.FormatInt(constant.Value, 10)
+    case ConstDouble:
+        return jasminFloat(constant.Value, "Double")
+    case ConstString:
+        return krakatauString(cp.GetUtf8(constant.StringIndex))
+    case ConstClass:
+        return cp.GetUtf8(constant.NameIndex)
+    }
+    return "0 ; unsupported constant " + krakatauConstant(cp, index, nil)
+}
+
+// jasminFloat writes a float or double, with the NaN and infinities spelled
+// the way Jasmin reads them, such as +FloatNaN and -DoubleInfinity. kind is
+// Float or Double.
+func jasminFloat(v float64, kind string) string {
+    switch {
+    case math.IsNaN(v):
+        return "+" + kind + "NaN"
+    case math.IsInf(v, 1):
+        return "+" + kind + "Infinity"
+    case math.IsInf(v, -1):
+        return "-" + kind + "Infinity"
+    }
+    bits := 64
+    if kind == "Float" {
+        bits = 32
+    }
+    return strings.TrimSuffix(krakatauFloat(v, bits), "f")
+}
+
+// jasminFlags names the flags Jasmin knows, dropping bits that have no name.
+func jasminFlags(f AccessFlag, names []flagName) string {
+    var named AccessFlag
+    for _, n := range names {
+        named |= n.flag
+    }
+    return flags(f & named, names)
+}
+
+// jasminName quotes names that contain spaces or would be read as a flag.
+func jasminName(name string) string {
+    if _, isFlag := flagNames[name]; isFlag || strings.ContainsAny(name, " \t") {
+        return "'" + name + "'"
+    }
+    return name
+}
@@ -1,8 +1,11 @@
 package jcr
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
     "io"
 )
 
@@ -29,6 +32,20 @@ type Class struct {
     Attributes []Attribute
 }
 
+// Name returns the internal name of the class, e.g. java/lang/String.
+func (c *Class) Name() string {
+    return c.ConstantPool.GetClassName(c.ThisIndex)
+}
+
+// SuperName returns the internal name of the super class, or "" for
+// java/lang/Object.
+func (c *Class) SuperName() string {
+    if c.SuperIndex == 0 {
+        return ""
+    }
+    return c.ConstantPool.GetClassName(c.SuperIndex)
+}
+
 const FLAG_PUBLIC = 0x0001
 const FLAG_PRIVATE = 0x0002
 const FLAG_PROTECTED = 0x0004
@@ -43,6 +60,13 @@ const FLAG_SYNTHETIC = 0x1000
 const FLAG_ANNOTATION = 0x2000
 const FLAG_ENUM = 0x4000
 
+// Method flags sharing bits with the field and class flags above.
+const FLAG_SYNCHRONIZED = 0x0020
+const FLAG_BRIDGE = 0x0040
+const FLAG_VARARGS = 0x0080
+const FLAG_NATIVE = 0x0100
+const FLAG_STRICT = 0x0800
+
 type AccessFlag uint16
 func (a AccessFlag) IsPublic() bool {
     return a & FLAG_PUBLIC > 0
@@ -59,6 +83,9 @@ func (a AccessFlag) IsAbstract() bool {
 func (a AccessFlag) IsEnum() bool {
     return a & FLAG_ENUM > 0
 }
+func (a AccessFlag) IsInterface() bool {
+    return a & FLAG_INTERFACE > 0
+}
 func (a AccessFlag) String() string {
     return fmt.Sprint(strconv.FormatInt(int64(a), 2))
 }
@@ -78,7 +105,10 @@ const (
     TNameType ConstantType = 12
     TMethodHandle ConstantType = 15
     TMethodType ConstantType = 16
-    TInvokeDynamic ConstantType = 17
+    TDynamic ConstantType = 17
+    TInvokeDynamic ConstantType = 18
+    TModule ConstantType = 19
+    TPackage ConstantType = 20
 )
 func (ct ConstantType) String() string {
     switch ct {
@@ -121,9 +151,18 @@ func (ct ConstantType) String() string {
         case TMethodType: {
             return "TMethodType"
         }
+        case TDynamic: {
+            return "TDynamic"
+        }
         case TInvokeDynamic: {
             return "TInvokeDynamic"
         }
+        case TModule: {
+            return "TModule"
+        }
+        case TPackage: {
+            return "TPackage"
+        }
         default: {
             return fmt.Sprintf("%d", ct)
         }
@@ -147,10 +186,163 @@ func (cp *ConstantPool) GetUtf8(index CpIndex) string {
     panic(fmt.Sprintf("Constant at index %d is not Utf8", index))
 }
 
+// GetClassName returns the internal name of the class constant at index.
+func (cp *ConstantPool) GetClassName(index CpIndex) string {
+    constant := *cp.Get(index)
+    if class, ok := constant.(ConstClass); ok {
+        return cp.GetUtf8(class.NameIndex)
+    }
+    panic(fmt.Sprintf("Constant at index %d is not a Class", index))
+}
+
+// GetModuleName returns the name of the module constant at index.
+func (cp *ConstantPool) GetModuleName(index CpIndex) string {
+    if module, ok := (*cp.Get(index)).(ConstModule); ok {
+        return cp.GetUtf8(module.NameIndex)
+    }
+    panic(fmt.Sprintf("Constant at index %d is not a Module", index))
+}
+
+// GetPackageName returns the internal name, e.g. java/lang, of the package
+// constant at index.
+func (cp *ConstantPool) GetPackageName(index CpIndex) string {
+    if pkg, ok := (*cp.Get(index)).(ConstPackage); ok {
+        return cp.GetUtf8(pkg.NameIndex)
+    }
+    panic(fmt.Sprintf("Constant at index %d is not a Package", index))
+}
+
+// GetMemberRef resolves a field, method, interface method or dynamic constant
+// into its class name, member name and descriptor. Dynamic constants have no
+// class and return an empty class name.
+func (cp *ConstantPool) GetMemberRef(index CpIndex) (string, string, string) {
+    var classIndex, nameTypeIndex CpIndex
+    switch constant := (*cp.Get(index)).(type) {
+    case ConstField:
+        classIndex, nameTypeIndex = constant.ClassIndex, constant.NameAndTypeIndex
+    case ConstMethod:
+        classIndex, nameTypeIndex = constant.ClassIndex, constant.NameAndTypeIndex
+    case ConstInterfaceMethod:
+        classIndex, nameTypeIndex = constant.ClassIndex, constant.NameAndTypeIndex
+    case ConstDynamic:
+        nameTypeIndex = constant.NameAndTypeIndex
+    case ConstInvokeDynamic:
+        nameTypeIndex = constant.NameAndTypeIndex
+    default:
+        panic(fmt.Sprintf("Constant at index %d is not a member reference", index))
+    }
+    nameType := (*cp.Get(nameTypeIndex)).(ConstNameType)
+    class := ""
+    if classIndex != 0 {
+        class = cp.GetClassName(classIndex)
+    }
+    return class, cp.GetUtf8(nameType.NameIndex), cp.GetUtf8(nameType.DescriptorIndex)
+}
+
 func (cp *ConstantPool) Add(c Constant) CpIndex {
     // TODO JH need to constrain the number of items in the constant pool
     cp.Constants = append(cp.Constants, c)
-    return CpIndex(len(cp.Constants))
+    index := CpIndex(len(cp.Constants))
+    if c.Type() == TLong || c.Type() == TDouble {
+        // long and double constants take up two entries in the pool
+        cp.Constants = append(cp.Constants, nil)
+    }
+    return index
+}
+
+// find returns the index of the first constant equal to c, or 0 if the pool
+// does not contain it yet.
+func (cp *ConstantPool) find(c Constant) CpIndex {
+    for i, existing := range cp.Constants {
+        if existing != nil && existing.Type() == c.Type() && sameConstant(existing, c) {
+            return CpIndex(i + 1)
+        }
+    }
+    return 0
+}
+
+// sameConstant compares floating point constants by their bits, keeping 0.0
+// and -0.0 apart and letting identical NaNs be shared.
+func sameConstant(a Constant, b Constant) bool {
+    switch a := a.(type) {
+    case ConstFloat:
+        return math.Float32bits(a.Value) == math.Float32bits(b.(ConstFloat).Value)
+    case ConstDouble:
+        return math.Float64bits(a.Value) == math.Float64bits(b.(ConstDouble).Value)
+    }
+    return a == b
+}
+
+func (cp *ConstantPool) addUnique(c Constant) CpIndex {
+    if index := cp.find(c); index != 0 {
+        return index
+    }
+    return cp.Add(c)
+}
+
+// The AddX helpers return the index of an existing matching constant, adding
+// a new entry only when the pool does not contain one yet.
+func (cp *ConstantPool) AddUtf8(s string) CpIndex {
+    for i, existing := range cp.Constants {
+        if utf8, ok := existing.(ConstUtf8); ok && string(utf8.Data) == s {
+            return CpIndex(i + 1)
+        }
+    }
+    return cp.Add(ConstUtf8{Length: uint16(len(s)), Data: []byte(s)})
+}
+func (cp *ConstantPool) AddClass(name string) CpIndex {
+    return cp.addUnique(ConstClass{NameIndex: cp.AddUtf8(name)})
+}
+func (cp *ConstantPool) AddString(s string) CpIndex {
+    return cp.addUnique(ConstString{StringIndex: cp.AddUtf8(s)})
+}
+func (cp *ConstantPool) AddInteger(v int32) CpIndex {
+    return cp.addUnique(ConstInteger{Value: v})
+}
+func (cp *ConstantPool) AddFloat(v float32) CpIndex {
+    return cp.addUnique(ConstFloat{Value: v})
+}
+func (cp *ConstantPool) AddLong(v int64) CpIndex {
+    return cp.addUnique(ConstLong{Value: v})
+}
+func (cp *ConstantPool) AddDouble(v float64) CpIndex {
+    return cp.addUnique(ConstDouble{Value: v})
+}
+func (cp *ConstantPool) AddNameType(name string, descriptor string) CpIndex {
+    return cp.addUnique(ConstNameType{
+        NameIndex: cp.AddUtf8(name),
+        DescriptorIndex: cp.AddUtf8(descriptor),
+    })
+}
+func (cp *ConstantPool) AddField(class string, name string, descriptor string) CpIndex {
+    return cp.addUnique(ConstField{
+        ClassIndex: cp.AddClass(class),
+        NameAndTypeIndex: cp.AddNameType(name, descriptor),
+    })
+}
+func (cp *ConstantPool) AddMethod(class string, name string, descriptor string) CpIndex {
+    return cp.addUnique(ConstMethod{
+        ClassIndex: cp.AddClass(class),
+        NameAndTypeIndex: cp.AddNameType(name, descriptor),
+    })
+}
+func (cp *ConstantPool) AddInterfaceMethod(class string, name string, descriptor string) CpIndex {
+    return cp.addUnique(ConstInterfaceMethod{
+        ClassIndex: cp.AddClass(class),
+        NameAndTypeIndex: cp.AddNameType(name, descriptor),
+    })
+}
+func (cp *ConstantPool) AddMethodType(descriptor string) CpIndex {
+    return cp.addUnique(ConstMethodType{DescriptorIndex: cp.AddUtf8(descriptor)})
+}
+func (cp *ConstantPool) AddMethodHandle(kind byte, ref CpIndex) CpIndex {
+    return cp.addUnique(ConstMethodHandle{ReferenceKind: kind, ReferenceIndex: ref})
+}
+func (cp *ConstantPool) AddModule(name string) CpIndex {
+    return cp.addUnique(ConstModule{NameIndex: cp.AddUtf8(name)})
+}
+func (cp *ConstantPool) AddPackage(name string) CpIndex {
+    return cp.addUnique(ConstPackage{NameIndex: cp.AddUtf8(name)})
 }
 func (cp *ConstantPool) Count() uint16 {
     return uint16(len(cp.Constants)) + 1
@@ -227,6 +419,140 @@ func (c ConstNameType) String() string {
         c.DescriptorIndex)
 }
 
+type ConstInterfaceMethod struct {
+    ClassIndex CpIndex
+    NameAndTypeIndex CpIndex
+}
+func (c ConstInterfaceMethod) Type() ConstantType {
+    return TInterfaceMethodref
+}
+func (c ConstInterfaceMethod) String() string {
+    return fmt.Sprintf("InterfaceMethod[class:%s, nameType:%s]", 
+            c.ClassIndex, 
+            c.NameAndTypeIndex)
+}
+
+type ConstInteger struct {
+    Value int32
+}
+func (c ConstInteger) Type() ConstantType {
+    return TInteger
+}
+func (c ConstInteger) String() string {
+    return fmt.Sprintf("Integer[%d]", c.Value)
+}
+
+type ConstFloat struct {
+    Value float32
+}
+func (c ConstFloat) Type() ConstantType {
+    return TFloat
+}
+func (c ConstFloat) String() string {
+    return fmt.Sprintf("Float[%g]", c.Value)
+}
+
+type ConstLong struct {
+    Value int64
+}
+func (c ConstLong) Type() ConstantType {
+    return TLong
+}
+func (c ConstLong) String() string {
+    return fmt.Sprintf("Long[%d]", c.Value)
+}
+
+type ConstDouble struct {
+    Value float64
+}
+func (c ConstDouble) Type() ConstantType {
+    return TDouble
+}
+func (c ConstDouble) String() string {
+    return fmt.Sprintf("Double[%g]", c.Value)
+}
+
+const (
+    REF_getField byte = 1
+    REF_getStatic byte = 2
+    REF_putField byte = 3
+    REF_putStatic byte = 4
+    REF_invokeVirtual byte = 5
+    REF_invokeStatic byte = 6
+    REF_invokeSpecial byte = 7
+    REF_newInvokeSpecial byte = 8
+    REF_invokeInterface byte = 9
+)
+
+type ConstMethodHandle struct {
+    ReferenceKind byte
+    ReferenceIndex CpIndex
+}
+func (c ConstMethodHandle) Type() ConstantType {
+    return TMethodHandle
+}
+func (c ConstMethodHandle) String() string {
+    return fmt.Sprintf("MethodHandle[kind:%d, ref:%s]", 
+        c.ReferenceKind, 
+        c.ReferenceIndex)
+}
+
+type ConstMethodType struct {
+    DescriptorIndex CpIndex
+}
+func (c ConstMethodType) Type() ConstantType {
+    return TMethodType
+}
+func (c ConstMethodType) String() string {
+    return fmt.Sprintf("MethodType[%s]", c.DescriptorIndex)
+}
+
+type ConstDynamic struct {
+    BootstrapMethodAttrIndex uint16
+    NameAndTypeIndex CpIndex
+}
+func (c ConstDynamic) Type() ConstantType {
+    return TDynamic
+}
+func (c ConstDynamic) String() string {
+    return fmt.Sprintf("Dynamic[bootstrap:%d, nameType:%s]", 
+        c.BootstrapMethodAttrIndex, 
+        c.NameAndTypeIndex)
+}
+
+type ConstInvokeDynamic struct {
+    BootstrapMethodAttrIndex uint16
+    NameAndTypeIndex CpIndex
+}
+func (c ConstInvokeDynamic) Type() ConstantType {
+    return TInvokeDynamic
+}
+func (c ConstInvokeDynamic) String() string {
+    return fmt.Sprintf("InvokeDynamic[bootstrap:%d, nameType:%s]", 
+        c.BootstrapMethodAttrIndex, 
+        c.NameAndTypeIndex)
+}
+
+type ConstModule struct {
+    NameIndex CpIndex
+}
+func (c ConstModule) Type() ConstantType {
+    return TModule
+}
+func (c ConstModule) String() string {
+    return fmt.Sprintf("Module[%s]", c.NameIndex)
+}
+
+type ConstPackage struct {
+    NameIndex CpIndex
+}
+func (c ConstPackage) Type() ConstantType {
+    return TPackage
+}
+func (c ConstPackage) String() string {
+    return fmt.Sprintf("Package[%s]", c.NameIndex)
+}
+
 type Method struct {
     Flags AccessFlag       
     NameIndex CpIndex
@@ -234,6 +560,67 @@ type Method struct {
     Attributes []Attribute
 }
 
+// Code decodes the method's Code attribute, returning nil for abstract and
+// native methods.
+func (m *Method) Code(cp *ConstantPool) *Code {
+    attr := FindAttribute(cp, m.Attributes, "Code")
+    if attr == nil {
+        return nil
+    }
+    var r io.Reader = bytes.NewReader(attr.Info)
+    var code Code
+    ReadCode(&r, &code)
+    return &code
+}
+
+// SetCode replaces the method's Code attribute with an encoding of code.
+func (m *Method) SetCode(cp *ConstantPool, code *Code) {
+    attr := CodeAttribute(cp, code)
+    if existing := FindAttribute(cp, m.Attributes, "Code"); existing != nil {
+        existing.Info = attr.Info
+        return
+    }
+    m.Attributes = append(m.Attributes, attr)
+}
+
+// FindMethod looks a method up by name and descriptor, as in "run(I)V", or by
+// name alone when only one method has it.
+func (c *Class) FindMethod(selector string) (*Method, error) {
+    name, descriptor, _ := strings.Cut(selector, "(")
+    if descriptor != "" {
+        descriptor = "(" + descriptor
+    }
+    var found *Method
+    for i := range c.Methods {
+        method := &c.Methods[i]
+        if c.ConstantPool.GetUtf8(method.NameIndex) != name {
+            continue
+        }
+        if descriptor != "" && c.ConstantPool.GetUtf8(method.DescriptorIndex) == descriptor {
+            return method, nil
+        }
+        if descriptor == "" && found != nil {
+            return nil, fmt.Errorf("%s is overloaded, give its descriptor as well", name)
+        }
+        if descriptor == "" {
+            found = method
+        }
+    }
+    if found == nil {
+        return nil, fmt.Errorf("no method %s in %s", selector, c.Name())
+    }
+    return found, nil
+}
+
+func FindAttribute(cp *ConstantPool, attributes []Attribute, name string) *Attribute {
+    for i := range attributes {
+        if cp.GetUtf8(attributes[i].NameIndex) == name {
+            return &attributes[i]
+        }
+    }
+    return nil
+}
+
 type Field struct {
     Flags AccessFlag       
     NameIndex CpIndex
@@ -257,10 +644,10 @@ type ExceptionHandler struct {
     StartPc uint16 
     EndPc uint16 
     HandlerPc uint16
-    CatchType string
+    CatchType CpIndex
 }
 func (e ExceptionHandler) IsFinally() bool {
-    return e.CatchType == ""
+    return e.CatchType == 0
 }
 
 // InnerClasses Attribute
@@ -0,0 +1,1018 @@
+package jcr
+
+import (
+    "encoding/binary"
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+    "github.com/jasonhightower/jcr/signature"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// JavapWriter prints a class the way `javap -v -c -p -s -l` does: the
+// resolved constant pool, every member with its descriptor and flags, and the
+// disassembled code with its tables.
+type JavapWriter struct {}
+
+func (j JavapWriter) Write(w *io.Writer, c *Class) error {
+    cp := c.ConstantPool
+
+    if attr := FindAttribute(cp, c.Attributes, "SourceFile"); attr != nil {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return err
+        }
+        io.WriteString(*w, fmt.Sprintf("Compiled from \"%s\"\n", cp.GetUtf8(index)))
+    }
+    io.WriteString(*w, javapClassHeader(c) + "\n")
+    io.WriteString(*w, fmt.Sprintf("  minor version: %d\n", c.Minor))
+    io.WriteString(*w, fmt.Sprintf("  major version: %d\n", c.Major))
+    io.WriteString(*w, "  flags: " + javapFlags(c.Flags, classFlagNames) + "\n")
+    io.WriteString(*w, javapComment(2, fmt.Sprintf("this_class: #%d", c.ThisIndex), c.Name()))
+    if c.SuperIndex != 0 {
+        io.WriteString(*w, javapComment(2, fmt.Sprintf("super_class: #%d", c.SuperIndex), c.SuperName()))
+    } else {
+        io.WriteString(*w, "  super_class: #0\n")
+    }
+    io.WriteString(*w, fmt.Sprintf("  interfaces: %d, fields: %d, methods: %d, attributes: %d\n",
+        len(c.Interfaces), len(c.Fields), len(c.Methods), len(c.Attributes)))
+
+    writeJavapPool(w, cp)
+
+    bootstraps, err := c.BootstrapMethods()
+    if err != nil {
+        return err
+    }
+
+    io.WriteString(*w, "{\n")
+    for i, field := range c.Fields {
+        if i > 0 {
+            io.WriteString(*w, "\n")
+        }
+        io.WriteString(*w, "  " + javapFieldHeader(cp, &field) + "\n")
+        io.WriteString(*w, "    descriptor: " + cp.GetUtf8(field.DescriptorIndex) + "\n")
+        io.WriteString(*w, "    flags: " + javapFlags(field.Flags, fieldFlagNames) + "\n")
+        if err := writeJavapAttributes(w, cp, field.Attributes, 4, bootstraps); err != nil {
+            return err
+        }
+    }
+    for i := range c.Methods {
+        method := &c.Methods[i]
+        if i > 0 || len(c.Fields) > 0 {
+            io.WriteString(*w, "\n")
+        }
+        header, err := javapMethodHeader(c, method)
+        if err != nil {
+            return err
+        }
+        io.WriteString(*w, "  " + header + "\n")
+        io.WriteString(*w, "    descriptor: " + cp.GetUtf8(method.DescriptorIndex) + "\n")
+        io.WriteString(*w, "    flags: " + javapFlags(method.Flags, methodFlagNames) + "\n")
+        for _, attr := range method.Attributes {
+            if cp.GetUtf8(attr.NameIndex) != "Code" {
+                if err := writeJavapAttributes(w, cp, []Attribute{attr}, 4, bootstraps); err != nil {
+                    return err
+                }
+                continue
+            }
+            if err := writeJavapCode(w, c, method, method.Code(cp), bootstraps); err != nil {
+                return err
+            }
+        }
+    }
+    io.WriteString(*w, "}\n")
+    return writeJavapAttributes(w, cp, c.Attributes, 0, bootstraps)
+}
+
+// javapComment lines up a trailing comment the way javap does, 40 columns
+// after the indentation.
+func javapComment(indent int, text string, comment string) string {
+    line := strings.Repeat(" ", indent) + text
+    if comment == "" {
+        return line + "\n"
+    }
+    if len(text) < 40 {
+        line += strings.Repeat(" ", 40 - len(text))
+    } else {
+        line += " "
+    }
+    return line + "// " + comment + "\n"
+}
+
+func javapFlags(f AccessFlag, names []flagName) string {
+    return fmt.Sprintf("(0x%04x) %s", uint16(f), javapFlagNames(f, names))
+}
+
+func javapFlagNames(f AccessFlag, names []flagName) string {
+    var result []string
+    for _, n := range names {
+        if f & n.flag != 0 {
+            result = append(result, "ACC_" + strings.ToUpper(n.name))
+        }
+    }
+    return strings.Join(result, ", ")
+}
+
+// javaModifiers lists the flags that are also Java modifiers, in the order
+// javap prints them.
+func javaModifiers(f AccessFlag, names []flagName) string {
+    result := ""
+    for _, n := range names {
+        switch n.name {
+        case "public", "private", "protected", "static", "final", "synchronized",
+            "volatile", "transient", "native", "abstract":
+            if f & n.flag != 0 {
+                result += n.name + " "
+            }
+        case "strict":
+            if f & n.flag != 0 {
+                result += "strictfp "
+            }
+        }
+    }
+    return result
+}
+
+func javapClassHeader(c *Class) string {
+    cp := c.ConstantPool
+    if m, err := c.Module(); err == nil && m != nil {
+        if m.Version != "" {
+            return "module " + m.Name + "@" + m.Version
+        }
+        return "module " + m.Name
+    }
+    name := strings.ReplaceAll(c.Name(), "/", ".")
+    super := ""
+    if c.SuperName() != "" && c.SuperName() != "java/lang/Object" {
+        super = strings.ReplaceAll(c.SuperName(), "/", ".")
+    }
+    var interfaces []string
+    for _, iface := range c.Interfaces {
+        interfaces = append(interfaces, strings.ReplaceAll(cp.GetClassName(iface), "/", "."))
+    }
+    if sig, err := signature.ParseClass(javapSignature(cp, c.Attributes)); err == nil {
+        name += sig.TypeParams.Java()
+        if super != "" {
+            super = sig.Super.Java()
+        }
+        interfaces = nil
+        for _, iface := range sig.Interfaces {
+            interfaces = append(interfaces, iface.Java())
+        }
+    }
+    sealed, permits := "", ""
+    if permitted, err := c.PermittedSubclasses(); err == nil && permitted != nil {
+        sealed = "sealed "
+        permits = " permits " + strings.ReplaceAll(strings.Join(permitted, ", "), "/", ".")
+    }
+    if c.Flags.IsInterface() {
+        header := javaModifiers(c.Flags &^ FLAG_ABSTRACT, classFlagNames) + sealed + "interface " + name
+        if len(interfaces) > 0 {
+            header += " extends " + strings.Join(interfaces, ", ")
+        }
+        return header + permits
+    }
+    header := javaModifiers(c.Flags, classFlagNames) + sealed + "class " + name
+    if super != "" {
+        header += " extends " + super
+    }
+    if len(interfaces) > 0 {
+        header += " implements " + strings.Join(interfaces, ", ")
+    }
+    return header + permits
+}
+
+func javapFieldHeader(cp *ConstantPool, field *Field) string {
+    typ := javaType(cp.GetUtf8(field.DescriptorIndex))
+    if sig, err := signature.ParseField(javapSignature(cp, field.Attributes)); err == nil {
+        typ = sig.Java()
+    }
+    return javaModifiers(field.Flags, fieldFlagNames) + typ + " " + cp.GetUtf8(field.NameIndex) + ";"
+}
+
+// javapSignature returns the generic signature in attrs, or "" when there is
+// none.
+func javapSignature(cp *ConstantPool, attrs []Attribute) string {
+    attr := FindAttribute(cp, attrs, "Signature")
+    if attr == nil {
+        return ""
+    }
+    index, err := DecodeIndex(attr.Info)
+    if err != nil {
+        return ""
+    }
+    return cp.GetUtf8(index)
+}
+
+func javapMethodHeader(c *Class, method *Method) (string, error) {
+    cp := c.ConstantPool
+    name := cp.GetUtf8(method.NameIndex)
+    if name == "<clinit>" {
+        return "static {};", nil
+    }
+    args, ret, err := splitMethodDescriptor(cp.GetUtf8(method.DescriptorIndex))
+    if err != nil {
+        return "", err
+    }
+    types := make([]string, len(args))
+    for i, arg := range args {
+        types[i] = javaType(arg)
+    }
+    typeParams, retType, throws := "", javaType(ret), []string(nil)
+    if sig, err := signature.ParseMethod(javapSignature(cp, method.Attributes)); err == nil {
+        // the signature leaves out synthetic parameters such as the outer
+        // instance of an inner class constructor
+        if len(sig.Params) == len(args) {
+            for i, param := range sig.Params {
+                types[i] = param.Java()
+            }
+        }
+        typeParams, retType = sig.TypeParams.Java(), sig.Result.Java()
+        for _, t := range sig.Throws {
+            throws = append(throws, t.Java())
+        }
+    }
+    var params []string
+    for i := range args {
+        param := types[i]
+        if i == len(args) - 1 && method.Flags & FLAG_VARARGS != 0 && strings.HasSuffix(param, "[]") {
+            param = strings.TrimSuffix(param, "[]") + "..."
+        }
+        params = append(params, param)
+    }
+    header := javaModifiers(method.Flags, methodFlagNames)
+    if typeParams != "" {
+        header += typeParams + " "
+    }
+    if name == "<init>" {
+        header += strings.ReplaceAll(c.Name(), "/", ".")
+    } else {
+        header += retType + " " + name
+    }
+    header += "(" + strings.Join(params, ", ") + ")"
+    if len(throws) > 0 {
+        header += " throws " + strings.Join(throws, ", ")
+    } else if attr := FindAttribute(cp, method.Attributes, "Exceptions"); attr != nil {
+        exceptions, err := DecodeExceptions(attr.Info)
+        if err != nil {
+            return "", err
+        }
+        var names []string
+        for _, exception := range exceptions {
+            names = append(names, strings.ReplaceAll(cp.GetClassName(exception), "/", "."))
+        }
+        header += " throws " + strings.Join(names, ", ")
+    }
+    return header + ";", nil
+}
+
+// javaType renders a field descriptor in Java syntax, e.g. java.lang.String[].
+func javaType(s string) string {
+    t, err := descriptor.ParseReturn(s)
+    if err != nil {
+        return s
+    }
+    return t.Java()
+}
+
+var javapTags = map[ConstantType]string{
+    TUtf8: "Utf8", TInteger: "Integer", TFloat: "Float", TLong: "Long", TDouble: "Double",
+    TClass: "Class", TString: "String", TFieldRef: "Fieldref", TMethodRef: "Methodref",
+    TInterfaceMethodref: "InterfaceMethodref", TNameType: "NameAndType", TMethodHandle: "MethodHandle",
+    TMethodType: "MethodType", TDynamic: "Dynamic", TInvokeDynamic: "InvokeDynamic",
+    TModule: "Module", TPackage: "Package",
+}
+
+func writeJavapPool(w *io.Writer, cp *ConstantPool) {
+    io.WriteString(*w, "Constant pool:\n")
+    width := len(fmt.Sprintf("#%d", cp.Count() - 1))
+    for i := 1; i < int(cp.Count()); i++ {
+        constant := *cp.Get(CpIndex(i))
+        if constant == nil {
+            continue
+        }
+        args, comment := javapPoolEntry(cp, CpIndex(i))
+        text := fmt.Sprintf("%*s = %-18s %s", width, fmt.Sprintf("#%d", i), javapTags[constant.Type()], args)
+        io.WriteString(*w, javapComment(2, text, comment))
+    }
+}
+
+// javapPoolEntry renders the operands of a constant pool entry and the
+// comment javap resolves them to.
+func javapPoolEntry(cp *ConstantPool, index CpIndex) (args string, comment string) {
+    switch c := (*cp.Get(index)).(type) {
+    case ConstUtf8:
+        args = javapEscape(c.String())
+    case ConstInteger:
+        args = strconv.FormatInt(int64(c.Value), 10)
+    case ConstFloat, ConstLong, ConstDouble:
+        args = javapNumber(c)
+    case ConstClass:
+        args, comment = fmt.Sprintf("#%d", c.NameIndex), javapClassName(cp.GetUtf8(c.NameIndex))
+    case ConstString:
+        args, comment = fmt.Sprintf("#%d", c.StringIndex), javapEscape(cp.GetUtf8(c.StringIndex))
+    case ConstMethodType:
+        args, comment = fmt.Sprintf("#%d", c.DescriptorIndex), cp.GetUtf8(c.DescriptorIndex)
+    case ConstModule:
+        args, comment = fmt.Sprintf("#%d", c.NameIndex), cp.GetUtf8(c.NameIndex)
+    case ConstPackage:
+        args, comment = fmt.Sprintf("#%d", c.NameIndex), cp.GetUtf8(c.NameIndex)
+    case ConstNameType:
+        args = fmt.Sprintf("#%d:#%d", c.NameIndex, c.DescriptorIndex)
+        comment = javapNameType(cp, index)
+    case ConstField:
+        args, comment = fmt.Sprintf("#%d.#%d", c.ClassIndex, c.NameAndTypeIndex), javapMember(cp, index)
+    case ConstMethod:
+        args, comment = fmt.Sprintf("#%d.#%d", c.ClassIndex, c.NameAndTypeIndex), javapMember(cp, index)
+    case ConstInterfaceMethod:
+        args, comment = fmt.Sprintf("#%d.#%d", c.ClassIndex, c.NameAndTypeIndex), javapMember(cp, index)
+    case ConstMethodHandle:
+        args, comment = fmt.Sprintf("%d:#%d", c.ReferenceKind, c.ReferenceIndex), javapHandle(cp, c)
+    case ConstDynamic:
+        args = fmt.Sprintf("#%d:#%d", c.BootstrapMethodAttrIndex, c.NameAndTypeIndex)
+        comment = fmt.Sprintf("#%d:%s", c.BootstrapMethodAttrIndex, javapNameType(cp, c.NameAndTypeIndex))
+    case ConstInvokeDynamic:
+        args = fmt.Sprintf("#%d:#%d", c.BootstrapMethodAttrIndex, c.NameAndTypeIndex)
+        comment = fmt.Sprintf("#%d:%s", c.BootstrapMethodAttrIndex, javapNameType(cp, c.NameAndTypeIndex))
+    }
+    return args, comment
+}
+
+func javapNumber(constant Constant) string {
+    switch c := constant.(type) {
+    case ConstInteger:
+        return strconv.FormatInt(int64(c.Value), 10)
+    case ConstFloat:
+        return javaFloat(float64(c.Value), 32) + "f"
+    case ConstLong:
+        return strconv.FormatInt(c.Value, 10) + "l"
+    case ConstDouble:
+        return javaFloat(c.Value, 64) + "d"
+    }
+    return ""
+}
+
+// javaFloat formats a float the way Java's Float.toString and
+// Double.toString do, e.g. 1.0, -0.0 and 1.0E10.
+func javaFloat(f float64, bits int) string {
+    switch {
+    case math.IsNaN(f):
+        return "NaN"
+    case math.IsInf(f, 1):
+        return "Infinity"
+    case math.IsInf(f, -1):
+        return "-Infinity"
+    }
+    if abs := math.Abs(f); abs == 0 || abs >= 1e-3 && abs < 1e7 {
+        text := strconv.FormatFloat(f, 'f', -1, bits)
+        if !strings.Contains(text, ".") {
+            text += ".0"
+        }
+        return text
+    }
+    mantissa, exponent, _ := strings.Cut(strconv.FormatFloat(f, 'E', -1, bits), "E")
+    if !strings.Contains(mantissa, ".") {
+        mantissa += ".0"
+    }
+    exp, _ := strconv.Atoi(exponent)
+    return mantissa + "E" + strconv.Itoa(exp)
+}
+
+// javapEscape writes control characters in a string as Java escapes.
+func javapEscape(s string) string {
+    var sb strings.Builder
+    for _, r := range s {
+        switch {
+        case r == '\n':
+            sb.WriteString("\\n")
+        case r == '\t':
+            sb.WriteString("\\t")
+        case r == '\r':
+            sb.WriteString("\\r")
+        case r == '\b':
+            sb.WriteString("\\b")
+        case r == '\f':
+            sb.WriteString("\\f")
+        case r < 0x20:
+            sb.WriteString(fmt.Sprintf("\\u%04x", r))
+        default:
+            sb.WriteRune(r)
+        }
+    }
+    return sb.String()
+}
+
+// javapClassName quotes array class names, as in "[I".
+func javapClassName(name string) string {
+    if strings.HasPrefix(name, "[") {
+        return "\"" + name + "\""
+    }
+    return name
+}
+
+func javapNameType(cp *ConstantPool, index CpIndex) string {
+    nameType := (*cp.Get(index)).(ConstNameType)
+    name := cp.GetUtf8(nameType.NameIndex)
+    if name == "<init>" || name == "<clinit>" {
+        name = "\"" + name + "\""
+    }
+    return name + ":" + cp.GetUtf8(nameType.DescriptorIndex)
+}
+
+// javapMember renders a field or method reference as class.name:descriptor.
+func javapMember(cp *ConstantPool, index CpIndex) string {
+    var classIndex, nameTypeIndex CpIndex
+    switch c := (*cp.Get(index)).(type) {
+    case ConstField:
+        classIndex, nameTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+    case ConstMethod:
+        classIndex, nameTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+    case ConstInterfaceMethod:
+        classIndex, nameTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+    }
+    return javapClassName(cp.GetClassName(classIndex)) + "." + javapNameType(cp, nameTypeIndex)
+}
+
+func javapHandle(cp *ConstantPool, handle ConstMethodHandle) string {
+    kind := fmt.Sprintf("%d", handle.ReferenceKind)
+    if int(handle.ReferenceKind) < len(handleKindNames) {
+        kind = "REF_" + handleKindNames[handle.ReferenceKind]
+    }
+    return kind + " " + javapMember(cp, handle.ReferenceIndex)
+}
+
+// javapConstant describes a loadable constant the way javap comments ldc.
+func javapConstant(cp *ConstantPool, index CpIndex) string {
+    switch c := (*cp.Get(index)).(type) {
+    case ConstInteger:
+        return "int " + javapNumber(c)
+    case ConstFloat:
+        return "float " + javapNumber(c)
+    case ConstLong:
+        return "long " + javapNumber(c)
+    case ConstDouble:
+        return "double " + javapNumber(c)
+    case ConstString:
+        return "String " + javapEscape(cp.GetUtf8(c.StringIndex))
+    case ConstClass:
+        return "class " + javapClassName(cp.GetUtf8(c.NameIndex))
+    case ConstMethodType:
+        return "MethodType " + cp.GetUtf8(c.DescriptorIndex)
+    case ConstMethodHandle:
+        return "MethodHandle " + javapHandle(cp, c)
+    case ConstDynamic:
+        return fmt.Sprintf("Dynamic #%d:%s", c.BootstrapMethodAttrIndex, javapNameType(cp, c.NameAndTypeIndex))
+    }
+    return ""
+}
+
+func writeJavapCode(w *io.Writer, c *Class, method *Method, code *Code, bootstraps []BootstrapMethod) error {
+    cp := c.ConstantPool
+    args, _, err := methodSlots(cp.GetUtf8(method.DescriptorIndex))
+    if err != nil {
+        return err
+    }
+    if !method.Flags.IsStatic() {
+        args++
+    }
+    io.WriteString(*w, "    Code:\n")
+    io.WriteString(*w, fmt.Sprintf("      stack=%d, locals=%d, args_size=%d\n", code.MaxStack, code.MaxLocals, args))
+
+    instructions, err := DecodeInstructions(code.ByteCode)
+    if err != nil {
+        return err
+    }
+    for _, instr := range instructions {
+        io.WriteString(*w, javapInstruction(cp, instr, bootstraps))
+    }
+
+    if len(code.ExceptionHandlers) > 0 {
+        io.WriteString(*w, "      Exception table:\n")
+        io.WriteString(*w, "         from    to  target type\n")
+        for _, handler := range code.ExceptionHandlers {
+            catchType := "any"
+            if !handler.IsFinally() {
+                catchType = "Class " + cp.GetClassName(handler.CatchType)
+            }
+            io.WriteString(*w, fmt.Sprintf("%14d %5d %5d   %s\n", handler.StartPc, handler.EndPc, handler.HandlerPc, catchType))
+        }
+    }
+
+    for _, attr := range code.Attributes {
+        switch name := cp.GetUtf8(attr.NameIndex); name {
+        case "LineNumberTable":
+            table, err := DecodeLineNumberTable(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, "      LineNumberTable:\n")
+            for _, entry := range table {
+                io.WriteString(*w, fmt.Sprintf("        line %d: %d\n", entry.Line, entry.StartPc))
+            }
+        case "LocalVariableTable", "LocalVariableTypeTable":
+            table, err := DecodeLocalVariableTable(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, "      " + name + ":\n")
+            io.WriteString(*w, "        Start  Length  Slot  Name   Signature\n")
+            for _, entry := range table {
+                io.WriteString(*w, fmt.Sprintf("%13d %7d %5d %5s   %s\n", entry.StartPc, entry.Length, entry.Index,
+                    cp.GetUtf8(entry.NameIndex), cp.GetUtf8(entry.DescriptorIndex)))
+            }
+        case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+            if err := writeJavapTypeAnnotations(w, cp, name, attr.Info, 6); err != nil {
+                return err
+            }
+        case "StackMapTable":
+            frames, err := code.StackMap(c, method)
+            if err != nil {
+                return err
+            }
+            writeJavapFrames(w, frames)
+        default:
+            if err := writeJavapAttributes(w, cp, []Attribute{attr}, 6, bootstraps); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// javapInstruction renders one instruction line, with switch tables spread
+// over the following lines. Call sites and dynamic constants of a kind
+// javac generates are explained after the constant in the comment.
+func javapInstruction(cp *ConstantPool, instr Instruction, bootstraps []BootstrapMethod) string {
+    op := instr.Opcode
+    mnemonic := Mnemonic(op)
+    operands, comment := "", ""
+    switch {
+    case op == Bipush:
+        operands = strconv.Itoa(int(int8(instr.Operands[0])))
+    case op == Sipush:
+        operands = strconv.Itoa(int(int16(binary.BigEndian.Uint16(instr.Operands))))
+    case op == Ldc || op == LdcW || op == Ldc2W:
+        operands, comment = fmt.Sprintf("#%d", instr.Index()), javapConstant(cp, instr.Index())
+        if _, ok := (*cp.Get(instr.Index())).(ConstDynamic); ok {
+            if explanation := explainCallSite(cp, bootstraps, instr.Index()); explanation != "" {
+                comment += " ; " + explanation
+            }
+        }
+    case op >= Iload && op <= Aload || op >= Istore && op <= Astore || op == Ret:
+        operands = strconv.Itoa(instr.Local())
+    case op == Iinc:
+        operands = fmt.Sprintf("%d, %d", instr.Operands[0], int8(instr.Operands[1]))
+    case op == Wide:
+        mnemonic = Mnemonic(Opcode(instr.Operands[0])) + "_w"
+        operands = strconv.Itoa(instr.Local())
+        if Opcode(instr.Operands[0]) == Iinc {
+            operands += fmt.Sprintf(", %d", int16(binary.BigEndian.Uint16(instr.Operands[3:])))
+        }
+    case op == Tableswitch || op == Lookupswitch:
+        dflt, keys, targets := instr.Switch()
+        text := fmt.Sprintf("%10d: %-13s { // ", instr.Offset, mnemonic)
+        if op == Tableswitch {
+            text += fmt.Sprintf("%d to %d\n", keys[0], keys[0] + int32(len(targets)) - 1)
+            for i, target := range targets {
+                text += fmt.Sprintf("%24d: %d\n", keys[0] + int32(i), target)
+            }
+        } else {
+            text += fmt.Sprintf("%d\n", len(keys))
+            for i, target := range targets {
+                text += fmt.Sprintf("%24d: %d\n", keys[i], target)
+            }
+        }
+        return text + fmt.Sprintf("%24s: %d\n            }\n", "default", dflt)
+    case op >= Getstatic && op <= Putfield:
+        operands, comment = fmt.Sprintf("#%d", instr.Index()), "Field " + javapMember(cp, instr.Index())
+    case op >= Invokevirtual && op <= Invokestatic:
+        kind := "Method "
+        if _, ok := (*cp.Get(instr.Index())).(ConstInterfaceMethod); ok {
+            kind = "InterfaceMethod "
+        }
+        operands, comment = fmt.Sprintf("#%d", instr.Index()), kind + javapMember(cp, instr.Index())
+    case op == Invokeinterface:
+        operands = fmt.Sprintf("#%d,  %d", instr.Index(), instr.Operands[2])
+        comment = "InterfaceMethod " + javapMember(cp, instr.Index())
+    case op == Invokedynamic:
+        indy := (*cp.Get(instr.Index())).(ConstInvokeDynamic)
+        operands = fmt.Sprintf("#%d,  0", instr.Index())
+        comment = fmt.Sprintf("InvokeDynamic #%d:%s", indy.BootstrapMethodAttrIndex, javapNameType(cp, indy.NameAndTypeIndex))
+        if explanation := explainCallSite(cp, bootstraps, instr.Index()); explanation != "" {
+            comment += " ; " + explanation
+        }
+    case op == New || op == Anewarray || op == Checkcast || op == Instanceof:
+        operands, comment = fmt.Sprintf("#%d", instr.Index()), "class " + javapClassName(cp.GetClassName(instr.Index()))
+    case op == Multianewarray:
+        operands = fmt.Sprintf("#%d,  %d", instr.Index(), instr.Operands[2])
+        comment = "class " + javapClassName(cp.GetClassName(instr.Index()))
+    case op == Newarray:
+        operands = fmt.Sprintf("%d", instr.Operands[0])
+        for name, atype := range arrayTypes {
+            if atype == instr.Operands[0] {
+                operands = name
+            }
+        }
+    case len(instr.Targets()) > 0:
+        operands = strconv.Itoa(instr.Targets()[0])
+    }
+    if operands == "" {
+        return fmt.Sprintf("%10d: %s\n", instr.Offset, mnemonic)
+    }
+    return javapComment(6, fmt.Sprintf("%4d: %-13s %s", instr.Offset, mnemonic, operands), comment)
+}
+
+// writeJavapAttributes prints the attributes that are not part of Code,
+// falling back to a hex dump for those javap does not decode.
+func writeJavapAttributes(w *io.Writer, cp *ConstantPool, attrs []Attribute, indent int, bootstraps []BootstrapMethod) error {
+    pad := strings.Repeat(" ", indent)
+    for _, attr := range attrs {
+        name := cp.GetUtf8(attr.NameIndex)
+        switch name {
+        case "ConstantValue":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "ConstantValue: " + javapConstant(cp, index) + "\n")
+        case "SourceFile":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + fmt.Sprintf("SourceFile: \"%s\"\n", cp.GetUtf8(index)))
+        case "Signature":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, javapComment(indent, fmt.Sprintf("Signature: #%d", index), cp.GetUtf8(index)))
+        case "Exceptions":
+            exceptions, err := DecodeExceptions(attr.Info)
+            if err != nil {
+                return err
+            }
+            var names []string
+            for _, exception := range exceptions {
+                names = append(names, strings.ReplaceAll(cp.GetClassName(exception), "/", "."))
+            }
+            io.WriteString(*w, pad + "Exceptions:\n")
+            io.WriteString(*w, pad + "  throws " + strings.Join(names, ", ") + "\n")
+        case "Deprecated", "Synthetic":
+            io.WriteString(*w, pad + name + ": true\n")
+        case "InnerClasses":
+            inners, err := DecodeInnerClasses(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "InnerClasses:\n")
+            for _, inner := range inners {
+                text := javaModifiers(inner.Flags, innerClassFlagNames)
+                comment := ""
+                if inner.InnerNameIndex != 0 {
+                    text += fmt.Sprintf("#%d= ", inner.InnerNameIndex)
+                    comment = cp.GetUtf8(inner.InnerNameIndex) + "="
+                }
+                text += fmt.Sprintf("#%d", inner.InnerClassIndex)
+                comment += "class " + javapClassName(cp.GetClassName(inner.InnerClassIndex))
+                if inner.OuterClassIndex != 0 {
+                    text += fmt.Sprintf(" of #%d", inner.OuterClassIndex)
+                    comment += " of class " + javapClassName(cp.GetClassName(inner.OuterClassIndex))
+                }
+                io.WriteString(*w, javapComment(indent + 2, text + ";", comment))
+            }
+        case "EnclosingMethod":
+            enclosing, err := DecodeEnclosingMethod(attr.Info)
+            if err != nil {
+                return err
+            }
+            comment := cp.GetClassName(enclosing.ClassIndex)
+            if enclosing.MethodIndex != 0 {
+                nameType := (*cp.Get(enclosing.MethodIndex)).(ConstNameType)
+                comment += "." + cp.GetUtf8(nameType.NameIndex)
+            }
+            text := fmt.Sprintf("EnclosingMethod: #%d.#%d", enclosing.ClassIndex, enclosing.MethodIndex)
+            io.WriteString(*w, javapComment(indent, text, comment))
+        case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+            annotations, err := DecodeAnnotations(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + name + ":\n")
+            writeJavapAnnotations(w, cp, annotations, indent + 2)
+        case "RuntimeVisibleParameterAnnotations", "RuntimeInvisibleParameterAnnotations":
+            params, err := DecodeParameterAnnotations(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + name + ":\n")
+            for i, annotations := range params {
+                io.WriteString(*w, fmt.Sprintf("%s  parameter %d:\n", pad, i))
+                writeJavapAnnotations(w, cp, annotations, indent + 4)
+            }
+        case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+            if err := writeJavapTypeAnnotations(w, cp, name, attr.Info, indent); err != nil {
+                return err
+            }
+        case "AnnotationDefault":
+            value, err := DecodeAnnotationDefault(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "AnnotationDefault:\n")
+            io.WriteString(*w, pad + "  default_value: " + javapElementValue(value) + "\n")
+            io.WriteString(*w, pad + "    " + value.Java(cp, descriptor.QualifiedName) + "\n")
+        case "NestHost":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "NestHost: class " + cp.GetClassName(index) + "\n")
+        case "NestMembers":
+            members, err := DecodeNestMembers(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "NestMembers:\n")
+            for _, member := range members {
+                io.WriteString(*w, pad + "  " + cp.GetClassName(member) + "\n")
+            }
+        case "Record":
+            components, err := DecodeRecord(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "Record:\n")
+            for i, component := range components {
+                if i > 0 {
+                    io.WriteString(*w, "\n")
+                }
+                typ := javaType(component.Descriptor(cp))
+                if sig, err := signature.ParseField(component.Signature(cp)); err == nil {
+                    typ = sig.Java()
+                }
+                io.WriteString(*w, pad + "  " + typ + " " + component.Name(cp) + ";\n")
+                io.WriteString(*w, pad + "    descriptor: " + component.Descriptor(cp) + "\n")
+                if err := writeJavapAttributes(w, cp, component.Attributes, indent + 4, bootstraps); err != nil {
+                    return err
+                }
+            }
+        case "PermittedSubclasses":
+            classes, err := DecodePermittedSubclasses(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "PermittedSubclasses:\n")
+            for _, class := range classes {
+                io.WriteString(*w, pad + "  " + cp.GetClassName(class) + "\n")
+            }
+        case "Module":
+            m, err := DecodeModule(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, pad + "Module:\n")
+            if err := writeJavapModule(w, cp, m, indent + 2); err != nil {
+                return err
+            }
+        case "ModulePackages":
+            packages, err := DecodeModulePackages(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, pkg := range packages {
+                if err := checkConstant(cp, pkg, TPackage); err != nil {
+                    return err
+                }
+            }
+            io.WriteString(*w, pad + "ModulePackages:\n")
+            for _, pkg := range packages {
+                io.WriteString(*w, javapComment(indent + 2, fmt.Sprintf("#%d", pkg), cp.GetPackageName(pkg)))
+            }
+        case "ModuleMainClass":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            if err := checkConstant(cp, index, TClass); err != nil {
+                return err
+            }
+            io.WriteString(*w, javapComment(indent, fmt.Sprintf("ModuleMainClass: #%d", index), cp.GetClassName(index)))
+        case "BootstrapMethods":
+            io.WriteString(*w, pad + "BootstrapMethods:\n")
+            for i, m := range bootstraps {
+                handle := (*cp.Get(m.MethodRef)).(ConstMethodHandle)
+                io.WriteString(*w, fmt.Sprintf("%s  %d: #%d %s\n", pad, i, m.MethodRef, javapHandle(cp, handle)))
+                io.WriteString(*w, pad + "    Method arguments:\n")
+                for _, arg := range m.Arguments {
+                    value := strings.SplitN(javapConstant(cp, arg), " ", 2)
+                    io.WriteString(*w, fmt.Sprintf("%s      #%d %s\n", pad, arg, value[len(value) - 1]))
+                }
+            }
+        default:
+            io.WriteString(*w, fmt.Sprintf("%s%s: length = 0x%x\n", pad, name, len(attr.Info)))
+            for i := 0; i < len(attr.Info); i += 16 {
+                line := pad + "  "
+                end := i + 16
+                if end > len(attr.Info) {
+                    end = len(attr.Info)
+                }
+                for _, b := range attr.Info[i:end] {
+                    line += fmt.Sprintf(" %02X", b)
+                }
+                io.WriteString(*w, line + "\n")
+            }
+        }
+    }
+    return nil
+}
+
+// writeJavapModule prints the tables of a Module attribute the way javap
+// does, as indexes and flags with the names they resolve to in comments.
+func writeJavapModule(w *io.Writer, cp *ConstantPool, m ModuleAttribute, indent int) error {
+    if err := m.check(cp); err != nil {
+        return err
+    }
+    named := func(indent int, index CpIndex, flags AccessFlag, name string, names []flagName) {
+        comment := "\"" + name + "\""
+        if flagNames := javapFlagNames(flags, names); flagNames != "" {
+            comment += " " + flagNames
+        }
+        io.WriteString(*w, javapComment(indent, fmt.Sprintf("#%d,%x", index, uint16(flags)), comment))
+    }
+    version := func(indent int, index CpIndex) {
+        if index == 0 {
+            io.WriteString(*w, strings.Repeat(" ", indent) + "#0\n")
+            return
+        }
+        io.WriteString(*w, javapComment(indent, fmt.Sprintf("#%d", index), cp.GetUtf8(index)))
+    }
+    named(indent, m.NameIndex, m.Flags, cp.GetModuleName(m.NameIndex), moduleFlagNames)
+    version(indent, m.VersionIndex)
+    io.WriteString(*w, javapComment(indent, strconv.Itoa(len(m.Requires)), "requires"))
+    for _, entry := range m.Requires {
+        named(indent + 2, entry.Index, entry.Flags, cp.GetModuleName(entry.Index), requiresFlagNames)
+        version(indent + 2, entry.VersionIndex)
+    }
+    for _, table := range []struct{ name string; entries []ModuleExportsEntry }{{"exports", m.Exports}, {"opens", m.Opens}} {
+        io.WriteString(*w, javapComment(indent, strconv.Itoa(len(table.entries)), table.name))
+        for _, entry := range table.entries {
+            comment := cp.GetPackageName(entry.Index)
+            if flagNames := javapFlagNames(entry.Flags, exportsFlagNames); flagNames != "" {
+                comment += " " + flagNames
+            }
+            io.WriteString(*w, javapComment(indent + 2, fmt.Sprintf("#%d,%x", entry.Index, uint16(entry.Flags)), comment))
+            if len(entry.To) > 0 {
+                io.WriteString(*w, javapComment(indent + 4, strconv.Itoa(len(entry.To)), "to"))
+            }
+            for _, to := range entry.To {
+                io.WriteString(*w, javapComment(indent + 6, fmt.Sprintf("#%d", to), "\"" + cp.GetModuleName(to) + "\""))
+            }
+        }
+    }
+    io.WriteString(*w, javapComment(indent, strconv.Itoa(len(m.Uses)), "uses"))
+    for _, index := range m.Uses {
+        io.WriteString(*w, javapComment(indent + 2, fmt.Sprintf("#%d", index), cp.GetClassName(index)))
+    }
+    io.WriteString(*w, javapComment(indent, strconv.Itoa(len(m.Provides)), "provides"))
+    for _, entry := range m.Provides {
+        io.WriteString(*w, javapComment(indent + 2, fmt.Sprintf("#%d", entry.Index), cp.GetClassName(entry.Index)))
+        io.WriteString(*w, javapComment(indent + 4, strconv.Itoa(len(entry.With)), "with"))
+        for _, with := range entry.With {
+            io.WriteString(*w, javapComment(indent + 6, fmt.Sprintf("#%d", with), cp.GetClassName(with)))
+        }
+    }
+    return nil
+}
+
+// writeJavapAnnotations lists annotations by their constant pool indexes,
+// each followed by its Java form.
+func writeJavapAnnotations(w *io.Writer, cp *ConstantPool, annotations []Annotation, indent int) {
+    pad := strings.Repeat(" ", indent)
+    for i, a := range annotations {
+        io.WriteString(*w, fmt.Sprintf("%s%d: %s\n", pad, i, javapAnnotation(a)))
+        io.WriteString(*w, pad + "  " + a.Java(cp, descriptor.QualifiedName) + "\n")
+    }
+}
+
+func writeJavapTypeAnnotations(w *io.Writer, cp *ConstantPool, name string, info []byte, indent int) error {
+    annotations, err := DecodeTypeAnnotations(info)
+    if err != nil {
+        return err
+    }
+    pad := strings.Repeat(" ", indent)
+    io.WriteString(*w, pad + name + ":\n")
+    for i, a := range annotations {
+        target := []string{a.TargetName()}
+        switch a.TargetType {
+        case TargetClassTypeParameter, TargetMethodTypeParameter:
+            target = append(target, fmt.Sprintf("param_index=%d", a.TypeParameterIndex))
+        case TargetClassTypeParameterBound, TargetMethodTypeParameterBound:
+            target = append(target, fmt.Sprintf("param_index=%d", a.TypeParameterIndex), fmt.Sprintf("bound_index=%d", a.BoundIndex))
+        case TargetSupertype:
+            target = append(target, fmt.Sprintf("type_index=%d", int16(a.SupertypeIndex)))
+        case TargetFormalParameter:
+            target = append(target, fmt.Sprintf("param_index=%d", a.FormalParameterIndex))
+        case TargetThrows:
+            target = append(target, fmt.Sprintf("throws_index=%d", a.ThrowsTypeIndex))
+        case TargetLocalVariable, TargetResourceVariable:
+            for _, local := range a.LocalVariables {
+                target = append(target, fmt.Sprintf("{start_pc=%d, length=%d, index=%d}", local.StartPc, local.Length, local.Index))
+            }
+        case TargetExceptionParameter:
+            target = append(target, fmt.Sprintf("exception_index=%d", a.ExceptionTableIndex))
+        case TargetInstanceof, TargetNew, TargetConstructorReference, TargetMethodReference:
+            target = append(target, fmt.Sprintf("offset=%d", a.Offset))
+        case TargetField, TargetReturn, TargetReceiver:
+        default:
+            target = append(target, fmt.Sprintf("offset=%d", a.Offset), fmt.Sprintf("type_index=%d", a.TypeArgumentIndex))
+        }
+        if path := a.PathString(); path != "" {
+            target = append(target, "location=" + path)
+        }
+        io.WriteString(*w, fmt.Sprintf("%s  %d: %s: %s\n", pad, i, javapAnnotation(a.Annotation), strings.Join(target, ", ")))
+        io.WriteString(*w, pad + "    " + a.Java(cp, descriptor.QualifiedName) + "\n")
+    }
+    return nil
+}
+
+func javapAnnotation(a Annotation) string {
+    var elements []string
+    for _, e := range a.Elements {
+        elements = append(elements, fmt.Sprintf("#%d=%s", e.NameIndex, javapElementValue(e.Value)))
+    }
+    return fmt.Sprintf("#%d(%s)", a.TypeIndex, strings.Join(elements, ","))
+}
+
+func javapElementValue(v ElementValue) string {
+    switch v.Tag {
+    case 'e':
+        return fmt.Sprintf("e#%d.#%d", v.TypeNameIndex, v.ConstNameIndex)
+    case 'c':
+        return fmt.Sprintf("c#%d", v.ClassInfoIndex)
+    case '@':
+        return "@" + javapAnnotation(*v.Annotation)
+    case '[':
+        var values []string
+        for _, value := range v.Values {
+            values = append(values, javapElementValue(value))
+        }
+        return "[" + strings.Join(values, ",") + "]"
+    }
+    return fmt.Sprintf("%c#%d", v.Tag, v.ConstIndex)
+}
+
+func writeJavapFrames(w *io.Writer, frames []StackMapFrame) {
+    io.WriteString(*w, fmt.Sprintf("      StackMapTable: number_of_entries = %d\n", len(frames)))
+    for _, f := range frames {
+        name := FrameTypeName(f.FrameType)
+        javapName := map[string]string{
+            "same_locals_1_stack_item_extended": "same_locals_1_stack_item_frame_extended",
+            "same_extended": "same_frame_extended",
+            "full": "full_frame",
+        }[name]
+        if javapName == "" {
+            javapName = name
+        }
+        io.WriteString(*w, fmt.Sprintf("        frame_type = %d /* %s */\n", f.FrameType, javapName))
+        if f.FrameType >= FrameSameLocals1Extended {
+            io.WriteString(*w, fmt.Sprintf("          offset_delta = %d\n", f.OffsetDelta))
+        }
+        switch name {
+        case "append":
+            added := f.Locals[len(f.Locals) - int(f.FrameType - FrameSameExtended):]
+            io.WriteString(*w, "          locals = " + javapTypes(added) + "\n")
+        case "full":
+            io.WriteString(*w, "          locals = " + javapTypes(f.Locals) + "\n")
+            io.WriteString(*w, "          stack = " + javapTypes(f.Stack) + "\n")
+        case "same_locals_1_stack_item", "same_locals_1_stack_item_extended":
+            io.WriteString(*w, "          stack = " + javapTypes(f.Stack) + "\n")
+        }
+    }
+}
+
+func javapTypes(types []VerificationType) string {
+    result := "["
+    for i, v := range types {
+        if i > 0 {
+            result += ","
+        }
+        switch v.Tag {
+        case VObject:
+            result += " class " + javapClassName(v.Class)
+        case VUninitialized:
+            result += fmt.Sprintf(" uninitialized %d", v.Offset)
+        case VUninitializedThis:
+            result += " uninitialized_this"
+        default:
+            result += " " + map[VerificationTag]string{
+                VTop: "top", VInteger: "int", VFloat: "float",
+                VDouble: "double", VLong: "long", VNull: "null",
+            }[v.Tag]
+        }
+    }
+    return result + " ]"
+}
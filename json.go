@@ -0,0 +1,555 @@
+package jcr
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+    "unicode/utf8"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// JSONWriter writes a class as JSON, and ReadJSON reads it back. The schema
+// mirrors the class file: every reference is kept as its constant pool index
+// so the class can be rebuilt exactly, and is accompanied by the resolved
+// name or value so tools do not have to follow indexes themselves.
+//
+// The top level object holds major, minor, flags (with flagNames), this and
+// super ({index, name}), interfaces, constantPool, fields, methods and
+// attributes. Constant pool entries carry their index, tag (the names used
+// by javap such as "Methodref"), the index fields of that tag and a resolved
+// value. Float and double entries also carry their raw bits in hex, which is
+// what ReadJSON uses since JSON has no NaN or infinity. Utf8 entries that are
+// not valid UTF-8 carry their raw bytes in base64.
+//
+// Attributes carry their name and the raw info in base64, together with a
+// decoded view of the attributes this package understands. The Code
+// attribute is written out in full as maxStack, maxLocals, instructions,
+// exceptionHandlers and attributes instead. Each instruction has its offset,
+// opcode mnemonic and raw operand bytes, plus the resolved constant, local
+// variable and branch targets where they apply.
+//
+// ReadJSON only uses the indexes, raw bits and bytes, info, and the opcode and
+// operands of instructions; the resolved values are ignored, so edits must be
+// made to the former.
+type JSONWriter struct {}
+
+type jsonClass struct {
+    Major uint16 `json:"major"`
+    Minor uint16 `json:"minor"`
+    Flags AccessFlag `json:"flags"`
+    FlagNames []string `json:"flagNames"`
+    This jsonClassRef `json:"this"`
+    Super jsonClassRef `json:"super"`
+    Interfaces []jsonClassRef `json:"interfaces"`
+    ConstantPool []jsonConstant `json:"constantPool"`
+    Fields []jsonMember `json:"fields"`
+    Methods []jsonMember `json:"methods"`
+    Attributes []jsonAttribute `json:"attributes"`
+}
+
+type jsonClassRef struct {
+    Index CpIndex `json:"index"`
+    Name string `json:"name,omitempty"`
+}
+
+type jsonConstant struct {
+    Index CpIndex `json:"index"`
+    Tag string `json:"tag"`
+    NameIndex CpIndex `json:"nameIndex,omitempty"`
+    ClassIndex CpIndex `json:"classIndex,omitempty"`
+    NameAndTypeIndex CpIndex `json:"nameAndTypeIndex,omitempty"`
+    DescriptorIndex CpIndex `json:"descriptorIndex,omitempty"`
+    StringIndex CpIndex `json:"stringIndex,omitempty"`
+    ReferenceKind byte `json:"referenceKind,omitempty"`
+    ReferenceIndex CpIndex `json:"referenceIndex,omitempty"`
+    Bootstrap *uint16 `json:"bootstrap,omitempty"`
+    Bits string `json:"bits,omitempty"`
+    Bytes []byte `json:"bytes,omitempty"`
+    Value any `json:"value,omitempty"`
+}
+
+type jsonMember struct {
+    Flags AccessFlag `json:"flags"`
+    FlagNames []string `json:"flagNames"`
+    NameIndex CpIndex `json:"nameIndex"`
+    Name string `json:"name"`
+    DescriptorIndex CpIndex `json:"descriptorIndex"`
+    Descriptor string `json:"descriptor"`
+    Attributes []jsonAttribute `json:"attributes"`
+}
+
+type jsonAttribute struct {
+    NameIndex CpIndex `json:"nameIndex"`
+    Name string `json:"name"`
+    Info []byte `json:"info,omitempty"`
+    Code *jsonCode `json:"code,omitempty"`
+    Decoded any `json:"decoded,omitempty"`
+}
+
+type jsonCode struct {
+    MaxStack uint16 `json:"maxStack"`
+    MaxLocals uint16 `json:"maxLocals"`
+    Instructions []jsonInstruction `json:"instructions"`
+    ExceptionHandlers []jsonHandler `json:"exceptionHandlers"`
+    Attributes []jsonAttribute `json:"attributes"`
+}
+
+type jsonInstruction struct {
+    Offset int `json:"offset"`
+    Opcode string `json:"opcode"`
+    Operands []int `json:"operands,omitempty"`
+    Index CpIndex `json:"index,omitempty"`
+    Constant string `json:"constant,omitempty"`
+    Local *int `json:"local,omitempty"`
+    Targets []int `json:"targets,omitempty"`
+}
+
+type jsonHandler struct {
+    StartPc uint16 `json:"startPc"`
+    EndPc uint16 `json:"endPc"`
+    HandlerPc uint16 `json:"handlerPc"`
+    CatchType jsonClassRef `json:"catchType"`
+}
+
+type jsonLocal struct {
+    StartPc uint16 `json:"startPc"`
+    Length uint16 `json:"length"`
+    Index uint16 `json:"index"`
+    Name string `json:"name"`
+    Descriptor string `json:"descriptor"`
+}
+
+type jsonBootstrap struct {
+    Method string `json:"method"`
+    Arguments []string `json:"arguments"`
+}
+
+func (j JSONWriter) Write(w *io.Writer, c *Class) error {
+    cp := c.ConstantPool
+    out := jsonClass{
+        Major: c.Major,
+        Minor: c.Minor,
+        Flags: c.Flags,
+        FlagNames: jsonFlags(c.Flags, classFlagNames),
+        This: jsonClassRef{Index: c.ThisIndex, Name: c.Name()},
+        Super: jsonClassRef{Index: c.SuperIndex, Name: c.SuperName()},
+        Interfaces: []jsonClassRef{},
+        Fields: []jsonMember{},
+        Methods: []jsonMember{},
+    }
+    for _, iface := range c.Interfaces {
+        out.Interfaces = append(out.Interfaces, jsonClassRef{Index: iface, Name: cp.GetClassName(iface)})
+    }
+    out.ConstantPool = jsonPool(cp)
+
+    bootstraps, err := c.BootstrapMethods()
+    if err != nil {
+        return err
+    }
+    for _, field := range c.Fields {
+        member, err := jsonMemberOf(cp, field.Flags, fieldFlagNames, field.NameIndex, field.DescriptorIndex, field.Attributes, bootstraps)
+        if err != nil {
+            return err
+        }
+        out.Fields = append(out.Fields, member)
+    }
+    for _, method := range c.Methods {
+        member, err := jsonMemberOf(cp, method.Flags, methodFlagNames, method.NameIndex, method.DescriptorIndex, method.Attributes, bootstraps)
+        if err != nil {
+            return err
+        }
+        out.Methods = append(out.Methods, member)
+    }
+    if out.Attributes, err = jsonAttributes(cp, c.Attributes, bootstraps); err != nil {
+        return err
+    }
+
+    encoder := json.NewEncoder(*w)
+    encoder.SetIndent("", "  ")
+    return encoder.Encode(out)
+}
+
+func jsonFlags(f AccessFlag, names []flagName) []string {
+    result := []string{}
+    for _, n := range names {
+        if f & n.flag != 0 {
+            result = append(result, n.name)
+        }
+    }
+    return result
+}
+
+func jsonPool(cp *ConstantPool) []jsonConstant {
+    pool := []jsonConstant{}
+    for i := 1; i < int(cp.Count()); i++ {
+        constant := *cp.Get(CpIndex(i))
+        if constant == nil {
+            continue
+        }
+        entry := jsonConstant{Index: CpIndex(i), Tag: javapTags[constant.Type()]}
+        switch c := constant.(type) {
+        case ConstUtf8:
+            entry.Value = string(c.Data)
+            if !utf8.Valid(c.Data) {
+                entry.Bytes = c.Data
+            }
+        case ConstInteger:
+            entry.Value = c.Value
+        case ConstFloat:
+            entry.Bits = fmt.Sprintf("0x%08x", math.Float32bits(c.Value))
+            if !math.IsNaN(float64(c.Value)) && !math.IsInf(float64(c.Value), 0) {
+                entry.Value = c.Value
+            }
+        case ConstLong:
+            entry.Value = c.Value
+        case ConstDouble:
+            entry.Bits = fmt.Sprintf("0x%016x", math.Float64bits(c.Value))
+            if !math.IsNaN(c.Value) && !math.IsInf(c.Value, 0) {
+                entry.Value = c.Value
+            }
+        case ConstClass:
+            entry.NameIndex, entry.Value = c.NameIndex, cp.GetUtf8(c.NameIndex)
+        case ConstString:
+            entry.StringIndex, entry.Value = c.StringIndex, cp.GetUtf8(c.StringIndex)
+        case ConstMethodType:
+            entry.DescriptorIndex, entry.Value = c.DescriptorIndex, cp.GetUtf8(c.DescriptorIndex)
+        case ConstModule:
+            entry.NameIndex, entry.Value = c.NameIndex, cp.GetUtf8(c.NameIndex)
+        case ConstPackage:
+            entry.NameIndex, entry.Value = c.NameIndex, cp.GetUtf8(c.NameIndex)
+        case ConstNameType:
+            entry.NameIndex, entry.DescriptorIndex = c.NameIndex, c.DescriptorIndex
+            entry.Value = cp.GetUtf8(c.NameIndex) + ":" + cp.GetUtf8(c.DescriptorIndex)
+        case ConstField:
+            entry.ClassIndex, entry.NameAndTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+            entry.Value = javapMember(cp, CpIndex(i))
+        case ConstMethod:
+            entry.ClassIndex, entry.NameAndTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+            entry.Value = javapMember(cp, CpIndex(i))
+        case ConstInterfaceMethod:
+            entry.ClassIndex, entry.NameAndTypeIndex = c.ClassIndex, c.NameAndTypeIndex
+            entry.Value = javapMember(cp, CpIndex(i))
+        case ConstMethodHandle:
+            entry.ReferenceKind, entry.ReferenceIndex = c.ReferenceKind, c.ReferenceIndex
+            entry.Value = javapHandle(cp, c)
+        case ConstDynamic:
+            bootstrap := c.BootstrapMethodAttrIndex
+            entry.Bootstrap, entry.NameAndTypeIndex = &bootstrap, c.NameAndTypeIndex
+            entry.Value = javapNameType(cp, c.NameAndTypeIndex)
+        case ConstInvokeDynamic:
+            bootstrap := c.BootstrapMethodAttrIndex
+            entry.Bootstrap, entry.NameAndTypeIndex = &bootstrap, c.NameAndTypeIndex
+            entry.Value = javapNameType(cp, c.NameAndTypeIndex)
+        }
+        pool = append(pool, entry)
+    }
+    return pool
+}
+
+func jsonMemberOf(cp *ConstantPool, flags AccessFlag, names []flagName, name CpIndex, descriptor CpIndex, attrs []Attribute, bootstraps []BootstrapMethod) (jsonMember, error) {
+    attributes, err := jsonAttributes(cp, attrs, bootstraps)
+    return jsonMember{
+        Flags: flags,
+        FlagNames: jsonFlags(flags, names),
+        NameIndex: name,
+        Name: cp.GetUtf8(name),
+        DescriptorIndex: descriptor,
+        Descriptor: cp.GetUtf8(descriptor),
+        Attributes: attributes,
+    }, err
+}
+
+func jsonAttributes(cp *ConstantPool, attrs []Attribute, bootstraps []BootstrapMethod) ([]jsonAttribute, error) {
+    result := []jsonAttribute{}
+    for _, attr := range attrs {
+        entry := jsonAttribute{NameIndex: attr.NameIndex, Name: cp.GetUtf8(attr.NameIndex), Info: attr.Info}
+        var err error
+        switch entry.Name {
+        case "Code":
+            entry.Info = nil
+            entry.Code, err = jsonCodeOf(cp, attr.Info, bootstraps)
+        case "SourceFile", "Signature":
+            var index CpIndex
+            if index, err = DecodeIndex(attr.Info); err == nil {
+                entry.Decoded = cp.GetUtf8(index)
+            }
+        case "ConstantValue":
+            var index CpIndex
+            if index, err = DecodeIndex(attr.Info); err == nil {
+                entry.Decoded = javapConstant(cp, index)
+            }
+        case "Exceptions":
+            var exceptions []CpIndex
+            if exceptions, err = DecodeExceptions(attr.Info); err == nil {
+                names := []string{}
+                for _, exception := range exceptions {
+                    names = append(names, cp.GetClassName(exception))
+                }
+                entry.Decoded = names
+            }
+        case "LineNumberTable":
+            entry.Decoded, err = DecodeLineNumberTable(attr.Info)
+        case "LocalVariableTable", "LocalVariableTypeTable":
+            var table []LocalVariable
+            if table, err = DecodeLocalVariableTable(attr.Info); err == nil {
+                locals := []jsonLocal{}
+                for _, local := range table {
+                    locals = append(locals, jsonLocal{local.StartPc, local.Length, local.Index,
+                        cp.GetUtf8(local.NameIndex), cp.GetUtf8(local.DescriptorIndex)})
+                }
+                entry.Decoded = locals
+            }
+        case "BootstrapMethods":
+            methods := []jsonBootstrap{}
+            for _, m := range bootstraps {
+                bootstrap := jsonBootstrap{Method: javapHandle(cp, (*cp.Get(m.MethodRef)).(ConstMethodHandle)), Arguments: []string{}}
+                for _, arg := range m.Arguments {
+                    bootstrap.Arguments = append(bootstrap.Arguments, javapConstant(cp, arg))
+                }
+                methods = append(methods, bootstrap)
+            }
+            entry.Decoded = methods
+        }
+        if err != nil {
+            return nil, err
+        }
+        result = append(result, entry)
+    }
+    return result, nil
+}
+
+func jsonCodeOf(cp *ConstantPool, info []byte, bootstraps []BootstrapMethod) (code *jsonCode, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            code = nil
+            err = fmt.Errorf("Malformed Code attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var decoded Code
+    ReadCode(&r, &decoded)
+    instructions, err := DecodeInstructions(decoded.ByteCode)
+    if err != nil {
+        return nil, err
+    }
+
+    code = &jsonCode{
+        MaxStack: decoded.MaxStack,
+        MaxLocals: decoded.MaxLocals,
+        Instructions: []jsonInstruction{},
+        ExceptionHandlers: []jsonHandler{},
+    }
+    for _, instr := range instructions {
+        code.Instructions = append(code.Instructions, jsonInstructionOf(cp, instr))
+    }
+    for _, handler := range decoded.ExceptionHandlers {
+        catchType := jsonClassRef{Index: handler.CatchType}
+        if !handler.IsFinally() {
+            catchType.Name = cp.GetClassName(handler.CatchType)
+        }
+        code.ExceptionHandlers = append(code.ExceptionHandlers, jsonHandler{handler.StartPc, handler.EndPc, handler.HandlerPc, catchType})
+    }
+    code.Attributes, err = jsonAttributes(cp, decoded.Attributes, bootstraps)
+    return code, err
+}
+
+func jsonInstructionOf(cp *ConstantPool, instr Instruction) jsonInstruction {
+    op := instr.Opcode
+    entry := jsonInstruction{Offset: instr.Offset, Opcode: Mnemonic(op), Targets: instr.Targets()}
+    for _, b := range instr.Operands {
+        entry.Operands = append(entry.Operands, int(b))
+    }
+    switch {
+    case op == Ldc || op == LdcW || op == Ldc2W:
+        entry.Index, entry.Constant = instr.Index(), javapConstant(cp, instr.Index())
+    case op >= Getstatic && op <= Invokeinterface:
+        entry.Index, entry.Constant = instr.Index(), javapMember(cp, instr.Index())
+    case op == Invokedynamic:
+        indy := (*cp.Get(instr.Index())).(ConstInvokeDynamic)
+        entry.Index = instr.Index()
+        entry.Constant = fmt.Sprintf("#%d:%s", indy.BootstrapMethodAttrIndex, javapNameType(cp, indy.NameAndTypeIndex))
+    case op == New || op == Anewarray || op == Checkcast || op == Instanceof || op == Multianewarray:
+        entry.Index, entry.Constant = instr.Index(), cp.GetClassName(instr.Index())
+    }
+    if local, size := localAccess(instr); size > 0 {
+        entry.Local = &local
+    }
+    return entry
+}
+
+// ReadJSON rebuilds a class from the output of JSONWriter.
+func ReadJSON(r *io.Reader) (*Class, error) {
+    decoder := json.NewDecoder(*r)
+    decoder.UseNumber()
+    var in jsonClass
+    if err := decoder.Decode(&in); err != nil {
+        return nil, err
+    }
+
+    class := Class{
+        Major: in.Major,
+        Minor: in.Minor,
+        Flags: in.Flags,
+        ThisIndex: in.This.Index,
+        SuperIndex: in.Super.Index,
+        ConstantPool: &ConstantPool{},
+    }
+    cp := class.ConstantPool
+    for _, entry := range in.ConstantPool {
+        constant, err := jsonConstantOf(entry)
+        if err != nil {
+            return nil, fmt.Errorf("Constant #%d: %s", entry.Index, err)
+        }
+        if entry.Index == 0 || int(entry.Index) <= len(cp.Constants) {
+            return nil, fmt.Errorf("Constant #%d: out of order", entry.Index)
+        }
+        for len(cp.Constants) < int(entry.Index) - 1 {
+            cp.Constants = append(cp.Constants, nil)
+        }
+        cp.Constants = append(cp.Constants, constant)
+    }
+
+    for _, iface := range in.Interfaces {
+        class.Interfaces = append(class.Interfaces, iface.Index)
+    }
+    for _, member := range in.Fields {
+        attributes, err := jsonReadAttributes(cp, member.Attributes)
+        if err != nil {
+            return nil, err
+        }
+        class.Fields = append(class.Fields, Field{member.Flags, member.NameIndex, member.DescriptorIndex, attributes})
+    }
+    for _, member := range in.Methods {
+        attributes, err := jsonReadAttributes(cp, member.Attributes)
+        if err != nil {
+            return nil, err
+        }
+        class.Methods = append(class.Methods, Method{member.Flags, member.NameIndex, member.DescriptorIndex, attributes})
+    }
+    attributes, err := jsonReadAttributes(cp, in.Attributes)
+    if err != nil {
+        return nil, err
+    }
+    class.Attributes = attributes
+    return &class, nil
+}
+
+var jsonTags = func() map[string]ConstantType {
+    tags := make(map[string]ConstantType)
+    for t, name := range javapTags {
+        tags[name] = t
+    }
+    return tags
+}()
+
+func jsonConstantOf(entry jsonConstant) (Constant, error) {
+    t, ok := jsonTags[entry.Tag]
+    if !ok {
+        return nil, fmt.Errorf("unknown tag %q", entry.Tag)
+    }
+    bootstrap := func() (uint16, error) {
+        if entry.Bootstrap == nil {
+            return 0, fmt.Errorf("missing bootstrap")
+        }
+        return *entry.Bootstrap, nil
+    }
+    switch t {
+    case TUtf8:
+        if entry.Bytes != nil {
+            return ConstUtf8{Length: uint16(len(entry.Bytes)), Data: entry.Bytes}, nil
+        }
+        s, ok := entry.Value.(string)
+        if !ok {
+            return nil, fmt.Errorf("expected a string value")
+        }
+        return ConstUtf8{Length: uint16(len(s)), Data: []byte(s)}, nil
+    case TInteger:
+        v, err := jsonInt(entry.Value, 32)
+        return ConstInteger{Value: int32(v)}, err
+    case TLong:
+        v, err := jsonInt(entry.Value, 64)
+        return ConstLong{Value: v}, err
+    case TFloat:
+        bits, err := strconv.ParseUint(entry.Bits, 0, 32)
+        return ConstFloat{Value: math.Float32frombits(uint32(bits))}, err
+    case TDouble:
+        bits, err := strconv.ParseUint(entry.Bits, 0, 64)
+        return ConstDouble{Value: math.Float64frombits(bits)}, err
+    case TClass:
+        return ConstClass{NameIndex: entry.NameIndex}, nil
+    case TString:
+        return ConstString{StringIndex: entry.StringIndex}, nil
+    case TMethodType:
+        return ConstMethodType{DescriptorIndex: entry.DescriptorIndex}, nil
+    case TModule:
+        return ConstModule{NameIndex: entry.NameIndex}, nil
+    case TPackage:
+        return ConstPackage{NameIndex: entry.NameIndex}, nil
+    case TNameType:
+        return ConstNameType{NameIndex: entry.NameIndex, DescriptorIndex: entry.DescriptorIndex}, nil
+    case TFieldRef:
+        return ConstField{ClassIndex: entry.ClassIndex, NameAndTypeIndex: entry.NameAndTypeIndex}, nil
+    case TMethodRef:
+        return ConstMethod{ClassIndex: entry.ClassIndex, NameAndTypeIndex: entry.NameAndTypeIndex}, nil
+    case TInterfaceMethodref:
+        return ConstInterfaceMethod{ClassIndex: entry.ClassIndex, NameAndTypeIndex: entry.NameAndTypeIndex}, nil
+    case TMethodHandle:
+        return ConstMethodHandle{ReferenceKind: entry.ReferenceKind, ReferenceIndex: entry.ReferenceIndex}, nil
+    case TDynamic:
+        index, err := bootstrap()
+        return ConstDynamic{BootstrapMethodAttrIndex: index, NameAndTypeIndex: entry.NameAndTypeIndex}, err
+    case TInvokeDynamic:
+        index, err := bootstrap()
+        return ConstInvokeDynamic{BootstrapMethodAttrIndex: index, NameAndTypeIndex: entry.NameAndTypeIndex}, err
+    }
+    return nil, fmt.Errorf("unknown tag %q", entry.Tag)
+}
+
+func jsonInt(value any, bits int) (int64, error) {
+    number, ok := value.(json.Number)
+    if !ok {
+        return 0, fmt.Errorf("expected a number value")
+    }
+    return strconv.ParseInt(string(number), 10, bits)
+}
+
+func jsonReadAttributes(cp *ConstantPool, attrs []jsonAttribute) ([]Attribute, error) {
+    var result []Attribute
+    for _, attr := range attrs {
+        if attr.Code == nil {
+            result = append(result, Attribute{NameIndex: attr.NameIndex, Info: attr.Info})
+            continue
+        }
+        code := Code{MaxStack: attr.Code.MaxStack, MaxLocals: attr.Code.MaxLocals}
+        for _, instr := range attr.Code.Instructions {
+            op, ok := OpcodeByName(instr.Opcode)
+            if !ok {
+                return nil, fmt.Errorf("Unknown opcode %s at offset %d", instr.Opcode, instr.Offset)
+            }
+            code.ByteCode = append(code.ByteCode, byte(op))
+            for _, b := range instr.Operands {
+                if b < 0 || b > 0xff {
+                    return nil, fmt.Errorf("Operand %d of %s at offset %d is not a byte", b, instr.Opcode, instr.Offset)
+                }
+                code.ByteCode = append(code.ByteCode, byte(b))
+            }
+        }
+        for _, handler := range attr.Code.ExceptionHandlers {
+            code.ExceptionHandlers = append(code.ExceptionHandlers,
+                ExceptionHandler{handler.StartPc, handler.EndPc, handler.HandlerPc, handler.CatchType.Index})
+        }
+        attributes, err := jsonReadAttributes(cp, attr.Code.Attributes)
+        if err != nil {
+            return nil, err
+        }
+        code.Attributes = attributes
+        var buf bytes.Buffer
+        var w io.Writer = &buf
+        WriteCode(&w, &code)
+        result = append(result, Attribute{NameIndex: attr.NameIndex, Info: buf.Bytes()})
+    }
+    return result, nil
+}
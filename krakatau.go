@@ -0,0 +1,1420 @@
+package jcr
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+    "strings"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// SyntaxError reports a problem in assembly source along with the line and
+// column, both starting at 1, where it was found.
+type SyntaxError struct {
+    Line int
+    Column int
+    Msg string
+}
+
+func (e *SyntaxError) Error() string {
+    return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+type token struct {
+    text string
+    quoted bool
+    line int
+    col int
+}
+
+var flagNames = map[string]AccessFlag{
+    "public": FLAG_PUBLIC,
+    "private": FLAG_PRIVATE,
+    "protected": FLAG_PROTECTED,
+    "static": FLAG_STATIC,
+    "final": FLAG_FINAL,
+    "super": FLAG_SUPER,
+    "synchronized": FLAG_SYNCHRONIZED,
+    "volatile": FLAG_VOLATILE,
+    "bridge": FLAG_BRIDGE,
+    "transient": FLAG_TRANSIENT,
+    "varargs": FLAG_VARARGS,
+    "native": FLAG_NATIVE,
+    "interface": FLAG_INTERFACE,
+    "abstract": FLAG_ABSTRACT,
+    "strict": FLAG_STRICT,
+    "synthetic": FLAG_SYNTHETIC,
+    "annotation": FLAG_ANNOTATION,
+    "enum": FLAG_ENUM,
+    "module": FLAG_MODULE,
+    "mandated": FLAG_MANDATED,
+}
+
+var handleKinds = map[string]byte{
+    "getField": REF_getField,
+    "getStatic": REF_getStatic,
+    "putField": REF_putField,
+    "putStatic": REF_putStatic,
+    "invokeVirtual": REF_invokeVirtual,
+    "invokeStatic": REF_invokeStatic,
+    "invokeSpecial": REF_invokeSpecial,
+    "newInvokeSpecial": REF_newInvokeSpecial,
+    "invokeInterface": REF_invokeInterface,
+}
+
+var arrayTypes = map[string]byte{
+    "boolean": 4, "char": 5, "float": 6, "double": 7,
+    "byte": 8, "short": 9, "int": 10, "long": 11,
+}
+
+// ReadKrakatau assembles Krakatau assembly, as produced by KrakatauWriter,
+// into a Class. Omitted .limit directives are computed from the code.
+func ReadKrakatau(r *io.Reader) (class *Class, err error) {
+    lines, err := tokenize(*r)
+    if err != nil {
+        return nil, err
+    }
+    p := &krakatauParser{lines: lines}
+    defer func() {
+        if r := recover(); r != nil {
+            syntaxErr, ok := r.(*SyntaxError)
+            if !ok {
+                panic(r)
+            }
+            class = nil
+            err = syntaxErr
+        }
+    }()
+    return p.parseClass(), nil
+}
+
+func tokenize(r io.Reader) ([][]token, error) {
+    var lines [][]token
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(nil, 1 << 24)
+    lineNo := 0
+    for scanner.Scan() {
+        lineNo++
+        text := scanner.Text()
+        var line []token
+        i := 0
+        for i < len(text) {
+            c := text[i]
+            if c == ' ' || c == '\t' || c == '\r' {
+                i++
+                continue
+            }
+            if c == ';' {
+                break
+            }
+            start := i
+            if c == '"' || c == '\'' {
+                value, end, err := unquote(text, i)
+                if err != nil {
+                    return nil, &SyntaxError{lineNo, start + 1, err.Error()}
+                }
+                line = append(line, token{value, true, lineNo, start + 1})
+                i = end
+                continue
+            }
+            for i < len(text) && text[i] != ' ' && text[i] != '\t' && text[i] != '\r' {
+                i++
+            }
+            line = append(line, token{text[start:i], false, lineNo, start + 1})
+        }
+        if len(line) > 0 {
+            lines = append(lines, line)
+        }
+    }
+    return lines, scanner.Err()
+}
+
+// unquote decodes the string literal starting at text[start], returning the
+// value and the index just past the closing quote.
+func unquote(text string, start int) (string, int, error) {
+    quote := text[start]
+    var sb strings.Builder
+    i := start + 1
+    for i < len(text) {
+        c := text[i]
+        if c == quote {
+            return sb.String(), i + 1, nil
+        }
+        if c != '\\' {
+            sb.WriteByte(c)
+            i++
+            continue
+        }
+        if i + 1 >= len(text) {
+            break
+        }
+        i++
+        switch text[i] {
+        case 'n':
+            sb.WriteByte('\n')
+        case 't':
+            sb.WriteByte('\t')
+        case 'r':
+            sb.WriteByte('\r')
+        case 'b':
+            sb.WriteByte('\b')
+        case 'f':
+            sb.WriteByte('\f')
+        case '0':
+            sb.WriteByte(0)
+        case 'u':
+            if i + 4 >= len(text) {
+                return "", i, fmt.Errorf("Truncated unicode escape")
+            }
+            v, err := strconv.ParseUint(text[i + 1:i + 5], 16, 16)
+            if err != nil {
+                return "", i, fmt.Errorf("Invalid unicode escape")
+            }
+            sb.WriteRune(rune(v))
+            i += 4
+        case 'x':
+            if i + 2 >= len(text) {
+                return "", i, fmt.Errorf("Truncated byte escape")
+            }
+            v, err := strconv.ParseUint(text[i + 1:i + 3], 16, 8)
+            if err != nil {
+                return "", i, fmt.Errorf("Invalid byte escape")
+            }
+            sb.WriteByte(byte(v))
+            i += 2
+        default:
+            sb.WriteByte(text[i])
+        }
+        i++
+    }
+    return "", i, fmt.Errorf("Unterminated string literal")
+}
+
+type krakatauParser struct {
+    lines [][]token
+    line int
+    tokens []token
+    pos int
+
+    class *Class
+    cp *ConstantPool
+    bootstraps []BootstrapMethod
+}
+
+// pendingAttribute is a code attribute whose line number or local variable
+// entries refer to labels that are not resolved until the code is assembled.
+type pendingAttribute struct {
+    attr Attribute
+    lines []pendingLine
+    locals []pendingLocal
+}
+
+type pendingLine struct {
+    start *Label
+    line uint16
+}
+
+type pendingLocal struct {
+    start *Label
+    end *Label
+    name CpIndex
+    descriptor CpIndex
+    index uint16
+}
+
+type pendingFrame struct {
+    at *Label
+    kind string
+    types []frameTypeRef
+    stack []frameTypeRef
+    chop int
+}
+
+// frameTypeRef is a verification type whose Uninitialized offset is not
+// known until the code has been assembled.
+type frameTypeRef struct {
+    VerificationType
+    label *Label
+}
+
+func (p *krakatauParser) fail(t token, format string, args ...any) {
+    panic(&SyntaxError{t.line, t.col, fmt.Sprintf(format, args...)})
+}
+
+// nextLine advances to the next line of tokens, reporting false at the end
+// of the input.
+func (p *krakatauParser) nextLine() bool {
+    if p.line >= len(p.lines) {
+        return false
+    }
+    p.tokens = p.lines[p.line]
+    p.pos = 0
+    p.line++
+    return true
+}
+
+func (p *krakatauParser) atEnd() bool {
+    return p.pos >= len(p.tokens)
+}
+
+func (p *krakatauParser) peek() string {
+    if p.atEnd() {
+        return ""
+    }
+    return p.tokens[p.pos].text
+}
+
+// last returns the current token, or the final token of the line when at
+// its end, for error reporting.
+func (p *krakatauParser) last() token {
+    if p.atEnd() {
+        t := p.tokens[len(p.tokens) - 1]
+        t.col += len(t.text)
+        return t
+    }
+    return p.tokens[p.pos]
+}
+
+func (p *krakatauParser) next() token {
+    if p.atEnd() {
+        p.fail(p.last(), "Unexpected end of line")
+    }
+    t := p.tokens[p.pos]
+    p.pos++
+    return t
+}
+
+func (p *krakatauParser) word() string {
+    t := p.next()
+    if t.quoted {
+        p.fail(t, "Expected a name but found a string")
+    }
+    return t.text
+}
+
+// name reads a name, which may be quoted when it contains spaces or would
+// otherwise be taken for a flag.
+func (p *krakatauParser) name() string {
+    return p.next().text
+}
+
+// poolRef reports whether t refers to a constant by index, as in [12]. [0]
+// stands for no constant where one is optional.
+func (p *krakatauParser) poolRef(t token) (CpIndex, bool) {
+    text := t.text
+    if t.quoted || len(text) < 3 || text[0] != '[' || text[len(text) - 1] != ']' {
+        return 0, false
+    }
+    v, err := strconv.ParseUint(text[1:len(text) - 1], 10, 16)
+    if err != nil {
+        return 0, false
+    }
+    if v >= uint64(p.cp.Count()) {
+        p.fail(t, "Constant %s is not defined", text)
+    }
+    return CpIndex(v), true
+}
+
+// classRef reads a class name or a [N] reference.
+func (p *krakatauParser) classRef() CpIndex {
+    t := p.next()
+    if index, ok := p.poolRef(t); ok {
+        return index
+    }
+    return p.cp.AddClass(t.text)
+}
+
+// utf8Ref reads a name or a [N] reference.
+func (p *krakatauParser) utf8Ref() CpIndex {
+    t := p.next()
+    if index, ok := p.poolRef(t); ok {
+        return index
+    }
+    return p.cp.AddUtf8(t.text)
+}
+
+// block calls parseLine for each line up to ".end name".
+func (p *krakatauParser) block(name string, parseLine func()) {
+    p.endOfLine()
+    for {
+        if !p.nextLine() {
+            p.fail(p.last(), "Missing .end %s", name)
+        }
+        if p.peek() == ".end" && !p.tokens[0].quoted {
+            p.next()
+            p.expect(name)
+            return
+        }
+        parseLine()
+        p.endOfLine()
+    }
+}
+
+func (p *krakatauParser) expect(text string) {
+    t := p.next()
+    if t.quoted || t.text != text {
+        p.fail(t, "Expected '%s' but found '%s'", text, t.text)
+    }
+}
+
+func (p *krakatauParser) endOfLine() {
+    if !p.atEnd() {
+        t := p.tokens[p.pos]
+        p.fail(t, "Unexpected '%s'", t.text)
+    }
+}
+
+func (p *krakatauParser) integer() int64 {
+    t := p.next()
+    v, err := strconv.ParseInt(t.text, 0, 64)
+    if t.quoted || err != nil {
+        p.fail(t, "Expected an integer but found '%s'", t.text)
+    }
+    return v
+}
+
+func (p *krakatauParser) integerIn(min int64, max int64) int64 {
+    t := p.last()
+    v := p.integer()
+    if v < min || v > max {
+        p.fail(t, "Value %d is out of range [%d, %d]", v, min, max)
+    }
+    return v
+}
+
+// flags reads flag names along with hex values for bits that have no name.
+func (p *krakatauParser) flags() AccessFlag {
+    var flags AccessFlag
+    for !p.atEnd() && !p.tokens[p.pos].quoted {
+        flag, ok := flagNames[p.peek()]
+        if !ok && strings.HasPrefix(p.peek(), "0x") {
+            v, err := strconv.ParseUint(p.peek(), 0, 16)
+            flag, ok = AccessFlag(v), err == nil
+        }
+        if !ok {
+            break
+        }
+        flags |= flag
+        p.pos++
+    }
+    return flags
+}
+
+// flagsOf reads the flags of a kind of module directive by the names in
+// names, which differ from those of classes and members, along with hex
+// values for bits that have no name.
+func (p *krakatauParser) flagsOf(names []flagName) AccessFlag {
+    var flags AccessFlag
+    for !p.atEnd() && !p.tokens[p.pos].quoted {
+        found := false
+        for _, n := range names {
+            if n.name == p.peek() {
+                flags |= n.flag
+                found = true
+            }
+        }
+        if !found && strings.HasPrefix(p.peek(), "0x") {
+            v, err := strconv.ParseUint(p.peek(), 0, 16)
+            flags, found = flags | AccessFlag(v), err == nil
+        }
+        if !found {
+            break
+        }
+        p.pos++
+    }
+    return flags
+}
+
+func (p *krakatauParser) parseClass() *Class {
+    p.cp = &ConstantPool{}
+    p.class = &Class{Major: 49, ConstantPool: p.cp}
+    for p.nextLine() {
+        t := p.next()
+        switch t.text {
+        case ".version":
+            p.class.Major = uint16(p.integerIn(0, 0xffff))
+            p.class.Minor = uint16(p.integerIn(0, 0xffff))
+        case ".const":
+            p.parseConst()
+        case ".class":
+            p.class.Flags = p.flags()
+            p.class.ThisIndex = p.classRef()
+        case ".super":
+            p.class.SuperIndex = p.classRef()
+        case ".implements":
+            p.class.Interfaces = append(p.class.Interfaces, p.classRef())
+        case ".field":
+            p.parseField()
+        case ".method":
+            p.parseMethod()
+        case ".innerclasses":
+            var inners []InnerClass
+            p.block("innerclasses", func() {
+                inner := InnerClass{InnerClassIndex: p.classRef(), OuterClassIndex: p.classRef()}
+                inner.InnerNameIndex = p.utf8Ref()
+                inner.Flags = p.flags()
+                inners = append(inners, inner)
+            })
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("InnerClasses"),
+                Info: encodeTable(inners),
+            })
+        case ".enclosing":
+            p.expect("method")
+            enclosing := EnclosingMethod{ClassIndex: p.classRef()}
+            if !p.atEnd() {
+                enclosing.MethodIndex = p.cp.AddNameType(p.name(), p.word())
+            }
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("EnclosingMethod"),
+                Info: EncodeEnclosingMethod(enclosing),
+            })
+        case ".sourcefile", ".signature", ".attribute":
+            p.class.Attributes = append(p.class.Attributes, p.attribute(t.text))
+        case ".nesthost":
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("NestHost"),
+                Info: EncodeIndex(p.classRef()),
+            })
+        case ".nestmembers", ".permittedsubclasses":
+            var classes []CpIndex
+            for !p.atEnd() {
+                classes = append(classes, p.classRef())
+            }
+            name := map[string]string{
+                ".nestmembers": "NestMembers",
+                ".permittedsubclasses": "PermittedSubclasses",
+            }[t.text]
+            p.class.Attributes = append(p.class.Attributes, Attribute{NameIndex: p.cp.AddUtf8(name), Info: encodeTable(classes)})
+        case ".record":
+            var components []RecordComponent
+            p.block("record", func() {
+                component := RecordComponent{NameIndex: p.utf8Ref(), DescriptorIndex: p.utf8Ref()}
+                if p.peek() == ".componentattributes" {
+                    p.next()
+                    p.block("componentattributes", func() {
+                        t := p.next()
+                        switch t.text {
+                        case ".signature", ".attribute":
+                            component.Attributes = append(component.Attributes, p.attribute(t.text))
+                        default:
+                            p.fail(t, "Unexpected '%s'", t.text)
+                        }
+                    })
+                }
+                components = append(components, component)
+            })
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("Record"),
+                Info: EncodeRecord(components),
+            })
+        case ".module":
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("Module"),
+                Info: EncodeModule(p.parseModule()),
+            })
+        case ".modulepackages":
+            var packages []CpIndex
+            for !p.atEnd() {
+                packages = append(packages, p.cp.AddPackage(p.name()))
+            }
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("ModulePackages"),
+                Info: EncodeModulePackages(packages),
+            })
+        case ".modulemainclass":
+            p.class.Attributes = append(p.class.Attributes, Attribute{
+                NameIndex: p.cp.AddUtf8("ModuleMainClass"),
+                Info: EncodeIndex(p.classRef()),
+            })
+        case ".end":
+            p.expect("class")
+        default:
+            p.fail(t, "Unexpected '%s'", t.text)
+        }
+        p.endOfLine()
+    }
+    if p.class.ThisIndex == 0 {
+        panic(&SyntaxError{len(p.lines), 1, "Missing .class directive"})
+    }
+    for i, constant := range p.cp.Constants {
+        if constant == nil && (i == 0 || !isWideConstant(p.cp.Constants[i - 1])) {
+            panic(&SyntaxError{len(p.lines), 1, fmt.Sprintf("Constant [%d] is never defined", i + 1)})
+        }
+    }
+    // module-info classes have no super class
+    if p.class.SuperIndex == 0 && p.class.Name() != "java/lang/Object" && !p.class.IsModule() {
+        p.class.SuperIndex = p.cp.AddClass("java/lang/Object")
+    }
+    if len(p.bootstraps) > 0 {
+        p.class.Attributes = append(p.class.Attributes, Attribute{
+            NameIndex: p.cp.AddUtf8("BootstrapMethods"),
+            Info: EncodeBootstrapMethods(p.bootstraps),
+        })
+    }
+    return p.class
+}
+
+// parseModule reads a .module block: the module's flags, name and version,
+// then a line per requires, exports, opens, uses and provides entry.
+func (p *krakatauParser) parseModule() ModuleAttribute {
+    version := func() CpIndex {
+        if p.peek() != "version" || p.tokens[p.pos].quoted {
+            return 0
+        }
+        p.next()
+        return p.cp.AddUtf8(p.next().text)
+    }
+    m := ModuleAttribute{Flags: p.flagsOf(moduleFlagNames)}
+    m.NameIndex = p.cp.AddModule(p.name())
+    m.VersionIndex = version()
+    p.block("module", func() {
+        switch t := p.next(); t.text {
+        case "requires":
+            entry := ModuleRequiresEntry{Flags: p.flagsOf(requiresFlagNames)}
+            entry.Index = p.cp.AddModule(p.name())
+            entry.VersionIndex = version()
+            m.Requires = append(m.Requires, entry)
+        case "exports", "opens":
+            entry := ModuleExportsEntry{Flags: p.flagsOf(exportsFlagNames)}
+            entry.Index = p.cp.AddPackage(p.name())
+            if p.peek() == "to" && !p.tokens[p.pos].quoted {
+                p.next()
+                for !p.atEnd() {
+                    entry.To = append(entry.To, p.cp.AddModule(p.name()))
+                }
+            }
+            if t.text == "exports" {
+                m.Exports = append(m.Exports, entry)
+            } else {
+                m.Opens = append(m.Opens, entry)
+            }
+        case "uses":
+            m.Uses = append(m.Uses, p.classRef())
+        case "provides":
+            entry := ModuleProvidesEntry{Index: p.classRef()}
+            p.expect("with")
+            for !p.atEnd() {
+                entry.With = append(entry.With, p.classRef())
+            }
+            m.Provides = append(m.Provides, entry)
+        default:
+            p.fail(t, "Expected requires, exports, opens, uses or provides but found '%s'", t.text)
+        }
+    })
+    return m
+}
+
+// utf8Attribute builds an attribute whose contents are a single Utf8 index,
+// such as SourceFile or Signature.
+func (p *krakatauParser) utf8Attribute(name string, value string) Attribute {
+    return Attribute{NameIndex: p.cp.AddUtf8(name), Info: EncodeIndex(p.cp.AddUtf8(value))}
+}
+
+// attribute reads the rest of a .sourcefile, .signature, .attribute or
+// .codeattribute directive.
+func (p *krakatauParser) attribute(directive string) Attribute {
+    switch directive {
+    case ".sourcefile":
+        return p.utf8Attribute("SourceFile", p.next().text)
+    case ".signature":
+        return p.utf8Attribute("Signature", p.next().text)
+    }
+    name := p.utf8Ref()
+    t := p.next()
+    if !t.quoted {
+        p.fail(t, "Expected the attribute contents as a string")
+    }
+    return Attribute{NameIndex: name, Info: []byte(t.text)}
+}
+
+// parseConst defines the constant at a given index, as in
+// .const [3] = Class [4]. Constants may refer to ones defined later but must
+// be defined before anything else refers to them.
+func (p *krakatauParser) parseConst() {
+    t := p.next()
+    text := strings.TrimSuffix(strings.TrimPrefix(t.text, "["), "]")
+    v, err := strconv.ParseUint(text, 10, 16)
+    if t.quoted || err != nil || v == 0 || len(text) + 2 != len(t.text) {
+        p.fail(t, "Expected a constant index such as [1] but found '%s'", t.text)
+    }
+    p.expect("=")
+    ref := func() CpIndex {
+        t := p.next()
+        text := strings.TrimSuffix(strings.TrimPrefix(t.text, "["), "]")
+        v, err := strconv.ParseUint(text, 10, 16)
+        if t.quoted || err != nil || len(text) + 2 != len(t.text) {
+            p.fail(t, "Expected a constant index such as [1] but found '%s'", t.text)
+        }
+        return CpIndex(v)
+    }
+    var constant Constant
+    switch kind := p.word(); kind {
+    case "Utf8":
+        s := p.next().text
+        constant = ConstUtf8{Length: uint16(len(s)), Data: []byte(s)}
+    case "Int":
+        constant = p.numeric(p.next(), TInteger)
+    case "Float":
+        constant = p.numeric(p.next(), TFloat)
+    case "Long":
+        constant = p.numeric(p.next(), TLong)
+    case "Double":
+        constant = p.numeric(p.next(), TDouble)
+    case "Class":
+        constant = ConstClass{NameIndex: ref()}
+    case "String":
+        constant = ConstString{StringIndex: ref()}
+    case "MethodType":
+        constant = ConstMethodType{DescriptorIndex: ref()}
+    case "Module":
+        constant = ConstModule{NameIndex: ref()}
+    case "Package":
+        constant = ConstPackage{NameIndex: ref()}
+    case "NameAndType":
+        constant = ConstNameType{NameIndex: ref(), DescriptorIndex: ref()}
+    case "Field":
+        constant = ConstField{ClassIndex: ref(), NameAndTypeIndex: ref()}
+    case "Method":
+        constant = ConstMethod{ClassIndex: ref(), NameAndTypeIndex: ref()}
+    case "InterfaceMethod":
+        constant = ConstInterfaceMethod{ClassIndex: ref(), NameAndTypeIndex: ref()}
+    case "MethodHandle":
+        k := p.next()
+        handleKind, ok := handleKinds[k.text]
+        if !ok {
+            // kinds without a name are written as numbers
+            p.pos--
+            handleKind = byte(p.integerIn(0, 0xff))
+        }
+        constant = ConstMethodHandle{ReferenceKind: handleKind, ReferenceIndex: ref()}
+    case "Dynamic":
+        constant = ConstDynamic{BootstrapMethodAttrIndex: uint16(p.integerIn(0, 0xffff)), NameAndTypeIndex: ref()}
+    case "InvokeDynamic":
+        constant = ConstInvokeDynamic{BootstrapMethodAttrIndex: uint16(p.integerIn(0, 0xffff)), NameAndTypeIndex: ref()}
+    default:
+        p.fail(p.tokens[p.pos - 1], "Unknown constant type '%s'", kind)
+    }
+
+    index := int(v)
+    size := 1
+    if isWideConstant(constant) {
+        size = 2
+    }
+    for len(p.cp.Constants) < index - 1 + size {
+        p.cp.Constants = append(p.cp.Constants, nil)
+    }
+    if p.cp.Constants[index - 1] != nil || (index > 1 && isWideConstant(p.cp.Constants[index - 2])) ||
+        (size == 2 && p.cp.Constants[index] != nil) {
+        p.fail(t, "Constant %s is already defined", t.text)
+    }
+    p.cp.Constants[index - 1] = constant
+}
+
+func isWideConstant(c Constant) bool {
+    return c != nil && (c.Type() == TLong || c.Type() == TDouble)
+}
+
+func (p *krakatauParser) parseField() {
+    var field Field
+    field.Flags = p.flags()
+    field.NameIndex = p.utf8Ref()
+    field.DescriptorIndex = p.utf8Ref()
+    descriptor := p.cp.GetUtf8(field.DescriptorIndex)
+    if p.peek() == "=" {
+        p.next()
+        field.Attributes = append(field.Attributes, Attribute{
+            NameIndex: p.cp.AddUtf8("ConstantValue"),
+            Info: EncodeIndex(p.fieldConstant(descriptor)),
+        })
+    }
+    if p.peek() == ".fieldattributes" {
+        p.next()
+        p.block("fieldattributes", func() {
+            t := p.next()
+            switch t.text {
+            case ".signature", ".attribute":
+                field.Attributes = append(field.Attributes, p.attribute(t.text))
+            default:
+                p.fail(t, "Unexpected '%s'", t.text)
+            }
+        })
+    }
+    p.class.Fields = append(p.class.Fields, field)
+}
+
+// fieldConstant reads a ConstantValue, using the field type to decide how to
+// store plain numbers.
+func (p *krakatauParser) fieldConstant(descriptor string) CpIndex {
+    t := p.tokens[p.pos]
+    if t.quoted || !isNumber(t.text) {
+        return p.constant()
+    }
+    p.pos++
+    typ := TInteger
+    switch descriptor {
+    case "J":
+        typ = TLong
+    case "F":
+        typ = TFloat
+    case "D":
+        typ = TDouble
+    }
+    return p.cp.addUnique(p.numeric(t, typ))
+}
+
+func isNumber(text string) bool {
+    if text == "" {
+        return false
+    }
+    c := text[0]
+    if c == '+' || c == '-' {
+        if len(text) == 1 {
+            return false
+        }
+        c = text[1]
+        if strings.HasPrefix(text[1:], "Infinity") {
+            return true
+        }
+    }
+    return (c >= '0' && c <= '9') || text == "NaN" || strings.HasPrefix(text, "NaN")
+}
+
+// nanBits decodes the NaN<0x7fc00001> form used for NaNs other than the
+// canonical one.
+func nanBits(text string) (uint64, bool) {
+    if !strings.HasPrefix(text, "NaN<") || !strings.HasSuffix(text, ">") {
+        return 0, false
+    }
+    v, err := strconv.ParseUint(text[4:len(text) - 1], 0, 64)
+    return v, err == nil
+}
+
+func parseFloat(text string, bits int) (float64, error) {
+    switch strings.TrimRight(text, "fFdD") {
+    case "NaN":
+        return math.NaN(), nil
+    case "+Infinity", "Infinity":
+        return math.Inf(1), nil
+    case "-Infinity":
+        return math.Inf(-1), nil
+    }
+    return strconv.ParseFloat(strings.TrimRight(text, "fFdD"), bits)
+}
+
+// constant reads a loadable constant and returns its pool index.
+func (p *krakatauParser) constant() CpIndex {
+    t := p.next()
+    if t.quoted {
+        return p.cp.AddString(t.text)
+    }
+    if index, ok := p.poolRef(t); ok {
+        return index
+    }
+    if isNumber(t.text) {
+        return p.cp.addUnique(p.numeric(t, numberType(t.text)))
+    }
+    switch t.text {
+    case "String":
+        return p.cp.AddString(p.next().text)
+    case "Class":
+        return p.cp.AddClass(p.word())
+    case "MethodType":
+        return p.cp.AddMethodType(p.word())
+    case "MethodHandle":
+        return p.methodHandle()
+    case "Dynamic":
+        bootstrap := p.bootstrap()
+        p.expect(":")
+        nameType := p.cp.AddNameType(p.word(), p.word())
+        return p.cp.addUnique(ConstDynamic{BootstrapMethodAttrIndex: bootstrap, NameAndTypeIndex: nameType})
+    }
+    p.fail(t, "Expected a constant but found '%s'", t.text)
+    return 0
+}
+
+// numberType infers the type of a numeric constant from its suffix and form.
+func numberType(text string) ConstantType {
+    last := text[len(text) - 1]
+    isHex := strings.HasPrefix(strings.TrimLeft(text, "+-"), "0x")
+    switch {
+    case last == 'L' || last == 'l':
+        return TLong
+    case (last == 'f' || last == 'F') && !isHex:
+        return TFloat
+    case !isHex && (strings.ContainsAny(text, ".eE") || strings.Contains(text, "Infinity") || strings.Contains(text, "NaN")):
+        return TDouble
+    }
+    return TInteger
+}
+
+// numeric parses t as a constant of the given type.
+func (p *krakatauParser) numeric(t token, typ ConstantType) Constant {
+    var err error
+    var constant Constant
+    switch typ {
+    case TLong:
+        var v int64
+        v, err = strconv.ParseInt(strings.TrimRight(t.text, "Ll"), 0, 64)
+        constant = ConstLong{Value: v}
+    case TFloat:
+        text := strings.TrimRight(t.text, "fF")
+        if bits, ok := nanBits(text); ok {
+            return ConstFloat{Value: math.Float32frombits(uint32(bits))}
+        }
+        var v float64
+        v, err = parseFloat(text, 32)
+        constant = ConstFloat{Value: float32(v)}
+    case TDouble:
+        text := strings.TrimRight(t.text, "dD")
+        if bits, ok := nanBits(text); ok {
+            return ConstDouble{Value: math.Float64frombits(bits)}
+        }
+        var v float64
+        v, err = parseFloat(text, 64)
+        constant = ConstDouble{Value: v}
+    default:
+        var v int64
+        v, err = strconv.ParseInt(t.text, 0, 32)
+        constant = ConstInteger{Value: int32(v)}
+    }
+    if t.quoted || err != nil {
+        p.fail(t, "Invalid %s '%s'", map[ConstantType]string{
+            TInteger: "int", TFloat: "float", TLong: "long", TDouble: "double",
+        }[typ], t.text)
+    }
+    return constant
+}
+
+// memberRef reads an optional Field, Method or InterfaceMethod keyword
+// followed by the class, name and descriptor of the member.
+func (p *krakatauParser) memberRef(defaultKind string) CpIndex {
+    if !p.atEnd() {
+        if index, ok := p.poolRef(p.tokens[p.pos]); ok {
+            p.pos++
+            return index
+        }
+    }
+    kind := defaultKind
+    switch p.peek() {
+    case "Field", "Method", "InterfaceMethod":
+        kind = p.word()
+    }
+    class, name, descriptor := p.word(), p.word(), p.word()
+    switch kind {
+    case "Field":
+        return p.cp.AddField(class, name, descriptor)
+    case "InterfaceMethod":
+        return p.cp.AddInterfaceMethod(class, name, descriptor)
+    }
+    return p.cp.AddMethod(class, name, descriptor)
+}
+
+func (p *krakatauParser) methodHandle() CpIndex {
+    t := p.next()
+    kind, ok := handleKinds[t.text]
+    if !ok {
+        p.fail(t, "Unknown method handle kind '%s'", t.text)
+    }
+    defaultKind := "Method"
+    if kind <= REF_putStatic {
+        defaultKind = "Field"
+    } else if kind == REF_invokeInterface {
+        defaultKind = "InterfaceMethod"
+    }
+    return p.cp.AddMethodHandle(kind, p.memberRef(defaultKind))
+}
+
+// bootstrap reads a bootstrap method handle and its static arguments up to
+// the ':' that precedes the call site name, returning the index of the entry
+// in the BootstrapMethods attribute.
+func (p *krakatauParser) bootstrap() uint16 {
+    var m BootstrapMethod
+    m.MethodRef = p.methodHandle()
+    for p.peek() != ":" || (!p.atEnd() && p.tokens[p.pos].quoted) {
+        m.Arguments = append(m.Arguments, p.constant())
+    }
+    for i, existing := range p.bootstraps {
+        if existing.MethodRef == m.MethodRef && sameIndexes(existing.Arguments, m.Arguments) {
+            return uint16(i)
+        }
+    }
+    p.bootstraps = append(p.bootstraps, m)
+    return uint16(len(p.bootstraps) - 1)
+}
+
+func sameIndexes(a []CpIndex, b []CpIndex) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+func (p *krakatauParser) parseMethod() {
+    var method Method
+    method.Flags = p.flags()
+    method.NameIndex = p.utf8Ref()
+    p.expect(":")
+    method.DescriptorIndex = p.utf8Ref()
+    p.endOfLine()
+
+    a := NewAssembler(p.cp)
+    labels := make(map[string]*Label)
+    label := func(t token) *Label {
+        if t.quoted {
+            p.fail(t, "Expected a label")
+        }
+        l, ok := labels[t.text]
+        if !ok {
+            l = a.NewLabel()
+            labels[t.text] = l
+        }
+        return l
+    }
+    labelTokens := make(map[*Label]token)
+    useLabel := func() *Label {
+        t := p.next()
+        l := label(t)
+        if _, ok := labelTokens[l]; !ok {
+            labelTokens[l] = t
+        }
+        return l
+    }
+
+    hasCode := false
+    stackLimit, localsLimit := int64(-1), int64(-1)
+    var frames []pendingFrame
+    var codeAttrs []pendingAttribute
+    codeAt, codeName := 0, CpIndex(0)
+    exceptionsAt := -1
+    var exceptions []CpIndex
+    for {
+        if !p.nextLine() {
+            panic(&SyntaxError{p.lines[len(p.lines) - 1][0].line + 1, 1, "Missing .end method"})
+        }
+        t := p.tokens[0]
+        if !t.quoted && strings.HasSuffix(t.text, ":") && len(t.text) > 1 {
+            p.next()
+            l := label(token{strings.TrimSuffix(t.text, ":"), false, t.line, t.col})
+            if l.marked {
+                p.fail(t, "Label %s is defined more than once", strings.TrimSuffix(t.text, ":"))
+            }
+            a.Mark(l)
+            if p.atEnd() {
+                continue
+            }
+            t = p.tokens[p.pos]
+        }
+        p.next()
+        switch t.text {
+        case ".end":
+            p.expect("method")
+            p.endOfLine()
+            if exceptionsAt >= 0 {
+                method.Attributes[exceptionsAt].Info = encodeTable(exceptions)
+            }
+            p.finishMethod(&method, a, hasCode, codeAt, codeName, stackLimit, localsLimit, frames, codeAttrs, labelTokens)
+            return
+        case ".code":
+            // the name and position of the Code attribute, as written in
+            // lossless output
+            codeAt, codeName = len(method.Attributes), p.utf8Ref()
+            hasCode = true
+        case ".limit":
+            switch t := p.next(); t.text {
+            case "stack":
+                stackLimit = p.integerIn(0, 0xffff)
+            case "locals":
+                localsLimit = p.integerIn(0, 0xffff)
+            default:
+                p.fail(t, "Expected stack or locals")
+            }
+            hasCode = true
+        case ".catch":
+            catchType := CpIndex(0)
+            if p.peek() == "all" {
+                p.next()
+            } else {
+                catchType = p.classRef()
+            }
+            p.expect("from")
+            start := useLabel()
+            p.expect("to")
+            end := useLabel()
+            p.expect("using")
+            handler := useLabel()
+            a.Catch(start, end, handler, catchType)
+        case ".stack":
+            here := a.NewLabel()
+            a.Mark(here)
+            frames = append(frames, p.parseFrame(here, useLabel))
+        case ".throws":
+            if exceptionsAt < 0 {
+                exceptionsAt = len(method.Attributes)
+                method.Attributes = append(method.Attributes, Attribute{NameIndex: p.cp.AddUtf8("Exceptions")})
+            }
+            exceptions = append(exceptions, p.classRef())
+        case ".signature", ".attribute":
+            method.Attributes = append(method.Attributes, p.attribute(t.text))
+        case ".codeattribute":
+            attr := p.attribute(t.text)
+            codeAttrs = append(codeAttrs, pendingAttribute{attr: attr})
+        case ".linenumbertable":
+            lines := []pendingLine{}
+            p.block("linenumbertable", func() {
+                start := useLabel()
+                lines = append(lines, pendingLine{start, uint16(p.integerIn(0, 0xffff))})
+            })
+            codeAttrs = append(codeAttrs, pendingAttribute{attr: Attribute{NameIndex: p.cp.AddUtf8("LineNumberTable")}, lines: lines})
+        case ".localvariabletable", ".localvariabletypetable":
+            locals := []pendingLocal{}
+            directive := strings.TrimPrefix(t.text, ".")
+            p.block(directive, func() {
+                var local pendingLocal
+                local.index = uint16(p.integerIn(0, 0xffff))
+                p.expect("is")
+                local.name = p.utf8Ref()
+                local.descriptor = p.utf8Ref()
+                p.expect("from")
+                local.start = useLabel()
+                p.expect("to")
+                local.end = useLabel()
+                locals = append(locals, local)
+            })
+            name := map[string]string{
+                "localvariabletable": "LocalVariableTable",
+                "localvariabletypetable": "LocalVariableTypeTable",
+            }[directive]
+            codeAttrs = append(codeAttrs, pendingAttribute{attr: Attribute{NameIndex: p.cp.AddUtf8(name)}, locals: locals})
+        default:
+            if t.quoted {
+                p.fail(t, "Unexpected string")
+            }
+            wide := t.text == "wide"
+            if wide {
+                t = p.next()
+            }
+            op, ok := OpcodeByName(t.text)
+            if !ok {
+                p.fail(t, "Unknown instruction '%s'", t.text)
+            }
+            if wide && !(op >= Iload && op <= Aload || op >= Istore && op <= Astore || op == Ret || op == Iinc) {
+                p.fail(t, "%s cannot be used with wide", t.text)
+            }
+            p.parseInstruction(a, op, wide, useLabel)
+            hasCode = true
+        }
+        p.endOfLine()
+    }
+}
+
+func (p *krakatauParser) parseFrame(at *Label, useLabel func() *Label) pendingFrame {
+    kind := p.last()
+    f := pendingFrame{at: at, kind: p.word()}
+    typeList := func() []frameTypeRef {
+        var types []frameTypeRef
+        for !p.atEnd() {
+            types = append(types, p.frameType(useLabel))
+        }
+        return types
+    }
+    switch f.kind {
+    case "same", "same_extended":
+    case "chop":
+        f.chop = int(p.integerIn(1, 3))
+    case "append":
+        f.types = typeList()
+        if len(f.types) < 1 || len(f.types) > 3 {
+            p.fail(p.last(), "An append frame adds between 1 and 3 locals")
+        }
+    case "same_locals_1_stack_item", "same_locals_1_stack_item_extended", "full":
+        p.endOfLine()
+        for {
+            if !p.nextLine() {
+                p.fail(p.last(), "Missing .end stack")
+            }
+            switch p.word() {
+            case "locals":
+                f.types = typeList()
+            case "stack":
+                f.stack = typeList()
+            case ".end":
+                p.expect("stack")
+                return f
+            default:
+                p.fail(p.tokens[0], "Expected locals, stack or .end stack")
+            }
+            p.endOfLine()
+        }
+    default:
+        p.fail(kind, "Unknown frame type '%s'", f.kind)
+    }
+    return f
+}
+
+func (p *krakatauParser) frameType(useLabel func() *Label) frameTypeRef {
+    t := p.next()
+    tags := map[string]VerificationTag{
+        "Top": VTop, "Integer": VInteger, "Float": VFloat, "Double": VDouble,
+        "Long": VLong, "Null": VNull, "UninitializedThis": VUninitializedThis,
+    }
+    if tag, ok := tags[t.text]; ok {
+        return frameTypeRef{VerificationType: VerificationType{Tag: tag}}
+    }
+    switch t.text {
+    case "Object":
+        return frameTypeRef{VerificationType: VerificationType{Tag: VObject, Class: p.word()}}
+    case "Uninitialized":
+        return frameTypeRef{VerificationType: VerificationType{Tag: VUninitialized}, label: useLabel()}
+    }
+    p.fail(t, "Unknown verification type '%s'", t.text)
+    return frameTypeRef{}
+}
+
+// parseInstruction assembles op with the encoding that was written, so that
+// iload 0, wide forms, ldc_w and goto_w are kept even where a shorter
+// encoding exists. Operands too large for the written form still widen it.
+func (p *krakatauParser) parseInstruction(a *Assembler, op Opcode, wide bool, useLabel func() *Label) {
+    switch {
+    case op >= Iload && op <= Aload || op >= Istore && op <= Astore || op == Ret:
+        index := uint16(p.integerIn(0, 0xffff))
+        switch {
+        case wide:
+            a.emit(byte(Wide), byte(op), byte(index >> 8), byte(index))
+        case index <= 0xff:
+            a.emit(byte(op), byte(index))
+        default:
+            a.Var(op, index)
+        }
+    case op == Iinc:
+        index := uint16(p.integerIn(0, 0xffff))
+        delta := int16(p.integerIn(-32768, 32767))
+        if wide {
+            a.emit(byte(Wide), byte(Iinc), byte(index >> 8), byte(index), byte(delta >> 8), byte(delta))
+        } else {
+            a.Iinc(index, delta)
+        }
+    case op == Bipush:
+        a.emit(byte(op), byte(p.integerIn(-128, 127)))
+    case op == Sipush:
+        v := p.integerIn(-32768, 32767)
+        a.emit(byte(op), byte(v >> 8), byte(v))
+    case op == LdcW:
+        index := p.constant()
+        if t := (*p.cp.Get(index)).Type(); t == TLong || t == TDouble {
+            p.fail(p.tokens[p.pos - 1], "ldc_w cannot load a long or double")
+        }
+        a.Ref(op, index)
+    case op == Ldc || op == Ldc2W:
+        a.Ldc(p.constant())
+    case op >= Getstatic && op <= Putfield:
+        a.Ref(op, p.memberRef("Field"))
+    case op == Invokevirtual || op == Invokespecial || op == Invokestatic:
+        a.Ref(op, p.memberRef("Method"))
+    case op == Invokeinterface:
+        index := p.memberRef("InterfaceMethod")
+        _, _, descriptor := p.cp.GetMemberRef(index)
+        args, _, err := methodSlots(descriptor)
+        if err != nil {
+            p.fail(p.tokens[p.pos - 1], "%s", err)
+        }
+        count := int64(args + 1)
+        if !p.atEnd() {
+            count = p.integerIn(1, 255)
+        }
+        a.InvokeInterface(index, byte(count))
+    case op == Invokedynamic:
+        if index, ok := p.poolRef(p.last()); ok && !p.atEnd() {
+            p.pos++
+            a.InvokeDynamic(index)
+            return
+        }
+        if p.peek() == "InvokeDynamic" {
+            p.next()
+        }
+        bootstrap := p.bootstrap()
+        p.expect(":")
+        nameType := p.cp.AddNameType(p.word(), p.word())
+        a.InvokeDynamic(p.cp.addUnique(ConstInvokeDynamic{BootstrapMethodAttrIndex: bootstrap, NameAndTypeIndex: nameType}))
+    case op == New || op == Anewarray || op == Checkcast || op == Instanceof:
+        if p.peek() == "Class" {
+            p.next()
+        }
+        a.Ref(op, p.classRef())
+    case op == Newarray:
+        t := p.next()
+        atype, ok := arrayTypes[t.text]
+        if !ok {
+            p.fail(t, "Unknown array type '%s'", t.text)
+        }
+        a.Newarray(atype)
+    case op == Multianewarray:
+        if p.peek() == "Class" {
+            p.next()
+        }
+        class := p.classRef()
+        a.Multianewarray(class, byte(p.integerIn(1, 255)))
+    case isBranch(op):
+        a.Branch(op, useLabel())
+    case op == Tableswitch:
+        low := int32(p.integerIn(math.MinInt32, math.MaxInt32))
+        var targets []*Label
+        for {
+            p.endOfLine()
+            if !p.nextLine() {
+                p.fail(p.last(), "Missing default in tableswitch")
+            }
+            if p.peek() == "default" {
+                p.next()
+                p.expect(":")
+                a.TableSwitch(low, useLabel(), targets...)
+                return
+            }
+            targets = append(targets, useLabel())
+        }
+    case op == Lookupswitch:
+        var keys []int32
+        var targets []*Label
+        for {
+            p.endOfLine()
+            if !p.nextLine() {
+                p.fail(p.last(), "Missing default in lookupswitch")
+            }
+            if p.peek() == "default" {
+                p.next()
+                p.expect(":")
+                a.LookupSwitch(useLabel(), keys, targets)
+                return
+            }
+            t := p.tokens[0]
+            key := int32(p.integerIn(math.MinInt32, math.MaxInt32))
+            if len(keys) > 0 && keys[len(keys) - 1] >= key {
+                p.fail(t, "Lookupswitch keys must be in increasing order")
+            }
+            keys = append(keys, key)
+            p.expect(":")
+            targets = append(targets, useLabel())
+        }
+    default:
+        a.Op(op)
+    }
+}
+
+func (p *krakatauParser) finishMethod(method *Method, a *Assembler, hasCode bool, codeAt int, codeName CpIndex,
+    stackLimit int64, localsLimit int64, frames []pendingFrame, codeAttrs []pendingAttribute, labelTokens map[*Label]token) {
+    if !hasCode && len(codeAttrs) > 0 {
+        p.fail(p.tokens[0], "Code attributes given for a method without code")
+    }
+    if !hasCode {
+        p.class.Methods = append(p.class.Methods, *method)
+        return
+    }
+    for l, t := range labelTokens {
+        if !l.marked {
+            p.fail(t, "Label %s is never defined", t.text)
+        }
+    }
+    code, err := a.Assemble()
+    if err != nil {
+        p.fail(p.tokens[0], "%s", err)
+    }
+    if stackLimit < 0 || localsLimit < 0 {
+        descriptor := p.cp.GetUtf8(method.DescriptorIndex)
+        maxStack, maxLocals, err := code.Maxs(p.cp, descriptor, method.Flags.IsStatic())
+        if err != nil {
+            p.fail(p.tokens[0], "Unable to compute limits: %s", err)
+        }
+        if stackLimit < 0 {
+            stackLimit = int64(maxStack)
+        }
+        if localsLimit < 0 {
+            localsLimit = int64(maxLocals)
+        }
+    }
+    code.MaxStack = uint16(stackLimit)
+    code.MaxLocals = uint16(localsLimit)
+
+    for _, pending := range codeAttrs {
+        attr := pending.attr
+        switch {
+        case pending.lines != nil:
+            var table []LineNumber
+            for _, l := range pending.lines {
+                table = append(table, LineNumber{StartPc: uint16(l.start.offset), Line: l.line})
+            }
+            attr.Info = encodeTable(table)
+        case pending.locals != nil:
+            var table []LocalVariable
+            for _, l := range pending.locals {
+                table = append(table, LocalVariable{
+                    StartPc: uint16(l.start.offset),
+                    Length: uint16(l.end.offset - l.start.offset),
+                    NameIndex: l.name,
+                    DescriptorIndex: l.descriptor,
+                    Index: l.index,
+                })
+            }
+            attr.Info = encodeTable(table)
+        }
+        code.Attributes = append(code.Attributes, attr)
+    }
+
+    if len(frames) > 0 {
+        resolve := func(refs []frameTypeRef) []VerificationType {
+            var types []VerificationType
+            for _, ref := range refs {
+                v := ref.VerificationType
+                if ref.label != nil {
+                    v.Offset = uint16(ref.label.offset)
+                }
+                types = append(types, v)
+            }
+            return types
+        }
+        initial, err := InitialLocals(p.class, method)
+        if err != nil {
+            p.fail(p.tokens[0], "%s", err)
+        }
+        locals := initial
+        var stackMap []StackMapFrame
+        for _, f := range frames {
+            frame := StackMapFrame{Offset: f.at.offset}
+            switch f.kind {
+            case "same", "same_extended":
+                frame.Locals = locals
+            case "chop":
+                if f.chop > len(locals) {
+                    p.fail(p.tokens[0], "Frame at offset %d chops more locals than there are", frame.Offset)
+                }
+                frame.Locals = locals[:len(locals) - f.chop]
+            case "append":
+                frame.Locals = append(append([]VerificationType(nil), locals...), resolve(f.types)...)
+            case "full":
+                frame.Locals = resolve(f.types)
+                frame.Stack = resolve(f.stack)
+            default:
+                frame.Locals = locals
+                frame.Stack = resolve(f.stack)
+            }
+            locals = frame.Locals
+            stackMap = append(stackMap, frame)
+        }
+        compressFrames(initial, stackMap)
+        code.SetStackMapTable(p.cp, stackMap)
+    }
+    // the method's other directives follow its code, so Code goes first
+    // unless .code placed it
+    attr := CodeAttribute(p.cp, code)
+    if codeName != 0 {
+        attr.NameIndex = codeName
+    }
+    method.Attributes = append(method.Attributes[:codeAt], append([]Attribute{attr}, method.Attributes[codeAt:]...)...)
+    p.class.Methods = append(p.class.Methods, *method)
+}
@@ -0,0 +1,158 @@
+package jcr
+
+import (
+    "bytes"
+    "io"
+    "strings"
+    "testing"
+)
+
+func disassemble(t *testing.T, class *Class, lossless bool) string {
+    t.Helper()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    if err := (KrakatauWriter{Lossless: lossless}).Write(&w, class); err != nil {
+        t.Fatalf("disassembling: %s", err)
+    }
+    return buf.String()
+}
+
+var krakatauSources = []struct {
+    name string
+    text string
+}{
+    {"code", `.version 52 0
+.class public super p/D
+.super java/lang/Object
+.field private static final N I = 42
+.field protected name Ljava/lang/String;
+
+.method public static max : (II)I
+    iload_0
+    iload_1
+    if_icmple L9
+    iload_0
+    ireturn
+L9:
+    iload_1
+    ireturn
+.end method
+
+.method public static pick : (I)Ljava/lang/String;
+    iload_0
+    lookupswitch
+        1 : L1
+        10 : L2
+        default : L3
+L1:
+    ldc "one"
+    areturn
+L2:
+    ldc "ten"
+    areturn
+L3:
+    aconst_null
+    areturn
+.end method
+
+.method public static parse : (Ljava/lang/String;)I
+    .catch java/lang/NumberFormatException from L0 to L5 using L6
+L0:
+    aload_0
+    invokestatic Method java/lang/Integer parseInt (Ljava/lang/String;)I
+L5:
+    ireturn
+L6:
+    pop
+    iconst_m1
+    ireturn
+.end method
+.end class
+`},
+    {"invokedynamic", `.version 52 0
+.class public super p/L
+.super java/lang/Object
+
+.method public static run : ()Ljava/lang/Runnable;
+    invokedynamic InvokeDynamic invokeStatic Method java/lang/invoke/LambdaMetafactory metafactory (Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodType;Ljava/lang/invoke/MethodHandle;Ljava/lang/invoke/MethodType;)Ljava/lang/invoke/CallSite; MethodType ()V MethodHandle invokeStatic Method p/L lambda ()V MethodType ()V : run ()Ljava/lang/Runnable;
+    areturn
+.end method
+
+.method private static lambda : ()V
+    return
+.end method
+.end class
+`},
+    {"record", `.version 61 0
+.class public final super p/R
+.super java/lang/Record
+.permittedsubclasses p/R
+.field private final x I
+
+.method public x : ()I
+    aload_0
+    getfield Field p/R x I
+    ireturn
+.end method
+
+.record
+    x I .componentattributes
+        .signature "I"
+    .end componentattributes
+.end record
+.end class
+`},
+    {"raw attribute", `.version 52 0
+.class public super p/A
+.super java/lang/Object
+.attribute Custom "\x00\x01\x00\x02"
+
+.method public static s : ()Ljava/lang/String;
+    ldc "s"
+    areturn
+.end method
+.end class
+`},
+}
+
+func TestKrakatauRoundTrip(t *testing.T) {
+    for _, source := range krakatauSources {
+        for _, lossless := range []bool{false, true} {
+            name := source.name
+            if lossless {
+                name += " lossless"
+            }
+            t.Run(name, func(t *testing.T) {
+                class := assemble(t, source.text)
+                text := disassemble(t, class, lossless)
+                again := assemble(t, text)
+                if lossless && !bytes.Equal(classBytes(t, class), classBytes(t, again)) {
+                    t.Fatalf("class file changed in the round trip through\n%s", text)
+                }
+                if textAgain := disassemble(t, again, lossless); textAgain != text {
+                    t.Fatalf("disassembly changed in the round trip:\n%s\nbecame\n%s", text, textAgain)
+                }
+            })
+        }
+    }
+}
+
+func TestKrakatauErrors(t *testing.T) {
+    tests := []struct {
+        name string
+        text string
+        err string
+    }{
+        {"bad limit", ".class p/E\n.super java/lang/Object\n.method static m : ()V\n    .limit heap 1\n    return\n.end method\n.end class\n", "Expected stack or locals"},
+        {"unknown frame", ".class p/E\n.super java/lang/Object\n.method static m : ()V\n    .stack odd\n    return\n.end method\n.end class\n", "Unknown frame type"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var r io.Reader = strings.NewReader(test.text)
+            _, err := ReadKrakatau(&r)
+            if err == nil || !strings.Contains(err.Error(), test.err) {
+                t.Fatalf("got error %v, want one containing %q", err, test.err)
+            }
+        })
+    }
+}
@@ -0,0 +1,346 @@
+package jcr
+
+import (
+    "fmt"
+    "github.com/jasonhightower/jcr/descriptor"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// Maxs computes the max_stack and max_locals values for c by following every
+// path through the code, including switch targets and exception handlers.
+// descriptor is the method descriptor and static whether the method has no
+// receiver, both of which determine the locals taken by the arguments.
+func (c *Code) Maxs(cp *ConstantPool, descriptor string, static bool) (uint16, uint16, error) {
+    instrs, err := DecodeInstructions(c.ByteCode)
+    if err != nil {
+        return 0, 0, err
+    }
+    index := make(map[int]int, len(instrs))
+    for i, instr := range instrs {
+        index[instr.Offset] = i
+    }
+
+    args, _, err := methodSlots(descriptor)
+    if err != nil {
+        return 0, 0, err
+    }
+    maxLocals := args
+    if !static {
+        maxLocals++
+    }
+
+    depths := make([]int, len(instrs))
+    for i := range depths {
+        depths[i] = -1
+    }
+    maxStack := 0
+    var work []int
+    visit := func(offset int, depth int) error {
+        i, ok := index[offset]
+        if !ok {
+            return fmt.Errorf("Jump to offset %d which is not an instruction", offset)
+        }
+        if depths[i] == -1 {
+            depths[i] = depth
+            work = append(work, i)
+        } else if depths[i] != depth {
+            return fmt.Errorf("Inconsistent stack height at offset %d: %d != %d", offset, depths[i], depth)
+        }
+        return nil
+    }
+
+    if len(instrs) > 0 {
+        if err := visit(0, 0); err != nil {
+            return 0, 0, err
+        }
+    }
+    for len(work) > 0 {
+        i := work[len(work) - 1]
+        work = work[:len(work) - 1]
+        instr := instrs[i]
+
+        pop, push, err := stackEffect(cp, instr)
+        if err != nil {
+            return 0, 0, err
+        }
+        depth := depths[i] - pop
+        if depth < 0 {
+            return 0, 0, fmt.Errorf("Stack underflow at offset %d (%s)", instr.Offset, instr.Opcode)
+        }
+        depth += push
+        if depth > maxStack {
+            maxStack = depth
+        }
+        if local, size := localAccess(instr); size > 0 && local + size > maxLocals {
+            maxLocals = local + size
+        }
+
+        for _, handler := range c.ExceptionHandlers {
+            if instr.Offset >= int(handler.StartPc) && instr.Offset < int(handler.EndPc) {
+                if err := visit(int(handler.HandlerPc), 1); err != nil {
+                    return 0, 0, err
+                }
+                if maxStack < 1 {
+                    maxStack = 1
+                }
+            }
+        }
+        for _, target := range instr.Targets() {
+            // the return address pushed by jsr is only on the stack at the
+            // subroutine entry
+            targetDepth := depth
+            if instr.Opcode == Jsr || instr.Opcode == Jsrw {
+                depth--
+            }
+            if err := visit(target, targetDepth); err != nil {
+                return 0, 0, err
+            }
+        }
+        if !instr.IsTerminal() {
+            if i + 1 >= len(instrs) {
+                return 0, 0, fmt.Errorf("Execution falls off the end of the code at offset %d", instr.Offset)
+            }
+            if err := visit(instrs[i + 1].Offset, depth); err != nil {
+                return 0, 0, err
+            }
+        }
+    }
+
+    if maxStack > 0xffff || maxLocals > 0xffff {
+        return 0, 0, fmt.Errorf("Computed limits exceed 65535 (stack %d, locals %d)", maxStack, maxLocals)
+    }
+    return uint16(maxStack), uint16(maxLocals), nil
+}
+
+// ComputeMaxs replaces MaxStack and MaxLocals with the computed values.
+func (c *Code) ComputeMaxs(cp *ConstantPool, descriptor string, static bool) error {
+    maxStack, maxLocals, err := c.Maxs(cp, descriptor, static)
+    if err != nil {
+        return err
+    }
+    c.MaxStack = maxStack
+    c.MaxLocals = maxLocals
+    return nil
+}
+
+// CheckMaxs verifies that every method in the class declares limits at least
+// as large as the computed ones.
+func (class *Class) CheckMaxs() error {
+    cp := class.ConstantPool
+    for i := range class.Methods {
+        method := &class.Methods[i]
+        code := method.Code(cp)
+        if code == nil {
+            continue
+        }
+        name := cp.GetUtf8(method.NameIndex)
+        descriptor := cp.GetUtf8(method.DescriptorIndex)
+        maxStack, maxLocals, err := code.Maxs(cp, descriptor, method.Flags.IsStatic())
+        if err != nil {
+            return fmt.Errorf("%s%s: %s", name, descriptor, err)
+        }
+        if code.MaxStack < maxStack {
+            return fmt.Errorf("%s%s: max_stack is %d but %d is required", name, descriptor, code.MaxStack, maxStack)
+        }
+        if code.MaxLocals < maxLocals {
+            return fmt.Errorf("%s%s: max_locals is %d but %d is required", name, descriptor, code.MaxLocals, maxLocals)
+        }
+    }
+    return nil
+}
+
+// localAccess returns the local variable index touched by instr and the
+// number of slots it occupies, or a size of 0 if no local is used.
+func localAccess(instr Instruction) (int, int) {
+    op := instr.Opcode
+    if op == Wide {
+        op = Opcode(instr.Operands[0])
+    }
+    switch op {
+    case Iload, Fload, Aload, Istore, Fstore, Astore, Iinc, Ret:
+        return instr.Local(), 1
+    case Lload, Dload, Lstore, Dstore:
+        return instr.Local(), 2
+    }
+    if op >= Iload0 && op <= Aload3 {
+        return instr.Local(), kindSize((int(op) - int(Iload0)) / 4)
+    }
+    if op >= Istore0 && op <= Astore3 {
+        return instr.Local(), kindSize((int(op) - int(Istore0)) / 4)
+    }
+    return 0, 0
+}
+
+// kindSize maps the i, l, f, d, a ordering used by the typed opcode families
+// to the number of slots the type takes.
+func kindSize(kind int) int {
+    if kind == 1 || kind == 3 {
+        return 2
+    }
+    return 1
+}
+
+// stackEffect returns the number of stack slots instr pops and pushes.
+func stackEffect(cp *ConstantPool, instr Instruction) (int, int, error) {
+    op := instr.Opcode
+    switch {
+    case op == Nop:
+        return 0, 0, nil
+    case op >= AconstNull && op <= Dconst1:
+        if op == Lconst0 || op == Lconst1 || op == Dconst0 || op == Dconst1 {
+            return 0, 2, nil
+        }
+        return 0, 1, nil
+    case op == Bipush || op == Sipush || op == Ldc || op == LdcW:
+        return 0, 1, nil
+    case op == Ldc2W:
+        return 0, 2, nil
+    case op >= Iload && op <= Aload:
+        return 0, kindSize(int(op - Iload)), nil
+    case op >= Iload0 && op <= Aload3:
+        return 0, kindSize(int(op - Iload0) / 4), nil
+    case op >= Iaload && op <= Saload:
+        if op == Laload || op == Daload {
+            return 2, 2, nil
+        }
+        return 2, 1, nil
+    case op >= Istore && op <= Astore:
+        return kindSize(int(op - Istore)), 0, nil
+    case op >= Istore0 && op <= Astore3:
+        return kindSize(int(op - Istore0) / 4), 0, nil
+    case op >= Iastore && op <= Sastore:
+        if op == Lastore || op == Dastore {
+            return 4, 0, nil
+        }
+        return 3, 0, nil
+    case op == Pop:
+        return 1, 0, nil
+    case op == Pop2:
+        return 2, 0, nil
+    case op == Dup:
+        return 1, 2, nil
+    case op == DupX1:
+        return 2, 3, nil
+    case op == DupX2:
+        return 3, 4, nil
+    case op == Dup2:
+        return 2, 4, nil
+    case op == Dup2X1:
+        return 3, 5, nil
+    case op == Dup2X2:
+        return 4, 6, nil
+    case op == Swap:
+        return 2, 2, nil
+    case op >= Iadd && op <= Drem:
+        size := kindSize(int(op - Iadd) % 4)
+        return 2 * size, size, nil
+    case op >= Ineg && op <= Dneg:
+        size := kindSize(int(op - Ineg))
+        return size, size, nil
+    case op >= Ishl && op <= Lushr:
+        if (op - Ishl) % 2 == 1 {
+            return 3, 2, nil
+        }
+        return 2, 1, nil
+    case op >= Iand && op <= Lxor:
+        if (op - Iand) % 2 == 1 {
+            return 4, 2, nil
+        }
+        return 2, 1, nil
+    case op == Iinc:
+        return 0, 0, nil
+    case op >= I2l && op <= 0x93:
+        // i2l through i2s, listed as (from, to) in opcode order
+        sizes := [][2]int{{1, 2}, {1, 1}, {1, 2}, {2, 1}, {2, 1}, {2, 2}, {1, 1},
+            {1, 2}, {1, 2}, {2, 1}, {2, 2}, {2, 1}, {1, 1}, {1, 1}, {1, 1}}
+        s := sizes[op - I2l]
+        return s[0], s[1], nil
+    case op == 0x94 || op == 0x97 || op == 0x98:
+        // lcmp, dcmpl, dcmpg
+        return 4, 1, nil
+    case op == 0x95 || op == 0x96:
+        // fcmpl, fcmpg
+        return 2, 1, nil
+    case op >= Ifeq && op <= Ifle:
+        return 1, 0, nil
+    case op >= IfIcmpeq && op <= Ifacmpne:
+        return 2, 0, nil
+    case op == Goto || op == Gotow || op == Ret:
+        return 0, 0, nil
+    case op == Jsr || op == Jsrw:
+        return 0, 1, nil
+    case op == Tableswitch || op == Lookupswitch:
+        return 1, 0, nil
+    case op == Ireturn || op == Freturn || op == Areturn:
+        return 1, 0, nil
+    case op == Lreturn || op == Deturn:
+        return 2, 0, nil
+    case op == Return:
+        return 0, 0, nil
+    case op >= Getstatic && op <= Putfield:
+        _, _, descriptor := cp.GetMemberRef(instr.Index())
+        size := fieldSlots(descriptor)
+        switch op {
+        case Getstatic:
+            return 0, size, nil
+        case Putstatic:
+            return size, 0, nil
+        case Getfield:
+            return 1, size, nil
+        }
+        return 1 + size, 0, nil
+    case op >= Invokevirtual && op <= Invokedynamic:
+        _, _, descriptor := cp.GetMemberRef(instr.Index())
+        args, ret, err := methodSlots(descriptor)
+        if err != nil {
+            return 0, 0, err
+        }
+        if op != Invokestatic && op != Invokedynamic {
+            args++
+        }
+        return args, ret, nil
+    case op == New:
+        return 0, 1, nil
+    case op == Newarray || op == Anewarray || op == Arraylength ||
+        op == Checkcast || op == Instanceof:
+        return 1, 1, nil
+    case op == Athrow || op == Monitorenter || op == Monitorexit:
+        return 1, 0, nil
+    case op == Multianewarray:
+        return int(instr.Operands[2]), 1, nil
+    case op == Ifnull || op == Ifnonnull:
+        return 1, 0, nil
+    case op == Wide:
+        inner := Opcode(instr.Operands[0])
+        switch {
+        case inner >= Iload && inner <= Aload:
+            return 0, kindSize(int(inner - Iload)), nil
+        case inner >= Istore && inner <= Astore:
+            return kindSize(int(inner - Istore)), 0, nil
+        case inner == Iinc || inner == Ret:
+            return 0, 0, nil
+        }
+        return 0, 0, fmt.Errorf("Invalid wide instruction at offset %d", instr.Offset)
+    }
+    return 0, 0, fmt.Errorf("Unsupported opcode %s at offset %d", op, instr.Offset)
+}
+
+// fieldSlots returns the number of stack or local slots a value of the given
+// field descriptor takes.
+func fieldSlots(s string) int {
+    t, err := descriptor.ParseReturn(s)
+    if err != nil {
+        return 1
+    }
+    return t.Slots()
+}
+
+// methodSlots returns the slots taken by the arguments and the return value
+// of a method descriptor.
+func methodSlots(s string) (int, int, error) {
+    m, err := descriptor.ParseMethod(s)
+    if err != nil {
+        return 0, 0, err
+    }
+    return m.ArgSlots(), m.Return.Slots(), nil
+}
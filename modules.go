@@ -0,0 +1,442 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+)
+
+const FLAG_MODULE = 0x8000
+
+// Flags of the module itself and of its requires, exports and opens entries.
+// Open and transitive share a bit: open applies to the module, transitive to
+// requires.
+const FLAG_OPEN = 0x0020
+const FLAG_TRANSITIVE = 0x0020
+const FLAG_STATIC_PHASE = 0x0040
+const FLAG_MANDATED = 0x8000
+
+var moduleFlagNames = []flagName{
+    {FLAG_OPEN, "open"}, {FLAG_SYNTHETIC, "synthetic"}, {FLAG_MANDATED, "mandated"},
+}
+
+var exportsFlagNames = []flagName{
+    {FLAG_SYNTHETIC, "synthetic"}, {FLAG_MANDATED, "mandated"},
+}
+
+var requiresFlagNames = []flagName{
+    {FLAG_TRANSITIVE, "transitive"}, {FLAG_STATIC_PHASE, "static"}, {FLAG_SYNTHETIC, "synthetic"},
+    {FLAG_MANDATED, "mandated"},
+}
+
+// ModuleAttribute is the Module attribute as stored in the class file, with
+// its names as constant pool indexes. VersionIndex is 0 when no version was
+// recorded.
+type ModuleAttribute struct {
+    NameIndex CpIndex
+    Flags AccessFlag
+    VersionIndex CpIndex
+    Requires []ModuleRequiresEntry
+    Exports []ModuleExportsEntry
+    Opens []ModuleExportsEntry
+    Uses []CpIndex
+    Provides []ModuleProvidesEntry
+}
+
+type ModuleRequiresEntry struct {
+    Index CpIndex
+    Flags AccessFlag
+    VersionIndex CpIndex
+}
+
+// ModuleExportsEntry is an entry of the exports or opens table. To lists the
+// modules a qualified export or open is restricted to.
+type ModuleExportsEntry struct {
+    Index CpIndex
+    Flags AccessFlag
+    To []CpIndex
+}
+
+type ModuleProvidesEntry struct {
+    Index CpIndex
+    With []CpIndex
+}
+
+func DecodeModule(info []byte) (m ModuleAttribute, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            m = ModuleAttribute{}
+            err = fmt.Errorf("Malformed Module attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    mustRead(&r, &m.NameIndex)
+    mustRead(&r, &m.Flags)
+    mustRead(&r, &m.VersionIndex)
+    var count uint16
+    mustRead(&r, &count)
+    m.Requires = make([]ModuleRequiresEntry, count)
+    mustRead(&r, &m.Requires)
+    m.Exports = mustReadModuleExports(&r)
+    m.Opens = mustReadModuleExports(&r)
+    m.Uses = mustReadIndexes(&r)
+    mustRead(&r, &count)
+    m.Provides = make([]ModuleProvidesEntry, count)
+    for i := range m.Provides {
+        mustRead(&r, &m.Provides[i].Index)
+        m.Provides[i].With = mustReadIndexes(&r)
+    }
+    return m, nil
+}
+
+func mustReadModuleExports(r *io.Reader) []ModuleExportsEntry {
+    var count uint16
+    mustRead(r, &count)
+    entries := make([]ModuleExportsEntry, count)
+    for i := range entries {
+        mustRead(r, &entries[i].Index)
+        mustRead(r, &entries[i].Flags)
+        entries[i].To = mustReadIndexes(r)
+    }
+    return entries
+}
+
+func mustReadIndexes(r *io.Reader) []CpIndex {
+    var count uint16
+    mustRead(r, &count)
+    indexes := make([]CpIndex, count)
+    mustRead(r, &indexes)
+    return indexes
+}
+
+func EncodeModule(m ModuleAttribute) []byte {
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, m.NameIndex)
+    mustWrite(&w, m.Flags)
+    mustWrite(&w, m.VersionIndex)
+    mustWrite(&w, uint16(len(m.Requires)))
+    mustWrite(&w, m.Requires)
+    for _, table := range [][]ModuleExportsEntry{m.Exports, m.Opens} {
+        mustWrite(&w, uint16(len(table)))
+        for _, entry := range table {
+            mustWrite(&w, entry.Index)
+            mustWrite(&w, entry.Flags)
+            mustWrite(&w, uint16(len(entry.To)))
+            mustWrite(&w, entry.To)
+        }
+    }
+    mustWrite(&w, uint16(len(m.Uses)))
+    mustWrite(&w, m.Uses)
+    mustWrite(&w, uint16(len(m.Provides)))
+    for _, entry := range m.Provides {
+        mustWrite(&w, entry.Index)
+        mustWrite(&w, uint16(len(entry.With)))
+        mustWrite(&w, entry.With)
+    }
+    return buf.Bytes()
+}
+
+// check verifies that the indexes of the attribute refer to constants of the
+// kinds they should, so that its names can be looked up.
+func (m ModuleAttribute) check(cp *ConstantPool) error {
+    type ref struct {
+        index CpIndex
+        kind ConstantType
+    }
+    refs := []ref{{m.NameIndex, TModule}}
+    version := func(index CpIndex) {
+        if index != 0 {
+            refs = append(refs, ref{index, TUtf8})
+        }
+    }
+    version(m.VersionIndex)
+    for _, entry := range m.Requires {
+        refs = append(refs, ref{entry.Index, TModule})
+        version(entry.VersionIndex)
+    }
+    for _, entry := range append(append([]ModuleExportsEntry{}, m.Exports...), m.Opens...) {
+        refs = append(refs, ref{entry.Index, TPackage})
+        for _, to := range entry.To {
+            refs = append(refs, ref{to, TModule})
+        }
+    }
+    for _, index := range m.Uses {
+        refs = append(refs, ref{index, TClass})
+    }
+    for _, entry := range m.Provides {
+        refs = append(refs, ref{entry.Index, TClass})
+        for _, with := range entry.With {
+            refs = append(refs, ref{with, TClass})
+        }
+    }
+    for _, r := range refs {
+        if err := checkConstant(cp, r.index, r.kind); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+var constantKindNames = map[ConstantType]string{TUtf8: "Utf8", TClass: "a Class", TModule: "a Module", TPackage: "a Package"}
+
+// checkConstant reports an error unless the constant at index is of the
+// given kind and, for classes, modules and packages, names a Utf8 constant.
+func checkConstant(cp *ConstantPool, index CpIndex, kind ConstantType) error {
+    if index == 0 || int(index) > len(cp.Constants) || *cp.Get(index) == nil || (*cp.Get(index)).Type() != kind {
+        return fmt.Errorf("Constant at index %d is not %s", index, constantKindNames[kind])
+    }
+    switch c := (*cp.Get(index)).(type) {
+    case ConstClass:
+        return checkConstant(cp, c.NameIndex, TUtf8)
+    case ConstModule:
+        return checkConstant(cp, c.NameIndex, TUtf8)
+    case ConstPackage:
+        return checkConstant(cp, c.NameIndex, TUtf8)
+    }
+    return nil
+}
+
+// DecodeModulePackages returns the package indexes listed in a
+// ModulePackages attribute. ModuleMainClass holds a single class index and
+// is read with DecodeIndex.
+func DecodeModulePackages(info []byte) ([]CpIndex, error) {
+    return decodeTable[CpIndex](info)
+}
+
+func EncodeModulePackages(packages []CpIndex) []byte {
+    return encodeTable(packages)
+}
+
+// ModuleDescriptor is a module declaration with its names resolved. Module
+// names are dotted, as in java.base, while packages and classes keep their
+// internal names, as in java/util and java/util/spi/ToolProvider. Versions
+// are "" when none was recorded.
+type ModuleDescriptor struct {
+    Name string
+    Flags AccessFlag
+    Version string
+    Requires []ModuleRequires
+    Exports []ModuleExports
+    Opens []ModuleExports
+    Uses []string
+    Provides []ModuleProvides
+    // Packages and MainClass come from the ModulePackages and
+    // ModuleMainClass attributes
+    Packages []string
+    MainClass string
+}
+
+type ModuleRequires struct {
+    Module string
+    Flags AccessFlag
+    Version string
+}
+
+// ModuleExports is an exports or opens directive, qualified when To names
+// the modules it is restricted to.
+type ModuleExports struct {
+    Package string
+    Flags AccessFlag
+    To []string
+}
+
+type ModuleProvides struct {
+    Service string
+    With []string
+}
+
+// IsModule reports whether the class is a module-info class.
+func (c *Class) IsModule() bool {
+    return c.Flags & FLAG_MODULE != 0
+}
+
+// Module decodes the module declaration of a module-info class, returning
+// nil for other classes.
+func (c *Class) Module() (m *ModuleDescriptor, err error) {
+    defer func() {
+        // indexes to constants of the wrong kind
+        if r := recover(); r != nil {
+            m = nil
+            err = fmt.Errorf("Malformed module declaration: %v", r)
+        }
+    }()
+    cp := c.ConstantPool
+    attr := FindAttribute(cp, c.Attributes, "Module")
+    if attr == nil {
+        return nil, nil
+    }
+    raw, err := DecodeModule(attr.Info)
+    if err != nil {
+        return nil, err
+    }
+    version := func(index CpIndex) string {
+        if index == 0 {
+            return ""
+        }
+        return cp.GetUtf8(index)
+    }
+    m = &ModuleDescriptor{Name: cp.GetModuleName(raw.NameIndex), Flags: raw.Flags, Version: version(raw.VersionIndex)}
+    for _, entry := range raw.Requires {
+        m.Requires = append(m.Requires, ModuleRequires{cp.GetModuleName(entry.Index), entry.Flags, version(entry.VersionIndex)})
+    }
+    exports := func(entries []ModuleExportsEntry) []ModuleExports {
+        var resolved []ModuleExports
+        for _, entry := range entries {
+            export := ModuleExports{Package: cp.GetPackageName(entry.Index), Flags: entry.Flags}
+            for _, to := range entry.To {
+                export.To = append(export.To, cp.GetModuleName(to))
+            }
+            resolved = append(resolved, export)
+        }
+        return resolved
+    }
+    m.Exports, m.Opens = exports(raw.Exports), exports(raw.Opens)
+    for _, index := range raw.Uses {
+        m.Uses = append(m.Uses, cp.GetClassName(index))
+    }
+    for _, entry := range raw.Provides {
+        provides := ModuleProvides{Service: cp.GetClassName(entry.Index)}
+        for _, with := range entry.With {
+            provides.With = append(provides.With, cp.GetClassName(with))
+        }
+        m.Provides = append(m.Provides, provides)
+    }
+
+    if attr := FindAttribute(cp, c.Attributes, "ModulePackages"); attr != nil {
+        packages, err := DecodeModulePackages(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        for _, index := range packages {
+            m.Packages = append(m.Packages, cp.GetPackageName(index))
+        }
+    }
+    if attr := FindAttribute(cp, c.Attributes, "ModuleMainClass"); attr != nil {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        m.MainClass = cp.GetClassName(index)
+    }
+    return m, nil
+}
+
+// Java renders the declaration as the source of module-info.java. The
+// requires and exports the compiler adds, such as requires java.base, are
+// left out, and the version and main class, which source can't declare, are
+// noted in comments.
+func (m *ModuleDescriptor) Java() string {
+    var b strings.Builder
+    if m.Version != "" {
+        b.WriteString("// version " + m.Version + "\n")
+    }
+    if m.MainClass != "" {
+        b.WriteString("// main class " + moduleJavaName(m.MainClass) + "\n")
+    }
+    if m.Flags & FLAG_OPEN != 0 {
+        b.WriteString("open ")
+    }
+    b.WriteString("module " + m.Name + " {\n")
+    implicit := func(flags AccessFlag) bool {
+        return flags & (FLAG_SYNTHETIC | FLAG_MANDATED) != 0
+    }
+    for _, requires := range m.Requires {
+        if implicit(requires.Flags) {
+            continue
+        }
+        b.WriteString("    requires ")
+        if requires.Flags & FLAG_TRANSITIVE != 0 {
+            b.WriteString("transitive ")
+        }
+        if requires.Flags & FLAG_STATIC_PHASE != 0 {
+            b.WriteString("static ")
+        }
+        b.WriteString(requires.Module + ";\n")
+    }
+    for _, table := range []struct{ directive string; entries []ModuleExports }{{"exports", m.Exports}, {"opens", m.Opens}} {
+        for _, entry := range table.entries {
+            if implicit(entry.Flags) {
+                continue
+            }
+            b.WriteString("    " + table.directive + " " + strings.ReplaceAll(entry.Package, "/", "."))
+            if len(entry.To) > 0 {
+                b.WriteString(" to " + strings.Join(entry.To, ", "))
+            }
+            b.WriteString(";\n")
+        }
+    }
+    for _, service := range m.Uses {
+        b.WriteString("    uses " + moduleJavaName(service) + ";\n")
+    }
+    for _, provides := range m.Provides {
+        var with []string
+        for _, name := range provides.With {
+            with = append(with, moduleJavaName(name))
+        }
+        b.WriteString("    provides " + moduleJavaName(provides.Service) + " with " + strings.Join(with, ", ") + ";\n")
+    }
+    b.WriteString("}\n")
+    return b.String()
+}
+
+// moduleJavaName turns the internal name of a class into the name source
+// uses for it, assuming $ only separates nested classes.
+func moduleJavaName(internal string) string {
+    return strings.NewReplacer("/", ".", "$", ".").Replace(internal)
+}
+
+// Class builds a module-info class declaring the module, with Module,
+// ModulePackages and ModuleMainClass attributes. The class file version is
+// that of Java 9, the first to support modules.
+func (m *ModuleDescriptor) Class() *Class {
+    cp := &ConstantPool{}
+    c := &Class{Major: 53, ConstantPool: cp, Flags: FLAG_MODULE}
+    c.ThisIndex = cp.AddClass("module-info")
+    version := func(version string) CpIndex {
+        if version == "" {
+            return 0
+        }
+        return cp.AddUtf8(version)
+    }
+    raw := ModuleAttribute{NameIndex: cp.AddModule(m.Name), Flags: m.Flags, VersionIndex: version(m.Version)}
+    for _, requires := range m.Requires {
+        raw.Requires = append(raw.Requires, ModuleRequiresEntry{cp.AddModule(requires.Module), requires.Flags, version(requires.Version)})
+    }
+    exports := func(entries []ModuleExports) []ModuleExportsEntry {
+        var raw []ModuleExportsEntry
+        for _, entry := range entries {
+            export := ModuleExportsEntry{Index: cp.AddPackage(entry.Package), Flags: entry.Flags}
+            for _, to := range entry.To {
+                export.To = append(export.To, cp.AddModule(to))
+            }
+            raw = append(raw, export)
+        }
+        return raw
+    }
+    raw.Exports, raw.Opens = exports(m.Exports), exports(m.Opens)
+    for _, service := range m.Uses {
+        raw.Uses = append(raw.Uses, cp.AddClass(service))
+    }
+    for _, provides := range m.Provides {
+        entry := ModuleProvidesEntry{Index: cp.AddClass(provides.Service)}
+        for _, with := range provides.With {
+            entry.With = append(entry.With, cp.AddClass(with))
+        }
+        raw.Provides = append(raw.Provides, entry)
+    }
+    c.Attributes = append(c.Attributes, Attribute{NameIndex: cp.AddUtf8("Module"), Info: EncodeModule(raw)})
+
+    if m.Packages != nil {
+        var packages []CpIndex
+        for _, pkg := range m.Packages {
+            packages = append(packages, cp.AddPackage(pkg))
+        }
+        c.Attributes = append(c.Attributes, Attribute{NameIndex: cp.AddUtf8("ModulePackages"), Info: EncodeModulePackages(packages)})
+    }
+    if m.MainClass != "" {
+        c.Attributes = append(c.Attributes, Attribute{NameIndex: cp.AddUtf8("ModuleMainClass"), Info: EncodeIndex(cp.AddClass(m.MainClass))})
+    }
+    return c
+}
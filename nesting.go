@@ -0,0 +1,314 @@
+package jcr
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// DecodeNestMembers returns the class indexes listed in a NestMembers
+// attribute. NestHost holds a single index and is read with DecodeIndex.
+func DecodeNestMembers(info []byte) ([]CpIndex, error) {
+    return decodeTable[CpIndex](info)
+}
+
+func EncodeNestMembers(members []CpIndex) []byte {
+    return encodeTable(members)
+}
+
+// InnerClasses decodes the class's InnerClasses attribute, returning nil if
+// it has none.
+func (c *Class) InnerClasses() ([]InnerClass, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "InnerClasses")
+    if attr == nil {
+        return nil, nil
+    }
+    return DecodeInnerClasses(attr.Info)
+}
+
+// EnclosingMethod decodes the EnclosingMethod attribute of a local or
+// anonymous class, returning nil for other classes.
+func (c *Class) EnclosingMethod() (*EnclosingMethod, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "EnclosingMethod")
+    if attr == nil {
+        return nil, nil
+    }
+    m, err := DecodeEnclosingMethod(attr.Info)
+    if err != nil {
+        return nil, err
+    }
+    return &m, nil
+}
+
+// NestHost returns the internal name of the host of the nest the class is
+// a member of, or "" if it has no NestHost attribute.
+func (c *Class) NestHost() (string, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "NestHost")
+    if attr == nil {
+        return "", nil
+    }
+    index, err := DecodeIndex(attr.Info)
+    if err != nil {
+        return "", err
+    }
+    return c.ConstantPool.GetClassName(index), nil
+}
+
+// NestMembers returns the internal names of the members of the nest the
+// class hosts.
+func (c *Class) NestMembers() ([]string, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "NestMembers")
+    if attr == nil {
+        return nil, nil
+    }
+    members, err := DecodeNestMembers(attr.Info)
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, member := range members {
+        names = append(names, c.ConstantPool.GetClassName(member))
+    }
+    return names, nil
+}
+
+// SimpleName returns the name of the class in Java source: the inner name
+// its InnerClasses entry records for a nested class, "" for an anonymous
+// class, and the name without the package for a top level class.
+func (c *Class) SimpleName() string {
+    if inner, ok := innerClassEntry(c, c.Name()); ok {
+        if inner.InnerNameIndex == 0 {
+            return ""
+        }
+        return c.ConstantPool.GetUtf8(inner.InnerNameIndex)
+    }
+    return c.Name()[strings.LastIndex(c.Name(), "/") + 1:]
+}
+
+type NestingKind int
+
+const (
+    NestingTopLevel NestingKind = iota
+    NestingMember
+    NestingLocal
+    NestingAnonymous
+)
+
+func (k NestingKind) String() string {
+    return [...]string{"top level", "member", "local", "anonymous"}[k]
+}
+
+// NestedClass is a class in the nesting tree of a jar. Class is nil for a
+// class only known from the InnerClasses entries of others. Flags are the
+// flags of the InnerClasses entry of a nested class, which keep the
+// modifiers, such as private and static, that the class file drops. Local
+// and anonymous classes declared in a method name it in Method, as
+// name(descriptor).
+type NestedClass struct {
+    Name string
+    Class *Class
+    Kind NestingKind
+    SimpleName string
+    Flags AccessFlag
+    Outer *NestedClass
+    Method string
+    Nested []*NestedClass
+}
+
+// JavaName returns the name source code uses for a class: the qualified
+// name with nested classes joined by dots, such as java.util.Map.Entry, for
+// top level and member classes, the simple name of a local class, and ""
+// for an anonymous class.
+func (n *NestedClass) JavaName() string {
+    switch n.Kind {
+    case NestingTopLevel:
+        return strings.ReplaceAll(n.Name, "/", ".")
+    case NestingMember:
+        if outer := n.Outer.JavaName(); outer != "" {
+            return outer + "." + n.SimpleName
+        }
+    }
+    return n.SimpleName
+}
+
+// ResolveNesting builds the nesting tree of a set of classes, such as those
+// of a jar, from their InnerClasses and EnclosingMethod attributes, and
+// returns its top level classes sorted by name. A class's own entry in
+// InnerClasses takes precedence over what other classes say about it.
+func ResolveNesting(classes []*Class) ([]*NestedClass, error) {
+    nodes := make(map[string]*NestedClass)
+    node := func(name string) *NestedClass {
+        n, ok := nodes[name]
+        if !ok {
+            n = &NestedClass{Name: name, SimpleName: name[strings.LastIndex(name, "/") + 1:]}
+            nodes[name] = n
+        }
+        return n
+    }
+    outers := make(map[string]string)
+    own := make(map[string]bool)
+    for _, c := range classes {
+        n := node(c.Name())
+        n.Class, n.Flags = c, c.Flags
+    }
+    for _, c := range classes {
+        cp := c.ConstantPool
+        inners, err := c.InnerClasses()
+        if err != nil {
+            return nil, fmt.Errorf("%s: %s", c.Name(), err)
+        }
+        for _, inner := range inners {
+            name := cp.GetClassName(inner.InnerClassIndex)
+            if own[name] {
+                continue
+            }
+            if name == c.Name() {
+                own[name] = true
+            }
+            n := node(name)
+            n.Flags = inner.Flags
+            delete(outers, name)
+            switch {
+            case inner.InnerNameIndex == 0:
+                n.Kind, n.SimpleName = NestingAnonymous, ""
+            case inner.OuterClassIndex == 0:
+                n.Kind, n.SimpleName = NestingLocal, cp.GetUtf8(inner.InnerNameIndex)
+            default:
+                n.Kind, n.SimpleName = NestingMember, cp.GetUtf8(inner.InnerNameIndex)
+                outers[name] = cp.GetClassName(inner.OuterClassIndex)
+            }
+        }
+    }
+    for _, c := range classes {
+        cp := c.ConstantPool
+        enclosing, err := c.EnclosingMethod()
+        if err != nil {
+            return nil, fmt.Errorf("%s: %s", c.Name(), err)
+        }
+        if enclosing != nil {
+            outers[c.Name()] = cp.GetClassName(enclosing.ClassIndex)
+            if enclosing.MethodIndex != 0 {
+                nameType, ok := (*cp.Get(enclosing.MethodIndex)).(ConstNameType)
+                if !ok {
+                    return nil, fmt.Errorf("%s: EnclosingMethod method %d is not a NameAndType", c.Name(), enclosing.MethodIndex)
+                }
+                nodes[c.Name()].Method = cp.GetUtf8(nameType.NameIndex) + cp.GetUtf8(nameType.DescriptorIndex)
+            }
+        }
+    }
+
+    var names []string
+    for name := range nodes {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    // only classes of the set and the classes enclosing them are linked
+    var top []*NestedClass
+    linked := make(map[string]bool)
+    var link func(n *NestedClass)
+    link = func(n *NestedClass) {
+        if linked[n.Name] {
+            return
+        }
+        linked[n.Name] = true
+        outer, ok := outers[n.Name]
+        if !ok || n.Kind == NestingTopLevel {
+            top = append(top, n)
+            return
+        }
+        n.Outer = node(outer)
+        n.Outer.Nested = append(n.Outer.Nested, n)
+        link(n.Outer)
+    }
+    for _, name := range names {
+        if nodes[name].Class != nil {
+            link(nodes[name])
+        }
+    }
+    sort.Slice(top, func(i, j int) bool { return top[i].Name < top[j].Name })
+    return top, nil
+}
+
+// CheckNests verifies that the nest declarations of classes agree on both
+// sides: every class naming a NestHost is listed in that host's NestMembers
+// and every listed member names the host back. Hosts and members missing
+// from classes are reported too, as are nests spanning packages.
+func CheckNests(classes []*Class) []error {
+    byName := make(map[string]*Class)
+    for _, c := range classes {
+        byName[c.Name()] = c
+    }
+    var problems []error
+    // a pair declared from both sides is only checked for packages once
+    paired := make(map[[2]string]bool)
+    checkPackages := func(host string, member string) {
+        if paired[[2]string{host, member}] {
+            return
+        }
+        paired[[2]string{host, member}] = true
+        if packageName(host) != packageName(member) {
+            problems = append(problems, fmt.Errorf("%s and its nest host %s are in different packages", member, host))
+        }
+    }
+    for _, c := range classes {
+        host, err := c.NestHost()
+        if err != nil {
+            problems = append(problems, fmt.Errorf("%s: %s", c.Name(), err))
+            continue
+        }
+        members, err := c.NestMembers()
+        if err != nil {
+            problems = append(problems, fmt.Errorf("%s: %s", c.Name(), err))
+            continue
+        }
+        if host != "" && members != nil {
+            problems = append(problems, fmt.Errorf("%s has both NestHost and NestMembers", c.Name()))
+        }
+        if host != "" {
+            checkPackages(host, c.Name())
+            if err := checkNestPair(byName, host, c.Name(), false); err != nil {
+                problems = append(problems, err)
+            }
+        }
+        for _, member := range members {
+            checkPackages(c.Name(), member)
+            if err := checkNestPair(byName, c.Name(), member, true); err != nil {
+                problems = append(problems, err)
+            }
+        }
+    }
+    return problems
+}
+
+// checkNestPair checks the side of a host and member pair that the other
+// side's declaration was not read from.
+func checkNestPair(byName map[string]*Class, host string, member string, fromHost bool) error {
+    if fromHost {
+        c, ok := byName[member]
+        if !ok {
+            return fmt.Errorf("nest member %s of %s is missing", member, host)
+        }
+        if declared, _ := c.NestHost(); declared != host {
+            return fmt.Errorf("%s lists %s in NestMembers but its NestHost is %q", host, member, declared)
+        }
+        return nil
+    }
+    c, ok := byName[host]
+    if !ok {
+        return fmt.Errorf("nest host %s of %s is missing", host, member)
+    }
+    members, _ := c.NestMembers()
+    for _, m := range members {
+        if m == member {
+            return nil
+        }
+    }
+    return fmt.Errorf("%s names %s as NestHost but is not in its NestMembers", member, host)
+}
+
+func packageName(name string) string {
+    if i := strings.LastIndex(name, "/"); i >= 0 {
+        return name[:i]
+    }
+    return ""
+}
@@ -15,33 +15,56 @@ func ReadClass(r *io.Reader) (*Class, error) {
     var class Class
     mustRead(r, &class.Minor)
     mustRead(r, &class.Major)
+    trace(r, "version %d.%d", class.Major, class.Minor)
 
     class.ConstantPool = &ConstantPool{}
 
     mustReadConstantPool(r, class.ConstantPool)
 
     mustRead(r, &class.Flags)
+    if tracer(r) != nil {
+        trace(r, "access flags 0x%04x%s", uint16(class.Flags), flags(class.Flags, classFlagNames))
+    }
     mustRead(r, &class.ThisIndex)
+    if tracer(r) != nil {
+        trace(r, "this class %s", traceClass(r, class.ThisIndex))
+    }
     mustRead(r, &class.SuperIndex)
+    if tracer(r) != nil {
+        trace(r, "super class %s", traceClass(r, class.SuperIndex))
+    }
 
     var count uint16
     mustRead(r, &count)
+    trace(r, "interfaces count %d", count)
     class.Interfaces = make([]CpIndex, count)
-    mustRead(r, &class.Interfaces)
+    for i := range class.Interfaces {
+        mustRead(r, &class.Interfaces[i])
+        if tracer(r) != nil {
+            trace(r, "interface %s", traceClass(r, class.Interfaces[i]))
+        }
+    }
     
     mustRead(r, &count)
+    trace(r, "fields count %d", count)
     class.Fields = make([]Field, count)
     for i := 0; i < int(count); i++ {
+        traceEnter(r, "field %d", i)
         mustReadField(r, &class.Fields[i])
+        traceLeave(r)
     }
 
     mustRead(r, &count)
+    trace(r, "methods count %d", count)
     class.Methods = make([]Method, count)
     for i := 0; i < int(count); i++ {
+        traceEnter(r, "method %d", i)
         mustReadMethod(r, &class.Methods[i])
+        traceLeave(r)
     }
 
     mustRead(r, &count)
+    trace(r, "attributes count %d", count)
     class.Attributes = make([]Attribute, count)
     for i := 0; i < int(count); i++ {
         mustReadAttribute(r, &class.Attributes[i])
@@ -52,30 +75,57 @@ func ReadClass(r *io.Reader) (*Class, error) {
 
 func ReadCode(r *io.Reader, c *Code) {
     mustRead(r, &c.MaxStack)
+    trace(r, "max stack %d", c.MaxStack)
     mustRead(r, &c.MaxLocals)
+    trace(r, "max locals %d", c.MaxLocals)
 
     var length uint32
     mustRead(r, &length)
+    trace(r, "code length %d", length)
 
     c.ByteCode = make([]byte, length)
     mustRead(r, &c.ByteCode)
+    traceCode(r, c.ByteCode)
 
     var exLength uint16
     mustRead(r, &exLength)
+    trace(r, "exception table length %d", exLength)
 
     c.ExceptionHandlers = make([]ExceptionHandler, exLength)
     for i := 0; i < int(exLength); i++ {
-        mustRead(r, &c.ExceptionHandlers[i])
+        handler := &c.ExceptionHandlers[i]
+        mustRead(r, handler)
+        if tracer(r) != nil {
+            trace(r, "exception handler from %d to %d using %d catching %s", handler.StartPc, handler.EndPc, handler.HandlerPc, traceClass(r, handler.CatchType))
+        }
+    }
+
+    var count uint16
+    mustRead(r, &count)
+    trace(r, "attributes count %d", count)
+    c.Attributes = make([]Attribute, count)
+    for i := 0; i < int(count); i++ {
+        mustReadAttribute(r, &c.Attributes[i])
     }
 }
 
 func mustReadMethod(r *io.Reader, m *Method) {
     mustRead(r, &m.Flags)
+    if tracer(r) != nil {
+        trace(r, "access flags 0x%04x%s", uint16(m.Flags), flags(m.Flags, methodFlagNames))
+    }
     mustRead(r, &m.NameIndex)
+    if tracer(r) != nil {
+        trace(r, "name %s", traceUtf8(r, m.NameIndex))
+    }
     mustRead(r, &m.DescriptorIndex)
+    if tracer(r) != nil {
+        trace(r, "descriptor %s", traceUtf8(r, m.DescriptorIndex))
+    }
 
     var count uint16
     mustRead(r, &count)
+    trace(r, "attributes count %d", count)
     m.Attributes = make([]Attribute, count)
 
     for i := 0; i < int(count); i++ {
@@ -85,11 +135,21 @@ func mustReadMethod(r *io.Reader, m *Method) {
 
 func mustReadField(r *io.Reader, f *Field) {
    mustRead(r, &f.Flags) 
+   if tracer(r) != nil {
+      trace(r, "access flags 0x%04x%s", uint16(f.Flags), flags(f.Flags, fieldFlagNames))
+   }
    mustRead(r, &f.NameIndex)
+   if tracer(r) != nil {
+      trace(r, "name %s", traceUtf8(r, f.NameIndex))
+   }
    mustRead(r, &f.DescriptorIndex)
+   if tracer(r) != nil {
+      trace(r, "descriptor %s", traceUtf8(r, f.DescriptorIndex))
+   }
 
    var count uint16
    mustRead(r, &count)
+   trace(r, "attributes count %d", count)
    f.Attributes = make([]Attribute, count)
    for i := 0; i < int(count); i++ {
        mustReadAttribute(r, &f.Attributes[i])
@@ -101,19 +161,32 @@ func mustReadAttribute(r *io.Reader, a *Attribute) {
 
     var count uint32
     mustRead(r, &count)
+    if tracer(r) != nil {
+        trace(r, "attribute %s, length %d", traceUtf8(r, a.NameIndex), count)
+    }
 
     a.Info = make([]byte, count)
     mustRead(r, &a.Info)
+    traceAttribute(r, a)
 }
 
 func mustReadConstantPool(r *io.Reader, cp *ConstantPool) {
     var count uint16
     mustRead(r, &count)
+    trace(r, "constant pool count %d", count)
 
     constantCount := int(count) - 1
     cp.Constants = make([]Constant, constantCount)
     for i := 0; i < constantCount; i++ {
         cp.Constants[i] = mustReadConstant(r)
+        traceConstant(r, i + 1, cp.Constants[i])
+        if t := cp.Constants[i].Type(); t == TLong || t == TDouble {
+            // the entry following a long or double is unusable
+            i++
+        }
+    }
+    if t := tracer(r); t != nil {
+        t.cp = cp
     }
 }
 
@@ -148,13 +221,58 @@ func mustReadConstant(r *io.Reader) Constant {
         var stringRef ConstString
         mustRead(r, &stringRef)
         return stringRef
+    case TInterfaceMethodref:
+        method := ConstInterfaceMethod{}
+        mustRead(r, &method)
+        return method
+    case TInteger:
+        integer := ConstInteger{}
+        mustRead(r, &integer)
+        return integer
+    case TFloat:
+        float := ConstFloat{}
+        mustRead(r, &float)
+        return float
+    case TLong:
+        long := ConstLong{}
+        mustRead(r, &long)
+        return long
+    case TDouble:
+        double := ConstDouble{}
+        mustRead(r, &double)
+        return double
+    case TMethodHandle:
+        handle := ConstMethodHandle{}
+        mustRead(r, &handle)
+        return handle
+    case TMethodType:
+        methodType := ConstMethodType{}
+        mustRead(r, &methodType)
+        return methodType
+    case TDynamic:
+        dynamic := ConstDynamic{}
+        mustRead(r, &dynamic)
+        return dynamic
+    case TInvokeDynamic:
+        indy := ConstInvokeDynamic{}
+        mustRead(r, &indy)
+        return indy
+    case TModule:
+        module := ConstModule{}
+        mustRead(r, &module)
+        return module
+    case TPackage:
+        pkg := ConstPackage{}
+        mustRead(r, &pkg)
+        return pkg
     }
-    return nil
+    panic(fmt.Sprintf("Unsupported constant type %s", constType))
 }
 
 func readJavaMagic(r *io.Reader) error {
     var magic uint32
     mustRead(r, &magic)
+    trace(r, "magic 0x%08x", magic)
 
     if magic != 0xCAFEBABE {
         return fmt.Errorf("Not a java class file")
@@ -0,0 +1,207 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+)
+
+// RecordComponent is an entry of the Record attribute. Its attributes hold
+// the component's Signature and annotations.
+type RecordComponent struct {
+    NameIndex CpIndex
+    DescriptorIndex CpIndex
+    Attributes []Attribute
+}
+
+func DecodeRecord(info []byte) (components []RecordComponent, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            components = nil
+            err = fmt.Errorf("Malformed Record attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var count uint16
+    mustRead(&r, &count)
+    components = make([]RecordComponent, count)
+    for i := range components {
+        component := &components[i]
+        mustRead(&r, &component.NameIndex)
+        mustRead(&r, &component.DescriptorIndex)
+        var attributes uint16
+        mustRead(&r, &attributes)
+        component.Attributes = make([]Attribute, attributes)
+        for j := range component.Attributes {
+            mustReadAttribute(&r, &component.Attributes[j])
+        }
+    }
+    return components, nil
+}
+
+func EncodeRecord(components []RecordComponent) []byte {
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, uint16(len(components)))
+    for _, component := range components {
+        mustWrite(&w, component.NameIndex)
+        mustWrite(&w, component.DescriptorIndex)
+        mustWrite(&w, uint16(len(component.Attributes)))
+        for i := range component.Attributes {
+            mustWriteAttribute(&w, &component.Attributes[i])
+        }
+    }
+    return buf.Bytes()
+}
+
+func (rc *RecordComponent) Name(cp *ConstantPool) string {
+    return cp.GetUtf8(rc.NameIndex)
+}
+
+func (rc *RecordComponent) Descriptor(cp *ConstantPool) string {
+    return cp.GetUtf8(rc.DescriptorIndex)
+}
+
+// Signature returns the generic type of the component from its Signature
+// attribute, or "" if it has none.
+func (rc *RecordComponent) Signature(cp *ConstantPool) string {
+    attr := FindAttribute(cp, rc.Attributes, "Signature")
+    if attr == nil {
+        return ""
+    }
+    index, err := DecodeIndex(attr.Info)
+    if err != nil {
+        return ""
+    }
+    return cp.GetUtf8(index)
+}
+
+func (rc *RecordComponent) Annotations(cp *ConstantPool) ([]Annotation, error) {
+    return FindAnnotations(cp, rc.Attributes)
+}
+
+// DecodePermittedSubclasses returns the class indexes listed in a
+// PermittedSubclasses attribute.
+func DecodePermittedSubclasses(info []byte) ([]CpIndex, error) {
+    return decodeTable[CpIndex](info)
+}
+
+func EncodePermittedSubclasses(classes []CpIndex) []byte {
+    return encodeTable(classes)
+}
+
+// IsRecord reports whether the class has a Record attribute.
+func (c *Class) IsRecord() bool {
+    return FindAttribute(c.ConstantPool, c.Attributes, "Record") != nil
+}
+
+// RecordComponents decodes the class's Record attribute, returning nil if it
+// has none.
+func (c *Class) RecordComponents() ([]RecordComponent, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "Record")
+    if attr == nil {
+        return nil, nil
+    }
+    return DecodeRecord(attr.Info)
+}
+
+// IsSealed reports whether the class has a PermittedSubclasses attribute.
+func (c *Class) IsSealed() bool {
+    return FindAttribute(c.ConstantPool, c.Attributes, "PermittedSubclasses") != nil
+}
+
+// PermittedSubclasses returns the internal names of the classes allowed to
+// extend or implement a sealed class.
+func (c *Class) PermittedSubclasses() ([]string, error) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "PermittedSubclasses")
+    if attr == nil {
+        return nil, nil
+    }
+    classes, err := DecodePermittedSubclasses(attr.Info)
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, class := range classes {
+        names = append(names, c.ConstantPool.GetClassName(class))
+    }
+    return names, nil
+}
+
+// CheckSealed verifies the sealed classes among classes: every permitted
+// subclass must exist and directly extend or implement its sealed class,
+// and every class of the set that does so must be permitted. Classes
+// outside the set, such as those of other jars, are looked up on path,
+// which may be nil.
+func CheckSealed(classes []*Class, path *ClassPath) []error {
+    byName := make(map[string]*Class)
+    for _, c := range classes {
+        byName[c.Name()] = c
+    }
+    find := func(name string) *Class {
+        if c, ok := byName[name]; ok {
+            return c
+        }
+        if path != nil {
+            if c, err := path.Find(name); err == nil {
+                return c
+            }
+        }
+        return nil
+    }
+
+    var problems []error
+    for _, c := range classes {
+        permitted, err := c.PermittedSubclasses()
+        if err != nil {
+            problems = append(problems, fmt.Errorf("%s: %s", c.Name(), err))
+            continue
+        }
+        for _, name := range permitted {
+            sub := find(name)
+            if sub == nil {
+                problems = append(problems, fmt.Errorf("permitted subclass %s of %s is missing", name, c.Name()))
+            } else if !directSubtype(sub, c.Name()) {
+                problems = append(problems, fmt.Errorf("permitted subclass %s does not extend %s", name, c.Name()))
+            }
+        }
+        for _, super := range directSupertypes(c) {
+            sealed := find(super)
+            if sealed == nil || !sealed.IsSealed() {
+                continue
+            }
+            allowed, err := sealed.PermittedSubclasses()
+            if err != nil {
+                continue
+            }
+            if !containsString(allowed, c.Name()) {
+                problems = append(problems, fmt.Errorf("%s extends sealed %s without being permitted", c.Name(), super))
+            }
+        }
+    }
+    return problems
+}
+
+func directSupertypes(c *Class) []string {
+    var supers []string
+    if c.SuperName() != "" {
+        supers = append(supers, c.SuperName())
+    }
+    for _, iface := range c.Interfaces {
+        supers = append(supers, c.ConstantPool.GetClassName(iface))
+    }
+    return supers
+}
+
+func directSubtype(c *Class, super string) bool {
+    return containsString(directSupertypes(c), super)
+}
+
+func containsString(list []string, s string) bool {
+    for _, item := range list {
+        if item == s {
+            return true
+        }
+    }
+    return false
+}
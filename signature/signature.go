@@ -0,0 +1,557 @@
+// Package signature parses the generic signatures of the Signature attribute
+// (JVMS 4.7.9.1), such as <T:Ljava/lang/Object;>(Ljava/util/List<TT;>;)TT;,
+// and renders them in Java syntax.
+package signature
+
+import (
+    "fmt"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+)
+
+// Type is a type in a signature: a BaseType, *ClassType, *TypeVariable or
+// *ArrayType. String returns the signature of the type and JavaName renders it
+// in Java syntax, naming classes with name.
+type Type interface {
+    String() string
+    Java() string
+    JavaName(name func(internal string) string) string
+}
+
+// BaseType is a primitive type or void, held as its descriptor character.
+type BaseType byte
+
+func (t BaseType) String() string {
+    return string(t)
+}
+
+func (t BaseType) Java() string {
+    return t.JavaName(descriptor.QualifiedName)
+}
+
+func (t BaseType) JavaName(name func(internal string) string) string {
+    return descriptor.Type{Base: byte(t)}.Java()
+}
+
+// TypeVariable refers to a type parameter by name.
+type TypeVariable struct {
+    Name string
+}
+
+func (t *TypeVariable) String() string {
+    return "T" + t.Name + ";"
+}
+
+func (t *TypeVariable) Java() string {
+    return t.Name
+}
+
+func (t *TypeVariable) JavaName(name func(internal string) string) string {
+    return t.Name
+}
+
+type ArrayType struct {
+    Elem Type
+}
+
+func (t *ArrayType) String() string {
+    return "[" + t.Elem.String()
+}
+
+func (t *ArrayType) Java() string {
+    return t.JavaName(descriptor.QualifiedName)
+}
+
+func (t *ArrayType) JavaName(name func(internal string) string) string {
+    return t.Elem.JavaName(name) + "[]"
+}
+
+// ClassType is a possibly parameterized class. Path holds the class followed
+// by the inner classes it is qualified with, as in Outer<T>.Inner<U>; the
+// first name includes the package, e.g. java/util/Map.
+type ClassType struct {
+    Path []SimpleClassType
+}
+
+type SimpleClassType struct {
+    Name string
+    Args []TypeArgument
+}
+
+// TypeArgument is an argument of a parameterized type. Wildcard is 0 for
+// the type itself, '+' for ? extends Type, '-' for ? super Type and '*' for
+// an unbounded ?, which has no Type.
+type TypeArgument struct {
+    Wildcard byte
+    Type Type
+}
+
+// InternalName returns the internal name of the class, with inner classes
+// joined by $.
+func (t *ClassType) InternalName() string {
+    var names []string
+    for _, s := range t.Path {
+        names = append(names, s.Name)
+    }
+    return strings.Join(names, "$")
+}
+
+func (t *ClassType) String() string {
+    var b strings.Builder
+    b.WriteByte('L')
+    for i, s := range t.Path {
+        if i > 0 {
+            b.WriteByte('.')
+        }
+        b.WriteString(s.Name)
+        if len(s.Args) > 0 {
+            b.WriteByte('<')
+            for _, arg := range s.Args {
+                b.WriteString(arg.String())
+            }
+            b.WriteByte('>')
+        }
+    }
+    b.WriteByte(';')
+    return b.String()
+}
+
+func (t *ClassType) Java() string {
+    return t.JavaName(descriptor.QualifiedName)
+}
+
+// JavaName renders the class type. Classes up to the first one with type
+// arguments are named as one, Outer$Inner, and the inner classes of a
+// parameterized class follow it as in Java source: Outer<T>.Inner.
+func (t *ClassType) JavaName(name func(internal string) string) string {
+    text := ""
+    for i, s := range t.Path {
+        if text == "" {
+            if len(s.Args) == 0 && i < len(t.Path) - 1 {
+                continue
+            }
+            outer := &ClassType{Path: t.Path[:i + 1]}
+            text = name(outer.InternalName())
+        } else {
+            text += "." + s.Name
+        }
+        text += typeArgumentsJava(s.Args, name)
+    }
+    return text
+}
+
+// ClassOf returns the class type of an internal name, with the classes of a
+// nested name such as java/util/Map$Entry as separate parts of the path.
+// Array class names give an *ArrayType.
+func ClassOf(internal string) Type {
+    if strings.HasPrefix(internal, "[") {
+        return FromDescriptor(descriptor.Object(internal))
+    }
+    t := &ClassType{}
+    start := strings.LastIndex(internal, "/") + 1
+    for {
+        i := strings.Index(internal[start:], "$")
+        if i <= 0 || start + i == len(internal) - 1 {
+            break
+        }
+        t.Path = append(t.Path, SimpleClassType{Name: internal[:start + i]})
+        internal, start = internal[start + i + 1:], 0
+    }
+    t.Path = append(t.Path, SimpleClassType{Name: internal})
+    return t
+}
+
+// FromDescriptor returns the type of a descriptor, for code that works
+// with signatures where they are present and with descriptors otherwise.
+func FromDescriptor(t descriptor.Type) Type {
+    var result Type
+    switch t.Base {
+    case 'L':
+        result = ClassOf(t.Class)
+    default:
+        result = BaseType(t.Base)
+    }
+    for i := 0; i < t.Dims; i++ {
+        result = &ArrayType{Elem: result}
+    }
+    return result
+}
+
+func (a TypeArgument) String() string {
+    switch a.Wildcard {
+    case 0:
+        return a.Type.String()
+    case '*':
+        return "*"
+    }
+    return string(a.Wildcard) + a.Type.String()
+}
+
+func (a TypeArgument) JavaName(name func(internal string) string) string {
+    switch a.Wildcard {
+    case '*':
+        return "?"
+    case '+':
+        return "? extends " + a.Type.JavaName(name)
+    case '-':
+        return "? super " + a.Type.JavaName(name)
+    }
+    return a.Type.JavaName(name)
+}
+
+func typeArgumentsJava(args []TypeArgument, name func(internal string) string) string {
+    if len(args) == 0 {
+        return ""
+    }
+    var texts []string
+    for _, arg := range args {
+        texts = append(texts, arg.JavaName(name))
+    }
+    return "<" + strings.Join(texts, ", ") + ">"
+}
+
+// TypeParameter declares a type variable. ClassBound is nil when the
+// parameter is only bounded by interfaces.
+type TypeParameter struct {
+    Name string
+    ClassBound Type
+    InterfaceBounds []Type
+}
+
+func (p TypeParameter) String() string {
+    text := p.Name + ":"
+    if p.ClassBound != nil {
+        text += p.ClassBound.String()
+    }
+    for _, bound := range p.InterfaceBounds {
+        text += ":" + bound.String()
+    }
+    return text
+}
+
+// JavaName renders the parameter as declared in Java, leaving out a bound of
+// Object that is not followed by others.
+func (p TypeParameter) JavaName(name func(internal string) string) string {
+    var bounds []string
+    if p.ClassBound != nil && (!isObject(p.ClassBound) || len(p.InterfaceBounds) > 0) {
+        bounds = append(bounds, p.ClassBound.JavaName(name))
+    }
+    for _, bound := range p.InterfaceBounds {
+        bounds = append(bounds, bound.JavaName(name))
+    }
+    if len(bounds) == 0 {
+        return p.Name
+    }
+    return p.Name + " extends " + strings.Join(bounds, " & ")
+}
+
+func isObject(t Type) bool {
+    class, ok := t.(*ClassType)
+    return ok && len(class.Path) == 1 && class.Path[0].Name == "java/lang/Object" && len(class.Path[0].Args) == 0
+}
+
+// TypeParameters are the type parameters of a generic class or method.
+type TypeParameters []TypeParameter
+
+func (ps TypeParameters) String() string {
+    if len(ps) == 0 {
+        return ""
+    }
+    text := "<"
+    for _, p := range ps {
+        text += p.String()
+    }
+    return text + ">"
+}
+
+func (ps TypeParameters) Java() string {
+    return ps.JavaName(descriptor.QualifiedName)
+}
+
+// JavaName renders the parameters as in a declaration, <K, V extends K>, or
+// "" when there are none.
+func (ps TypeParameters) JavaName(name func(internal string) string) string {
+    if len(ps) == 0 {
+        return ""
+    }
+    var texts []string
+    for _, p := range ps {
+        texts = append(texts, p.JavaName(name))
+    }
+    return "<" + strings.Join(texts, ", ") + ">"
+}
+
+// ClassSignature is the signature of a class: its type parameters and the
+// parameterized types it extends and implements.
+type ClassSignature struct {
+    TypeParams TypeParameters
+    Super *ClassType
+    Interfaces []*ClassType
+}
+
+func (s *ClassSignature) String() string {
+    text := s.TypeParams.String() + s.Super.String()
+    for _, iface := range s.Interfaces {
+        text += iface.String()
+    }
+    return text
+}
+
+func (s *ClassSignature) Java() string {
+    return s.JavaName(descriptor.QualifiedName)
+}
+
+// JavaName renders the class signature as it follows the class name in a
+// class declaration: <T> extends Base<T> implements List<T>.
+func (s *ClassSignature) JavaName(name func(internal string) string) string {
+    text := s.TypeParams.JavaName(name)
+    if !isObject(s.Super) {
+        text += " extends " + s.Super.JavaName(name)
+    }
+    var interfaces []string
+    for _, iface := range s.Interfaces {
+        interfaces = append(interfaces, iface.JavaName(name))
+    }
+    if len(interfaces) > 0 {
+        text += " implements " + strings.Join(interfaces, ", ")
+    }
+    return strings.TrimPrefix(text, " ")
+}
+
+// MethodSignature is the signature of a method. Throws holds class types
+// and type variables.
+type MethodSignature struct {
+    TypeParams TypeParameters
+    Params []Type
+    Result Type
+    Throws []Type
+}
+
+func (s *MethodSignature) String() string {
+    text := s.TypeParams.String() + "("
+    for _, param := range s.Params {
+        text += param.String()
+    }
+    text += ")" + s.Result.String()
+    for _, throws := range s.Throws {
+        text += "^" + throws.String()
+    }
+    return text
+}
+
+func (s *MethodSignature) Java() string {
+    return s.JavaName(descriptor.QualifiedName)
+}
+
+// JavaName renders the method signature like the descriptor package renders
+// method types, e.g. <T> java.util.List<T> (T[]) throws java.io.IOException.
+func (s *MethodSignature) JavaName(name func(internal string) string) string {
+    text := s.TypeParams.JavaName(name)
+    if text != "" {
+        text += " "
+    }
+    var params []string
+    for _, param := range s.Params {
+        params = append(params, param.JavaName(name))
+    }
+    text += s.Result.JavaName(name) + " (" + strings.Join(params, ", ") + ")"
+    var throws []string
+    for _, t := range s.Throws {
+        throws = append(throws, t.JavaName(name))
+    }
+    if len(throws) > 0 {
+        text += " throws " + strings.Join(throws, ", ")
+    }
+    return text
+}
+
+// ParseClass parses the signature of a class.
+func ParseClass(signature string) (s *ClassSignature, err error) {
+    err = parse(signature, func(p *parser) {
+        s = &ClassSignature{TypeParams: p.typeParameters(), Super: p.classType()}
+        for !p.done() {
+            s.Interfaces = append(s.Interfaces, p.classType())
+        }
+    })
+    return
+}
+
+// ParseMethod parses the signature of a method.
+func ParseMethod(signature string) (s *MethodSignature, err error) {
+    err = parse(signature, func(p *parser) {
+        s = &MethodSignature{TypeParams: p.typeParameters()}
+        p.expect('(')
+        for p.peek() != ')' {
+            s.Params = append(s.Params, p.javaType())
+        }
+        p.expect(')')
+        if p.peek() == 'V' {
+            p.pos++
+            s.Result = BaseType('V')
+        } else {
+            s.Result = p.javaType()
+        }
+        for !p.done() {
+            p.expect('^')
+            if p.peek() == 'T' {
+                s.Throws = append(s.Throws, p.typeVariable())
+            } else {
+                s.Throws = append(s.Throws, p.classType())
+            }
+        }
+    })
+    return
+}
+
+// ParseField parses the signature of a field, which is a reference type.
+func ParseField(signature string) (t Type, err error) {
+    err = parse(signature, func(p *parser) {
+        t = p.referenceType()
+    })
+    return
+}
+
+// parser reads a signature, panicking at the first malformed part; parse
+// turns that into an error.
+type parser struct {
+    text string
+    pos int
+}
+
+func parse(signature string, read func(p *parser)) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("Malformed signature %s: %v", signature, r)
+        }
+    }()
+    p := &parser{text: signature}
+    read(p)
+    if !p.done() {
+        panic(fmt.Sprintf("unexpected %q", p.text[p.pos:]))
+    }
+    return nil
+}
+
+func (p *parser) done() bool {
+    return p.pos >= len(p.text)
+}
+
+func (p *parser) peek() byte {
+    if p.done() {
+        panic("unexpected end")
+    }
+    return p.text[p.pos]
+}
+
+func (p *parser) expect(c byte) {
+    if p.peek() != c {
+        panic(fmt.Sprintf("expected %q at %d", c, p.pos))
+    }
+    p.pos++
+}
+
+func (p *parser) identifier(stops string) string {
+    start := p.pos
+    for !strings.ContainsRune(stops, rune(p.peek())) {
+        p.pos++
+    }
+    if p.pos == start {
+        panic(fmt.Sprintf("missing identifier at %d", p.pos))
+    }
+    return p.text[start:p.pos]
+}
+
+func (p *parser) typeParameters() TypeParameters {
+    if p.done() || p.peek() != '<' {
+        return nil
+    }
+    p.pos++
+    var params TypeParameters
+    for p.peek() != '>' {
+        param := TypeParameter{Name: p.identifier(":")}
+        p.expect(':')
+        if c := p.peek(); c == 'L' || c == 'T' || c == '[' {
+            param.ClassBound = p.referenceType()
+        }
+        for p.peek() == ':' {
+            p.pos++
+            param.InterfaceBounds = append(param.InterfaceBounds, p.referenceType())
+        }
+        params = append(params, param)
+    }
+    p.pos++
+    if len(params) == 0 {
+        panic("empty type parameters")
+    }
+    return params
+}
+
+// javaType reads a reference type or a primitive type.
+func (p *parser) javaType() Type {
+    switch c := p.peek(); c {
+    case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+        p.pos++
+        return BaseType(c)
+    }
+    return p.referenceType()
+}
+
+func (p *parser) referenceType() Type {
+    switch c := p.peek(); c {
+    case 'L':
+        return p.classType()
+    case 'T':
+        return p.typeVariable()
+    case '[':
+        p.pos++
+        return &ArrayType{Elem: p.javaType()}
+    default:
+        panic(fmt.Sprintf("unexpected %q at %d", c, p.pos))
+    }
+}
+
+func (p *parser) typeVariable() *TypeVariable {
+    p.expect('T')
+    name := p.identifier(";")
+    p.pos++
+    return &TypeVariable{Name: name}
+}
+
+func (p *parser) classType() *ClassType {
+    p.expect('L')
+    t := &ClassType{}
+    for {
+        t.Path = append(t.Path, SimpleClassType{Name: p.identifier("<.;"), Args: p.typeArguments()})
+        if p.peek() != '.' {
+            break
+        }
+        p.pos++
+    }
+    p.expect(';')
+    return t
+}
+
+func (p *parser) typeArguments() []TypeArgument {
+    if p.peek() != '<' {
+        return nil
+    }
+    p.pos++
+    var args []TypeArgument
+    for p.peek() != '>' {
+        switch c := p.peek(); c {
+        case '*':
+            p.pos++
+            args = append(args, TypeArgument{Wildcard: c})
+        case '+', '-':
+            p.pos++
+            args = append(args, TypeArgument{Wildcard: c, Type: p.referenceType()})
+        default:
+            args = append(args, TypeArgument{Type: p.referenceType()})
+        }
+    }
+    p.pos++
+    if len(args) == 0 {
+        panic("empty type arguments")
+    }
+    return args
+}
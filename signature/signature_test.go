@@ -0,0 +1,142 @@
+package signature
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestParseClass(t *testing.T) {
+    tests := []struct {
+        signature string
+        java string
+        err string
+    }{
+        {"Ljava/lang/Object;", "", ""},
+        {"<T:Ljava/lang/Object;>Ljava/lang/Object;Ljava/util/List<TT;>;", "<T> implements java.util.List<T>", ""},
+        {"<K::Ljava/lang/Comparable<TK;>;V:TK;>Ljava/util/AbstractMap<TK;TV;>;", "<K extends java.lang.Comparable<K>, V extends K> extends java.util.AbstractMap<K, V>", ""},
+        {"<E:Ljava/lang/Enum<TE;>;>Ljava/lang/Object;", "<E extends java.lang.Enum<E>>", ""},
+        {"", "", "unexpected end"},
+        {"<>Ljava/lang/Object;", "", "empty type parameters"},
+        {"<T>Ljava/lang/Object;", "", "unexpected end"},
+        {"Ljava/lang/Object", "", "unexpected end"},
+        {"Ljava/util/List<>;", "", "empty type arguments"},
+        {"TT;", "", "expected 'L'"},
+    }
+    for _, test := range tests {
+        t.Run(test.signature, func(t *testing.T) {
+            got, err := ParseClass(test.signature)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.String() != test.signature {
+                t.Errorf("got signature %s, want %s", got, test.signature)
+            }
+            if got.Java() != test.java {
+                t.Errorf("got %q in Java, want %q", got.Java(), test.java)
+            }
+        })
+    }
+}
+
+func TestParseMethod(t *testing.T) {
+    tests := []struct {
+        signature string
+        java string
+        err string
+    }{
+        {"()V", "void ()", ""},
+        {"<T:Ljava/lang/Object;>([TT;I)Ljava/util/List<TT;>;", "<T> java.util.List<T> (T[], int)", ""},
+        {"(Ljava/util/Map<+Ljava/lang/Number;-Ljava/lang/Integer;>;)Ljava/lang/Class<*>;", "java.lang.Class<?> (java.util.Map<? extends java.lang.Number, ? super java.lang.Integer>)", ""},
+        {"<X:Ljava/lang/Throwable;>()V^TX;^Ljava/io/IOException;", "<X extends java.lang.Throwable> void () throws X, java.io.IOException", ""},
+        {"V", "", "expected '('"},
+        {"(I", "", "unexpected end"},
+        {"(V)V", "", "unexpected 'V'"},
+        {"()", "", "unexpected end"},
+        {"()VI", "", "expected '^'"},
+        {"()V^", "", "unexpected end"},
+        {"(TT)V", "", "unexpected end"},
+    }
+    for _, test := range tests {
+        t.Run(test.signature, func(t *testing.T) {
+            got, err := ParseMethod(test.signature)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.String() != test.signature {
+                t.Errorf("got signature %s, want %s", got, test.signature)
+            }
+            if got.Java() != test.java {
+                t.Errorf("got %q in Java, want %q", got.Java(), test.java)
+            }
+        })
+    }
+}
+
+func TestParseField(t *testing.T) {
+    tests := []struct {
+        signature string
+        java string
+        err string
+    }{
+        {"TT;", "T", ""},
+        {"[[Ljava/lang/String;", "java.lang.String[][]", ""},
+        {"Ljava/util/Map$Entry<TK;TV;>;", "java.util.Map$Entry<K, V>", ""},
+        {"Lp/Outer<TT;>.Inner.Deep<[I>;", "p.Outer<T>.Inner.Deep<int[]>", ""},
+        {"I", "", "unexpected 'I'"},
+        {"", "", "unexpected end"},
+        {"T;", "", "missing identifier"},
+        {"L;", "", "missing identifier"},
+        {"Ljava/lang/String;I", "", "unexpected \"I\""},
+    }
+    for _, test := range tests {
+        t.Run(test.signature, func(t *testing.T) {
+            got, err := ParseField(test.signature)
+            if test.err != "" {
+                if err == nil || !strings.Contains(err.Error(), test.err) {
+                    t.Fatalf("got error %v, want one containing %q", err, test.err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if got.String() != test.signature {
+                t.Errorf("got signature %s, want %s", got, test.signature)
+            }
+            if got.Java() != test.java {
+                t.Errorf("got %q in Java, want %q", got.Java(), test.java)
+            }
+        })
+    }
+}
+
+func TestClassOf(t *testing.T) {
+    tests := []struct {
+        internal string
+        want string
+        java string
+    }{
+        {"java/lang/String", "Ljava/lang/String;", "java.lang.String"},
+        {"java/util/Map$Entry", "Ljava/util/Map.Entry;", "java.util.Map$Entry"},
+        {"p/Money$", "Lp/Money$;", "p.Money$"},
+        {"[Ljava/lang/String;", "[Ljava/lang/String;", "java.lang.String[]"},
+    }
+    for _, test := range tests {
+        got := ClassOf(test.internal)
+        if got.String() != test.want || got.Java() != test.java {
+            t.Errorf("ClassOf(%q) = %s, %s, want %s, %s", test.internal, got, got.Java(), test.want, test.java)
+        }
+    }
+}
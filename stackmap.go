@@ -0,0 +1,317 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+)
+
+type VerificationTag byte
+const (
+    VTop VerificationTag = 0
+    VInteger VerificationTag = 1
+    VFloat VerificationTag = 2
+    VDouble VerificationTag = 3
+    VLong VerificationTag = 4
+    VNull VerificationTag = 5
+    VUninitializedThis VerificationTag = 6
+    VObject VerificationTag = 7
+    VUninitialized VerificationTag = 8
+)
+
+// VerificationType is a single entry in the locals or stack of a frame. Class
+// holds the internal name (or array descriptor) of an Object type and Offset
+// the position of the new instruction of an Uninitialized type.
+type VerificationType struct {
+    Tag VerificationTag
+    Class string
+    Offset uint16
+}
+
+func (v VerificationType) String() string {
+    switch v.Tag {
+    case VTop:
+        return "Top"
+    case VInteger:
+        return "Integer"
+    case VFloat:
+        return "Float"
+    case VDouble:
+        return "Double"
+    case VLong:
+        return "Long"
+    case VNull:
+        return "Null"
+    case VUninitializedThis:
+        return "UninitializedThis"
+    case VObject:
+        return "Object " + v.Class
+    case VUninitialized:
+        return fmt.Sprintf("Uninitialized %d", v.Offset)
+    }
+    return fmt.Sprintf("%d", v.Tag)
+}
+
+func (v VerificationType) IsWide() bool {
+    return v.Tag == VLong || v.Tag == VDouble
+}
+
+func (v VerificationType) IsReference() bool {
+    return v.Tag == VNull || v.Tag == VObject || v.Tag == VUninitialized || v.Tag == VUninitializedThis
+}
+
+const (
+    FrameSame byte = 0
+    FrameSameLocals1 byte = 64
+    FrameSameLocals1Extended byte = 247
+    FrameChop byte = 248
+    FrameSameExtended byte = 251
+    FrameAppend byte = 252
+    FrameFull byte = 255
+)
+
+// StackMapFrame is an entry of the StackMapTable attribute. Locals and Stack
+// always hold the complete frame state, whatever the compressed FrameType,
+// and Offset is the absolute bytecode offset the frame applies to.
+type StackMapFrame struct {
+    FrameType byte
+    OffsetDelta uint16
+    Offset int
+    Locals []VerificationType
+    Stack []VerificationType
+}
+
+// FrameTypeName returns the name the JVM specification uses for a frame type.
+func FrameTypeName(frameType byte) string {
+    switch {
+    case frameType < FrameSameLocals1:
+        return "same"
+    case frameType < 128:
+        return "same_locals_1_stack_item"
+    case frameType < FrameSameLocals1Extended:
+        return "reserved"
+    case frameType == FrameSameLocals1Extended:
+        return "same_locals_1_stack_item_extended"
+    case frameType < FrameSameExtended:
+        return "chop"
+    case frameType == FrameSameExtended:
+        return "same_extended"
+    case frameType < FrameFull:
+        return "append"
+    }
+    return "full"
+}
+
+// compressFrames picks the smallest frame type for each frame given the
+// implicit initial frame of the method, and fills in the offset deltas.
+func compressFrames(initial []VerificationType, frames []StackMapFrame) {
+    prevLocals := initial
+    prevOffset := -1
+    for i := range frames {
+        f := &frames[i]
+        f.OffsetDelta = uint16(f.Offset - prevOffset - 1)
+        delta := int(f.OffsetDelta)
+        diff := len(f.Locals) - len(prevLocals)
+        switch {
+        case len(f.Stack) == 0 && sameTypes(f.Locals, prevLocals):
+            if delta <= 63 {
+                f.FrameType = FrameSame + byte(delta)
+            } else {
+                f.FrameType = FrameSameExtended
+            }
+        case len(f.Stack) == 1 && sameTypes(f.Locals, prevLocals):
+            if delta <= 63 {
+                f.FrameType = FrameSameLocals1 + byte(delta)
+            } else {
+                f.FrameType = FrameSameLocals1Extended
+            }
+        case len(f.Stack) == 0 && diff < 0 && diff >= -3 && sameTypes(f.Locals, prevLocals[:len(f.Locals)]):
+            f.FrameType = byte(int(FrameSameExtended) + diff)
+        case len(f.Stack) == 0 && diff > 0 && diff <= 3 && sameTypes(f.Locals[:len(prevLocals)], prevLocals):
+            f.FrameType = byte(int(FrameSameExtended) + diff)
+        default:
+            f.FrameType = FrameFull
+        }
+        prevLocals = f.Locals
+        prevOffset = f.Offset
+    }
+}
+
+func sameTypes(a []VerificationType, b []VerificationType) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for i := range a {
+        if a[i] != b[i] {
+            return false
+        }
+    }
+    return true
+}
+
+// EncodeStackMapTable encodes frames, which must already be compressed, as
+// the contents of a StackMapTable attribute.
+func EncodeStackMapTable(cp *ConstantPool, frames []StackMapFrame) []byte {
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, uint16(len(frames)))
+    for i := range frames {
+        f := &frames[i]
+        mustWrite(&w, f.FrameType)
+        switch {
+        case f.FrameType < FrameSameLocals1:
+        case f.FrameType < 128:
+            mustWriteVerificationType(&w, cp, f.Stack[0])
+        case f.FrameType == FrameSameLocals1Extended:
+            mustWrite(&w, f.OffsetDelta)
+            mustWriteVerificationType(&w, cp, f.Stack[0])
+        case f.FrameType >= FrameChop && f.FrameType <= FrameSameExtended:
+            mustWrite(&w, f.OffsetDelta)
+        case f.FrameType < FrameFull:
+            mustWrite(&w, f.OffsetDelta)
+            for _, v := range f.Locals[len(f.Locals) - int(f.FrameType - FrameSameExtended):] {
+                mustWriteVerificationType(&w, cp, v)
+            }
+        default:
+            mustWrite(&w, f.OffsetDelta)
+            mustWrite(&w, uint16(len(f.Locals)))
+            for _, v := range f.Locals {
+                mustWriteVerificationType(&w, cp, v)
+            }
+            mustWrite(&w, uint16(len(f.Stack)))
+            for _, v := range f.Stack {
+                mustWriteVerificationType(&w, cp, v)
+            }
+        }
+    }
+    return buf.Bytes()
+}
+
+// SetStackMapTable replaces the StackMapTable attribute of the code with the
+// given frames, removing it when there are none.
+func (c *Code) SetStackMapTable(cp *ConstantPool, frames []StackMapFrame) {
+    var attrs []Attribute
+    for _, attr := range c.Attributes {
+        if cp.GetUtf8(attr.NameIndex) != "StackMapTable" {
+            attrs = append(attrs, attr)
+        }
+    }
+    if len(frames) > 0 {
+        attrs = append(attrs, Attribute{
+            NameIndex: cp.AddUtf8("StackMapTable"),
+            Info: EncodeStackMapTable(cp, frames),
+        })
+    }
+    c.Attributes = attrs
+}
+
+func mustWriteVerificationType(w *io.Writer, cp *ConstantPool, v VerificationType) {
+    mustWrite(w, v.Tag)
+    switch v.Tag {
+    case VObject:
+        mustWrite(w, cp.AddClass(v.Class))
+    case VUninitialized:
+        mustWrite(w, v.Offset)
+    }
+}
+
+// StackMap decodes the StackMapTable attribute of the code, returning nil if
+// there is none. The method is needed to rebuild the implicit initial frame
+// that the first entry is relative to.
+func (c *Code) StackMap(class *Class, method *Method) ([]StackMapFrame, error) {
+    attr := FindAttribute(class.ConstantPool, c.Attributes, "StackMapTable")
+    if attr == nil {
+        return nil, nil
+    }
+    initial, err := InitialLocals(class, method)
+    if err != nil {
+        return nil, err
+    }
+    return DecodeStackMapTable(class.ConstantPool, attr.Info, initial)
+}
+
+// DecodeStackMapTable decodes the contents of a StackMapTable attribute,
+// expanding every frame to its complete locals and stack.
+func DecodeStackMapTable(cp *ConstantPool, info []byte, initial []VerificationType) (frames []StackMapFrame, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            frames = nil
+            err = fmt.Errorf("Malformed StackMapTable: %v", r)
+        }
+    }()
+
+    var r io.Reader = bytes.NewReader(info)
+    var count uint16
+    mustRead(&r, &count)
+    frames = make([]StackMapFrame, count)
+
+    locals := initial
+    offset := -1
+    for i := 0; i < int(count); i++ {
+        f := &frames[i]
+        mustRead(&r, &f.FrameType)
+        switch {
+        case f.FrameType < FrameSameLocals1:
+            f.OffsetDelta = uint16(f.FrameType)
+            f.Locals = locals
+        case f.FrameType < 128:
+            f.OffsetDelta = uint16(f.FrameType - FrameSameLocals1)
+            f.Locals = locals
+            f.Stack = []VerificationType{mustReadVerificationType(&r, cp)}
+        case f.FrameType < FrameSameLocals1Extended:
+            return nil, fmt.Errorf("Reserved frame type %d", f.FrameType)
+        case f.FrameType == FrameSameLocals1Extended:
+            mustRead(&r, &f.OffsetDelta)
+            f.Locals = locals
+            f.Stack = []VerificationType{mustReadVerificationType(&r, cp)}
+        case f.FrameType <= FrameSameExtended:
+            mustRead(&r, &f.OffsetDelta)
+            chop := int(FrameSameExtended - f.FrameType)
+            if chop > len(locals) {
+                return nil, fmt.Errorf("Frame %d chops %d locals from %d", i, chop, len(locals))
+            }
+            f.Locals = locals[:len(locals) - chop]
+        case f.FrameType < FrameFull:
+            mustRead(&r, &f.OffsetDelta)
+            f.Locals = append([]VerificationType(nil), locals...)
+            for j := 0; j < int(f.FrameType - FrameSameExtended); j++ {
+                f.Locals = append(f.Locals, mustReadVerificationType(&r, cp))
+            }
+        default:
+            mustRead(&r, &f.OffsetDelta)
+            var n uint16
+            mustRead(&r, &n)
+            f.Locals = make([]VerificationType, n)
+            for j := range f.Locals {
+                f.Locals[j] = mustReadVerificationType(&r, cp)
+            }
+            mustRead(&r, &n)
+            f.Stack = make([]VerificationType, n)
+            for j := range f.Stack {
+                f.Stack[j] = mustReadVerificationType(&r, cp)
+            }
+        }
+        offset += int(f.OffsetDelta) + 1
+        f.Offset = offset
+        locals = f.Locals
+    }
+    return frames, nil
+}
+
+func mustReadVerificationType(r *io.Reader, cp *ConstantPool) VerificationType {
+    var v VerificationType
+    mustRead(r, &v.Tag)
+    switch v.Tag {
+    case VObject:
+        var index CpIndex
+        mustRead(r, &index)
+        v.Class = cp.GetClassName(index)
+    case VUninitialized:
+        mustRead(r, &v.Offset)
+    default:
+        if v.Tag > VUninitialized {
+            panic(fmt.Sprintf("Invalid verification type tag %d", v.Tag))
+        }
+    }
+    return v
+}
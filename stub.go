@@ -0,0 +1,1095 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "math"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+    "github.com/jasonhightower/jcr/signature"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// StubWriter writes the public API of a class as Java source that compiles
+// against the same dependencies: declarations keep their generics,
+// annotations, constant values and throws clauses while every body just
+// throws UnsupportedOperationException. Nested classes are only included
+// by WriteStubs, which sees the whole jar.
+type StubWriter struct {}
+
+func (s StubWriter) Write(w *io.Writer, c *Class) error {
+    return writeSource(w, c, map[string]*Class{c.Name(): c}, false)
+}
+
+// WriteStubs writes a stub for every public top level class into dir, in a
+// directory tree that mirrors the packages.
+func WriteStubs(classes []*Class, dir string) error {
+    return writeSources(classes, dir, false)
+}
+
+// writeSources writes the source of the top level classes into dir. Stubs
+// are only written for public classes.
+func writeSources(classes []*Class, dir string, decompile bool) error {
+    byName := make(map[string]*Class)
+    for _, c := range classes {
+        byName[c.Name()] = c
+    }
+    for _, c := range classes {
+        if !decompile && !stubVisible(c.Flags) && !c.IsModule() || stubNested(c) || c.Flags & FLAG_SYNTHETIC != 0 {
+            continue
+        }
+        path := filepath.Join(dir, filepath.FromSlash(c.Name()) + ".java")
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            return err
+        }
+        f, err := os.Create(path)
+        if err != nil {
+            return err
+        }
+        var w io.Writer = f
+        err = writeSource(&w, c, byName, decompile)
+        f.Close()
+        if err != nil {
+            return fmt.Errorf("%s: %s", c.Name(), err)
+        }
+    }
+    return nil
+}
+
+func stubVisible(f AccessFlag) bool {
+    return f & (FLAG_PUBLIC | FLAG_PROTECTED) != 0 && f & FLAG_SYNTHETIC == 0
+}
+
+// stubNested reports whether the class is declared inside another class,
+// which its own InnerClasses attribute records.
+func stubNested(c *Class) bool {
+    _, ok := innerClassEntry(c, c.Name())
+    return ok
+}
+
+func innerClassEntry(c *Class, name string) (InnerClass, bool) {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "InnerClasses")
+    if attr == nil {
+        return InnerClass{}, false
+    }
+    inners, err := DecodeInnerClasses(attr.Info)
+    if err != nil {
+        return InnerClass{}, false
+    }
+    for _, inner := range inners {
+        if c.ConstantPool.GetClassName(inner.InnerClassIndex) == name {
+            return inner, true
+        }
+    }
+    return InnerClass{}, false
+}
+
+func writeSource(w *io.Writer, c *Class, classes map[string]*Class, decompile bool) error {
+    name := c.Name()
+    pkg := ""
+    if i := strings.LastIndex(name, "/"); i >= 0 {
+        pkg = name[:i]
+    }
+    s := &stubber{classes: classes, imports: stubImports{pkg: pkg, simple: make(map[string]string)}, decompile: decompile,
+        inlined: make(map[*Method]bool)}
+    s.imports.claim(c)
+
+    var body bytes.Buffer
+    s.out = &body
+    if c.IsModule() {
+        if err := s.module(c); err != nil {
+            return err
+        }
+    } else if err := s.class(c, c.Flags, ""); err != nil {
+        return err
+    }
+
+    if pkg != "" {
+        io.WriteString(*w, "package " + strings.ReplaceAll(pkg, "/", ".") + ";\n\n")
+    }
+    var imports []string
+    for top, used := range s.imports.used {
+        if used {
+            imports = append(imports, strings.ReplaceAll(top, "/", "."))
+        }
+    }
+    sort.Strings(imports)
+    for _, imp := range imports {
+        io.WriteString(*w, "import " + imp + ";\n")
+    }
+    if len(imports) > 0 {
+        io.WriteString(*w, "\n")
+    }
+    _, err := (*w).Write(body.Bytes())
+    return err
+}
+
+// stubImports decides how a class is referred to in a stub: by its simple
+// name if it is in the same package, in java.lang or can be imported without
+// a clash, and by its qualified name otherwise.
+type stubImports struct {
+    pkg string
+    simple map[string]string
+    used map[string]bool
+}
+
+// claim reserves the simple name of the class being written so imports
+// don't shadow it.
+func (s *stubImports) claim(c *Class) {
+    top, _ := stubSplitNested(c.Name())
+    s.simple[stubSimpleName(top)] = top
+}
+
+// stubSplitNested splits a binary name such as a/b/Outer$Inner into the top
+// level class and the Java path of the nested class, ".Inner".
+func stubSplitNested(name string) (string, string) {
+    start := strings.LastIndex(name, "/") + 1
+    i := strings.Index(name[start:], "$")
+    if i <= 0 || start + i == len(name) - 1 {
+        return name, ""
+    }
+    return name[:start + i], strings.ReplaceAll(name[start + i:], "$", ".")
+}
+
+func stubSimpleName(name string) string {
+    return name[strings.LastIndex(name, "/") + 1:]
+}
+
+func (s *stubImports) name(internal string) string {
+    top, nested := stubSplitNested(internal)
+    simple := stubSimpleName(top)
+    pkg := ""
+    if i := strings.LastIndex(top, "/"); i >= 0 {
+        pkg = top[:i]
+    }
+    if owner, ok := s.simple[simple]; ok {
+        if owner == top {
+            if s.used == nil {
+                s.used = make(map[string]bool)
+            }
+            if pkg != s.pkg && pkg != "java/lang" {
+                s.used[top] = true
+            }
+            return simple + nested
+        }
+        return strings.ReplaceAll(top, "/", ".") + nested
+    }
+    s.simple[simple] = top
+    return s.name(internal)
+}
+
+type stubber struct {
+    out *bytes.Buffer
+    classes map[string]*Class
+    imports stubImports
+    // decompile includes private members and decompiles the method bodies
+    decompile bool
+    // inlined are the synthetic methods of lambdas whose bodies were written
+    // in place
+    inlined map[*Method]bool
+}
+
+// visible reports whether a member or nested class is written.
+func (s *stubber) visible(f AccessFlag) bool {
+    if s.decompile {
+        return true
+    }
+    return stubVisible(f)
+}
+
+func (s *stubber) write(indent string, text string) {
+    s.out.WriteString(indent + text + "\n")
+}
+
+func (s *stubber) class(c *Class, flags AccessFlag, indent string) error {
+    cp := c.ConstantPool
+    if err := s.annotations(c.ConstantPool, c.Attributes, indent); err != nil {
+        return err
+    }
+
+    modifiers := javaModifiers(flags &^ (FLAG_SYNTHETIC | FLAG_SUPER), innerClassFlagNames)
+    kind := "class "
+    switch {
+    case flags & FLAG_ANNOTATION != 0:
+        kind = "@interface "
+        modifiers = strings.ReplaceAll(strings.ReplaceAll(modifiers, "abstract ", ""), "static ", "")
+    case flags.IsInterface():
+        kind = "interface "
+        modifiers = strings.ReplaceAll(strings.ReplaceAll(modifiers, "abstract ", ""), "static ", "")
+    case flags.IsEnum():
+        kind = "enum "
+        modifiers = strings.ReplaceAll(strings.ReplaceAll(modifiers, "final ", ""), "abstract ", "")
+    case c.IsRecord():
+        // records are implicitly final and, when nested, static
+        kind = "record "
+        modifiers = strings.ReplaceAll(strings.ReplaceAll(modifiers, "final ", ""), "static ", "")
+    }
+    permitted, err := c.PermittedSubclasses()
+    if err != nil {
+        return err
+    }
+    if permitted != nil && kind != "enum " {
+        modifiers += "sealed "
+    } else if s.nonSealed(c, flags) {
+        modifiers += "non-sealed "
+    }
+    _, nested := stubSplitNested(c.Name())
+    simple := stubSimpleName(c.Name())
+    if nested != "" {
+        simple = nested[strings.LastIndex(nested, ".") + 1:]
+    }
+    header := modifiers + kind + simple
+
+    super := c.SuperName()
+    var interfaces []string
+    for _, iface := range c.Interfaces {
+        interfaces = append(interfaces, cp.GetClassName(iface))
+    }
+    if flags & FLAG_ANNOTATION != 0 {
+        // java.lang.annotation.Annotation is implied
+        interfaces = nil
+    }
+    signature := ""
+    if attr := FindAttribute(cp, c.Attributes, "Signature"); attr != nil {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return err
+        }
+        signature = cp.GetUtf8(index)
+    }
+
+    var superText string
+    var interfaceTexts []string
+    if signature != "" {
+        params, superSig, ifaceSigs, err := s.classSignature(signature)
+        if err != nil {
+            return err
+        }
+        header += params
+        superText, interfaceTexts = superSig, ifaceSigs
+    } else {
+        if super != "" {
+            superText = s.imports.name(super)
+        }
+        for _, iface := range interfaces {
+            interfaceTexts = append(interfaceTexts, s.imports.name(iface))
+        }
+    }
+    if kind == "record " {
+        components, err := s.recordComponents(c)
+        if err != nil {
+            return err
+        }
+        header += "(" + components + ")"
+    }
+
+    switch kind {
+    case "class ":
+        if super != "" && super != "java/lang/Object" {
+            header += " extends " + superText
+        }
+        if len(interfaceTexts) > 0 {
+            header += " implements " + strings.Join(interfaceTexts, ", ")
+        }
+    case "interface ":
+        if len(interfaceTexts) > 0 {
+            header += " extends " + strings.Join(interfaceTexts, ", ")
+        }
+    case "enum ", "record ":
+        // java.lang.Record is implied
+        if len(interfaceTexts) > 0 {
+            header += " implements " + strings.Join(interfaceTexts, ", ")
+        }
+    }
+    if permitted != nil && kind != "enum " {
+        var names []string
+        for _, name := range permitted {
+            names = append(names, s.imports.name(name))
+        }
+        header += " permits " + strings.Join(names, ", ")
+    }
+    s.write(indent, header + " {")
+
+    inner := indent + "    "
+    if flags.IsEnum() {
+        var constants []string
+        for _, field := range c.Fields {
+            if field.Flags.IsEnum() {
+                constants = append(constants, cp.GetUtf8(field.NameIndex))
+            }
+        }
+        s.write(inner, strings.Join(constants, ", ") + ";")
+    }
+    for i := range c.Fields {
+        if err := s.field(c, &c.Fields[i], inner); err != nil {
+            return err
+        }
+    }
+    // lambda bodies are written where the lambdas are created, so their
+    // methods come last and only for lambdas that were left as calls
+    var lambdas []*Method
+    for i := range c.Methods {
+        if s.decompile && lambdaMethod(cp, &c.Methods[i]) {
+            lambdas = append(lambdas, &c.Methods[i])
+            continue
+        }
+        if err := s.method(c, flags, &c.Methods[i], inner); err != nil {
+            return err
+        }
+    }
+    for _, method := range lambdas {
+        if s.inlined[method] {
+            continue
+        }
+        if err := s.method(c, flags, method, inner); err != nil {
+            return err
+        }
+    }
+    if err := s.nestedClasses(c, inner); err != nil {
+        return err
+    }
+    s.write(indent, "}")
+    return nil
+}
+
+// module writes the declaration of a module-info class.
+func (s *stubber) module(c *Class) error {
+    m, err := c.Module()
+    if err != nil {
+        return err
+    }
+    if m == nil {
+        return fmt.Errorf("module-info class without a Module attribute")
+    }
+    if err := s.annotations(c.ConstantPool, c.Attributes, ""); err != nil {
+        return err
+    }
+    s.out.WriteString(m.Java())
+    return nil
+}
+
+// nonSealed reports whether a class extends a sealed class of the jar without
+// being final or sealed itself, which source declares as non-sealed.
+func (s *stubber) nonSealed(c *Class, flags AccessFlag) bool {
+    if flags.IsFinal() || flags.IsEnum() || c.IsRecord() {
+        return false
+    }
+    for _, super := range directSupertypes(c) {
+        if sealed, ok := s.classes[super]; ok && sealed.IsSealed() {
+            return true
+        }
+    }
+    return false
+}
+
+// recordComponents renders the component list of a record header, such as
+// int x, List<String> names.
+func (s *stubber) recordComponents(c *Class) (string, error) {
+    cp := c.ConstantPool
+    components, err := c.RecordComponents()
+    if err != nil {
+        return "", err
+    }
+    varargs := false
+    if canonical := recordCanonical(c, components); canonical != nil {
+        varargs = canonical.Flags & FLAG_VARARGS != 0
+    }
+    var texts []string
+    for i := range components {
+        component := &components[i]
+        typ := s.descriptorType(component.Descriptor(cp))
+        if sig := component.Signature(cp); sig != "" {
+            if typ, err = s.typeSignature(sig); err != nil {
+                return "", err
+            }
+        }
+        if i == len(components) - 1 && varargs && strings.HasSuffix(typ, "[]") {
+            typ = strings.TrimSuffix(typ, "[]") + "..."
+        }
+        annotations, err := component.Annotations(cp)
+        if err != nil {
+            return "", err
+        }
+        text := ""
+        for _, a := range annotations {
+            text += a.Java(cp, s.imports.name) + " "
+        }
+        texts = append(texts, text + typ + " " + component.Name(cp))
+    }
+    return strings.Join(texts, ", "), nil
+}
+
+// recordCanonical returns the canonical constructor of a record, the one
+// taking its components in order.
+func recordCanonical(c *Class, components []RecordComponent) *Method {
+    descriptor := "("
+    for i := range components {
+        descriptor += components[i].Descriptor(c.ConstantPool)
+    }
+    descriptor += ")V"
+    for i := range c.Methods {
+        method := &c.Methods[i]
+        if c.ConstantPool.GetUtf8(method.NameIndex) == "<init>" && c.ConstantPool.GetUtf8(method.DescriptorIndex) == descriptor {
+            return method
+        }
+    }
+    return nil
+}
+
+// recordImplicit reports whether a member of a record is one the compiler
+// derives from the components: their fields, the canonical constructor, the
+// accessors and the final toString, hashCode and equals.
+func recordImplicit(c *Class, name string, descriptor string, flags AccessFlag, isField bool) bool {
+    components, err := c.RecordComponents()
+    if err != nil || components == nil {
+        return false
+    }
+    if isField {
+        // records can't declare instance fields
+        return !flags.IsStatic()
+    }
+    if flags.IsStatic() {
+        return false
+    }
+    switch {
+    case name == "<init>":
+        canonical := recordCanonical(c, components)
+        return canonical != nil && c.ConstantPool.GetUtf8(canonical.DescriptorIndex) == descriptor
+    case flags.IsFinal() && (name == "toString" && descriptor == "()Ljava/lang/String;" ||
+        name == "hashCode" && descriptor == "()I" ||
+        name == "equals" && descriptor == "(Ljava/lang/Object;)Z"):
+        return true
+    }
+    for i := range components {
+        if components[i].Name(c.ConstantPool) == name && "()" + components[i].Descriptor(c.ConstantPool) == descriptor {
+            return true
+        }
+    }
+    return false
+}
+
+// recordGenerated reports whether an implicit member of a record has the
+// body javac generates for it: an accessor returning its field, equals,
+// hashCode and toString passing their arguments to an ObjectMethods call
+// site, and a canonical constructor assigning each field its parameter.
+func recordGenerated(c *Class, method *Method) bool {
+    cp := c.ConstantPool
+    code := method.Code(cp)
+    if code == nil {
+        return false
+    }
+    instrs, err := DecodeInstructions(code.ByteCode)
+    if err != nil || len(instrs) < 2 || instrs[len(instrs) - 1].Opcode < Ireturn || instrs[len(instrs) - 1].Opcode > Return {
+        return false
+    }
+    instrs = instrs[:len(instrs) - 1]
+    components, err := c.RecordComponents()
+    if err != nil {
+        return false
+    }
+    isLoad := func(instr Instruction) bool {
+        op := instr.Opcode
+        return op >= Iload && op <= Aload || op >= Iload0 && op <= Aload3
+    }
+    ownField := func(instr Instruction, name string) bool {
+        owner, field, _ := cp.GetMemberRef(instr.Index())
+        return owner == c.Name() && field == name
+    }
+
+    name := cp.GetUtf8(method.NameIndex)
+    switch name {
+    case "<init>":
+        if len(instrs) != 2 + 3 * len(components) || instrs[0].Opcode != Aload0 || instrs[1].Opcode != Invokespecial {
+            return false
+        }
+        if owner, init, _ := cp.GetMemberRef(instrs[1].Index()); owner != "java/lang/Record" || init != "<init>" {
+            return false
+        }
+        for i := range components {
+            assign := instrs[2 + 3 * i:]
+            if assign[0].Opcode != Aload0 || !isLoad(assign[1]) || assign[2].Opcode != Putfield ||
+                !ownField(assign[2], components[i].Name(cp)) {
+                return false
+            }
+        }
+        return true
+    case "toString", "hashCode", "equals":
+        call := instrs[len(instrs) - 1]
+        if call.Opcode != Invokedynamic {
+            return false
+        }
+        for _, instr := range instrs[:len(instrs) - 1] {
+            if !isLoad(instr) {
+                return false
+            }
+        }
+        bootstraps, err := c.BootstrapMethods()
+        if err != nil {
+            return false
+        }
+        site, err := ResolveCallSite(cp, bootstraps, call.Index())
+        if err != nil {
+            return false
+        }
+        methods := site.ObjectMethods(cp)
+        return methods != nil && methods.Method == name && methods.Record == c.Name()
+    }
+    return len(instrs) == 2 && instrs[0].Opcode == Aload0 && instrs[1].Opcode == Getfield && ownField(instrs[1], name)
+}
+
+func (s *stubber) nestedClasses(c *Class, indent string) error {
+    attr := FindAttribute(c.ConstantPool, c.Attributes, "InnerClasses")
+    if attr == nil {
+        return nil
+    }
+    inners, err := DecodeInnerClasses(attr.Info)
+    if err != nil {
+        return err
+    }
+    for _, inner := range inners {
+        if inner.OuterClassIndex == 0 || inner.InnerNameIndex == 0 || !s.visible(inner.Flags) ||
+            c.ConstantPool.GetClassName(inner.OuterClassIndex) != c.Name() {
+            continue
+        }
+        nested, ok := s.classes[c.ConstantPool.GetClassName(inner.InnerClassIndex)]
+        if !ok {
+            continue
+        }
+        s.out.WriteString("\n")
+        if err := s.class(nested, inner.Flags, indent); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (s *stubber) field(c *Class, field *Field, indent string) error {
+    cp := c.ConstantPool
+    name := cp.GetUtf8(field.NameIndex)
+    if field.Flags.IsEnum() || !s.visible(field.Flags) || !javaIdentifier(name) {
+        return nil
+    }
+    if s.decompile && field.Flags & FLAG_SYNTHETIC != 0 && c.Flags.IsEnum() {
+        return nil
+    }
+    descriptor := cp.GetUtf8(field.DescriptorIndex)
+    if recordImplicit(c, name, descriptor, field.Flags, true) {
+        return nil
+    }
+    typ := s.descriptorType(descriptor)
+    if attr := FindAttribute(cp, field.Attributes, "Signature"); attr != nil {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return err
+        }
+        if typ, err = s.typeSignature(cp.GetUtf8(index)); err != nil {
+            return err
+        }
+    }
+
+    s.out.WriteString("\n")
+    if err := s.annotations(cp, field.Attributes, indent); err != nil {
+        return err
+    }
+    modifiers := javaModifiers(field.Flags &^ FLAG_SYNTHETIC, fieldFlagNames)
+    if c.Flags.IsInterface() {
+        modifiers = ""
+    }
+    text := modifiers + typ + " " + name
+
+    if attr := FindAttribute(cp, field.Attributes, "ConstantValue"); attr != nil && field.Flags.IsStatic() {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return err
+        }
+        text += " = " + javaLiteral(cp, index, descriptor)
+    } else if !s.decompile && field.Flags.IsFinal() || c.Flags.IsInterface() {
+        // decompiled classes assign their final fields in the constructors
+        // and static initializers, which interfaces can't have
+        text += " = " + javaDefaultValue(descriptor)
+    }
+    s.write(indent, text + ";")
+    return nil
+}
+
+func (s *stubber) method(c *Class, classFlags AccessFlag, method *Method, indent string) error {
+    cp := c.ConstantPool
+    name := cp.GetUtf8(method.NameIndex)
+    descriptor := cp.GetUtf8(method.DescriptorIndex)
+    isInterface := classFlags.IsInterface()
+    if name == "<clinit>" {
+        if !s.decompile || isInterface || classFlags.IsEnum() {
+            return nil
+        }
+        s.out.WriteString("\n")
+        s.write(indent, "static {")
+        s.body(c, method, nil, 0, indent + "    ")
+        s.write(indent, "}")
+        return nil
+    }
+    if method.Flags & FLAG_BRIDGE != 0 || method.Flags & FLAG_SYNTHETIC != 0 && (!s.decompile || classFlags.IsEnum()) {
+        return nil
+    }
+    if !s.visible(method.Flags) || name != "<init>" && !javaIdentifier(name) {
+        return nil
+    }
+    if classFlags.IsEnum() && (name == "<init>" || name == "$values" ||
+        name == "values" && method.Flags.IsStatic() && strings.HasPrefix(descriptor, "()") ||
+        name == "valueOf" && method.Flags.IsStatic() && strings.HasPrefix(descriptor, "(Ljava/lang/String;)")) {
+        return nil
+    }
+    // hand-written versions are kept when decompiling
+    if recordImplicit(c, name, descriptor, method.Flags, false) && (!s.decompile || recordGenerated(c, method)) {
+        return nil
+    }
+
+    args, ret, err := splitMethodDescriptor(descriptor)
+    if err != nil {
+        return err
+    }
+    var types []string
+    for _, arg := range args {
+        types = append(types, s.descriptorType(arg))
+    }
+    returnType := s.descriptorType(ret)
+    typeParams := ""
+    var throws []string
+    if attr := FindAttribute(cp, method.Attributes, "Exceptions"); attr != nil {
+        exceptions, err := DecodeExceptions(attr.Info)
+        if err != nil {
+            return err
+        }
+        for _, exception := range exceptions {
+            throws = append(throws, s.imports.name(cp.GetClassName(exception)))
+        }
+    }
+
+    // the outer instance passed to the constructor of an inner class is not
+    // declared in the source
+    skip := 0
+    if name == "<init>" {
+        if inner, ok := innerClassEntry(c, c.Name()); ok && inner.Flags & FLAG_STATIC == 0 && inner.OuterClassIndex != 0 &&
+            len(args) > 0 && args[0] == classNameDescriptor(cp.GetClassName(inner.OuterClassIndex)) {
+            skip = 1
+        }
+    }
+
+    if attr := FindAttribute(cp, method.Attributes, "Signature"); attr != nil {
+        index, err := DecodeIndex(attr.Info)
+        if err != nil {
+            return err
+        }
+        params, sigArgs, sigRet, sigThrows, err := s.methodSignature(cp.GetUtf8(index))
+        if err != nil {
+            return err
+        }
+        typeParams, returnType = params, sigRet
+        if len(sigArgs) == len(args) - skip {
+            copy(types[skip:], sigArgs)
+        }
+        if len(sigThrows) > 0 {
+            throws = sigThrows
+        }
+    }
+
+    names, err := stubParameterNames(c, method, len(args))
+    if err != nil {
+        return err
+    }
+    var params []string
+    for i := skip; i < len(args); i++ {
+        typ := types[i]
+        if i == len(args) - 1 && method.Flags & FLAG_VARARGS != 0 && strings.HasSuffix(typ, "[]") {
+            typ = strings.TrimSuffix(typ, "[]") + "..."
+        }
+        param, err := s.parameterAnnotations(cp, method, i - skip)
+        if err != nil {
+            return err
+        }
+        params = append(params, param + typ + " " + names[i])
+    }
+
+    s.out.WriteString("\n")
+    if err := s.annotations(cp, method.Attributes, indent); err != nil {
+        return err
+    }
+    flags := method.Flags &^ (FLAG_NATIVE | FLAG_STRICT)
+    if classFlags.IsEnum() {
+        flags &^= FLAG_ABSTRACT
+    }
+    abstract := flags.IsAbstract()
+    modifiers := javaModifiers(flags, methodFlagNames)
+    if isInterface {
+        modifiers = strings.ReplaceAll(strings.ReplaceAll(modifiers, "public ", ""), "abstract ", "")
+        if !abstract && !method.Flags.IsStatic() {
+            modifiers = "default " + modifiers
+        }
+    }
+    text := modifiers
+    if typeParams != "" {
+        text += typeParams + " "
+    }
+    if name == "<init>" {
+        _, nested := stubSplitNested(c.Name())
+        simple := stubSimpleName(c.Name())
+        if nested != "" {
+            simple = nested[strings.LastIndex(nested, ".") + 1:]
+        }
+        text += simple
+    } else {
+        text += returnType + " " + name
+    }
+    text += "(" + strings.Join(params, ", ") + ")"
+    if len(throws) > 0 {
+        text += " throws " + strings.Join(throws, ", ")
+    }
+
+    if classFlags & FLAG_ANNOTATION != 0 {
+        value, err := method.AnnotationDefault(cp)
+        if err != nil {
+            return err
+        }
+        if value != nil {
+            text += " default " + value.Java(cp, s.imports.name)
+        }
+        s.write(indent, text + ";")
+        return nil
+    }
+    if abstract {
+        s.write(indent, text + ";")
+        return nil
+    }
+    s.write(indent, text + " {")
+    if s.decompile {
+        s.body(c, method, names, skip, indent + "    ")
+        s.write(indent, "}")
+        return nil
+    }
+    if name == "<init>" {
+        call, err := s.superCall(c)
+        if err != nil {
+            return err
+        }
+        if call != "" {
+            s.write(indent + "    ", call)
+        }
+    }
+    s.write(indent + "    ", "throw new UnsupportedOperationException();")
+    s.write(indent, "}")
+    return nil
+}
+
+func (s *stubber) body(c *Class, method *Method, names []string, skip int, indent string) {
+    for _, line := range s.methodBody(c, method, names, skip) {
+        s.write(indent, line)
+    }
+}
+
+// superCall chooses a constructor of the super class when it is in the jar
+// and has no accessible constructor without parameters, passing default
+// values cast to the parameter types to pick the overload. The constructors
+// of a record other than the canonical one delegate to it instead.
+func (s *stubber) superCall(c *Class) (string, error) {
+    if components, err := c.RecordComponents(); err == nil && components != nil {
+        var values []string
+        for i := range components {
+            arg := components[i].Descriptor(c.ConstantPool)
+            values = append(values, "(" + s.descriptorType(arg) + ") " + javaDefaultValue(arg))
+        }
+        return "this(" + strings.Join(values, ", ") + ");", nil
+    }
+    super, ok := s.classes[c.SuperName()]
+    if !ok {
+        return "", nil
+    }
+    var call string
+    for _, method := range super.Methods {
+        if super.ConstantPool.GetUtf8(method.NameIndex) != "<init>" || method.Flags & FLAG_PRIVATE != 0 {
+            continue
+        }
+        args, _, err := splitMethodDescriptor(super.ConstantPool.GetUtf8(method.DescriptorIndex))
+        if err != nil {
+            return "", fmt.Errorf("%s: %s", super.Name(), err)
+        }
+        if len(args) == 0 {
+            return "", nil
+        }
+        if call == "" {
+            var values []string
+            for _, arg := range args {
+                values = append(values, "(" + s.descriptorType(arg) + ") " + javaDefaultValue(arg))
+            }
+            call = "super(" + strings.Join(values, ", ") + ");"
+        }
+    }
+    return call, nil
+}
+
+// stubParameterNames takes the names from MethodParameters or the local
+// variable table, falling back to arg0, arg1 and so on.
+func stubParameterNames(c *Class, method *Method, count int) ([]string, error) {
+    cp := c.ConstantPool
+    names := make([]string, count)
+    for i := range names {
+        names[i] = fmt.Sprintf("arg%d", i)
+    }
+    if attr := FindAttribute(cp, method.Attributes, "MethodParameters"); attr != nil {
+        params, err := DecodeMethodParameters(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        if len(params) == count {
+            for i, param := range params {
+                if param.NameIndex != 0 && javaIdentifier(cp.GetUtf8(param.NameIndex)) {
+                    names[i] = cp.GetUtf8(param.NameIndex)
+                }
+            }
+            return stubUniqueNames(names), nil
+        }
+    }
+    code := method.Code(cp)
+    if code == nil {
+        return names, nil
+    }
+    attr := FindAttribute(cp, code.Attributes, "LocalVariableTable")
+    if attr == nil {
+        return names, nil
+    }
+    table, err := DecodeLocalVariableTable(attr.Info)
+    if err != nil {
+        return nil, err
+    }
+    args, _, err := splitMethodDescriptor(cp.GetUtf8(method.DescriptorIndex))
+    if err != nil {
+        return nil, err
+    }
+    slot := 0
+    if !method.Flags.IsStatic() {
+        slot = 1
+    }
+    for i, arg := range args {
+        for _, local := range table {
+            if local.StartPc == 0 && int(local.Index) == slot && javaIdentifier(cp.GetUtf8(local.NameIndex)) {
+                names[i] = cp.GetUtf8(local.NameIndex)
+            }
+        }
+        slot += fieldSlots(arg)
+    }
+    return stubUniqueNames(names), nil
+}
+
+func stubUniqueNames(names []string) []string {
+    seen := make(map[string]bool)
+    for i, name := range names {
+        if seen[name] {
+            names[i] = fmt.Sprintf("arg%d", i)
+        }
+        seen[names[i]] = true
+    }
+    return names
+}
+
+func (s *stubber) annotations(cp *ConstantPool, attrs []Attribute, indent string) error {
+    annotations, err := FindAnnotations(cp, attrs)
+    if err != nil {
+        return err
+    }
+    for _, a := range annotations {
+        s.write(indent, a.Java(cp, s.imports.name))
+    }
+    return nil
+}
+
+func (s *stubber) parameterAnnotations(cp *ConstantPool, method *Method, index int) (string, error) {
+    params, err := method.ParameterAnnotations(cp)
+    if err != nil || index >= len(params) {
+        return "", err
+    }
+    text := ""
+    for _, a := range params[index] {
+        text += a.Java(cp, s.imports.name) + " "
+    }
+    return text, nil
+}
+
+// descriptorType renders a field descriptor as a Java type, importing the
+// classes it names.
+func (s *stubber) descriptorType(d string) string {
+    t, err := descriptor.ParseReturn(d)
+    if err != nil {
+        return d
+    }
+    return t.JavaName(s.imports.name)
+}
+
+// javaLiteral writes the constant at index as a Java literal of the type of
+// the field descriptor.
+func javaLiteral(cp *ConstantPool, index CpIndex, descriptor string) string {
+    switch c := (*cp.Get(index)).(type) {
+    case ConstInteger:
+        switch descriptor {
+        case "Z":
+            return strconv.FormatBool(c.Value != 0)
+        case "C":
+            return javaCharLiteral(rune(uint16(c.Value)))
+        case "B":
+            return "(byte) " + strconv.Itoa(int(int8(c.Value)))
+        case "S":
+            return "(short) " + strconv.Itoa(int(int16(c.Value)))
+        }
+        return strconv.Itoa(int(c.Value))
+    case ConstLong:
+        return strconv.FormatInt(c.Value, 10) + "L"
+    case ConstFloat:
+        return javaFloatLiteral(float64(c.Value), 32)
+    case ConstDouble:
+        return javaFloatLiteral(c.Value, 64)
+    case ConstString:
+        return javaStringLiteral(cp.GetUtf8(c.StringIndex))
+    }
+    return javaDefaultValue(descriptor)
+}
+
+// javaFloatLiteral writes a float or double literal with its suffix, using
+// a constant expression for NaN and the infinities.
+func javaFloatLiteral(f float64, bits int) string {
+    suffix := "d"
+    if bits == 32 {
+        suffix = "f"
+    }
+    switch {
+    case math.IsNaN(f):
+        return "(0.0" + suffix + " / 0.0" + suffix + ")"
+    case math.IsInf(f, 1):
+        return "(1.0" + suffix + " / 0.0" + suffix + ")"
+    case math.IsInf(f, -1):
+        return "(-1.0" + suffix + " / 0.0" + suffix + ")"
+    }
+    return javaFloat(f, bits) + suffix
+}
+
+func javaDefaultValue(descriptor string) string {
+    switch descriptor {
+    case "Z":
+        return "false"
+    case "C":
+        return "'\\0'"
+    case "J":
+        return "0L"
+    case "F":
+        return "0.0f"
+    case "D":
+        return "0.0d"
+    case "B", "S", "I":
+        return "0"
+    }
+    return "null"
+}
+
+func javaStringLiteral(s string) string {
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for _, r := range s {
+        sb.WriteString(javaEscape(r, '"'))
+    }
+    sb.WriteByte('"')
+    return sb.String()
+}
+
+func javaCharLiteral(r rune) string {
+    return "'" + javaEscape(r, '\'') + "'"
+}
+
+func javaEscape(r rune, quote rune) string {
+    switch r {
+    case '\n':
+        return "\\n"
+    case '\t':
+        return "\\t"
+    case '\r':
+        return "\\r"
+    case '\b':
+        return "\\b"
+    case '\f':
+        return "\\f"
+    case '\\':
+        return "\\\\"
+    case quote:
+        return "\\" + string(quote)
+    }
+    if r < 0x20 || r >= 0x7f && r <= 0xffff {
+        return fmt.Sprintf("\\u%04x", r)
+    }
+    if r > 0xffff {
+        r -= 0x10000
+        return fmt.Sprintf("\\u%04x\\u%04x", 0xd800 + (r >> 10), 0xdc00 + (r & 0x3ff))
+    }
+    return string(r)
+}
+
+var javaKeywords = map[string]bool{
+    "abstract": true, "assert": true, "boolean": true, "break": true, "byte": true, "case": true,
+    "catch": true, "char": true, "class": true, "const": true, "continue": true, "default": true,
+    "do": true, "double": true, "else": true, "enum": true, "extends": true, "final": true,
+    "finally": true, "float": true, "for": true, "goto": true, "if": true, "implements": true,
+    "import": true, "instanceof": true, "int": true, "interface": true, "long": true, "native": true,
+    "new": true, "package": true, "private": true, "protected": true, "public": true, "return": true,
+    "short": true, "static": true, "strictfp": true, "super": true, "switch": true, "synchronized": true,
+    "this": true, "throw": true, "throws": true, "transient": true, "try": true, "void": true,
+    "volatile": true, "while": true, "true": true, "false": true, "null": true, "_": true,
+}
+
+func javaIdentifier(name string) bool {
+    if name == "" || javaKeywords[name] {
+        return false
+    }
+    for i, r := range name {
+        letter := r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f
+        if !letter && (i == 0 || r < '0' || r > '9') {
+            return false
+        }
+    }
+    return true
+}
+
+// classSignature renders the parts of a class signature as Java source,
+// importing the classes it names.
+func (s *stubber) classSignature(text string) (params string, super string, interfaces []string, err error) {
+    sig, err := signature.ParseClass(text)
+    if err != nil {
+        return
+    }
+    params, super = sig.TypeParams.JavaName(s.imports.name), sig.Super.JavaName(s.imports.name)
+    for _, iface := range sig.Interfaces {
+        interfaces = append(interfaces, iface.JavaName(s.imports.name))
+    }
+    return
+}
+
+func (s *stubber) methodSignature(text string) (params string, args []string, ret string, throws []string, err error) {
+    sig, err := signature.ParseMethod(text)
+    if err != nil {
+        return
+    }
+    params, ret = sig.TypeParams.JavaName(s.imports.name), sig.Result.JavaName(s.imports.name)
+    for _, param := range sig.Params {
+        args = append(args, param.JavaName(s.imports.name))
+    }
+    for _, t := range sig.Throws {
+        throws = append(throws, t.JavaName(s.imports.name))
+    }
+    return
+}
+
+func (s *stubber) typeSignature(text string) (string, error) {
+    sig, err := signature.ParseField(text)
+    if err != nil {
+        return "", err
+    }
+    return sig.JavaName(s.imports.name), nil
+}
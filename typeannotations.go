@@ -0,0 +1,693 @@
+package jcr
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+    "github.com/jasonhightower/jcr/signature"
+    . "github.com/jasonhightower/bytecode"
+)
+
+// The target_type values of a type annotation, which say whether it is on a
+// declaration in the class, field or method, or on a type in the code.
+const (
+    TargetClassTypeParameter byte = 0x00
+    TargetMethodTypeParameter byte = 0x01
+    TargetSupertype byte = 0x10
+    TargetClassTypeParameterBound byte = 0x11
+    TargetMethodTypeParameterBound byte = 0x12
+    TargetField byte = 0x13
+    TargetReturn byte = 0x14
+    TargetReceiver byte = 0x15
+    TargetFormalParameter byte = 0x16
+    TargetThrows byte = 0x17
+    TargetLocalVariable byte = 0x40
+    TargetResourceVariable byte = 0x41
+    TargetExceptionParameter byte = 0x42
+    TargetInstanceof byte = 0x43
+    TargetNew byte = 0x44
+    TargetConstructorReference byte = 0x45
+    TargetMethodReference byte = 0x46
+    TargetCast byte = 0x47
+    TargetConstructorInvocationTypeArgument byte = 0x48
+    TargetMethodInvocationTypeArgument byte = 0x49
+    TargetConstructorReferenceTypeArgument byte = 0x4A
+    TargetMethodReferenceTypeArgument byte = 0x4B
+)
+
+var targetNames = map[byte]string{
+    TargetClassTypeParameter: "CLASS_TYPE_PARAMETER", TargetMethodTypeParameter: "METHOD_TYPE_PARAMETER",
+    TargetSupertype: "CLASS_EXTENDS", TargetClassTypeParameterBound: "CLASS_TYPE_PARAMETER_BOUND",
+    TargetMethodTypeParameterBound: "METHOD_TYPE_PARAMETER_BOUND", TargetField: "FIELD",
+    TargetReturn: "METHOD_RETURN", TargetReceiver: "METHOD_RECEIVER", TargetFormalParameter: "METHOD_FORMAL_PARAMETER",
+    TargetThrows: "THROWS", TargetLocalVariable: "LOCAL_VARIABLE", TargetResourceVariable: "RESOURCE_VARIABLE",
+    TargetExceptionParameter: "EXCEPTION_PARAMETER", TargetInstanceof: "INSTANCEOF", TargetNew: "NEW",
+    TargetConstructorReference: "CONSTRUCTOR_REFERENCE", TargetMethodReference: "METHOD_REFERENCE",
+    TargetCast: "CAST", TargetConstructorInvocationTypeArgument: "CONSTRUCTOR_INVOCATION_TYPE_ARGUMENT",
+    TargetMethodInvocationTypeArgument: "METHOD_INVOCATION_TYPE_ARGUMENT",
+    TargetConstructorReferenceTypeArgument: "CONSTRUCTOR_REFERENCE_TYPE_ARGUMENT",
+    TargetMethodReferenceTypeArgument: "METHOD_REFERENCE_TYPE_ARGUMENT",
+}
+
+// The type_path_kind values of the steps of a type path.
+const (
+    TypePathArray byte = 0
+    TypePathNested byte = 1
+    TypePathWildcard byte = 2
+    TypePathTypeArgument byte = 3
+)
+
+// TypeAnnotation is an entry of RuntimeVisibleTypeAnnotations or
+// RuntimeInvisibleTypeAnnotations. The target_info fields in use depend on
+// TargetType: TypeParameterIndex and BoundIndex for type parameters and
+// their bounds, SupertypeIndex (65535 for the superclass), the
+// FormalParameterIndex, ThrowsTypeIndex, LocalVariables for locals and
+// resources, ExceptionTableIndex for catch parameters and Offset, with
+// TypeArgumentIndex for explicit type arguments, for types in the code.
+type TypeAnnotation struct {
+    TargetType byte
+    TypeParameterIndex uint8
+    BoundIndex uint8
+    SupertypeIndex uint16
+    FormalParameterIndex uint8
+    ThrowsTypeIndex uint16
+    LocalVariables []LocalVariableTarget
+    ExceptionTableIndex uint16
+    Offset uint16
+    TypeArgumentIndex uint8
+    Path []TypePathEntry
+    Annotation
+}
+
+// LocalVariableTarget is a range of code in which a local variable of an
+// annotated type lives in slot Index.
+type LocalVariableTarget struct {
+    StartPc uint16
+    Length uint16
+    Index uint16
+}
+
+// TypePathEntry is a step of a type path. ArgumentIndex is used by
+// TypePathTypeArgument steps.
+type TypePathEntry struct {
+    Kind byte
+    ArgumentIndex uint8
+}
+
+func DecodeTypeAnnotations(info []byte) (annotations []TypeAnnotation, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            annotations = nil
+            err = fmt.Errorf("Malformed type annotations attribute: %v", r)
+        }
+    }()
+    var r io.Reader = bytes.NewReader(info)
+    var count uint16
+    mustRead(&r, &count)
+    annotations = make([]TypeAnnotation, count)
+    for i := range annotations {
+        annotations[i] = mustReadTypeAnnotation(&r)
+    }
+    return annotations, nil
+}
+
+func mustReadTypeAnnotation(r *io.Reader) TypeAnnotation {
+    var a TypeAnnotation
+    mustRead(r, &a.TargetType)
+    switch a.TargetType {
+    case TargetClassTypeParameter, TargetMethodTypeParameter:
+        mustRead(r, &a.TypeParameterIndex)
+    case TargetSupertype:
+        mustRead(r, &a.SupertypeIndex)
+    case TargetClassTypeParameterBound, TargetMethodTypeParameterBound:
+        mustRead(r, &a.TypeParameterIndex)
+        mustRead(r, &a.BoundIndex)
+    case TargetField, TargetReturn, TargetReceiver:
+    case TargetFormalParameter:
+        mustRead(r, &a.FormalParameterIndex)
+    case TargetThrows:
+        mustRead(r, &a.ThrowsTypeIndex)
+    case TargetLocalVariable, TargetResourceVariable:
+        var count uint16
+        mustRead(r, &count)
+        a.LocalVariables = make([]LocalVariableTarget, count)
+        mustRead(r, &a.LocalVariables)
+    case TargetExceptionParameter:
+        mustRead(r, &a.ExceptionTableIndex)
+    case TargetInstanceof, TargetNew, TargetConstructorReference, TargetMethodReference:
+        mustRead(r, &a.Offset)
+    case TargetCast, TargetConstructorInvocationTypeArgument, TargetMethodInvocationTypeArgument,
+        TargetConstructorReferenceTypeArgument, TargetMethodReferenceTypeArgument:
+        mustRead(r, &a.Offset)
+        mustRead(r, &a.TypeArgumentIndex)
+    default:
+        panic(fmt.Sprintf("unknown target type 0x%02x", a.TargetType))
+    }
+    var length uint8
+    mustRead(r, &length)
+    a.Path = make([]TypePathEntry, length)
+    mustRead(r, &a.Path)
+    a.Annotation = mustReadAnnotation(r)
+    return a
+}
+
+// EncodeTypeAnnotations fails on an unknown target type, whose target_info
+// it could not write, and on malformed annotations.
+func EncodeTypeAnnotations(annotations []TypeAnnotation) (info []byte, err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            info = nil
+            err = fmt.Errorf("Unable to encode type annotations: %v", r)
+        }
+    }()
+    var buf bytes.Buffer
+    var w io.Writer = &buf
+    mustWrite(&w, uint16(len(annotations)))
+    for _, a := range annotations {
+        mustWrite(&w, a.TargetType)
+        switch a.TargetType {
+        case TargetClassTypeParameter, TargetMethodTypeParameter:
+            mustWrite(&w, a.TypeParameterIndex)
+        case TargetSupertype:
+            mustWrite(&w, a.SupertypeIndex)
+        case TargetClassTypeParameterBound, TargetMethodTypeParameterBound:
+            mustWrite(&w, a.TypeParameterIndex)
+            mustWrite(&w, a.BoundIndex)
+        case TargetFormalParameter:
+            mustWrite(&w, a.FormalParameterIndex)
+        case TargetThrows:
+            mustWrite(&w, a.ThrowsTypeIndex)
+        case TargetLocalVariable, TargetResourceVariable:
+            mustWrite(&w, uint16(len(a.LocalVariables)))
+            mustWrite(&w, a.LocalVariables)
+        case TargetExceptionParameter:
+            mustWrite(&w, a.ExceptionTableIndex)
+        case TargetInstanceof, TargetNew, TargetConstructorReference, TargetMethodReference:
+            mustWrite(&w, a.Offset)
+        case TargetCast, TargetConstructorInvocationTypeArgument, TargetMethodInvocationTypeArgument,
+            TargetConstructorReferenceTypeArgument, TargetMethodReferenceTypeArgument:
+            mustWrite(&w, a.Offset)
+            mustWrite(&w, a.TypeArgumentIndex)
+        default:
+            panic(fmt.Sprintf("unknown target type 0x%02x", a.TargetType))
+        }
+        mustWrite(&w, uint8(len(a.Path)))
+        mustWrite(&w, a.Path)
+        mustWriteAnnotation(&w, a.Annotation)
+    }
+    return buf.Bytes(), nil
+}
+
+// FindTypeAnnotations decodes both the RuntimeVisibleTypeAnnotations and the
+// RuntimeInvisibleTypeAnnotations in attrs.
+func FindTypeAnnotations(cp *ConstantPool, attrs []Attribute) ([]TypeAnnotation, error) {
+    var annotations []TypeAnnotation
+    for _, name := range []string{"RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations"} {
+        attr := FindAttribute(cp, attrs, name)
+        if attr == nil {
+            continue
+        }
+        found, err := DecodeTypeAnnotations(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        annotations = append(annotations, found...)
+    }
+    return annotations, nil
+}
+
+// TargetName returns the name javap gives the target type, e.g. FIELD.
+func (a TypeAnnotation) TargetName() string {
+    if name, ok := targetNames[a.TargetType]; ok {
+        return name
+    }
+    return fmt.Sprintf("0x%02x", a.TargetType)
+}
+
+// PathString renders the type path the way javap does, e.g.
+// [TYPE_ARGUMENT(0), ARRAY], or "" for an empty path.
+func (a TypeAnnotation) PathString() string {
+    if len(a.Path) == 0 {
+        return ""
+    }
+    var steps []string
+    for _, step := range a.Path {
+        switch step.Kind {
+        case TypePathArray:
+            steps = append(steps, "ARRAY")
+        case TypePathNested:
+            steps = append(steps, "INNER_TYPE")
+        case TypePathWildcard:
+            steps = append(steps, "WILDCARD")
+        default:
+            steps = append(steps, fmt.Sprintf("TYPE_ARGUMENT(%d)", step.ArgumentIndex))
+        }
+    }
+    return "[" + strings.Join(steps, ", ") + "]"
+}
+
+// TypeLocation is the part of a type a type path leads to. Type is the
+// annotated type and, for a class type, Segment is the index in its Path of
+// the class the annotation is on; TypePathNested steps move to the next
+// one. Class types are given one segment per nested class. A path ending at
+// a wildcard annotates the wildcard itself, which is left in Wildcard with
+// Type nil.
+type TypeLocation struct {
+    Type signature.Type
+    Segment int
+    Wildcard *signature.TypeArgument
+}
+
+// LocateTypePath follows a type path from t. inners are the InnerClasses
+// entries of the class the annotation is found in, which tell the nested
+// classes of t from classes whose names merely contain $, and which of them
+// are inner classes: TypePathNested steps only lead into inner classes, so
+// that the path to a static nested class such as Map.Entry is empty (JVMS
+// 4.7.20.2).
+func LocateTypePath(cp *ConstantPool, inners []InnerClass, t signature.Type, path []TypePathEntry) (TypeLocation, error) {
+    nesting := make(map[string]InnerClass)
+    for _, inner := range inners {
+        if inner.OuterClassIndex != 0 && inner.InnerNameIndex != 0 {
+            nesting[cp.GetClassName(inner.InnerClassIndex)] = inner
+        }
+    }
+    at := func(t signature.Type) (TypeLocation, error) {
+        class, ok := t.(*signature.ClassType)
+        if !ok {
+            return TypeLocation{Type: t}, nil
+        }
+        nested, start, err := nestClassType(cp, nesting, class)
+        return TypeLocation{Type: nested, Segment: start}, err
+    }
+    loc, err := at(t)
+    if err != nil {
+        return loc, err
+    }
+    for i, step := range path {
+        class, _ := loc.Type.(*signature.ClassType)
+        switch {
+        case step.Kind == TypePathArray:
+            if array, ok := loc.Type.(*signature.ArrayType); ok {
+                if loc, err = at(array.Elem); err != nil {
+                    return loc, err
+                }
+                continue
+            }
+        case step.Kind == TypePathNested:
+            if class != nil && loc.Segment + 1 < len(class.Path) {
+                loc.Segment++
+                continue
+            }
+        case step.Kind == TypePathWildcard:
+            if loc.Wildcard != nil && loc.Wildcard.Type != nil {
+                if loc, err = at(loc.Wildcard.Type); err != nil {
+                    return loc, err
+                }
+                continue
+            }
+        case step.Kind == TypePathTypeArgument:
+            if class != nil && int(step.ArgumentIndex) < len(class.Path[loc.Segment].Args) {
+                arg := &class.Path[loc.Segment].Args[step.ArgumentIndex]
+                if arg.Wildcard != 0 {
+                    loc = TypeLocation{Wildcard: arg}
+                } else if loc, err = at(arg.Type); err != nil {
+                    return loc, err
+                }
+                continue
+            }
+        }
+        return loc, fmt.Errorf("step %d of type path %v does not apply to %v", i, path, t)
+    }
+    return loc, nil
+}
+
+// nestClassType splits a class type into a segment per class, going by
+// nesting, the InnerClasses entries by class name, where it has them and by
+// the segments of t otherwise. It returns the new type with the index of the
+// segment an empty type path refers to: the innermost class that is not an
+// inner class. Entries whose outer classes lead back to a class already on
+// the chain are reported as an error.
+func nestClassType(cp *ConstantPool, nesting map[string]InnerClass, t *signature.ClassType) (*signature.ClassType, int, error) {
+    type class struct {
+        name string
+        simple string
+        inner bool
+        args []signature.TypeArgument
+    }
+    names := make([]string, len(t.Path))
+    args := make(map[string][]signature.TypeArgument)
+    for i, segment := range t.Path {
+        names[i] = segment.Name
+        if i > 0 {
+            names[i] = names[i - 1] + "$" + segment.Name
+        }
+        args[names[i]] = segment.Args
+    }
+    // from the innermost class out
+    var chain []class
+    visited := make(map[string]bool)
+    for name, i := names[len(names) - 1], len(names) - 1; ; {
+        if visited[name] {
+            return nil, 0, fmt.Errorf("InnerClasses entries of %s form a cycle", name)
+        }
+        visited[name] = true
+        c := class{name: name, simple: name, args: args[name]}
+        if inner, ok := nesting[name]; ok {
+            c.simple = cp.GetUtf8(inner.InnerNameIndex)
+            c.inner = inner.Flags & (FLAG_STATIC | FLAG_INTERFACE) == 0
+            chain = append(chain, c)
+            name = cp.GetClassName(inner.OuterClassIndex)
+            continue
+        }
+        for i >= 0 && names[i] != name {
+            i--
+        }
+        if i <= 0 {
+            chain = append(chain, c)
+            break
+        }
+        // a segment of the type with no entry of its own
+        c.simple, c.inner = t.Path[i].Name, true
+        chain = append(chain, c)
+        name = names[i - 1]
+    }
+
+    nested := &signature.ClassType{}
+    start := 0
+    for i := len(chain) - 1; i >= 0; i-- {
+        c := chain[i]
+        name := c.simple
+        if i == len(chain) - 1 {
+            name = c.name
+        }
+        nested.Path = append(nested.Path, signature.SimpleClassType{Name: name, Args: c.args})
+        if !c.inner {
+            start = len(nested.Path) - 1
+        }
+    }
+    return nested, start, nil
+}
+
+// AnnotatedType is a type annotation together with the type its target
+// selects and the location its type path leads to within that type. Type
+// is nil where the class file does not record the type, as for the type
+// arguments of generic method calls.
+type AnnotatedType struct {
+    TypeAnnotation
+    Type signature.Type
+    Location TypeLocation
+}
+
+// annotatedTypes locates each of the annotations in the type target returns
+// for it.
+func annotatedTypes(c *Class, annotations []TypeAnnotation, target func(a TypeAnnotation) (signature.Type, error)) ([]AnnotatedType, error) {
+    inners, err := c.InnerClasses()
+    if err != nil {
+        return nil, err
+    }
+    var result []AnnotatedType
+    for _, a := range annotations {
+        t, err := target(a)
+        if err != nil {
+            return nil, fmt.Errorf("%s type annotation: %v", a.TargetName(), err)
+        }
+        annotated := AnnotatedType{TypeAnnotation: a, Type: t}
+        if t != nil {
+            if annotated.Location, err = LocateTypePath(c.ConstantPool, inners, t, a.Path); err != nil {
+                return nil, err
+            }
+        }
+        result = append(result, annotated)
+    }
+    return result, nil
+}
+
+// fieldSignatureType returns the type of the generic signature in attrs if
+// there is one and the type of the descriptor otherwise.
+func fieldSignatureType(cp *ConstantPool, attrs []Attribute, d string) (signature.Type, error) {
+    if sig := javapSignature(cp, attrs); sig != "" {
+        return signature.ParseField(sig)
+    }
+    t, err := descriptor.ParseField(d)
+    if err != nil {
+        return nil, err
+    }
+    return signature.FromDescriptor(t), nil
+}
+
+func (c *Class) signature() (*signature.ClassSignature, error) {
+    cp := c.ConstantPool
+    if sig := javapSignature(cp, c.Attributes); sig != "" {
+        return signature.ParseClass(sig)
+    }
+    sig := &signature.ClassSignature{}
+    if super, ok := signature.ClassOf(c.SuperName()).(*signature.ClassType); ok && c.SuperName() != "" {
+        sig.Super = super
+    }
+    for _, iface := range c.Interfaces {
+        if t, ok := signature.ClassOf(cp.GetClassName(iface)).(*signature.ClassType); ok {
+            sig.Interfaces = append(sig.Interfaces, t)
+        }
+    }
+    return sig, nil
+}
+
+// typeParameterBound returns a bound of a type parameter, where index 0 is
+// the class bound and the interface bounds follow.
+func typeParameterBound(params signature.TypeParameters, a TypeAnnotation) (signature.Type, error) {
+    if int(a.TypeParameterIndex) >= len(params) {
+        return nil, fmt.Errorf("no type parameter %d", a.TypeParameterIndex)
+    }
+    param := params[a.TypeParameterIndex]
+    bounds := append([]signature.Type{param.ClassBound}, param.InterfaceBounds...)
+    if int(a.BoundIndex) >= len(bounds) || bounds[a.BoundIndex] == nil {
+        return nil, fmt.Errorf("no bound %d of type parameter %s", a.BoundIndex, param.Name)
+    }
+    return bounds[a.BoundIndex], nil
+}
+
+// AnnotatedTypes returns the type annotations on the type parameters of the
+// class and on the types it extends and implements.
+func (c *Class) AnnotatedTypes() ([]AnnotatedType, error) {
+    annotations, err := FindTypeAnnotations(c.ConstantPool, c.Attributes)
+    if err != nil || len(annotations) == 0 {
+        return nil, err
+    }
+    sig, err := c.signature()
+    if err != nil {
+        return nil, err
+    }
+    return annotatedTypes(c, annotations, func(a TypeAnnotation) (signature.Type, error) {
+        switch a.TargetType {
+        case TargetClassTypeParameter:
+            if int(a.TypeParameterIndex) >= len(sig.TypeParams) {
+                return nil, fmt.Errorf("no type parameter %d", a.TypeParameterIndex)
+            }
+            return &signature.TypeVariable{Name: sig.TypeParams[a.TypeParameterIndex].Name}, nil
+        case TargetClassTypeParameterBound:
+            return typeParameterBound(sig.TypeParams, a)
+        case TargetSupertype:
+            if a.SupertypeIndex == 65535 && sig.Super != nil {
+                return sig.Super, nil
+            }
+            if int(a.SupertypeIndex) < len(sig.Interfaces) {
+                return sig.Interfaces[a.SupertypeIndex], nil
+            }
+            return nil, fmt.Errorf("no supertype %d", a.SupertypeIndex)
+        }
+        return nil, fmt.Errorf("not a class target")
+    })
+}
+
+// AnnotatedTypes returns the type annotations on the type of a field of c.
+func (f *Field) AnnotatedTypes(c *Class) ([]AnnotatedType, error) {
+    cp := c.ConstantPool
+    annotations, err := FindTypeAnnotations(cp, f.Attributes)
+    if err != nil || len(annotations) == 0 {
+        return nil, err
+    }
+    t, err := fieldSignatureType(cp, f.Attributes, cp.GetUtf8(f.DescriptorIndex))
+    if err != nil {
+        return nil, err
+    }
+    return annotatedTypes(c, annotations, func(a TypeAnnotation) (signature.Type, error) {
+        if a.TargetType != TargetField {
+            return nil, fmt.Errorf("not a field target")
+        }
+        return t, nil
+    })
+}
+
+// AnnotatedTypes returns the type annotations of a method of c, both those
+// in its signature and those on the types of locals and expressions in its
+// code.
+func (m *Method) AnnotatedTypes(c *Class) ([]AnnotatedType, error) {
+    cp := c.ConstantPool
+    annotations, err := FindTypeAnnotations(cp, m.Attributes)
+    if err != nil {
+        return nil, err
+    }
+    code := m.Code(cp)
+    if code != nil {
+        inCode, err := FindTypeAnnotations(cp, code.Attributes)
+        if err != nil {
+            return nil, err
+        }
+        annotations = append(annotations, inCode...)
+    }
+    if len(annotations) == 0 {
+        return nil, nil
+    }
+
+    sig := &signature.MethodSignature{}
+    if text := javapSignature(cp, m.Attributes); text != "" {
+        if sig, err = signature.ParseMethod(text); err != nil {
+            return nil, err
+        }
+    }
+    d, err := descriptor.ParseMethod(cp.GetUtf8(m.DescriptorIndex))
+    if err != nil {
+        return nil, err
+    }
+    if sig.Result == nil {
+        sig.Result = signature.FromDescriptor(d.Return)
+        for _, arg := range d.Args {
+            sig.Params = append(sig.Params, signature.FromDescriptor(arg))
+        }
+    }
+    if len(sig.Throws) == 0 {
+        if attr := FindAttribute(cp, m.Attributes, "Exceptions"); attr != nil {
+            exceptions, err := DecodeExceptions(attr.Info)
+            if err != nil {
+                return nil, err
+            }
+            for _, exception := range exceptions {
+                sig.Throws = append(sig.Throws, signature.ClassOf(cp.GetClassName(exception)))
+            }
+        }
+    }
+    var instrs []Instruction
+    if code != nil {
+        if instrs, err = DecodeInstructions(code.ByteCode); err != nil {
+            return nil, err
+        }
+    }
+
+    return annotatedTypes(c, annotations, func(a TypeAnnotation) (signature.Type, error) {
+        switch a.TargetType {
+        case TargetMethodTypeParameter:
+            if int(a.TypeParameterIndex) >= len(sig.TypeParams) {
+                return nil, fmt.Errorf("no type parameter %d", a.TypeParameterIndex)
+            }
+            return &signature.TypeVariable{Name: sig.TypeParams[a.TypeParameterIndex].Name}, nil
+        case TargetMethodTypeParameterBound:
+            return typeParameterBound(sig.TypeParams, a)
+        case TargetReturn:
+            return sig.Result, nil
+        case TargetReceiver:
+            return c.receiverType()
+        case TargetFormalParameter:
+            if int(a.FormalParameterIndex) >= len(sig.Params) {
+                return nil, fmt.Errorf("no parameter %d", a.FormalParameterIndex)
+            }
+            return sig.Params[a.FormalParameterIndex], nil
+        case TargetThrows:
+            if int(a.ThrowsTypeIndex) >= len(sig.Throws) {
+                return nil, fmt.Errorf("no thrown type %d", a.ThrowsTypeIndex)
+            }
+            return sig.Throws[a.ThrowsTypeIndex], nil
+        case TargetLocalVariable, TargetResourceVariable:
+            return localVariableType(cp, code, a.LocalVariables)
+        case TargetExceptionParameter:
+            if code == nil || int(a.ExceptionTableIndex) >= len(code.ExceptionHandlers) {
+                return nil, fmt.Errorf("no exception handler %d", a.ExceptionTableIndex)
+            }
+            handler := code.ExceptionHandlers[a.ExceptionTableIndex]
+            if handler.IsFinally() {
+                return signature.ClassOf("java/lang/Throwable"), nil
+            }
+            return signature.ClassOf(cp.GetClassName(handler.CatchType)), nil
+        case TargetInstanceof, TargetNew, TargetCast:
+            for _, instr := range instrs {
+                if instr.Offset == int(a.Offset) {
+                    return instructionType(cp, instr)
+                }
+            }
+            return nil, fmt.Errorf("no instruction at offset %d", a.Offset)
+        }
+        // method references and explicit type arguments are not recorded
+        return nil, nil
+    })
+}
+
+// receiverType is the type of this, parameterized by the class's own type
+// variables.
+func (c *Class) receiverType() (signature.Type, error) {
+    sig, err := c.signature()
+    if err != nil {
+        return nil, err
+    }
+    t := signature.ClassOf(c.Name())
+    if class, ok := t.(*signature.ClassType); ok && len(sig.TypeParams) > 0 {
+        last := &class.Path[len(class.Path) - 1]
+        for _, param := range sig.TypeParams {
+            last.Args = append(last.Args, signature.TypeArgument{Type: &signature.TypeVariable{Name: param.Name}})
+        }
+    }
+    return t, nil
+}
+
+// localVariableType finds the type of a local variable in the
+// LocalVariableTypeTable, or the LocalVariableTable when it is not generic.
+func localVariableType(cp *ConstantPool, code *Code, ranges []LocalVariableTarget) (signature.Type, error) {
+    if code == nil || len(ranges) == 0 {
+        return nil, fmt.Errorf("no local variable")
+    }
+    target := ranges[0]
+    for _, name := range []string{"LocalVariableTypeTable", "LocalVariableTable"} {
+        attr := FindAttribute(cp, code.Attributes, name)
+        if attr == nil {
+            continue
+        }
+        table, err := DecodeLocalVariableTable(attr.Info)
+        if err != nil {
+            return nil, err
+        }
+        for _, local := range table {
+            if local.Index != target.Index || local.StartPc != target.StartPc || local.Length != target.Length {
+                continue
+            }
+            if name == "LocalVariableTypeTable" {
+                return signature.ParseField(cp.GetUtf8(local.DescriptorIndex))
+            }
+            t, err := descriptor.ParseField(cp.GetUtf8(local.DescriptorIndex))
+            if err != nil {
+                return nil, err
+            }
+            return signature.FromDescriptor(t), nil
+        }
+    }
+    // without debug information the type is unknown
+    return nil, nil
+}
+
+// instructionType returns the type an instanceof, checkcast or object or
+// array creation names.
+func instructionType(cp *ConstantPool, instr Instruction) (signature.Type, error) {
+    switch instr.Opcode {
+    case Instanceof, Checkcast, New, Multianewarray:
+        return signature.ClassOf(cp.GetClassName(instr.Index())), nil
+    case Anewarray:
+        return &signature.ArrayType{Elem: signature.ClassOf(cp.GetClassName(instr.Index()))}, nil
+    case Newarray:
+        if base := newarrayTypes[instr.Operands[0]]; base != 0 {
+            return &signature.ArrayType{Elem: signature.BaseType(base)}, nil
+        }
+    }
+    return nil, fmt.Errorf("%s at offset %d names no type", Mnemonic(instr.Opcode), instr.Offset)
+}
+
+// newarrayTypes maps the atype operand of newarray to the element descriptor.
+var newarrayTypes = map[byte]byte{4: 'Z', 5: 'C', 6: 'F', 7: 'D', 8: 'B', 9: 'S', 10: 'I', 11: 'J'}
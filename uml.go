@@ -0,0 +1,397 @@
+package jcr
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+    "github.com/jasonhightower/jcr/descriptor"
+)
+
+const (
+    UMLPlantUML string = "plantuml"
+    UMLMermaid string = "mermaid"
+)
+
+// UMLOptions selects the notation of a class diagram and what it shows.
+// Packages are dotted prefixes such as com.example; Visibility is the least
+// visible access shown, one of public, protected, package and private.
+type UMLOptions struct {
+    Format string
+    Packages []string
+    Visibility string
+}
+
+var umlVisibilities = map[string]int{"private": 0, "package": 1, "protected": 2, "public": 3}
+
+// WriteUML writes a class diagram of classes. Besides inheritance and
+// implementation, a field whose type (or array element type) is another
+// class of the diagram draws an association labelled with the field name.
+func WriteUML(w *io.Writer, classes []*Class, opts UMLOptions) error {
+    if opts.Format == "" {
+        opts.Format = UMLPlantUML
+    }
+    if opts.Format != UMLPlantUML && opts.Format != UMLMermaid {
+        return fmt.Errorf("unknown diagram format %s", opts.Format)
+    }
+    if opts.Visibility == "" {
+        opts.Visibility = "private"
+    }
+    least, ok := umlVisibilities[opts.Visibility]
+    if !ok {
+        return fmt.Errorf("unknown visibility %s", opts.Visibility)
+    }
+
+    u := &umlDiagram{opts: opts, least: least, selected: make(map[string]bool)}
+    for _, c := range classes {
+        if u.include(c) {
+            u.classes = append(u.classes, c)
+            u.selected[c.Name()] = true
+        }
+    }
+    sort.Slice(u.classes, func(i, j int) bool { return u.classes[i].Name() < u.classes[j].Name() })
+    u.memberLists = make(map[string][]umlMember)
+    for _, c := range u.classes {
+        members, err := u.members(c)
+        if err != nil {
+            return fmt.Errorf("%s: %s", c.Name(), err)
+        }
+        u.memberLists[c.Name()] = members
+    }
+
+    var lines []string
+    if opts.Format == UMLPlantUML {
+        lines = u.plantUML()
+    } else {
+        lines = u.mermaid()
+    }
+    for _, line := range lines {
+        io.WriteString(*w, line + "\n")
+    }
+    return nil
+}
+
+type umlDiagram struct {
+    opts UMLOptions
+    least int
+    classes []*Class
+    selected map[string]bool
+    memberLists map[string][]umlMember
+}
+
+func (u *umlDiagram) include(c *Class) bool {
+    if c.Flags & FLAG_SYNTHETIC != 0 || strings.HasSuffix(c.Name(), "module-info") {
+        return false
+    }
+    flags := c.Flags
+    if inner, ok := innerClassEntry(c, c.Name()); ok {
+        if inner.InnerNameIndex == 0 {
+            // anonymous classes are implementation details
+            return false
+        }
+        flags = inner.Flags
+    }
+    if umlVisibility(flags) < u.least {
+        return false
+    }
+    if len(u.opts.Packages) == 0 {
+        return true
+    }
+    for _, prefix := range u.opts.Packages {
+        prefix = strings.ReplaceAll(prefix, ".", "/")
+        if strings.HasPrefix(c.Name(), prefix + "/") || c.Name() == prefix {
+            return true
+        }
+    }
+    return false
+}
+
+func umlVisibility(f AccessFlag) int {
+    switch {
+    case f & FLAG_PUBLIC != 0:
+        return 3
+    case f & FLAG_PROTECTED != 0:
+        return 2
+    case f & FLAG_PRIVATE != 0:
+        return 0
+    }
+    return 1
+}
+
+func umlVisibilitySymbol(f AccessFlag) string {
+    return []string{"-", "~", "#", "+"}[umlVisibility(f)]
+}
+
+// umlID turns a class name into an identifier both notations accept. The
+// escapes start and end with _, which is itself doubled, so that different
+// names never share an identifier: p/a_b is p_S_a__b, p/a$b is p_S_a_D_b and
+// other characters are written in hex, as _x2D_ for -.
+func umlID(name string) string {
+    var b strings.Builder
+    for _, r := range name {
+        switch {
+        case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9':
+            b.WriteRune(r)
+        case r == '_':
+            b.WriteString("__")
+        case r == '/':
+            b.WriteString("_S_")
+        case r == '$':
+            b.WriteString("_D_")
+        default:
+            b.WriteString(fmt.Sprintf("_x%X_", r))
+        }
+    }
+    return b.String()
+}
+
+// umlSimpleName is the name of a class within its package, with nested
+// classes written Outer.Inner.
+func umlSimpleName(name string) string {
+    return strings.ReplaceAll(name[strings.LastIndex(name, "/") + 1:], "$", ".")
+}
+
+func umlPackage(name string) string {
+    if slash := strings.LastIndex(name, "/"); slash >= 0 {
+        return strings.ReplaceAll(name[:slash], "/", ".")
+    }
+    return ""
+}
+
+// umlType renders a descriptor with simple class names.
+func umlType(s string) string {
+    t, err := descriptor.ParseReturn(s)
+    if err != nil {
+        return s
+    }
+    return t.JavaName(umlSimpleName)
+}
+
+func umlStereotype(c *Class) string {
+    switch {
+    case c.Flags & FLAG_ANNOTATION != 0:
+        return "annotation"
+    case c.Flags.IsInterface():
+        return "interface"
+    case c.Flags.IsEnum():
+        return "enumeration"
+    case c.SuperName() == "java/lang/Record":
+        return "record"
+    case c.Flags.IsAbstract():
+        return "abstract"
+    }
+    return ""
+}
+
+// umlMember is a field or method ready to be written in either notation.
+type umlMember struct {
+    visibility string
+    name string
+    typ string
+    params []string
+    method bool
+    static bool
+    abstract bool
+    constant bool
+}
+
+func (u *umlDiagram) members(c *Class) ([]umlMember, error) {
+    cp := c.ConstantPool
+    var members []umlMember
+    for _, field := range c.Fields {
+        if field.Flags & FLAG_SYNTHETIC != 0 || umlVisibility(field.Flags) < u.least {
+            continue
+        }
+        members = append(members, umlMember{
+            visibility: umlVisibilitySymbol(field.Flags),
+            name: cp.GetUtf8(field.NameIndex),
+            typ: umlType(cp.GetUtf8(field.DescriptorIndex)),
+            static: field.Flags.IsStatic(),
+            constant: field.Flags.IsEnum(),
+        })
+    }
+    for _, method := range c.Methods {
+        name := cp.GetUtf8(method.NameIndex)
+        if method.Flags & (FLAG_SYNTHETIC | FLAG_BRIDGE) != 0 || name == "<clinit>" || umlVisibility(method.Flags) < u.least {
+            continue
+        }
+        args, ret, err := splitMethodDescriptor(cp.GetUtf8(method.DescriptorIndex))
+        if err != nil {
+            return nil, err
+        }
+        member := umlMember{
+            visibility: umlVisibilitySymbol(method.Flags),
+            name: name,
+            typ: umlType(ret),
+            method: true,
+            static: method.Flags.IsStatic(),
+            abstract: method.Flags.IsAbstract() && !c.Flags.IsInterface(),
+        }
+        if name == "<init>" {
+            member.name, member.typ = umlSimpleName(c.Name()), ""
+        }
+        for _, arg := range args {
+            member.params = append(member.params, umlType(arg))
+        }
+        members = append(members, member)
+    }
+    return members, nil
+}
+
+// umlRelation is an arrow from one class of the diagram to another.
+type umlRelation struct {
+    from string
+    to string
+    arrow string
+    label string
+}
+
+// relations lists the arrows in the "Super <|-- Sub" direction both
+// notations use.
+func (u *umlDiagram) relations() []umlRelation {
+    var relations []umlRelation
+    for _, c := range u.classes {
+        cp := c.ConstantPool
+        if super := c.SuperName(); u.selected[super] {
+            relations = append(relations, umlRelation{super, c.Name(), "<|--", ""})
+        }
+        for _, iface := range c.Interfaces {
+            name := cp.GetClassName(iface)
+            if !u.selected[name] {
+                continue
+            }
+            arrow := "<|.."
+            if c.Flags.IsInterface() {
+                arrow = "<|--"
+            }
+            relations = append(relations, umlRelation{name, c.Name(), arrow, ""})
+        }
+        for _, field := range c.Fields {
+            if field.Flags & FLAG_SYNTHETIC != 0 || field.Flags.IsEnum() || umlVisibility(field.Flags) < u.least {
+                continue
+            }
+            t, err := descriptor.ParseField(cp.GetUtf8(field.DescriptorIndex))
+            if err != nil {
+                continue
+            }
+            // arrays of a class are associations with the class too
+            if t.Base != 'L' || !u.selected[t.Class] {
+                continue
+            }
+            relations = append(relations, umlRelation{c.Name(), t.Class, "-->", cp.GetUtf8(field.NameIndex)})
+        }
+    }
+    return relations
+}
+
+func (u *umlDiagram) plantUML() []string {
+    lines := []string{"@startuml"}
+    byPackage := make(map[string][]*Class)
+    var packages []string
+    for _, c := range u.classes {
+        pkg := umlPackage(c.Name())
+        if _, ok := byPackage[pkg]; !ok {
+            packages = append(packages, pkg)
+        }
+        byPackage[pkg] = append(byPackage[pkg], c)
+    }
+    for _, pkg := range packages {
+        indent := ""
+        if pkg != "" {
+            lines = append(lines, "package " + pkg + " {")
+            indent = "    "
+        }
+        for _, c := range byPackage[pkg] {
+            keyword, stereotype := "class", ""
+            switch umlStereotype(c) {
+            case "annotation":
+                keyword = "annotation"
+            case "interface":
+                keyword = "interface"
+            case "enumeration":
+                keyword = "enum"
+            case "abstract":
+                keyword = "abstract class"
+            case "record":
+                stereotype = " <<record>>"
+            }
+            lines = append(lines, fmt.Sprintf("%s%s \"%s\" as %s%s {", indent, keyword, umlSimpleName(c.Name()), umlID(c.Name()), stereotype))
+            for _, m := range u.memberLists[c.Name()] {
+                text := m.visibility
+                if m.static {
+                    text += "{static} "
+                }
+                if m.abstract {
+                    text += "{abstract} "
+                }
+                switch {
+                case m.constant:
+                    text = m.name
+                case m.method:
+                    text += m.name + "(" + strings.Join(m.params, ", ") + ")"
+                    if m.typ != "" {
+                        text += " : " + m.typ
+                    }
+                default:
+                    text += m.name + " : " + m.typ
+                }
+                lines = append(lines, indent + "    " + text)
+            }
+            lines = append(lines, indent + "}")
+        }
+        if pkg != "" {
+            lines = append(lines, "}")
+        }
+    }
+    for _, r := range u.relations() {
+        line := umlID(r.from) + " " + r.arrow + " " + umlID(r.to)
+        if r.label != "" {
+            line += " : " + r.label
+        }
+        lines = append(lines, line)
+    }
+    return append(lines, "@enduml")
+}
+
+func (u *umlDiagram) mermaid() []string {
+    lines := []string{"classDiagram"}
+    for _, c := range u.classes {
+        lines = append(lines, fmt.Sprintf("    class %s[\"%s\"] {", umlID(c.Name()), umlSimpleName(c.Name())))
+        if stereotype := umlStereotype(c); stereotype != "" {
+            lines = append(lines, "        <<" + stereotype + ">>")
+        }
+        for _, m := range u.memberLists[c.Name()] {
+            var text string
+            switch {
+            case m.constant:
+                text = m.name
+            case m.method:
+                text = m.visibility + m.name + "(" + strings.Join(m.params, ", ") + ")"
+                if m.typ != "" {
+                    text += " " + m.typ
+                }
+                if m.abstract {
+                    text += "*"
+                }
+                if m.static {
+                    text += "$"
+                }
+            default:
+                text = m.visibility + m.typ + " " + m.name
+                if m.static {
+                    text += "$"
+                }
+            }
+            lines = append(lines, "        " + text)
+        }
+        lines = append(lines, "    }")
+    }
+    for _, r := range u.relations() {
+        line := "    " + umlID(r.from) + " " + r.arrow + " " + umlID(r.to)
+        if r.label != "" {
+            line += " : " + r.label
+        }
+        lines = append(lines, line)
+    }
+    return lines
+}
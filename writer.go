@@ -1,219 +1,947 @@
 package jcr
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
+	"unicode/utf8"
     . "github.com/jasonhightower/bytecode"
 )
-type KrakatauWriter struct {}
+// KrakatauWriter disassembles a class into Krakatau assembly that
+// ReadKrakatau accepts. Attributes it does not understand are written as raw
+// bytes. When any of them may hold constant pool indexes the constant pool is
+// written out as well, so that those indexes still refer to the same
+// constants once the output is reassembled.
+//
+// In Lossless mode the constant pool is written out as .const definitions,
+// everything refers to it by [N] index and every attribute other than Code is
+// kept as raw bytes, so reassembling the output reproduces the class file
+// byte for byte.
+type KrakatauWriter struct {
+    Lossless bool
+    // pinned is set while writing a class whose constant pool is written
+    // out because raw attributes refer to it
+    pinned bool
+}
 
 func (k KrakatauWriter) Write(w *io.Writer, class *Class) error {
     cp := class.ConstantPool
 
-    classRef := (*cp.Get(class.ThisIndex)).(ConstClass)
-
+    io.WriteString(*w, fmt.Sprintf(".version %d %d\n", class.Major, class.Minor))
+    if k.Lossless {
+        writeKrakatauPool(w, cp)
+    } else if k.pinned = krakatauNeedsPool(class); k.pinned {
+        writeKrakatauPool(w, cp)
+    }
     io.WriteString(*w, ".class")
-    io.WriteString(*w, flags(class.Flags))
+    io.WriteString(*w, flags(class.Flags, classFlagNames))
     io.WriteString(*w, " ")
-    io.WriteString(*w, cp.GetUtf8(classRef.NameIndex))
+    io.WriteString(*w, k.class(cp, class.ThisIndex))
     io.WriteString(*w, "\n")
     
-    io.WriteString(*w, ".super ")
-    classRef = (*cp.Get(class.SuperIndex)).(ConstClass)
-    io.WriteString(*w, cp.GetUtf8(classRef.NameIndex))
-    io.WriteString(*w, "\n")
+    if class.SuperIndex != 0 {
+        io.WriteString(*w, ".super ")
+        io.WriteString(*w, k.class(cp, class.SuperIndex))
+        io.WriteString(*w, "\n")
+    }
+    for _, iface := range class.Interfaces {
+        io.WriteString(*w, ".implements ")
+        io.WriteString(*w, k.class(cp, iface))
+        io.WriteString(*w, "\n")
+    }
+
+    bootstraps, err := class.BootstrapMethods()
+    if err != nil {
+        return err
+    }
+
+    if len(class.Fields) > 0 {
+        io.WriteString(*w, "\n")
+    }
+    for _, field := range class.Fields {
+        io.WriteString(*w, ".field")
+        io.WriteString(*w, flags(field.Flags, fieldFlagNames))
+        io.WriteString(*w, " ")
+        io.WriteString(*w, k.utf8(cp, field.NameIndex))
+        io.WriteString(*w, " ")
+        io.WriteString(*w, k.utf8(cp, field.DescriptorIndex))
+        var others []Attribute
+        for _, attr := range field.Attributes {
+            if k.Lossless || cp.GetUtf8(attr.NameIndex) != "ConstantValue" {
+                others = append(others, attr)
+                continue
+            }
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, " = ")
+            io.WriteString(*w, krakatauConstant(cp, index, bootstraps))
+        }
+        if len(others) > 0 {
+            io.WriteString(*w, " .fieldattributes\n")
+            if err := k.writeAttributes(w, cp, others, "    "); err != nil {
+                return err
+            }
+            io.WriteString(*w, ".end fieldattributes")
+        }
+        io.WriteString(*w, "\n")
+    }
 
     methodL := len(class.Methods) 
     for i := 0; i < methodL; i++ {
         method := class.Methods[i]
         io.WriteString(*w, "\n")
         io.WriteString(*w, ".method")
-        io.WriteString(*w, flags(method.Flags))
+        io.WriteString(*w, flags(method.Flags, methodFlagNames))
         io.WriteString(*w, " ")
-        io.WriteString(*w, cp.GetUtf8(method.NameIndex))
+        io.WriteString(*w, k.utf8(cp, method.NameIndex))
         io.WriteString(*w, " : ")
-        io.WriteString(*w, cp.GetUtf8(method.DescriptorIndex))
+        io.WriteString(*w, k.utf8(cp, method.DescriptorIndex))
         io.WriteString(*w, "\n")
 
-        // find code attribute
-        attrL := len(method.Attributes)
-        for j := 0; j < attrL; j++ {
-            attrType := cp.GetUtf8(method.Attributes[j].NameIndex)
-            if attrType == "Code" {
-                attrBytes := method.Attributes[j].Info
-    
-                var codeReader io.Reader
-                codeReader = bytes.NewReader(attrBytes)
-                // FIXME this should be handled by a second pass of the reader
-                var code Code
-                ReadCode(&codeReader, &code)
-
-                io.WriteString(*w, "    .limit stack ")
-                io.WriteString(*w, strconv.FormatInt(int64(code.MaxStack), 10))
-                io.WriteString(*w, "\n    .limit locals ")
-                io.WriteString(*w, strconv.FormatInt(int64(code.MaxLocals), 10))
-                io.WriteString(*w, "\n\n")
-
-                instructions := ReadByteCode(code.ByteCode)
-                for k := 0; k < len(instructions); k++ {
-                    io.WriteString(*w, "    ")
-                    io.WriteString(*w, fmt.Sprintf("%s", instructions[k].Opcode))
-
-                    switch instructions[k].Opcode {
-                        case Bipush:
-                            io.WriteString(*w, fmt.Sprintf(" %d", instructions[k].Operands[0]))
-                        case Sipush:
-                            io.WriteString(*w, fmt.Sprintf(" %d", binary.BigEndian.Uint16(instructions[k].Operands)))
-                        case Ldc:
-                            constant :=  cp.Get(CpIndex(instructions[k].Operands[0]))
-                            if (*constant).Type() == TString {
-                                strConst := (*constant).(ConstString)
-                                io.WriteString(*w, fmt.Sprintf(" \"%s\"", cp.GetUtf8(strConst.StringIndex)))
-                            } else {
-                                panic("Unsupported constant type")
-                            }
-                        case LdcW:
-                            io.WriteString(*w, fmt.Sprintf(" %s", cp.GetUtf8(CpIndex(binary.BigEndian.Uint16(instructions[k].Operands)))))
-                        case Ldc2W:
-                        case Iload:
-                        case Lload:
-                        case Dload:
-                        case Fload:
-                        case Aload:
-                        case Istore:
-                        case Lstore:
-                        case Fstore:
-                        case Dstore:
-                        case Astore:
-                        case Iinc:
-                        case Ifeq:
-                        case Ifne:
-                        case Iflt:
-                        case Ifgt:
-                        case Ifle:
-                        case Ifge:
-                        case IfIcmpeq:
-                        case IfIcmpne:
-                        case IfIcmplt:
-                        case IfIcmple:
-                        case IfIcmpgt:
-                        case IfIcmpge:
-                        case Goto:
-                        case Jsr:
-                        case Ret:
-                        case Tableswitch:
-                            panic("Tableswitch not implemented")
-                        case Lookupswitch:
-                            panic("Lookupswitch not implemented")
-                        case Getstatic:
-                            cpIndex := CpIndex(binary.BigEndian.Uint16(instructions[k].Operands))
-                            field := (*cp.Get(cpIndex)).(ConstField)
-                            class := (*cp.Get(field.ClassIndex)).(ConstClass)
-                            fNameType := (*cp.Get(field.NameAndTypeIndex)).(ConstNameType)
-                            io.WriteString(*w, fmt.Sprintf(" %s %s %s", cp.GetUtf8(class.NameIndex), cp.GetUtf8(fNameType.NameIndex), cp.GetUtf8(fNameType.DescriptorIndex)))
-                        case Putstatic:
-                        case Getfield:
-                        case Putfield:
-                        case Invokevirtual:
-                            cpIndex := CpIndex(binary.BigEndian.Uint16(instructions[k].Operands))
-                            method := (*cp.Get(cpIndex)).(ConstMethod)
-                            class := (*cp.Get(method.ClassIndex)).(ConstClass)
-                            nameType := (*cp.Get(method.NameAndTypeIndex)).(ConstNameType)
-                            io.WriteString(*w, fmt.Sprintf(" %s %s %s", cp.GetUtf8(class.NameIndex), cp.GetUtf8(nameType.NameIndex), cp.GetUtf8(nameType.DescriptorIndex)))
-                        case Invokespecial:
-                            cpIndex := CpIndex(binary.BigEndian.Uint16(instructions[k].Operands))
-                            method := (*cp.Get(cpIndex)).(ConstMethod)
-                            class := (*cp.Get(method.ClassIndex)).(ConstClass)
-                            nameType := (*cp.Get(method.NameAndTypeIndex)).(ConstNameType)
-                            io.WriteString(*w, fmt.Sprintf(" %s %s %s", cp.GetUtf8(class.NameIndex), cp.GetUtf8(nameType.NameIndex), cp.GetUtf8(nameType.DescriptorIndex)))
-                        case Invokestatic:
-                        case Invokeinterface:
-                        case Invokedynamic:
-                        case New:
-                        case Newarray:
-                        case Anewarray:
-                        case Checkcast:
-                        case Instanceof:
-                        case Wide:
-                            panic("Wide opcode not supported yet")
-                        case Multianewarray:
-                        case Ifnull:
-                        case Ifnonnull:
-                        case Gotow:
-                        case Jsrw:
+        // attributes preceding Code only occur in lossless output, where
+        // their position has to be kept
+        var before, after []Attribute
+        var codeAttr *Attribute
+        for j, attr := range method.Attributes {
+            switch {
+            case codeAttr == nil && cp.GetUtf8(attr.NameIndex) == "Code":
+                codeAttr = &method.Attributes[j]
+            case codeAttr == nil && k.Lossless:
+                before = append(before, attr)
+            default:
+                after = append(after, attr)
+            }
+        }
+        if err := k.writeAttributes(w, cp, before, "    "); err != nil {
+            return err
+        }
+        if codeAttr != nil {
+            if k.Lossless {
+                io.WriteString(*w, fmt.Sprintf("    .code [%d]\n", codeAttr.NameIndex))
+            }
+            err := k.writeCode(w, class, &method, method.Code(cp), bootstraps)
+            if err != nil {
+                return err
+            }
+        }
+        if err := k.writeAttributes(w, cp, after, "    "); err != nil {
+            return err
+        }
+        io.WriteString(*w, ".end method\n")
+    }
+
+    var others []Attribute
+    for _, attr := range class.Attributes {
+        // regenerated from the invokedynamic instructions and constants,
+        // unless those refer to it by index
+        if k.Lossless || k.pinned || cp.GetUtf8(attr.NameIndex) != "BootstrapMethods" {
+            others = append(others, attr)
+        }
+    }
+    if len(others) > 0 {
+        io.WriteString(*w, "\n")
+    }
+    if err := k.writeAttributes(w, cp, others, ""); err != nil {
+        return err
+    }
+    io.WriteString(*w, ".end class\n")
+    return nil
+}
+
+// class names a class, quoted where needed, or refers to its constant in
+// lossless mode.
+func (k KrakatauWriter) class(cp *ConstantPool, index CpIndex) string {
+    if k.Lossless {
+        return fmt.Sprintf("[%d]", index)
+    }
+    return krakatauName(cp.GetClassName(index))
+}
+
+func (k KrakatauWriter) utf8(cp *ConstantPool, index CpIndex) string {
+    if k.Lossless {
+        return fmt.Sprintf("[%d]", index)
+    }
+    return krakatauName(cp.GetUtf8(index))
+}
+
+// writeAttributes writes the class, field or method attributes that have
+// their own directive and falls back to .attribute for the rest.
+func (k KrakatauWriter) writeAttributes(w *io.Writer, cp *ConstantPool, attrs []Attribute, indent string) error {
+    for _, attr := range attrs {
+        name := cp.GetUtf8(attr.NameIndex)
+        if k.Lossless {
+            name = ""
+        }
+        switch name {
+        case "SourceFile", "Signature":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, fmt.Sprintf("%s.%s %s\n", indent, strings.ToLower(name), krakatauString(cp.GetUtf8(index))))
+        case "Exceptions":
+            exceptions, err := DecodeExceptions(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, exception := range exceptions {
+                io.WriteString(*w, indent + ".throws " + k.class(cp, exception) + "\n")
+            }
+        case "InnerClasses":
+            inners, err := DecodeInnerClasses(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + ".innerclasses\n")
+            for _, inner := range inners {
+                io.WriteString(*w, fmt.Sprintf("%s    %s %s %s%s\n", indent, krakatauClassOrNull(cp, inner.InnerClassIndex),
+                    krakatauClassOrNull(cp, inner.OuterClassIndex), krakatauUtf8OrNull(cp, inner.InnerNameIndex),
+                    flags(inner.Flags, innerClassFlagNames)))
+            }
+            io.WriteString(*w, indent + ".end innerclasses\n")
+        case "EnclosingMethod":
+            enclosing, err := DecodeEnclosingMethod(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + ".enclosing method " + k.class(cp, enclosing.ClassIndex))
+            if enclosing.MethodIndex != 0 {
+                nameType := (*cp.Get(enclosing.MethodIndex)).(ConstNameType)
+                io.WriteString(*w, " " + krakatauName(cp.GetUtf8(nameType.NameIndex)) + " " + cp.GetUtf8(nameType.DescriptorIndex))
+            }
+            io.WriteString(*w, "\n")
+        case "NestHost":
+            host, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + ".nesthost " + k.class(cp, host) + "\n")
+        case "NestMembers", "PermittedSubclasses":
+            classes, err := decodeTable[CpIndex](attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + "." + strings.ToLower(name))
+            for _, class := range classes {
+                io.WriteString(*w, " " + k.class(cp, class))
+            }
+            io.WriteString(*w, "\n")
+        case "Record":
+            components, err := DecodeRecord(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + ".record\n")
+            for _, component := range components {
+                io.WriteString(*w, fmt.Sprintf("%s    %s %s", indent, krakatauName(component.Name(cp)), krakatauName(component.Descriptor(cp))))
+                if len(component.Attributes) > 0 {
+                    io.WriteString(*w, " .componentattributes\n")
+                    if err := k.writeAttributes(w, cp, component.Attributes, indent + "        "); err != nil {
+                        return err
                     }
-                    io.WriteString(*w, "\n")
+                    io.WriteString(*w, indent + "    .end componentattributes")
+                }
+                io.WriteString(*w, "\n")
+            }
+            io.WriteString(*w, indent + ".end record\n")
+        case "Module":
+            m, err := DecodeModule(attr.Info)
+            if err != nil {
+                return err
+            }
+            if err := k.writeModule(w, cp, m, indent); err != nil {
+                return err
+            }
+        case "ModulePackages":
+            packages, err := DecodeModulePackages(attr.Info)
+            if err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + ".modulepackages")
+            for _, pkg := range packages {
+                if err := checkConstant(cp, pkg, TPackage); err != nil {
+                    return err
                 }
-                break
+                io.WriteString(*w, " " + krakatauName(cp.GetPackageName(pkg)))
+            }
+            io.WriteString(*w, "\n")
+        case "ModuleMainClass":
+            index, err := DecodeIndex(attr.Info)
+            if err != nil {
+                return err
             }
+            if err := checkConstant(cp, index, TClass); err != nil {
+                return err
+            }
+            io.WriteString(*w, indent + ".modulemainclass " + k.class(cp, index) + "\n")
+        default:
+            k.writeRawAttribute(w, cp, ".attribute", attr, indent)
         }
-        io.WriteString(*w, ".end method\n")
     }
+    return nil
+}
 
+// writeModule writes a Module attribute as a .module block, with the
+// module's flags and version followed by a line per requires, exports, opens,
+// uses and provides entry.
+func (k KrakatauWriter) writeModule(w *io.Writer, cp *ConstantPool, m ModuleAttribute, indent string) error {
+    if err := m.check(cp); err != nil {
+        return err
+    }
+    version := func(index CpIndex) string {
+        if index == 0 {
+            return ""
+        }
+        return " version " + krakatauString(cp.GetUtf8(index))
+    }
+    io.WriteString(*w, indent + ".module" + flags(m.Flags, moduleFlagNames) + " " + krakatauModuleName(cp.GetModuleName(m.NameIndex)) +
+        version(m.VersionIndex) + "\n")
+    for _, entry := range m.Requires {
+        io.WriteString(*w, indent + "    requires" + flags(entry.Flags, requiresFlagNames) + " " +
+            krakatauModuleName(cp.GetModuleName(entry.Index)) + version(entry.VersionIndex) + "\n")
+    }
+    for _, table := range []struct{ directive string; entries []ModuleExportsEntry }{{"exports", m.Exports}, {"opens", m.Opens}} {
+        for _, entry := range table.entries {
+            io.WriteString(*w, indent + "    " + table.directive + flags(entry.Flags, exportsFlagNames) + " " +
+                krakatauModuleName(cp.GetPackageName(entry.Index)))
+            if len(entry.To) > 0 {
+                io.WriteString(*w, " to")
+                for _, to := range entry.To {
+                    io.WriteString(*w, " " + krakatauModuleName(cp.GetModuleName(to)))
+                }
+            }
+            io.WriteString(*w, "\n")
+        }
+    }
+    for _, index := range m.Uses {
+        io.WriteString(*w, indent + "    uses " + k.class(cp, index) + "\n")
+    }
+    for _, entry := range m.Provides {
+        io.WriteString(*w, indent + "    provides " + k.class(cp, entry.Index) + " with")
+        for _, with := range entry.With {
+            io.WriteString(*w, " " + k.class(cp, with))
+        }
+        io.WriteString(*w, "\n")
+    }
+    io.WriteString(*w, indent + ".end module\n")
     return nil
 }
 
-type JavapWriter struct {}
-func (j JavapWriter) Write(w *io.Writer, c *Class) error {
-        io.WriteString(*w, fmt.Sprintf("Java Class Version: %d.%d\n", c.Major, c.Minor))
-        io.WriteString(*w, fmt.Sprintf("  IsPublic: %t\n", c.Flags.IsPublic()))
-        io.WriteString(*w, fmt.Sprintf("  this: #%d\n", c.ThisIndex))
-        io.WriteString(*w, fmt.Sprintf("  super: #%d\n", c.SuperIndex))
-        io.WriteString(*w, fmt.Sprintf("  interfaces: %d\n", len(c.Interfaces)))
-        io.WriteString(*w, fmt.Sprintf("  fields: %d\n", len(c.Fields)))
-        io.WriteString(*w, fmt.Sprintf("  methods: %d\n", len(c.Methods)))
-        for i := 0; i < len(c.Methods); i++ {
-            io.WriteString(*w, fmt.Sprintf("    #%d\n", c.Methods[i].NameIndex))
-            for j := 0; j < len(c.Methods[i].Attributes); j++ {
-                io.WriteString(*w, fmt.Sprintf("      #%d\n", c.Methods[i].Attributes[j].NameIndex))
-                cd := c.ConstantPool.Constants[c.Methods[i].Attributes[j].NameIndex - 1]
-                var utf8 ConstUtf8
-                utf8 = cd.(ConstUtf8)
-                io.WriteString(*w, fmt.Sprintf("     %s\n", string(utf8.Data)))
-                if "Code" == string(utf8.Data) {
-                    var ior io.Reader
-                    ior = bytes.NewReader(c.Methods[i].Attributes[j].Info)
-                    var ca Code
-                    ReadCode(&ior, &ca)
-    //                readCodeAttribute(&ior, &ca)
-                    io.WriteString(*w, "       code:\n")
-                    instrs:= ReadByteCode(ca.ByteCode)
-                    for k := 0; k < len(instrs); k++ {
-                        io.WriteString(*w, fmt.Sprintf("%s\n", instrs[k]))
+// krakatauModuleName quotes module and package names that would otherwise
+// be read as a flag or keyword of a .module block.
+func krakatauModuleName(name string) string {
+    switch name {
+    case "open", "transitive", "static", "synthetic", "mandated", "version", "to", "with":
+        return krakatauString(name)
+    }
+    return krakatauName(name)
+}
+
+// krakatauAttributes are the attributes written as something other than raw
+// bytes outside lossless mode.
+var krakatauAttributes = map[string]bool{
+    "SourceFile": true, "Signature": true, "Exceptions": true, "InnerClasses": true, "EnclosingMethod": true,
+    "NestHost": true, "NestMembers": true, "PermittedSubclasses": true, "Record": true,
+    "Module": true, "ModulePackages": true, "ModuleMainClass": true,
+    "ConstantValue": true, "Code": true, "BootstrapMethods": true,
+    "StackMapTable": true, "LineNumberTable": true, "LocalVariableTable": true, "LocalVariableTypeTable": true,
+}
+
+// krakatauNeedsPool reports whether any attribute of the class is written as
+// raw bytes that may refer to the constant pool, which then has to keep its
+// layout. Records that cannot be decoded count as raw.
+func krakatauNeedsPool(class *Class) bool {
+    cp := class.ConstantPool
+    var raw func(attrs []Attribute) bool
+    raw = func(attrs []Attribute) bool {
+        for _, attr := range attrs {
+            switch name := cp.GetUtf8(attr.NameIndex); {
+            case name == "Record":
+                components, err := DecodeRecord(attr.Info)
+                if err != nil {
+                    return true
+                }
+                for _, component := range components {
+                    if raw(component.Attributes) {
+                        return true
                     }
-    //                readBytecode(ca.Code)
-                }        
+                }
+            case krakatauAttributes[name]:
+            case name == "Deprecated", name == "Synthetic", name == "SourceDebugExtension", len(attr.Info) == 0:
+                // these hold no indexes
+            default:
+                return true
             }
         }
-        io.WriteString(*w, fmt.Sprintf("  attributes: %d\n", len(c.Attributes)))
-        io.WriteString(*w, fmt.Sprintf("  Constant pool count: %d\n", c.ConstantPool.Count()))
-        for i := 1; i < int(c.ConstantPool.Count()); i++ {
-            c := c.ConstantPool.Get(CpIndex(i))
-            io.WriteString(*w, fmt.Sprintf("   %d | %s\n", i, *c))
+        return false
+    }
+    if raw(class.Attributes) {
+        return true
+    }
+    for _, field := range class.Fields {
+        if raw(field.Attributes) {
+            return true
+        }
+    }
+    for _, method := range class.Methods {
+        if raw(method.Attributes) {
+            return true
+        }
+        if code := method.Code(cp); code != nil && raw(code.Attributes) {
+            return true
+        }
+    }
+    return false
+}
+
+// usesBootstrap reports whether the instruction refers to a bootstrap method
+// through an invokedynamic or dynamic constant.
+func usesBootstrap(cp *ConstantPool, instr Instruction) bool {
+    switch instr.Opcode {
+    case Invokedynamic:
+        return true
+    case Ldc, LdcW, Ldc2W:
+        return (*cp.Get(instr.Index())).Type() == TDynamic
+    }
+    return false
+}
+
+func (k KrakatauWriter) writeRawAttribute(w *io.Writer, cp *ConstantPool, directive string, attr Attribute, indent string) {
+    name := krakatauName(cp.GetUtf8(attr.NameIndex))
+    if k.Lossless {
+        io.WriteString(*w, fmt.Sprintf("%s%s [%d] %s ; %s\n", indent, directive, attr.NameIndex, krakatauBytes(attr.Info), name))
+        return
+    }
+    io.WriteString(*w, fmt.Sprintf("%s%s %s %s\n", indent, directive, name, krakatauBytes(attr.Info)))
+}
+
+// writeKrakatauPool defines every constant at its original index.
+func writeKrakatauPool(w *io.Writer, cp *ConstantPool) {
+    io.WriteString(*w, "\n")
+    for i := 1; i < int(cp.Count()); i++ {
+        constant := *cp.Get(CpIndex(i))
+        if constant == nil {
+            continue
+        }
+        io.WriteString(*w, fmt.Sprintf(".const [%d] = %s\n", i, krakatauPoolEntry(constant)))
+    }
+    io.WriteString(*w, "\n")
+}
+
+func krakatauPoolEntry(constant Constant) string {
+    switch c := constant.(type) {
+    case ConstUtf8:
+        return "Utf8 " + krakatauString(string(c.Data))
+    case ConstInteger:
+        return fmt.Sprintf("Int %d", c.Value)
+    case ConstFloat:
+        return "Float " + krakatauFloat32(c.Value)
+    case ConstLong:
+        return fmt.Sprintf("Long %dL", c.Value)
+    case ConstDouble:
+        return "Double " + krakatauFloat64(c.Value)
+    case ConstClass:
+        return fmt.Sprintf("Class [%d]", c.NameIndex)
+    case ConstString:
+        return fmt.Sprintf("String [%d]", c.StringIndex)
+    case ConstMethodType:
+        return fmt.Sprintf("MethodType [%d]", c.DescriptorIndex)
+    case ConstNameType:
+        return fmt.Sprintf("NameAndType [%d] [%d]", c.NameIndex, c.DescriptorIndex)
+    case ConstField:
+        return fmt.Sprintf("Field [%d] [%d]", c.ClassIndex, c.NameAndTypeIndex)
+    case ConstMethod:
+        return fmt.Sprintf("Method [%d] [%d]", c.ClassIndex, c.NameAndTypeIndex)
+    case ConstInterfaceMethod:
+        return fmt.Sprintf("InterfaceMethod [%d] [%d]", c.ClassIndex, c.NameAndTypeIndex)
+    case ConstMethodHandle:
+        kind := fmt.Sprintf("%d", c.ReferenceKind)
+        if int(c.ReferenceKind) < len(handleKindNames) {
+            kind = handleKindNames[c.ReferenceKind]
         }
+        return fmt.Sprintf("MethodHandle %s [%d]", kind, c.ReferenceIndex)
+    case ConstDynamic:
+        return fmt.Sprintf("Dynamic %d [%d]", c.BootstrapMethodAttrIndex, c.NameAndTypeIndex)
+    case ConstInvokeDynamic:
+        return fmt.Sprintf("InvokeDynamic %d [%d]", c.BootstrapMethodAttrIndex, c.NameAndTypeIndex)
+    case ConstModule:
+        return fmt.Sprintf("Module [%d]", c.NameIndex)
+    case ConstPackage:
+        return fmt.Sprintf("Package [%d]", c.NameIndex)
+    }
+    panic(fmt.Sprintf("Unsupported constant %v", constant))
+}
 
+func (k KrakatauWriter) writeCode(w *io.Writer, class *Class, method *Method, code *Code, bootstraps []BootstrapMethod) error {
+    cp := class.ConstantPool
+
+    io.WriteString(*w, "    .limit stack ")
+    io.WriteString(*w, strconv.FormatInt(int64(code.MaxStack), 10))
+    io.WriteString(*w, "\n    .limit locals ")
+    io.WriteString(*w, strconv.FormatInt(int64(code.MaxLocals), 10))
+    io.WriteString(*w, "\n\n")
+
+    instructions, err := DecodeInstructions(code.ByteCode)
+    if err != nil {
+        return err
+    }
+    var frames []StackMapFrame
+    if !k.Lossless {
+        // lossless output keeps the StackMapTable as it was encoded
+        frames, err = code.StackMap(class, method)
+        if err != nil {
+            return err
+        }
+    }
+
+    labels := make(map[int]bool)
+    for _, instr := range instructions {
+        for _, target := range instr.Targets() {
+            labels[target] = true
+        }
+    }
+    frameAt := make(map[int]StackMapFrame)
+    for _, frame := range frames {
+        frameAt[frame.Offset] = frame
+        for _, v := range append(frame.Locals, frame.Stack...) {
+            if v.Tag == VUninitialized {
+                labels[int(v.Offset)] = true
+            }
+        }
+    }
+    for _, handler := range code.ExceptionHandlers {
+        labels[int(handler.StartPc)] = true
+        labels[int(handler.EndPc)] = true
+        labels[int(handler.HandlerPc)] = true
+    }
+
+    var lineNumbers []LineNumber
+    var localVariables [][]LocalVariable
+    var others []Attribute
+    for _, attr := range code.Attributes {
+        name := cp.GetUtf8(attr.NameIndex)
+        if k.Lossless {
+            name = ""
+        }
+        switch name {
+        case "StackMapTable":
+        case "LineNumberTable":
+            table, err := DecodeLineNumberTable(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, entry := range table {
+                labels[int(entry.StartPc)] = true
+            }
+            lineNumbers = append(lineNumbers, table...)
+        case "LocalVariableTable", "LocalVariableTypeTable":
+            table, err := DecodeLocalVariableTable(attr.Info)
+            if err != nil {
+                return err
+            }
+            for _, entry := range table {
+                labels[int(entry.StartPc)] = true
+                labels[int(entry.StartPc) + int(entry.Length)] = true
+            }
+            localVariables = append(localVariables, table)
+            others = append(others, attr)
+        default:
+            others = append(others, attr)
+        }
+    }
+
+    for _, instr := range instructions {
+        if labels[instr.Offset] {
+            io.WriteString(*w, krakatauLabel(instr.Offset) + ":\n")
+        }
+        if frame, ok := frameAt[instr.Offset]; ok {
+            writeKrakatauFrame(w, frame)
+        }
+        io.WriteString(*w, "    ")
+        // with the pool written out, call sites keep their bootstrap
+        // method indexes
+        io.WriteString(*w, krakatauInstruction(cp, instr, bootstraps, k.Lossless || k.pinned && usesBootstrap(cp, instr)))
         io.WriteString(*w, "\n")
+    }
+    if labels[len(code.ByteCode)] {
+        io.WriteString(*w, krakatauLabel(len(code.ByteCode)) + ":\n")
+    }
+
+    if len(code.ExceptionHandlers) > 0 || len(lineNumbers) > 0 || len(others) > 0 {
         io.WriteString(*w, "\n")
-        return nil
+    }
+    for _, handler := range code.ExceptionHandlers {
+        catchType := "[0]"
+        if !handler.IsFinally() {
+            catchType = k.class(cp, handler.CatchType)
+        }
+        io.WriteString(*w, fmt.Sprintf("    .catch %s from %s to %s using %s\n", catchType,
+            krakatauLabel(int(handler.StartPc)), krakatauLabel(int(handler.EndPc)), krakatauLabel(int(handler.HandlerPc))))
+    }
+    if len(lineNumbers) > 0 {
+        io.WriteString(*w, "    .linenumbertable\n")
+        for _, entry := range lineNumbers {
+            io.WriteString(*w, fmt.Sprintf("        %s %d\n", krakatauLabel(int(entry.StartPc)), entry.Line))
+        }
+        io.WriteString(*w, "    .end linenumbertable\n")
+    }
+    for _, attr := range others {
+        name := cp.GetUtf8(attr.NameIndex)
+        if k.Lossless || name != "LocalVariableTable" && name != "LocalVariableTypeTable" {
+            k.writeRawAttribute(w, cp, ".codeattribute", attr, "    ")
+            continue
+        }
+        directive := strings.ToLower(name)
+        io.WriteString(*w, "    ." + directive + "\n")
+        for _, entry := range localVariables[0] {
+            io.WriteString(*w, fmt.Sprintf("        %d is %s %s from %s to %s\n", entry.Index,
+                krakatauName(cp.GetUtf8(entry.NameIndex)), krakatauName(cp.GetUtf8(entry.DescriptorIndex)),
+                krakatauLabel(int(entry.StartPc)), krakatauLabel(int(entry.StartPc) + int(entry.Length))))
+        }
+        io.WriteString(*w, "    .end " + directive + "\n")
+        localVariables = localVariables[1:]
+    }
+    return nil
 }
 
-func flags(f AccessFlag) string {
-    result := ""
-    if f.IsPublic() {
-        result += " public"
+func krakatauLabel(offset int) string {
+    return fmt.Sprintf("L%d", offset)
+}
+
+// krakatauInstruction renders an instruction. In lossless mode constant pool
+// operands are written by index, followed by a comment with their value.
+func krakatauInstruction(cp *ConstantPool, instr Instruction, bootstraps []BootstrapMethod, lossless bool) string {
+    op := instr.Opcode
+    text := Mnemonic(op)
+    switch {
+    case lossless && (op == Ldc || op == LdcW || op == Ldc2W || op >= Getstatic && op <= Invokedynamic ||
+        op == New || op == Anewarray || op == Checkcast || op == Instanceof || op == Multianewarray):
+        comment := strings.TrimPrefix(krakatauInstruction(cp, instr, bootstraps, false), text + " ")
+        text = fmt.Sprintf("%s [%d]", text, instr.Index())
+        if op == Invokeinterface || op == Multianewarray {
+            text += fmt.Sprintf(" %d", instr.Operands[2])
+        }
+        return text + " ; " + strings.ReplaceAll(comment, "\n", " ")
+    case op == Bipush:
+        return fmt.Sprintf("%s %d", text, int8(instr.Operands[0]))
+    case op == Sipush:
+        return fmt.Sprintf("%s %d", text, int16(binary.BigEndian.Uint16(instr.Operands)))
+    case op == Ldc || op == LdcW || op == Ldc2W:
+        text += " " + krakatauConstant(cp, instr.Index(), bootstraps)
+        if _, ok := (*cp.Get(instr.Index())).(ConstDynamic); ok {
+            if explanation := explainCallSite(cp, bootstraps, instr.Index()); explanation != "" {
+                text += " ; " + explanation
+            }
+        }
+        return text
+    case op >= Iload && op <= Aload || op >= Istore && op <= Astore || op == Ret:
+        return fmt.Sprintf("%s %d", text, instr.Local())
+    case op == Iinc:
+        return fmt.Sprintf("%s %d %d", text, instr.Operands[0], int8(instr.Operands[1]))
+    case op == Wide:
+        inner := Opcode(instr.Operands[0])
+        if inner == Iinc {
+            delta := int16(binary.BigEndian.Uint16(instr.Operands[3:]))
+            return fmt.Sprintf("%s %s %d %d", text, Mnemonic(inner), instr.Local(), delta)
+        }
+        return fmt.Sprintf("%s %s %d", text, Mnemonic(inner), instr.Local())
+    case op == Tableswitch:
+        dflt, keys, targets := instr.Switch()
+        text = fmt.Sprintf("%s %d", text, keys[0])
+        for _, target := range targets {
+            text += "\n        " + krakatauLabel(target)
+        }
+        return text + "\n        default : " + krakatauLabel(dflt)
+    case op == Lookupswitch:
+        dflt, keys, targets := instr.Switch()
+        for i, target := range targets {
+            text += fmt.Sprintf("\n        %d : %s", keys[i], krakatauLabel(target))
+        }
+        return text + "\n        default : " + krakatauLabel(dflt)
+    case op >= Getstatic && op <= Invokestatic:
+        return text + " " + krakatauMemberRef(cp, instr.Index())
+    case op == Invokeinterface:
+        return fmt.Sprintf("%s %s %d", text, krakatauMemberRef(cp, instr.Index()), instr.Operands[2])
+    case op == Invokedynamic:
+        indy := (*cp.Get(instr.Index())).(ConstInvokeDynamic)
+        text += " InvokeDynamic " + krakatauCallSite(cp, indy.BootstrapMethodAttrIndex, indy.NameAndTypeIndex, bootstraps)
+        // explain the call sites javac generates
+        if explanation := explainCallSite(cp, bootstraps, instr.Index()); explanation != "" {
+            text += " ; " + explanation
+        }
+        return text
+    case op == New || op == Anewarray || op == Checkcast || op == Instanceof:
+        return text + " " + cp.GetClassName(instr.Index())
+    case op == Newarray:
+        for name, atype := range arrayTypes {
+            if atype == instr.Operands[0] {
+                return text + " " + name
+            }
+        }
+        return fmt.Sprintf("%s %d", text, instr.Operands[0])
+    case op == Multianewarray:
+        return fmt.Sprintf("%s %s %d", text, cp.GetClassName(instr.Index()), instr.Operands[2])
+    case len(instr.Targets()) > 0:
+        return text + " " + krakatauLabel(instr.Targets()[0])
     }
-    if f.IsStatic() {
-        result += " static"
+    return text
+}
+
+func krakatauConstant(cp *ConstantPool, index CpIndex, bootstraps []BootstrapMethod) string {
+    switch constant := (*cp.Get(index)).(type) {
+    case ConstInteger:
+        return strconv.FormatInt(int64(constant.Value), 10)
+    case ConstFloat:
+        return krakatauFloat32(constant.Value)
+    case ConstLong:
+        return strconv.FormatInt(constant.Value, 10) + "L"
+    case ConstDouble:
+        return krakatauFloat64(constant.Value)
+    case ConstString:
+        return krakatauString(cp.GetUtf8(constant.StringIndex))
+    case ConstClass:
+        return "Class " + cp.GetUtf8(constant.NameIndex)
+    case ConstMethodType:
+        return "MethodType " + cp.GetUtf8(constant.DescriptorIndex)
+    case ConstMethodHandle:
+        return "MethodHandle " + krakatauHandle(cp, constant)
+    case ConstDynamic:
+        return "Dynamic " + krakatauCallSite(cp, constant.BootstrapMethodAttrIndex, constant.NameAndTypeIndex, bootstraps)
+    default:
+        return fmt.Sprintf("%s", constant)
     }
-    if f.IsFinal() {
-        result += " final"
+}
+
+var handleKindNames = []string{"", "getField", "getStatic", "putField", "putStatic",
+    "invokeVirtual", "invokeStatic", "invokeSpecial", "newInvokeSpecial", "invokeInterface"}
+
+func krakatauHandle(cp *ConstantPool, handle ConstMethodHandle) string {
+    kind := fmt.Sprintf("%d", handle.ReferenceKind)
+    if int(handle.ReferenceKind) < len(handleKindNames) {
+        kind = handleKindNames[handle.ReferenceKind]
     }
-    if f.IsAbstract() {
-        result += " abstract"
+    return kind + " " + krakatauMemberRef(cp, handle.ReferenceIndex)
+}
+
+// krakatauCallSite renders the bootstrap method, static arguments, name and
+// descriptor shared by invokedynamic and dynamic constants.
+func krakatauCallSite(cp *ConstantPool, bootstrap uint16, nameTypeIndex CpIndex, bootstraps []BootstrapMethod) string {
+    nameType := (*cp.Get(nameTypeIndex)).(ConstNameType)
+    text := fmt.Sprintf("[bootstrap %d]", bootstrap)
+    if int(bootstrap) < len(bootstraps) {
+        m := bootstraps[bootstrap]
+        text = krakatauHandle(cp, (*cp.Get(m.MethodRef)).(ConstMethodHandle))
+        for _, arg := range m.Arguments {
+            text += " " + krakatauConstant(cp, arg, bootstraps)
+        }
+    }
+    return text + " : " + cp.GetUtf8(nameType.NameIndex) + " " + cp.GetUtf8(nameType.DescriptorIndex)
+}
+
+func krakatauMemberRef(cp *ConstantPool, index CpIndex) string {
+    kind := "Method"
+    switch (*cp.Get(index)).(type) {
+    case ConstField:
+        kind = "Field"
+    case ConstInterfaceMethod:
+        kind = "InterfaceMethod"
+    }
+    class, name, descriptor := cp.GetMemberRef(index)
+    return fmt.Sprintf("%s %s %s %s", kind, class, name, descriptor)
+}
+
+// krakatauFloat32 and krakatauFloat64 write NaNs other than the canonical one
+// as NaN<0xbits> so that the exact bits survive reassembly.
+func krakatauFloat32(v float32) string {
+    if bits := math.Float32bits(v); v != v && bits != 0x7fc00000 {
+        return fmt.Sprintf("NaN<0x%08x>f", bits)
+    }
+    return krakatauFloat(float64(v), 32)
+}
+
+func krakatauFloat64(v float64) string {
+    if bits := math.Float64bits(v); v != v && bits != 0x7ff8000000000000 {
+        return fmt.Sprintf("NaN<0x%016x>", bits)
+    }
+    return krakatauFloat(v, 64)
+}
+
+func krakatauFloat(v float64, bits int) string {
+    var text string
+    switch {
+    case math.IsNaN(v):
+        text = "NaN"
+    case math.IsInf(v, 1):
+        text = "+Infinity"
+    case math.IsInf(v, -1):
+        text = "-Infinity"
+    default:
+        text = strconv.FormatFloat(v, 'g', -1, bits)
+        if !strings.ContainsAny(text, ".eE") {
+            text += ".0"
+        }
+    }
+    if bits == 32 {
+        text += "f"
+    }
+    return text
+}
+
+func krakatauString(s string) string {
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for i := 0; i < len(s); {
+        r, size := utf8.DecodeRuneInString(s[i:])
+        switch {
+        case r == utf8.RuneError && size == 1:
+            sb.WriteString(fmt.Sprintf("\\x%02x", s[i]))
+        case r == '"' || r == '\\':
+            sb.WriteByte('\\')
+            sb.WriteRune(r)
+        case r == '\n':
+            sb.WriteString("\\n")
+        case r == '\t':
+            sb.WriteString("\\t")
+        case r == '\r':
+            sb.WriteString("\\r")
+        case r < 0x20 || r == 0x7f:
+            sb.WriteString(fmt.Sprintf("\\u%04x", r))
+        default:
+            sb.WriteRune(r)
+        }
+        i += size
+    }
+    sb.WriteByte('"')
+    return sb.String()
+}
+
+func writeKrakatauFrame(w *io.Writer, f StackMapFrame) {
+    switch name := FrameTypeName(f.FrameType); name {
+    case "same", "same_extended":
+        io.WriteString(*w, fmt.Sprintf("    .stack %s\n", name))
+    case "chop":
+        io.WriteString(*w, fmt.Sprintf("    .stack chop %d\n", FrameSameExtended - f.FrameType))
+    case "append":
+        added := f.Locals[len(f.Locals) - int(f.FrameType - FrameSameExtended):]
+        io.WriteString(*w, "    .stack append" + krakatauTypes(added) + "\n")
+    case "full":
+        io.WriteString(*w, "    .stack full\n")
+        io.WriteString(*w, "        locals" + krakatauTypes(f.Locals) + "\n")
+        io.WriteString(*w, "        stack" + krakatauTypes(f.Stack) + "\n")
+        io.WriteString(*w, "    .end stack\n")
+    default:
+        io.WriteString(*w, "    .stack same_locals_1_stack_item\n")
+        io.WriteString(*w, "        stack" + krakatauTypes(f.Stack) + "\n")
+        io.WriteString(*w, "    .end stack\n")
+    }
+}
+
+func krakatauTypes(types []VerificationType) string {
+    result := ""
+    for _, v := range types {
+        if v.Tag == VUninitialized {
+            result += fmt.Sprintf(" Uninitialized L%d", v.Offset)
+        } else {
+            result += " " + v.String()
+        }
     }
     return result
 }
 
+type flagName struct {
+    flag AccessFlag
+    name string
+}
+
+var classFlagNames = []flagName{
+    {FLAG_PUBLIC, "public"}, {FLAG_FINAL, "final"}, {FLAG_SUPER, "super"},
+    {FLAG_INTERFACE, "interface"}, {FLAG_ABSTRACT, "abstract"}, {FLAG_SYNTHETIC, "synthetic"},
+    {FLAG_ANNOTATION, "annotation"}, {FLAG_ENUM, "enum"}, {FLAG_MODULE, "module"},
+}
+
+var innerClassFlagNames = []flagName{
+    {FLAG_PUBLIC, "public"}, {FLAG_PRIVATE, "private"}, {FLAG_PROTECTED, "protected"},
+    {FLAG_STATIC, "static"}, {FLAG_FINAL, "final"}, {FLAG_INTERFACE, "interface"},
+    {FLAG_ABSTRACT, "abstract"}, {FLAG_SYNTHETIC, "synthetic"}, {FLAG_ANNOTATION, "annotation"},
+    {FLAG_ENUM, "enum"},
+}
+
+var fieldFlagNames = []flagName{
+    {FLAG_PUBLIC, "public"}, {FLAG_PRIVATE, "private"}, {FLAG_PROTECTED, "protected"},
+    {FLAG_STATIC, "static"}, {FLAG_FINAL, "final"}, {FLAG_VOLATILE, "volatile"},
+    {FLAG_TRANSIENT, "transient"}, {FLAG_SYNTHETIC, "synthetic"}, {FLAG_ENUM, "enum"},
+}
+
+var methodFlagNames = []flagName{
+    {FLAG_PUBLIC, "public"}, {FLAG_PRIVATE, "private"}, {FLAG_PROTECTED, "protected"},
+    {FLAG_STATIC, "static"}, {FLAG_FINAL, "final"}, {FLAG_SYNCHRONIZED, "synchronized"},
+    {FLAG_BRIDGE, "bridge"}, {FLAG_VARARGS, "varargs"}, {FLAG_NATIVE, "native"},
+    {FLAG_ABSTRACT, "abstract"}, {FLAG_STRICT, "strict"}, {FLAG_SYNTHETIC, "synthetic"},
+}
+
+// flags names each set bit of f using the names that apply to the kind of
+// declaration, since the same bit means different things on classes, fields
+// and methods.
+func flags(f AccessFlag, names []flagName) string {
+    result := ""
+    for _, n := range names {
+        if f & n.flag != 0 {
+            result += " " + n.name
+            f &^= n.flag
+        }
+    }
+    if f != 0 {
+        // bits without a meaning for this kind of declaration
+        result += fmt.Sprintf(" 0x%04x", uint16(f))
+    }
+    return result
+}
+
+// krakatauName quotes names that would otherwise be read as a flag, a
+// comment or several tokens.
+func krakatauName(name string) string {
+    if _, isFlag := flagNames[name]; isFlag || name == "" || strings.ContainsAny(name, " \t\r\n") ||
+        strings.ContainsAny(name[:1], ";\"'[0123456789") {
+        return krakatauString(name)
+    }
+    return name
+}
+
+func krakatauClassOrNull(cp *ConstantPool, index CpIndex) string {
+    if index == 0 {
+        return "[0]"
+    }
+    return cp.GetClassName(index)
+}
+
+func krakatauUtf8OrNull(cp *ConstantPool, index CpIndex) string {
+    if index == 0 {
+        return "[0]"
+    }
+    return krakatauName(cp.GetUtf8(index))
+}
+
+// krakatauBytes quotes raw attribute contents, escaping every byte that is
+// not printable ASCII so the literal decodes back to the same bytes.
+func krakatauBytes(data []byte) string {
+    var sb strings.Builder
+    sb.WriteByte('"')
+    for _, b := range data {
+        switch {
+        case b == '"' || b == '\\':
+            sb.WriteByte('\\')
+            sb.WriteByte(b)
+        case b < 0x20 || b >= 0x7f:
+            sb.WriteString(fmt.Sprintf("\\x%02x", b))
+        default:
+            sb.WriteByte(b)
+        }
+    }
+    sb.WriteByte('"')
+    return sb.String()
+}